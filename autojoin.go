@@ -0,0 +1,336 @@
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rplEndOfMOTD = "376"
+	errNoMOTD    = "422"
+
+	errNoSuchChannel     = "403"
+	errTooManyChannels   = "405"
+	errChannelIsFull     = "471"
+	errInviteOnlyChan    = "473"
+	errBannedFromChan    = "474"
+	errBadChannelKey     = "475"
+	errBadChanMask       = "476"
+	errNeedReggedNick    = "477"
+	defaultRetryDelay    = 30 * time.Second
+	defaultMaxRetryDelay = 10 * time.Minute
+	defaultMaxRetries    = 5
+)
+
+//AutoJoinConfig configures the channels joined by the AutoJoin handler
+type AutoJoinConfig struct {
+	Channels map[string]string //Channel names and their keys ("" for none)
+
+	RetryDelay    time.Duration //Delay before retrying a failed join. Defaults to 30 seconds, doubling each time.
+	MaxRetryDelay time.Duration //Defaults to 10 minutes
+	MaxRetries    int           //Retries before giving up. Defaults to 5, -1 retries forever.
+
+	RejoinOnKick bool
+	RejoinDelay  time.Duration //Delay before rejoining after a kick
+
+	//OnFailure is called when giving up on a channel
+	OnFailure func(channel, reason string)
+}
+
+//AutoJoiner joins and keeps track of a set of channels
+type AutoJoiner interface {
+	//Join adds a channel to the set, joining it if already registered
+	Join(channel, key string)
+	//Remove removes a channel from the set. It does not part the channel.
+	Remove(channel string)
+	//Failed returns the channels that could not be joined, and why
+	Failed() map[string]string
+}
+
+type autoJoinChannel struct {
+	key     string
+	joined  bool
+	retries int
+	timer   *time.Timer
+}
+
+type autoJoiner struct {
+	client Client
+	conf   AutoJoinConfig
+
+	mLock    *sync.Mutex
+	channels map[string]*autoJoinChannel //Keyed by casefolded name
+	names    map[string]string           //Casefolded name to name, including failed channels
+	failed   map[string]string           //Reasons, keyed by casefolded name
+	ready    bool
+}
+
+//AutoJoinHandler returns a ClientHandler joining the configured channels
+func AutoJoinHandler(conf AutoJoinConfig) ClientHandler {
+	return func(client Client) {
+		RegisterAutoJoinHandler(client, conf)
+	}
+}
+
+//RegisterAutoJoinHandler joins the configured channels once registration
+//completes (end of MOTD). Channels are joined with as few JOINs as the
+//server allows, and joins failing because the channel is full, invite
+//only, banned, keyed or needs a registered nick are retried with backoff.
+func RegisterAutoJoinHandler(client Client, conf AutoJoinConfig) AutoJoiner {
+	if conf.RetryDelay == 0 {
+		conf.RetryDelay = defaultRetryDelay
+	}
+	if conf.MaxRetryDelay == 0 {
+		conf.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if conf.MaxRetries == 0 {
+		conf.MaxRetries = defaultMaxRetries
+	}
+	aj := &autoJoiner{client: client, conf: conf, mLock: new(sync.Mutex),
+		channels: make(map[string]*autoJoinChannel), names: make(map[string]string), failed: make(map[string]string)}
+	for name, key := range conf.Channels {
+		aj.channels[casefold(name)] = &autoJoinChannel{key: key}
+		aj.names[casefold(name)] = name
+	}
+
+	client.AddHandler(Incoming, aj.handle, rplEndOfMOTD, errNoMOTD, "JOIN", "KICK",
+		errNoSuchChannel, errTooManyChannels, errChannelIsFull, errInviteOnlyChan,
+		errBannedFromChan, errBadChannelKey, errBadChanMask, errNeedReggedNick)
+	client.AddHandler(Outgoing, aj.parted, "PART")
+	return aj
+}
+
+func (aj *autoJoiner) Join(channel, key string) {
+	aj.mLock.Lock()
+	folded := casefold(channel)
+	aj.channels[folded] = &autoJoinChannel{key: key}
+	aj.names[folded] = channel
+	delete(aj.failed, folded)
+	ready := aj.ready
+	aj.mLock.Unlock()
+	if ready {
+		aj.join(channel)
+	}
+}
+
+func (aj *autoJoiner) Remove(channel string) {
+	aj.mLock.Lock()
+	defer aj.mLock.Unlock()
+	folded := casefold(channel)
+	if ch, ok := aj.channels[folded]; ok && ch.timer != nil {
+		ch.timer.Stop()
+	}
+	delete(aj.channels, folded)
+	delete(aj.names, folded)
+	delete(aj.failed, folded)
+}
+
+func (aj *autoJoiner) Failed() map[string]string {
+	aj.mLock.Lock()
+	defer aj.mLock.Unlock()
+	failed := make(map[string]string, len(aj.failed))
+	for folded, reason := range aj.failed {
+		failed[aj.names[folded]] = reason
+	}
+	return failed
+}
+
+func (aj *autoJoiner) handle(msg Message) {
+	params := msg.Params()
+	switch msg.Command() {
+	case rplEndOfMOTD, errNoMOTD:
+		aj.mLock.Lock()
+		already := aj.ready
+		aj.ready = true
+		var names []string
+		for folded := range aj.channels {
+			names = append(names, aj.names[folded])
+		}
+		aj.mLock.Unlock()
+		if !already {
+			aj.join(names...)
+		}
+	case "JOIN":
+		if len(params) > 0 && aj.isSelf(msg.Nick()) {
+			aj.mLock.Lock()
+			if ch, ok := aj.channels[casefold(params[0])]; ok {
+				ch.joined = true
+				ch.retries = 0
+			}
+			aj.mLock.Unlock()
+		}
+	case "KICK":
+		//:nick!user@host KICK #channel kicked :reason
+		if len(params) > 1 && aj.isSelf(params[1]) {
+			aj.mLock.Lock()
+			ch, ok := aj.channels[casefold(params[0])]
+			if ok {
+				ch.joined = false
+			}
+			aj.mLock.Unlock()
+			if ok && aj.conf.RejoinOnKick {
+				aj.schedule(params[0], aj.conf.RejoinDelay)
+			}
+		}
+	case errChannelIsFull, errInviteOnlyChan, errBannedFromChan, errBadChannelKey, errNeedReggedNick:
+		//:server 474 nick #channel :Cannot join channel (+b)
+		if len(params) > 1 {
+			aj.retry(params[1], strings.TrimPrefix(params[len(params)-1], ":"))
+		}
+	case errNoSuchChannel, errTooManyChannels, errBadChanMask:
+		if len(params) > 1 {
+			aj.fail(params[1], strings.TrimPrefix(params[len(params)-1], ":"))
+		}
+	}
+}
+
+//parted stops managing channels we part
+func (aj *autoJoiner) parted(msg Message) {
+	if len(msg.Params()) > 0 {
+		for _, ch := range strings.Split(msg.Params()[0], ",") {
+			aj.Remove(ch)
+		}
+	}
+}
+
+//retry schedules another attempt to join the channel, or gives up
+func (aj *autoJoiner) retry(channel, reason string) {
+	aj.mLock.Lock()
+	ch, ok := aj.channels[casefold(channel)]
+	if !ok {
+		aj.mLock.Unlock()
+		return
+	}
+	if aj.conf.MaxRetries >= 0 && ch.retries >= aj.conf.MaxRetries {
+		aj.mLock.Unlock()
+		aj.fail(channel, reason)
+		return
+	}
+	delay := aj.conf.RetryDelay << uint(ch.retries)
+	if delay > aj.conf.MaxRetryDelay || delay <= 0 {
+		delay = aj.conf.MaxRetryDelay
+	}
+	ch.retries++
+	aj.mLock.Unlock()
+	aj.schedule(channel, delay)
+}
+
+//fail gives up on joining the channel
+func (aj *autoJoiner) fail(channel, reason string) {
+	aj.mLock.Lock()
+	folded := casefold(channel)
+	if _, ok := aj.channels[folded]; !ok {
+		aj.mLock.Unlock()
+		return
+	}
+	name := aj.names[folded]
+	delete(aj.channels, folded)
+	aj.failed[folded] = reason
+	aj.mLock.Unlock()
+	if aj.conf.OnFailure != nil {
+		aj.conf.OnFailure(name, reason)
+	}
+}
+
+func (aj *autoJoiner) schedule(channel string, delay time.Duration) {
+	aj.mLock.Lock()
+	defer aj.mLock.Unlock()
+	ch, ok := aj.channels[casefold(channel)]
+	if !ok {
+		return
+	}
+	if ch.timer != nil {
+		ch.timer.Stop()
+	}
+	ch.timer = time.AfterFunc(delay, func() { aj.join(channel) })
+}
+
+//join sends JOINs for the channels that aren't joined yet
+func (aj *autoJoiner) join(channels ...string) {
+	aj.mLock.Lock()
+	keys := make(map[string]string)
+	var names []string
+	for _, name := range channels {
+		if ch, ok := aj.channels[casefold(name)]; ok && !ch.joined {
+			names = append(names, name)
+			keys[name] = ch.key
+		}
+	}
+	aj.mLock.Unlock()
+
+	maxTargets := 0
+	if targmax, ok := aj.client.Supports("TARGMAX"); ok {
+		maxTargets = targetLimit(targmax, "JOIN")
+	}
+	for _, msg := range JoinMessages(names, keys, maxTargets) {
+		aj.client.Send(msg)
+	}
+}
+
+func (aj *autoJoiner) isSelf(nick string) bool {
+	return nick != "" && casefold(nick) == casefold(aj.client.Nick())
+}
+
+//JoinMessages returns as few JOIN messages as needed to join the
+//channels, using the keys where given. maxTargets limits the channels
+//per message (0 for no limit), and each message fits in MaxLineLength.
+func JoinMessages(channels []string, keys map[string]string, maxTargets int) []Message {
+	//Keys apply to the first channels of a JOIN, so keyed channels go first
+	sorted := append([]string(nil), channels...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ki, kj := keys[sorted[i]] != "", keys[sorted[j]] != ""
+		if ki != kj {
+			return ki
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	var msgs []Message
+	var names, chanKeys []string
+	length := 0
+	flush := func() {
+		if len(names) > 0 {
+			line := "JOIN " + strings.Join(names, ",")
+			if len(chanKeys) > 0 {
+				line += " " + strings.Join(chanKeys, ",")
+			}
+			msgs = append(msgs, NewMessage(line))
+		}
+		names, chanKeys, length = nil, nil, len("JOIN  \r\n")
+	}
+	flush()
+
+	for _, name := range sorted {
+		key := keys[name]
+		added := len(name) + 1
+		if key != "" {
+			added += len(key) + 1
+		}
+		if (maxTargets > 0 && len(names) >= maxTargets) || length+added > MaxLineLength {
+			flush()
+		}
+		names = append(names, name)
+		if key != "" {
+			chanKeys = append(chanKeys, key)
+		}
+		length += added
+	}
+	flush()
+	return msgs
+}
+
+//targetLimit returns the limit for a command in a TARGMAX value
+//(e.g. "JOIN:5,PRIVMSG:4,NAMES:"), or 0 if unlimited
+func targetLimit(targmax, command string) int {
+	for _, limit := range strings.Split(targmax, ",") {
+		kv := strings.SplitN(limit, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], command) {
+			n, _ := strconv.Atoi(kv[1])
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinMessages(t *testing.T) {
+	keys := map[string]string{"#b": "bkey", "#d": "dkey"}
+	msgs := JoinMessages([]string{"#a", "#b", "#c", "#d"}, keys, 0)
+	if len(msgs) != 1 || msgs[0].String() != "JOIN #b,#d,#a,#c bkey,dkey" {
+		t.Errorf("Expected keyed channels first in one JOIN, Received %v", msgs)
+	}
+
+	msgs = JoinMessages([]string{"#a", "#b", "#c"}, keys, 2)
+	if len(msgs) != 2 || msgs[0].String() != "JOIN #b,#a bkey" || msgs[1].String() != "JOIN #c" {
+		t.Errorf("Expected TARGMAX to be respected, Received %v", msgs)
+	}
+
+	var many []string
+	for i := 0; i < 100; i++ {
+		many = append(many, "#channel"+strings.Repeat("x", i%10))
+	}
+	msgs = JoinMessages(many, nil, 0)
+	if len(msgs) < 2 {
+		t.Errorf("Expected long JOINs to be split, Received %d", len(msgs))
+	}
+	for _, msg := range msgs {
+		if len(msg.String())+2 > MaxLineLength {
+			t.Errorf("JOIN is too long: %d", len(msg.String()))
+		}
+	}
+
+	if n := targetLimit("PRIVMSG:4,JOIN:5,NAMES:", "JOIN"); n != 5 {
+		t.Errorf("Expected TARGMAX JOIN:5, Received %d", n)
+	}
+}
+
+func TestAutoJoinHandler(t *testing.T) {
+	var failures []string
+	conn, b := newBufferConn(
+		":server 001 me :Welcome",
+		":server 005 me TARGMAX=JOIN:2 :are supported",
+		":server 376 me :End of /MOTD command.",
+		":me!u@h JOIN #a",
+		":server 403 me #nope :No such channel",
+		":server 474 me #banned :Cannot join channel (+b)",
+	)
+	client := NewClientWrapper(conn, AutoJoinHandler(AutoJoinConfig{
+		Channels:   map[string]string{"#a": "", "#banned": "", "#nope": "", "#keyed": "key"},
+		MaxRetries: -1,
+		OnFailure:  func(channel, reason string) { failures = append(failures, channel+": "+reason) },
+	}))
+	readAll(client)
+
+	sent := b.lines()
+	expected := []string{"JOIN #keyed,#a key", "JOIN #banned,#nope"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+	if !reflect.DeepEqual(failures, []string{"#nope: No such channel"}) {
+		t.Errorf("Expected #nope to fail, Received %q", failures)
+	}
+}
+
+func TestAutoJoinRetry(t *testing.T) {
+	conn, b := newBufferConn(
+		":server 001 me :Welcome",
+		":server 422 me :MOTD File is missing",
+		":me!u@h JOIN #a",
+		":server 474 me #banned :Cannot join channel (+b)",
+		":op!u@h KICK #a me :bye",
+	)
+	client := NewClientWrapper(conn, AutoJoinHandler(AutoJoinConfig{
+		Channels:     map[string]string{"#a": "", "#banned": ""},
+		RetryDelay:   10 * time.Millisecond,
+		RejoinOnKick: true,
+	}))
+	readAll(client)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		sent := b.lines()
+		if len(sent) == 3 {
+			if sent[0] != "JOIN #a,#banned" || !(sent[1] == "JOIN #banned" && sent[2] == "JOIN #a" || sent[1] == "JOIN #a" && sent[2] == "JOIN #banned") {
+				t.Errorf("Expected #banned to be retried and #a rejoined, Received %q", sent)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Timed out waiting for retries, Received %q", b.lines())
+}
+
+func TestAutoJoinFailed(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 001 me :Welcome",
+		":server 376 me :End of /MOTD command.",
+		":server 403 me #nope :No such channel",
+	)
+	client := NewClientWrapper(conn)
+	aj := RegisterAutoJoinHandler(client, AutoJoinConfig{Channels: map[string]string{"#Nope": ""}})
+	readAll(client)
+
+	if failed := aj.Failed(); !reflect.DeepEqual(failed, map[string]string{"#Nope": "No such channel"}) {
+		t.Errorf("Expected #Nope to fail, Received %q", failed)
+	}
+	aj.Remove("#nope")
+	if failed := aj.Failed(); len(failed) != 0 {
+		t.Errorf("Expected no failures after removing #nope, Received %q", failed)
+	}
+}
+
+func TestAutoJoinChannels(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 001 me :Welcome",
+		":server 376 me :End of /MOTD command.",
+		":me!u@h JOIN #a",
+		":server 353 me = #a :me @op",
+		":server 366 me #a :End of /NAMES list.",
+		":me!u@h JOIN #b",
+		":server 353 me = #b :me",
+		":server 366 me #b :End of /NAMES list.",
+	)
+	client := NewClientWrapper(conn, AutoJoinHandler(AutoJoinConfig{Channels: map[string]string{"#a": "", "#b": ""}}))
+	readAll(client)
+
+	if names := client.ChannelNames(); !reflect.DeepEqual(names, []string{"#a", "#b"}) {
+		t.Errorf("Expected to be in #a and #b, Received %q", names)
+	}
+	if modes, err := client.UserModes("#a", "op"); err != nil || modes != "o" {
+		t.Errorf("Expected op to be an op in #a, Received %q %v", modes, err)
+	}
+}
@@ -0,0 +1,249 @@
+package irc
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rplInviteList      = "346"
+	rplEndOfInviteList = "347"
+	rplExceptList      = "348"
+	rplEndOfExceptList = "349"
+	rplBanList         = "367"
+	rplEndOfBanList    = "368"
+	rplQuietList       = "728" //Includes the mode, for other list modes
+	rplEndOfQuietList  = "729"
+
+	defaultModesLimit = 3
+)
+
+//listNumerics maps list replies to the mode they list
+var listNumerics = map[string]byte{
+	rplBanList: 'b', rplEndOfBanList: 'b',
+	rplExceptList: 'e', rplEndOfExceptList: 'e',
+	rplInviteList: 'I', rplEndOfInviteList: 'I',
+}
+
+//BanStyle is the part of a user's hostmask a ban matches
+type BanStyle int
+
+const (
+	BanDefault  BanStyle = iota //The default for the caller, BanHost for BanMask
+	BanNick                     //nick!*@*
+	BanNickHost                 //nick!*@host
+	BanUserHost                 //*!user@host, ignoring a ~ on the username
+	BanHost                     //*!*@host
+	BanDomain                   //*!*@*.domain, or a range of addresses
+)
+
+//BanMask returns a mask matching the hostmask (nick!user@host) in the
+//given style.
+func BanMask(hostmask string, style BanStyle) string {
+	nick, user, host := splitHostmask(hostmask)
+	switch style {
+	case BanNick:
+		return nick + "!*@*"
+	case BanNickHost:
+		return nick + "!*@" + host
+	case BanUserHost:
+		return "*!*" + strings.TrimPrefix(user, "~") + "@" + host
+	case BanDomain:
+		return "*!*@" + domainMask(host)
+	}
+	return "*!*@" + host
+}
+
+//AccountBanMask returns an extban matching users logged in to the
+//account, using the server's EXTBAN token. The bool is false if the
+//server does not support account extbans.
+func AccountBanMask(is ISupport, account string) (string, bool) {
+	extban, ok := is.Supports("EXTBAN")
+	if !ok {
+		return "", false
+	}
+	parts := strings.SplitN(extban, ",", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	prefix, types := parts[0], parts[1]
+	switch {
+	case prefix == "" && strings.Contains(types, "R"):
+		//InspIRCd
+		return "R:" + account, true
+	case prefix != "" && strings.Contains(types, "a"):
+		//Charybdis, Solanum ($a:account) and UnrealIRCd (~a:account)
+		return prefix + "a:" + account, true
+	}
+	return "", false
+}
+
+//QuietMask returns the list mode and mask that quiets users matching the
+//mask: +q on servers with a q list mode (Charybdis, Solanum), or an
+//extban (InspIRCd m:, UnrealIRCd ~q:). The bool is false if the server
+//doesn't support quiets.
+func QuietMask(is ISupport, mask string) (mode byte, quiet string, ok bool) {
+	if listModes, _, _, _ := ChanModes(is); strings.IndexByte(listModes, 'q') >= 0 {
+		return 'q', mask, true
+	}
+	extban, _ := is.Supports("EXTBAN")
+	parts := strings.SplitN(extban, ",", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+	prefix, types := parts[0], parts[1]
+	switch {
+	case prefix == "" && strings.Contains(types, "m"):
+		return 'b', "m:" + mask, true
+	case prefix != "" && strings.Contains(types, "q"):
+		return 'b', prefix + "q:" + mask, true
+	}
+	return 0, "", false
+}
+
+//ModeListMessage returns a MODE requesting a list mode (e.g. 'b' for
+//bans) of a channel. The replies are cached by the Channels handler.
+func ModeListMessage(channel string, mode byte) Message {
+	return NewMessage("MODE " + channel + " +" + string(mode))
+}
+
+//BanMessages returns MODEs banning the masks, sending as many per
+//message as the server's MODES allows.
+func BanMessages(is ISupport, channel string, masks ...string) []Message {
+	return listModeMessages(is, channel, true, 'b', masks)
+}
+
+//UnbanMessages returns MODEs removing the bans on the masks
+func UnbanMessages(is ISupport, channel string, masks ...string) []Message {
+	return listModeMessages(is, channel, false, 'b', masks)
+}
+
+func listModeMessages(is ISupport, channel string, set bool, mode byte, masks []string) []Message {
+	changes := make([]ModeChange, len(masks))
+	for i, mask := range masks {
+		changes[i] = ModeChange{Set: set, Mode: mode, Param: mask}
+	}
+	return ModeMessages(is, channel, changes)
+}
+
+//ModeMessages returns as few MODE messages as needed to make the changes
+//to a channel, respecting the server's MODES limit on changes with
+//parameters, and the maximum line length.
+func ModeMessages(is ISupport, channel string, changes []ModeChange) []Message {
+	limit := defaultModesLimit
+	if modes, ok := is.Supports("MODES"); ok {
+		limit, _ = strconv.Atoi(modes)
+	}
+
+	var msgs []Message
+	var modes, params []string
+	length := 0
+	flush := func() {
+		if len(modes) > 0 {
+			line := "MODE " + channel + " " + modeString(modes)
+			if len(params) > 0 {
+				line += " " + strings.Join(params, " ")
+			}
+			msgs = append(msgs, NewMessage(line))
+		}
+		modes, params = nil, nil
+		length = len("MODE  \r\n") + len(channel)
+	}
+	flush()
+
+	for _, change := range changes {
+		s := change.String()
+		added := len(s) + 1 //Slightly overestimates with repeated +/-
+		if len(modes) > 0 && ((change.Param != "" && limit > 0 && len(params) >= limit) || length+added > MaxLineLength) {
+			flush()
+		}
+		modes = append(modes, s[:2])
+		if change.Param != "" {
+			params = append(params, change.Param)
+		}
+		length += added
+	}
+	flush()
+	return msgs
+}
+
+//modeString joins changes like ["+b", "+b", "-o"] into "+bb-o"
+func modeString(modes []string) string {
+	var b strings.Builder
+	sign := byte(0)
+	for _, m := range modes {
+		if m[0] != sign {
+			sign = m[0]
+			b.WriteByte(sign)
+		}
+		b.WriteByte(m[1])
+	}
+	return b.String()
+}
+
+//ModeListHandler returns a ClientHandler requesting the list modes (e.g.
+//"beI") of each channel after joining it, so they are available from
+//Channels.ModeList.
+func ModeListHandler(modes string) ClientHandler {
+	return func(client Client) {
+		client.AddHandler(Incoming, func(msg Message) {
+			//:server 366 nick #channel :End of /NAMES list.
+			if len(msg.Params()) > 1 {
+				for i := 0; i < len(modes); i++ {
+					client.Send(ModeListMessage(msg.Params()[1], modes[i]))
+				}
+			}
+		}, rplEndofNames)
+	}
+}
+
+//listEntry parses the mask, setter and time from the parameters of a
+//list reply, starting with the mask
+func listEntry(params []string) ListEntry {
+	entry := ListEntry{Mask: params[0]}
+	if len(params) > 1 {
+		entry.SetBy = strings.TrimPrefix(params[1], ":")
+	}
+	if len(params) > 2 {
+		if ts, err := strconv.ParseInt(strings.TrimPrefix(params[2], ":"), 10, 64); err == nil {
+			entry.SetAt = time.Unix(ts, 0)
+		}
+	}
+	return entry
+}
+
+func splitHostmask(hostmask string) (nick, user, host string) {
+	nick, user, host = hostmask, "*", "*"
+	if i := strings.IndexByte(nick, '@'); i >= 0 {
+		nick, host = nick[:i], nick[i+1:]
+	}
+	if i := strings.IndexByte(nick, '!'); i >= 0 {
+		nick, user = nick[:i], nick[i+1:]
+	}
+	return nick, user, host
+}
+
+//domainMask returns a mask for the host's domain or address range
+func domainMask(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			//1.2.3.4 -> 1.2.3.*
+			return host[:strings.LastIndexByte(host, '.')+1] + "*"
+		}
+		//Ban the /64
+		ip16 := ip.To16()
+		var groups []string
+		for i := 0; i < 8; i += 2 {
+			groups = append(groups, strconv.FormatUint(uint64(ip16[i])<<8|uint64(ip16[i+1]), 16))
+		}
+		return strings.Join(groups, ":") + ":*"
+	}
+	//Cloaks (e.g. user/nick) and single labels are banned as they are
+	labels := strings.Split(host, ".")
+	if strings.Contains(host, "/") || len(labels) < 3 {
+		return host
+	}
+	return "*." + strings.Join(labels[1:], ".")
+}
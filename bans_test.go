@@ -0,0 +1,98 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBanMask(t *testing.T) {
+	tests := []struct {
+		hostmask string
+		style    BanStyle
+		mask     string
+	}{
+		{"nick!~user@host.example.com", BanNick, "nick!*@*"},
+		{"nick!~user@host.example.com", BanNickHost, "nick!*@host.example.com"},
+		{"nick!~user@host.example.com", BanUserHost, "*!*user@host.example.com"},
+		{"nick!~user@host.example.com", BanHost, "*!*@host.example.com"},
+		{"nick!~user@host.example.com", BanDomain, "*!*@*.example.com"},
+		{"nick!user@example.com", BanDomain, "*!*@example.com"},
+		{"nick!user@192.168.1.20", BanDomain, "*!*@192.168.1.*"},
+		{"nick!user@2001:db8:1:2:3:4:5:6", BanDomain, "*!*@2001:db8:1:2:*"},
+		{"nick!user@user/nick", BanDomain, "*!*@user/nick"},
+	}
+	for _, test := range tests {
+		if mask := BanMask(test.hostmask, test.style); mask != test.mask {
+			t.Errorf("BanMask(%q, %d): Expected %q, Received %q", test.hostmask, test.style, test.mask, mask)
+		}
+	}
+}
+
+func TestAccountBanMask(t *testing.T) {
+	tests := []struct {
+		extban, mask string
+		ok           bool
+	}{
+		{"$,ajrxz", "$a:acct", true},
+		{"~,acfjmnpqrtCGOST", "~a:acct", true},
+		{",ACNOQRSTUacjmnopqrsz", "R:acct", true},
+		{"$,jrxz", "", false},
+	}
+	for _, test := range tests {
+		conn, _ := newBufferConn(":server 005 me EXTBAN=" + test.extban + " :are supported")
+		is := RegisterISupportHandler(conn)
+		readAll(conn)
+		if mask, ok := AccountBanMask(is, "acct"); mask != test.mask || ok != test.ok {
+			t.Errorf("EXTBAN=%s: Expected %q %t, Received %q %t", test.extban, test.mask, test.ok, mask, ok)
+		}
+	}
+}
+
+func TestBanMessages(t *testing.T) {
+	conn, _ := newBufferConn(":server 005 me MODES=2 :are supported")
+	is := RegisterISupportHandler(conn)
+	readAll(conn)
+
+	msgs := BanMessages(is, "#chan", "a!*@*", "b!*@*", "c!*@*")
+	if len(msgs) != 2 || msgs[0].String() != "MODE #chan +bb a!*@* b!*@*" || msgs[1].String() != "MODE #chan +b c!*@*" {
+		t.Errorf("Expected bans to respect MODES, Received %v", msgs)
+	}
+
+	msgs = ModeMessages(is, "#chan", []ModeChange{{false, 'b', "a!*@*"}, {true, 'm', ""}, {true, 'o', "nick"}})
+	if len(msgs) != 1 || msgs[0].String() != "MODE #chan -b+mo a!*@* nick" {
+		t.Errorf("Expected changes to be combined, Received %v", msgs)
+	}
+}
+
+func TestChannelsHandlerModeLists(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 005 me CHANMODES=Ibeq,k,l,imnst :are supported",
+		"JOIN #chan",
+		":server 367 me #chan a!*@* op!u@h 1500000000",
+		":server 367 me #chan b!*@* op!u@h 1500000001",
+		":server 368 me #chan :End of Channel Ban List",
+		":server 349 me #chan :End of Channel Exception List",
+		":server 728 me #chan q c!*@* op!u@h 1500000002",
+		":server 729 me #chan q :End of Channel Quiet List",
+		":op!u@h MODE #chan -b+b-q a!*@* d!*@* c!*@*",
+	)
+	channels := RegisterChannelsHandler(conn)
+	readAll(conn)
+
+	bans, err := channels.ModeList("#chan", 'b')
+	if err != nil || len(bans) != 2 || bans[0].Mask != "b!*@*" || bans[1].Mask != "d!*@*" {
+		t.Errorf("Unexpected bans %+v (%v)", bans, err)
+	}
+	if bans[0].SetBy != "op!u@h" || !bans[0].SetAt.Equal(time.Unix(1500000001, 0)) || bans[1].SetBy != "op!u@h" {
+		t.Errorf("Unexpected setters %+v", bans)
+	}
+	if excepts, err := channels.ModeList("#chan", 'e'); err != nil || len(excepts) != 0 {
+		t.Errorf("Expected an empty exception list, Received %+v (%v)", excepts, err)
+	}
+	if quiets, err := channels.ModeList("#chan", 'q'); err != nil || len(quiets) != 0 {
+		t.Errorf("Expected an empty quiet list, Received %+v (%v)", quiets, err)
+	}
+	if _, err := channels.ModeList("#chan", 'I'); err != ErrListNotLoaded {
+		t.Errorf("Expected ErrListNotLoaded, Received %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package bot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/oooska/irc"
+)
+
+//AllPermissions in a role or grant gives every permission
+const AllPermissions = "*"
+
+//Grant gives roles and permissions to the users matching all of its
+//criteria. Empty criteria match everyone, so at least one of Mask,
+//Account or Level should be set.
+type Grant struct {
+	Mask    string `json:"mask,omitempty"`    //nick!user@host glob, e.g. *!*@trusted.host
+	Account string `json:"account,omitempty"` //Services account the user must be logged in to
+	//Minimum channel membership, as a mode or prefix (e.g. "o" or "@").
+	//Only matches commands used in a channel.
+	Level       string   `json:"level,omitempty"`
+	Channel     string   `json:"channel,omitempty"` //Only applies in this channel, if set
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+//User is what an ACL knows about the user of a command
+type User struct {
+	Hostmask string //nick!user@host
+	Account  string //Empty if not logged in, or unknown
+	Channel  string //Empty in a query
+	Modes    string //Membership modes in the channel, e.g. "ov"
+	Prefix   string //The server's PREFIX, e.g. "(ov)@+"
+}
+
+//ACL decides which permissions users have. It is safe for concurrent use.
+type ACL struct {
+	mLock  *sync.RWMutex
+	roles  map[string][]string
+	grants []Grant
+}
+
+//aclFile is the persisted form of an ACL
+type aclFile struct {
+	Roles  map[string][]string `json:"roles"`
+	Grants []Grant             `json:"grants"`
+}
+
+//NewACL returns an empty ACL
+func NewACL() *ACL {
+	return &ACL{mLock: new(sync.RWMutex), roles: make(map[string][]string)}
+}
+
+//LoadACL reads an ACL saved with Save. An empty ACL is returned if the
+//file does not exist.
+func LoadACL(path string) (*ACL, error) {
+	acl := NewACL()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return acl, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var f aclFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Roles != nil {
+		acl.roles = f.Roles
+	}
+	acl.grants = f.Grants
+	return acl, nil
+}
+
+//Save writes the ACL to the file as JSON, replacing it atomically
+func (acl *ACL) Save(path string) error {
+	acl.mLock.RLock()
+	data, err := json.MarshalIndent(aclFile{Roles: acl.roles, Grants: acl.grants}, "", "\t")
+	acl.mLock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//SetRole sets the permissions given by a role. A role with no
+//permissions is removed.
+func (acl *ACL) SetRole(role string, permissions ...string) {
+	acl.mLock.Lock()
+	defer acl.mLock.Unlock()
+	if len(permissions) == 0 {
+		delete(acl.roles, role)
+	} else {
+		acl.roles[role] = permissions
+	}
+}
+
+//Grant adds a grant
+func (acl *ACL) Grant(g Grant) {
+	acl.mLock.Lock()
+	acl.grants = append(acl.grants, g)
+	acl.mLock.Unlock()
+}
+
+//Revoke removes the grants with the same Mask, Account, Level and Channel.
+//Returns the number of grants removed.
+func (acl *ACL) Revoke(g Grant) int {
+	acl.mLock.Lock()
+	defer acl.mLock.Unlock()
+	kept := acl.grants[:0]
+	for _, existing := range acl.grants {
+		if existing.Mask != g.Mask || existing.Account != g.Account || existing.Level != g.Level || existing.Channel != g.Channel {
+			kept = append(kept, existing)
+		}
+	}
+	removed := len(acl.grants) - len(kept)
+	acl.grants = kept
+	return removed
+}
+
+//Grants returns a copy of the grants
+func (acl *ACL) Grants() []Grant {
+	acl.mLock.RLock()
+	defer acl.mLock.RUnlock()
+	return append([]Grant(nil), acl.grants...)
+}
+
+//Allowed reports whether the user has the permission
+func (acl *ACL) Allowed(u User, permission string) bool {
+	acl.mLock.RLock()
+	defer acl.mLock.RUnlock()
+	for _, g := range acl.grants {
+		if !g.matches(u) {
+			continue
+		}
+		if hasPermission(g.Permissions, permission) {
+			return true
+		}
+		for _, role := range g.Roles {
+			if hasPermission(acl.roles[role], permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Authorize checks the permission for the user of a command. Set it as
+//the Router's Authorize function.
+//
+//The user's hostmask comes from the command's message, and their account
+//and channel modes are looked up when the command is used, so a user
+//taking a trusted nick or logging out of a trusted account isn't
+//allowed. Enable the account-notify, extended-join and account-tag
+//capabilities so the client knows users' accounts.
+func (acl *ACL) Authorize(ctx *Context, permission string) bool {
+	u := User{Hostmask: strings.TrimPrefix(ctx.Message.Prefix(), ":"), Channel: ctx.Channel}
+	if account, ok := ctx.Message.Tags()["account"]; ok {
+		u.Account = account
+	} else {
+		u.Account, _ = ctx.Client.Account(ctx.Nick)
+	}
+	if ctx.Channel != "" {
+		u.Modes, _ = ctx.Client.UserModes(ctx.Channel, ctx.Nick)
+		u.Prefix, _ = ctx.Client.Supports("PREFIX")
+	}
+	return acl.Allowed(u, permission)
+}
+
+//WhoAccountsHandler is a ClientHandler that asks for the accounts of
+//everyone in a channel after joining it, if the server supports WHOX.
+func WhoAccountsHandler(client irc.Client) {
+	client.AddHandler(irc.Incoming, func(msg irc.Message) {
+		//:server 366 nick #channel :End of /NAMES list.
+		if _, ok := client.Supports("WHOX"); ok && len(msg.Params()) > 1 {
+			client.Send(irc.WhoAccountsMessage(msg.Params()[1]))
+		}
+	}, "366")
+}
+
+func (g Grant) matches(u User) bool {
+	if g.Channel != "" && !strings.EqualFold(g.Channel, u.Channel) {
+		return false
+	}
+	if g.Mask != "" && !irc.MatchMask(g.Mask, u.Hostmask) {
+		return false
+	}
+	if g.Account != "" && (u.Account == "" || !strings.EqualFold(g.Account, u.Account)) {
+		return false
+	}
+	if g.Level != "" && (u.Channel == "" || !hasLevel(u, g.Level)) {
+		return false
+	}
+	return true
+}
+
+//hasLevel reports whether the user has a membership mode ranked at
+//least as high as level
+func hasLevel(u User, level string) bool {
+	modes, symbols := irc.Prefixes(prefixISupport(u.Prefix))
+	rank := strings.Index(modes, level)
+	if rank < 0 {
+		rank = strings.Index(symbols, level)
+	}
+	if rank < 0 || len(level) != 1 {
+		return false
+	}
+	for _, m := range u.Modes {
+		if i := strings.IndexRune(modes, m); i >= 0 && i <= rank {
+			return true
+		}
+	}
+	return false
+}
+
+//prefixISupport is an irc.ISupport only knowing PREFIX
+type prefixISupport string
+
+func (p prefixISupport) Supports(token string) (string, bool) {
+	if token != "PREFIX" || p == "" {
+		return "", false
+	}
+	return string(p), true
+}
+
+func hasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission || p == AllPermissions {
+			return true
+		}
+	}
+	return false
+}
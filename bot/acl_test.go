@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestACLAllowed(t *testing.T) {
+	acl := NewACL()
+	acl.SetRole("admin", AllPermissions)
+	acl.SetRole("moderator", "kick", "ban")
+	acl.Grant(Grant{Account: "alice", Roles: []string{"admin"}})
+	acl.Grant(Grant{Mask: "*!*@trusted.host", Roles: []string{"moderator"}})
+	acl.Grant(Grant{Level: "o", Permissions: []string{"topic"}})
+	acl.Grant(Grant{Level: "+", Channel: "#chan", Permissions: []string{"voiced"}})
+
+	prefix := "(qohv)~@%+"
+	tests := []struct {
+		user       User
+		permission string
+		allowed    bool
+	}{
+		{User{Hostmask: "a!a@host", Account: "alice"}, "anything", true},
+		{User{Hostmask: "alice!a@host"}, "anything", false},
+		{User{Hostmask: "b!b@trusted.host"}, "kick", true},
+		{User{Hostmask: "b!b@trusted.host"}, "quit", false},
+		{User{Hostmask: "b!b@untrusted.host"}, "kick", false},
+		{User{Hostmask: "c!c@h", Channel: "#other", Modes: "q", Prefix: prefix}, "topic", true},
+		{User{Hostmask: "c!c@h", Channel: "#other", Modes: "o", Prefix: prefix}, "topic", true},
+		{User{Hostmask: "c!c@h", Channel: "#other", Modes: "hv", Prefix: prefix}, "topic", false},
+		{User{Hostmask: "c!c@h", Modes: "o", Prefix: prefix}, "topic", false},
+		{User{Hostmask: "c!c@h", Channel: "#chan", Modes: "v"}, "voiced", true},
+		{User{Hostmask: "c!c@h", Channel: "#other", Modes: "v"}, "voiced", false},
+	}
+	for _, test := range tests {
+		if acl.Allowed(test.user, test.permission) != test.allowed {
+			t.Errorf("Allowed(%+v, %s): Expected %t", test.user, test.permission, test.allowed)
+		}
+	}
+
+	if n := acl.Revoke(Grant{Account: "alice"}); n != 1 {
+		t.Errorf("Expected 1 grant to be revoked, Received %d", n)
+	}
+	if acl.Allowed(User{Hostmask: "a!a@host", Account: "alice"}, "anything") {
+		t.Error("Expected revoked grant to no longer apply")
+	}
+}
+
+func TestACLPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+
+	acl, err := LoadACL(path)
+	if err != nil {
+		t.Fatalf("Expected a missing file to give an empty ACL, Received %v", err)
+	}
+	acl.SetRole("admin", AllPermissions)
+	acl.Grant(Grant{Account: "alice", Channel: "#chan", Roles: []string{"admin"}})
+	if err := acl.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadACL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Grants(), acl.Grants()) || !reflect.DeepEqual(loaded.roles, acl.roles) {
+		t.Errorf("Expected %+v, Received %+v", acl.Grants(), loaded.Grants())
+	}
+}
+
+func TestACLAuthorize(t *testing.T) {
+	acl := NewACL()
+	acl.Grant(Grant{Account: "alice", Permissions: []string{"admin"}})
+	acl.Grant(Grant{Level: "@", Permissions: []string{"op"}})
+	acl.Grant(Grant{Mask: "trusted!*@trusted.host", Permissions: []string{"admin"}})
+	r := NewRouter("!")
+	r.Authorize = acl.Authorize
+	r.Register(Command{Name: "admin", Permission: "admin", Handler: func(ctx *Context) error { return ctx.Reply("ok") }})
+	r.Register(Command{Name: "op", Permission: "op", Handler: func(ctx *Context) error { return ctx.Reply("ok") }})
+
+	sent := run(r,
+		"JOIN #chan",
+		":server 353 bot = #chan :bot @alice mallory",
+		":server 366 bot #chan :End of /NAMES list.",
+		":alice!a@h ACCOUNT alice",
+		":alice!a@h PRIVMSG #chan :!admin",
+		":alice!a@h PRIVMSG #chan :!op",
+		//Taking a trusted nick doesn't give its access
+		":alice!a@h NICK alice_",
+		":mallory!m@h NICK alice",
+		":alice!m@h PRIVMSG #chan :!admin",
+		":alice!m@h PRIVMSG #chan :!op",
+		//Nor does keeping the nick after logging out
+		":alice_!a@h PRIVMSG #chan :!admin",
+		":alice_!a@h ACCOUNT *",
+		":alice_!a@h PRIVMSG #chan :!admin",
+		"@account=alice :alice_!a@h PRIVMSG #chan :!admin",
+		":trusted!t@trusted.host PRIVMSG #chan :!admin",
+		":trusted!t@other.host PRIVMSG #chan :!admin",
+	)
+	expected := []string{
+		"PRIVMSG #chan :ok",
+		"PRIVMSG #chan :ok",
+		"PRIVMSG #chan :Permission denied",
+		"PRIVMSG #chan :Permission denied",
+		"PRIVMSG #chan :ok",
+		"PRIVMSG #chan :Permission denied",
+		"PRIVMSG #chan :ok",
+		"PRIVMSG #chan :ok",
+		"PRIVMSG #chan :Permission denied",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+)
+
+//ErrUnterminatedQuote is returned when an argument's closing quote is missing
+var ErrUnterminatedQuote = errors.New("bot: unterminated quote")
+
+//SplitArgs splits a command line into arguments on whitespace. Arguments
+//may be quoted with double or single quotes to include spaces, and a
+//backslash escapes the next character. Quotes only open at the start of
+//an argument, so apostrophes in words (you're) are kept.
+//
+//	kick bob "being rude" -> [kick bob being rude]
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case (r == '"' || r == '\'') && !inArg:
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, ErrUnterminatedQuote
+	}
+	if escaped {
+		arg.WriteRune('\\')
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+//argSpec is the number of arguments a command accepts, parsed from its usage
+type argSpec struct {
+	min, max int //max is -1 if unlimited
+}
+
+//parseArgSpec parses a usage string such as "<nick> [reason...]".
+//<arg> is required, [arg] is optional, and an argument ending in ...
+//accepts any number of words.
+func parseArgSpec(usage string) argSpec {
+	spec := argSpec{}
+	for _, arg := range strings.Fields(usage) {
+		if strings.HasSuffix(strings.TrimRight(arg, ">]"), "...") {
+			if strings.HasPrefix(arg, "<") {
+				spec.min++
+			}
+			spec.max = -1
+			return spec
+		}
+		if strings.HasPrefix(arg, "[") {
+			spec.max++
+		} else {
+			spec.min++
+			spec.max++
+		}
+	}
+	return spec
+}
+
+func (s argSpec) accepts(n int) bool {
+	return n >= s.min && (s.max < 0 || n <= s.max)
+}
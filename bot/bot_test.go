@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/irctest"
+)
+
+//run feeds the lines to a client named "bot" using the router, and returns
+//what the client sent
+func run(r *Router, lines ...string) []string {
+	script := irctest.NewScript(append([]string{":server 001 bot :Welcome"}, lines...)...)
+	client := irc.NewClientWrapper(irc.NewConnectionWrapper(script), r.Handler)
+	for {
+		if _, err := client.Read(); err != nil {
+			break
+		}
+	}
+	return script.Written()
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+		err  error
+	}{
+		{"", nil, nil},
+		{"a b  c", []string{"a", "b", "c"}, nil},
+		{`bob "being rude"`, []string{"bob", "being rude"}, nil},
+		{`'it"s' ""`, []string{`it"s`, ""}, nil},
+		{`a\ b c\"`, []string{"a b", `c"`}, nil},
+		{`"unterminated`, nil, ErrUnterminatedQuote},
+		{`bob you're rude`, []string{"bob", "you're", "rude"}, nil},
+		{`say it's "a test"`, []string{"say", "it's", "a test"}, nil},
+	}
+	for _, test := range tests {
+		args, err := SplitArgs(test.line)
+		if err != test.err || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("SplitArgs(%q): Expected %q (%v), Received %q (%v)", test.line, test.args, test.err, args, err)
+		}
+	}
+}
+
+func TestArgSpec(t *testing.T) {
+	tests := []struct {
+		usage    string
+		min, max int
+	}{
+		{"", 0, 0},
+		{"<nick>", 1, 1},
+		{"<nick> [reason]", 1, 2},
+		{"<nick> [reason...]", 1, -1},
+		{"<words...>", 1, -1},
+	}
+	for _, test := range tests {
+		if spec := parseArgSpec(test.usage); spec.min != test.min || spec.max != test.max {
+			t.Errorf("parseArgSpec(%q): Expected %d-%d, Received %d-%d", test.usage, test.min, test.max, spec.min, spec.max)
+		}
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	r := NewRouter("!")
+	var got [][]string
+	r.Register(Command{Name: "echo", Aliases: []string{"say"}, Args: "<words...>", Handler: func(ctx *Context) error {
+		got = append(got, ctx.Args)
+		return ctx.Reply(strings.Join(ctx.Args, " "))
+	}})
+	r.Register(Command{Name: "fail", Handler: func(ctx *Context) error { return errors.New("broken") }})
+
+	sent := run(r,
+		`:alice!a@h PRIVMSG #chan :!echo hello "big world"`,
+		":alice!a@h PRIVMSG #chan :bot: SAY hi",
+		":alice!a@h PRIVMSG bot :echo query",
+		":alice!a@h PRIVMSG #chan :echo not a command",
+		":alice!a@h PRIVMSG #chan :!unknown",
+		":alice!a@h PRIVMSG #chan :!echo",
+		":alice!a@h PRIVMSG #chan :!fail",
+	)
+
+	expected := []string{
+		"PRIVMSG #chan :hello big world",
+		"PRIVMSG #chan :hi",
+		"PRIVMSG alice :query",
+		"PRIVMSG #chan :Usage: !echo <words...>",
+		"PRIVMSG #chan :Error: broken",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+	if len(got) != 3 || !reflect.DeepEqual(got[0], []string{"hello", "big world"}) {
+		t.Errorf("Unexpected arguments: %q", got)
+	}
+	if err := r.Register(Command{Name: "Say", Handler: func(*Context) error { return nil }}); err != ErrDuplicateCommand {
+		t.Errorf("Expected ErrDuplicateCommand, Received %v", err)
+	}
+}
+
+func TestRouterChanTypes(t *testing.T) {
+	r := NewRouter("!")
+	r.Register(Command{Name: "ping", Handler: func(ctx *Context) error { return ctx.Reply("pong") }})
+	sent := run(r,
+		":server 005 bot CHANTYPES=#~ :are supported",
+		":alice!a@h PRIVMSG ~chan :!ping",
+		":alice!a@h PRIVMSG &local :ping",
+	)
+	expected := []string{"PRIVMSG ~chan :pong", "PRIVMSG alice :pong"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
+
+func TestRouterPermissions(t *testing.T) {
+	r := NewRouter("!")
+	r.Authorize = func(ctx *Context, permission string) bool {
+		return ctx.Nick == "admin" && permission == "admin"
+	}
+	r.Register(Command{Name: "quit", Description: "Disconnects", Permission: "admin", Handler: func(ctx *Context) error {
+		return ctx.Reply("bye")
+	}})
+	r.Register(Command{Name: "ping", Handler: func(ctx *Context) error { return ctx.Reply("pong") }})
+
+	sent := run(r,
+		":alice!a@h PRIVMSG #chan :!quit",
+		":admin!a@h PRIVMSG #chan :!quit",
+		":alice!a@h PRIVMSG #chan :!help",
+		":admin!a@h PRIVMSG #chan :!help",
+		":alice!a@h PRIVMSG #chan :!help !quit",
+	)
+	expected := []string{
+		"PRIVMSG #chan :Permission denied",
+		"PRIVMSG #chan :bye",
+		"PRIVMSG #chan :Commands: !help !ping",
+		"PRIVMSG #chan :Commands: !help !ping !quit",
+		"PRIVMSG #chan :Usage: !quit - Disconnects",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
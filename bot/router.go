@@ -0,0 +1,259 @@
+/*
+Package bot provides a command framework for IRC bots.
+
+Commands are registered with a Router, which is attached to a client as
+a ClientHandler:
+
+	r := bot.NewRouter("!")
+	r.Register(bot.Command{
+		Name:        "kick",
+		Args:        "<nick> [reason...]",
+		Description: "Kicks a user from the channel",
+		Permission:  "op",
+		Handler:     kick,
+	})
+	client, err := irc.NewClient(addr, false, r.Handler)
+
+Commands are invoked with the prefix ("!kick bob"), by highlighting the
+bot ("bot: kick bob"), or in a query without either ("kick bob").
+*/
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oooska/irc"
+)
+
+//ErrDuplicateCommand is returned when registering a name or alias already in use
+var ErrDuplicateCommand = errors.New("bot: command already registered")
+
+//Command describes a bot command
+type Command struct {
+	Name        string
+	Aliases     []string
+	Args        string //Usage, e.g. "<nick> [reason...]". Determines the accepted argument count.
+	Description string
+	Permission  string //Required permission, checked with the Router's Authorize function
+	Handler     func(*Context) error
+
+	spec argSpec
+}
+
+//Usage returns the command's name followed by its arguments
+func (cmd Command) Usage() string {
+	return strings.TrimSpace(cmd.Name + " " + cmd.Args)
+}
+
+//Context is passed to command handlers
+type Context struct {
+	Client  irc.Client
+	Message irc.Message
+	Command *Command
+	Nick    string   //Nick of the user who invoked the command
+	Channel string   //Channel the command was invoked in, empty if in a query
+	Args    []string //Parsed arguments, excluding the command name
+	Line    string   //Unparsed arguments
+
+	router *Router
+}
+
+//Reply responds in the channel the command was used in, or to the user
+//in a query.
+func (ctx *Context) Reply(text string) error {
+	_, err := ctx.Client.PrivMsg(ctx.target(), text)
+	return err
+}
+
+//Replyf formats and sends a reply
+func (ctx *Context) Replyf(format string, args ...interface{}) error {
+	return ctx.Reply(fmt.Sprintf(format, args...))
+}
+
+//ReplyPrivately responds to the user with a notice
+func (ctx *Context) ReplyPrivately(text string) error {
+	_, err := ctx.Client.Notice(ctx.Nick, text)
+	return err
+}
+
+//Allowed reports whether the user may use commands requiring the permission
+func (ctx *Context) Allowed(permission string) bool {
+	if permission == "" || ctx.router.Authorize == nil {
+		return true
+	}
+	return ctx.router.Authorize(ctx, permission)
+}
+
+func (ctx *Context) target() string {
+	if ctx.Channel != "" {
+		return ctx.Channel
+	}
+	return ctx.Nick
+}
+
+//Router dispatches PRIVMSGs to registered commands
+type Router struct {
+	Prefix string
+	//Authorize is called for commands that require a permission. If nil,
+	//all commands are permitted.
+	Authorize func(ctx *Context, permission string) bool
+
+	mLock    *sync.RWMutex
+	commands map[string]*Command //Keyed by lowercase name and aliases
+}
+
+//NewRouter returns a Router for commands starting with prefix. A help
+//command is registered automatically.
+func NewRouter(prefix string) *Router {
+	r := &Router{Prefix: prefix, mLock: new(sync.RWMutex), commands: make(map[string]*Command)}
+	r.Register(Command{
+		Name:        "help",
+		Args:        "[command]",
+		Description: "Lists commands, or describes a command",
+		Handler:     r.help,
+	})
+	return r
+}
+
+//Register adds a command to the router
+func (r *Router) Register(cmd Command) error {
+	if cmd.Name == "" || cmd.Handler == nil {
+		return errors.New("bot: commands require a name and handler")
+	}
+	cmd.spec = parseArgSpec(cmd.Args)
+
+	r.mLock.Lock()
+	defer r.mLock.Unlock()
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if _, ok := r.commands[strings.ToLower(name)]; ok {
+			return ErrDuplicateCommand
+		}
+	}
+	for _, name := range names {
+		r.commands[strings.ToLower(name)] = &cmd
+	}
+	return nil
+}
+
+//Command returns the command registered with the name or alias
+func (r *Router) Command(name string) (*Command, bool) {
+	r.mLock.RLock()
+	defer r.mLock.RUnlock()
+	cmd, ok := r.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+//Commands returns the registered commands sorted by name
+func (r *Router) Commands() []*Command {
+	r.mLock.RLock()
+	defer r.mLock.RUnlock()
+	var cmds []*Command
+	for name, cmd := range r.commands {
+		if strings.EqualFold(name, cmd.Name) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+//Handler is a ClientHandler that dispatches commands sent to the client.
+//Commands are run from the connection's read loop, so long running
+//commands should start their own goroutine.
+func (r *Router) Handler(client irc.Client) {
+	client.AddHandler(irc.Incoming, func(msg irc.Message) {
+		r.Dispatch(client, msg)
+	}, "PRIVMSG")
+}
+
+//Dispatch runs the command in the PRIVMSG, if any. Returns true if the
+//message invoked a command.
+func (r *Router) Dispatch(client irc.Client, msg irc.Message) bool {
+	if len(msg.Params()) < 2 || msg.Nick() == "" {
+		return false
+	}
+	ctx := &Context{Client: client, Message: msg, Nick: msg.Nick(), router: r}
+	target := msg.Params()[0]
+	if irc.IsChannel(client, target) {
+		ctx.Channel = target
+	}
+
+	line, ok := r.commandLine(client.Nick(), msg.Trailing(), ctx.Channel == "")
+	if !ok {
+		return false
+	}
+	name := line
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, ctx.Line = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	cmd, ok := r.Command(name)
+	if !ok {
+		return false
+	}
+	ctx.Command = cmd
+
+	if !ctx.Allowed(cmd.Permission) {
+		ctx.Reply("Permission denied")
+		return true
+	}
+	args, err := SplitArgs(ctx.Line)
+	if err != nil || !cmd.spec.accepts(len(args)) {
+		ctx.Reply("Usage: " + r.Prefix + cmd.Usage())
+		return true
+	}
+	ctx.Args = args
+	if err := cmd.Handler(ctx); err != nil {
+		ctx.Reply("Error: " + err.Error())
+	}
+	return true
+}
+
+//commandLine returns the command and arguments in the text, if it invokes
+//a command by prefix or highlight. Messages in queries don't need either.
+func (r *Router) commandLine(nick, text string, query bool) (string, bool) {
+	text = strings.TrimSpace(text)
+	if r.Prefix != "" && strings.HasPrefix(text, r.Prefix) {
+		return strings.TrimSpace(text[len(r.Prefix):]), true
+	}
+	if nick != "" && len(text) > len(nick) && strings.EqualFold(text[:len(nick)], nick) {
+		switch text[len(nick)] {
+		case ':', ',':
+			return strings.TrimSpace(text[len(nick)+1:]), true
+		}
+	}
+	if query && text != "" && text[0] != '\x01' {
+		return text, true
+	}
+	return "", false
+}
+
+//help lists the commands the user may use, or describes a command
+func (r *Router) help(ctx *Context) error {
+	if len(ctx.Args) == 1 {
+		cmd, ok := r.Command(strings.TrimPrefix(ctx.Args[0], r.Prefix))
+		if !ok {
+			return ctx.Reply("Unknown command " + ctx.Args[0])
+		}
+		help := "Usage: " + r.Prefix + cmd.Usage()
+		if len(cmd.Aliases) > 0 {
+			help += " (aliases: " + strings.Join(cmd.Aliases, ", ") + ")"
+		}
+		if cmd.Description != "" {
+			help += " - " + cmd.Description
+		}
+		return ctx.Reply(help)
+	}
+
+	var names []string
+	for _, cmd := range r.Commands() {
+		if ctx.Allowed(cmd.Permission) {
+			names = append(names, r.Prefix+cmd.Name)
+		}
+	}
+	return ctx.Reply("Commands: " + strings.Join(names, " "))
+}
@@ -0,0 +1,194 @@
+/*
+Package bouncer implements an IRC bouncer (BNC). The bouncer stays
+connected to upstream networks on behalf of its users, and lets them
+attach any number of IRC clients (downstreams) to those connections.
+
+When a client attaches, the bouncer replays the welcome, the channels
+the upstream connection is in (JOIN, topic and names) and the buffered
+backlog. Everything received from upstream is sent to every attached
+client, and commands from clients are forwarded upstream. Clients too
+slow to keep up are disconnected rather than holding up the others.
+
+Clients authenticate with the connection password:
+
+	PASS username[@client]/network:password
+
+The network may be left out if the user only has one. Only the backlog
+received since a client last detached is replayed to it, so a user with
+several clients should give each one a name. Clients that negotiate
+server-time get the backlog tagged with the time it was received.
+*/
+package bouncer
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Errors returned when authenticating downstream clients
+var (
+	ErrBadLogin       = errors.New("bouncer: invalid username or password")
+	ErrUnknownNetwork = errors.New("bouncer: unknown network")
+)
+
+//ErrClosed is returned by Serve after Close
+var ErrClosed = errors.New("bouncer: closed")
+
+//Config configures the bouncer's users and networks
+type Config struct {
+	Users map[string]UserConfig //Keyed by username
+	//Time to wait before reconnecting to a network. Defaults to 10 seconds,
+	//doubling on each failure up to 5 minutes.
+	ReconnectDelay time.Duration
+}
+
+//UserConfig configures a bouncer user
+type UserConfig struct {
+	Password string
+	Networks map[string]NetworkConfig //Keyed by network name
+}
+
+//NetworkConfig configures an upstream network connection
+type NetworkConfig struct {
+	Address  string //host:port
+	SSL      bool
+	Password string //Server password (PASS), if any
+	Nick     string
+	Username string
+	Realname string
+	Channels []string //Joined after connecting
+}
+
+//Bouncer accepts downstream clients and relays them to upstream networks
+type Bouncer struct {
+	conf Config
+
+	mLock     *sync.Mutex
+	upstreams map[string]*upstream //Keyed by user/network
+	listeners []net.Listener
+	closed    bool
+}
+
+//New returns a bouncer for the configured users. Call Start to connect
+//to the upstream networks.
+func New(conf Config) *Bouncer {
+	if conf.ReconnectDelay == 0 {
+		conf.ReconnectDelay = 10 * time.Second
+	}
+	return &Bouncer{conf: conf, mLock: new(sync.Mutex), upstreams: make(map[string]*upstream)}
+}
+
+//Start connects to every user's networks. Connections are retried in
+//the background until the bouncer is closed.
+func (b *Bouncer) Start() {
+	b.mLock.Lock()
+	defer b.mLock.Unlock()
+	if b.closed {
+		return
+	}
+	for user, uc := range b.conf.Users {
+		for network, nc := range uc.Networks {
+			key := user + "/" + network
+			if _, ok := b.upstreams[key]; !ok {
+				up := newUpstream(network, nc, b.conf.ReconnectDelay)
+				b.upstreams[key] = up
+				go up.run()
+			}
+		}
+	}
+}
+
+//Serve accepts downstream clients on the listener until the bouncer is closed
+func (b *Bouncer) Serve(l net.Listener) error {
+	b.mLock.Lock()
+	if b.closed {
+		b.mLock.Unlock()
+		l.Close()
+		return ErrClosed
+	}
+	b.listeners = append(b.listeners, l)
+	b.mLock.Unlock()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if b.isClosed() {
+				return ErrClosed
+			}
+			return err
+		}
+		go b.ServeConn(c)
+	}
+}
+
+//ServeConn serves a single downstream client until it disconnects
+func (b *Bouncer) ServeConn(c net.Conn) {
+	if b.isClosed() {
+		c.Close()
+		return
+	}
+	newDownstream(b, c).run()
+}
+
+func (b *Bouncer) isClosed() bool {
+	b.mLock.Lock()
+	defer b.mLock.Unlock()
+	return b.closed
+}
+
+//Close disconnects from all networks and downstream clients
+func (b *Bouncer) Close() {
+	b.mLock.Lock()
+	b.closed = true
+	listeners := b.listeners
+	var ups []*upstream
+	for _, up := range b.upstreams {
+		ups = append(ups, up)
+	}
+	b.mLock.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	for _, up := range ups {
+		up.close()
+	}
+}
+
+//authenticate returns the upstream and client name for the PASS sent by
+//a downstream client
+func (b *Bouncer) authenticate(pass string) (*upstream, string, error) {
+	//username[@client]/network:password
+	creds := strings.SplitN(pass, ":", 2)
+	if len(creds) != 2 {
+		return nil, "", ErrBadLogin
+	}
+	login := strings.SplitN(creds[0], "/", 2)
+	client := ""
+	if i := strings.IndexByte(login[0], '@'); i >= 0 {
+		login[0], client = login[0][:i], login[0][i+1:]
+	}
+	uc, ok := b.conf.Users[login[0]]
+	if !ok || uc.Password == "" || uc.Password != creds[1] {
+		return nil, "", ErrBadLogin
+	}
+
+	network := ""
+	if len(login) > 1 {
+		network = login[1]
+	} else if len(uc.Networks) == 1 {
+		for name := range uc.Networks {
+			network = name
+		}
+	}
+
+	b.mLock.Lock()
+	defer b.mLock.Unlock()
+	up, ok := b.upstreams[login[0]+"/"+network]
+	if !ok {
+		return nil, "", ErrUnknownNetwork
+	}
+	return up, client, nil
+}
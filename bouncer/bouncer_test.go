@@ -0,0 +1,267 @@
+package bouncer
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/irctest"
+)
+
+const timeout = 2 * time.Second
+
+func newTestBouncer(t *testing.T) (*Bouncer, *irctest.Server, *irctest.Conn) {
+	s := irctest.NewServer()
+	b := New(Config{
+		ReconnectDelay: 10 * time.Millisecond,
+		Users: map[string]UserConfig{
+			"alice": {Password: "secret", Networks: map[string]NetworkConfig{
+				"test": {Address: s.Addr(), Nick: "alice", Username: "alice", Realname: "Alice", Channels: []string{"#chan"}},
+			}},
+		},
+	})
+	b.Start()
+	if _, err := s.WaitFor("JOIN", timeout); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := s.WaitForConn(1, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, s, conn
+}
+
+//attach connects a downstream client to the bouncer, sending the lines
+//before registering
+func attach(b *Bouncer, pass string, lines ...string) (irc.Conn, <-chan irc.Message) {
+	client, server := net.Pipe()
+	go b.ServeConn(server)
+	conn := irc.NewConnectionWrapper(client)
+	msgs := make(chan irc.Message, 100)
+	go func() {
+		defer close(msgs)
+		for {
+			msg, err := conn.Read()
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	for _, line := range lines {
+		conn.Write(irc.NewMessage(line))
+	}
+	conn.Write(irc.NewMessage("PASS " + pass))
+	conn.Write(irc.NickMessage("someone"))
+	conn.Write(irc.UserMessage("someone", "0", "*", ":Someone"))
+	return conn, msgs
+}
+
+func waitFor(t *testing.T, msgs <-chan irc.Message, command string, match func(irc.Message) bool) irc.Message {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				t.Fatalf("Connection closed while waiting for %s", command)
+			}
+			if msg.Command() == command && (match == nil || match(msg)) {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("Timed out waiting for %s", command)
+		}
+	}
+}
+
+func TestBouncerAttach(t *testing.T) {
+	b, s, upstream := newTestBouncer(t)
+	defer s.Close()
+	defer b.Close()
+
+	upstream.Send(":bob!bob@host PRIVMSG #chan :hello")
+
+	conn, msgs := attach(b, "alice/test:secret")
+	defer conn.Close()
+
+	welcome := waitFor(t, msgs, "001", nil)
+	if welcome.Params()[0] != "alice" {
+		t.Errorf("Expected the welcome to be for alice, got %s", welcome)
+	}
+	waitFor(t, msgs, "JOIN", nil)
+	waitFor(t, msgs, "PRIVMSG", func(msg irc.Message) bool { return msg.Trailing() == "hello" })
+
+	//Live messages are relayed
+	upstream.Send(":bob!bob@host PRIVMSG #chan :again")
+	waitFor(t, msgs, "PRIVMSG", func(msg irc.Message) bool { return msg.Trailing() == "again" })
+
+	//Messages are forwarded upstream
+	conn.Write(irc.PrivMessage("#chan", "hi there"))
+	if _, err := s.WaitFor("PRIVMSG", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	//Pings are answered by the bouncer
+	conn.Write(irc.NewMessage("PING :token"))
+	waitFor(t, msgs, "PONG", nil)
+}
+
+func TestBouncerEcho(t *testing.T) {
+	b, s, _ := newTestBouncer(t)
+	defer s.Close()
+	defer b.Close()
+
+	first, _ := attach(b, "alice:secret")
+	defer first.Close()
+	second, msgs := attach(b, "alice/test:secret")
+	defer second.Close()
+	waitFor(t, msgs, "001", nil)
+
+	first.Write(irc.NewMessage("@+draft/label=x PRIVMSG #chan :from first"))
+	msg := waitFor(t, msgs, "PRIVMSG", func(msg irc.Message) bool { return msg.Trailing() == "from first" })
+	if msg.Prefix() == "" || msg.Tags() != nil {
+		t.Errorf("Expected echoed message to have a prefix and no tags, got %s", msg)
+	}
+}
+
+func TestBouncerBadLogin(t *testing.T) {
+	b, s, _ := newTestBouncer(t)
+	defer s.Close()
+	defer b.Close()
+
+	for _, pass := range []string{"alice/test:wrong", "bob:secret", "alice/other:secret", ""} {
+		conn, msgs := attach(b, pass)
+		waitFor(t, msgs, "464", nil)
+		conn.Close()
+	}
+}
+
+func TestBouncerReconnect(t *testing.T) {
+	b, s, upstream := newTestBouncer(t)
+	defer s.Close()
+	defer b.Close()
+
+	upstream.Disconnect()
+	if _, err := s.WaitForConn(2, timeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//detached waits until no downstreams are attached to the upstream
+func detached(t *testing.T, up *upstream) {
+	deadline := time.Now().Add(timeout)
+	for {
+		up.mLock.Lock()
+		n := len(up.downstreams)
+		up.mLock.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the downstreams to detach")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBouncerBacklog(t *testing.T) {
+	b, s, upstream := newTestBouncer(t)
+	defer s.Close()
+	defer b.Close()
+
+	upstream.Send(":bob!bob@host PRIVMSG #chan :hello")
+	conn, msgs := attach(b, "alice/test:secret")
+	waitFor(t, msgs, "PRIVMSG", func(msg irc.Message) bool { return msg.Trailing() == "hello" })
+	conn.Close()
+	detached(t, b.upstreams["alice/test"])
+
+	//Only what was missed is replayed when reattaching
+	upstream.Send(":bob!bob@host PRIVMSG #chan :missed")
+	conn, msgs = attach(b, "alice/test:secret")
+	defer conn.Close()
+	msg := waitFor(t, msgs, "PRIVMSG", nil)
+	if msg.Trailing() != "missed" {
+		t.Errorf("Expected only the missed message, got %s", msg)
+	}
+	if msg.Tags() != nil {
+		t.Errorf("Expected no tags without server-time, got %s", msg)
+	}
+
+	//Another client gets the whole backlog, with server-time if requested
+	phone, msgs := attach(b, "alice@phone/test:secret", "CAP LS 302", "CAP REQ :server-time")
+	defer phone.Close()
+	waitFor(t, msgs, "CAP", func(msg irc.Message) bool { return param(msg, 1) == "ACK" })
+	phone.Write(irc.NewMessage("CAP END"))
+	for _, text := range []string{"hello", "missed"} {
+		msg := waitFor(t, msgs, "PRIVMSG", nil)
+		if msg.Trailing() != text {
+			t.Errorf("Expected %q, got %s", text, msg)
+		}
+		if _, err := time.Parse(serverTimeFormat, msg.Tags()["time"]); err != nil {
+			t.Errorf("Expected a server-time tag, got %s", msg)
+		}
+	}
+}
+
+func TestBouncerSlowClient(t *testing.T) {
+	b, s, upstream := newTestBouncer(t)
+	defer s.Close()
+	defer b.Close()
+
+	//A client that never reads
+	slow, server := net.Pipe()
+	defer slow.Close()
+	go b.ServeConn(server)
+	io.WriteString(slow, "PASS alice@slow/test:secret\r\nNICK slow\r\nUSER slow 0 * :Slow\r\n")
+
+	conn, msgs := attach(b, "alice/test:secret")
+	defer conn.Close()
+	waitFor(t, msgs, "001", nil)
+
+	//The other clients keep receiving messages, and the slow one is dropped
+	lines := make([]string, 50)
+	for i := range lines {
+		lines[i] = ":bob!bob@host PRIVMSG #chan :flood " + strconv.Itoa(i)
+	}
+	for sent := 0; sent <= sendQueueLength; sent += len(lines) {
+		upstream.Send(lines...)
+		waitFor(t, msgs, "PRIVMSG", func(msg irc.Message) bool { return msg.Trailing() == "flood 49" })
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, slow)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(timeout):
+		t.Error("Expected the slow client to be disconnected")
+	}
+}
+
+func TestUpstreamCloseStopsReconnecting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	up := newUpstream("test", NetworkConfig{Address: addr, Nick: "alice"}, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		up.run()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	up.close()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Error("Expected closing to stop waiting to reconnect")
+	}
+}
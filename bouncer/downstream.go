@@ -0,0 +1,285 @@
+package bouncer
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oooska/irc"
+)
+
+var errNotConnected = errors.New("bouncer: not connected to the network")
+
+//Capabilities supported by the bouncer
+var downstreamCaps = map[string]bool{"server-time": true}
+
+const (
+	serverTimeFormat = "2006-01-02T15:04:05.000Z"
+	sendQueueLength  = 512 //Lines queued for a client before it is disconnected
+)
+
+//downstream is a client attached to the bouncer
+type downstream struct {
+	bouncer *Bouncer
+	conn    irc.Conn
+	nick    string
+	pass    string
+	user    string
+	client  string //Name from the login, to track the backlog it has seen
+	up      *upstream
+
+	capNeg     bool //CAP negotiation in progress
+	serverTime bool //server-time was negotiated
+
+	out       chan irc.Message //Lines waiting for writeLoop
+	done      chan struct{}    //Closed by close
+	closeOnce sync.Once
+}
+
+func newDownstream(b *Bouncer, c net.Conn) *downstream {
+	d := &downstream{bouncer: b, conn: irc.NewConnectionWrapper(c),
+		out: make(chan irc.Message, sendQueueLength), done: make(chan struct{})}
+	go d.writeLoop()
+	return d
+}
+
+//writeLoop writes the queued lines until the client is closed, then
+//writes what is left and disconnects it
+func (d *downstream) writeLoop() {
+	defer d.conn.Close()
+	for {
+		select {
+		case msg := <-d.out:
+			if err := d.conn.Write(msg); err != nil {
+				d.close()
+				return
+			}
+		case <-d.done:
+			for {
+				select {
+				case msg := <-d.out:
+					d.conn.Write(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *downstream) run() {
+	defer d.close()
+	for {
+		msg, err := d.conn.Read()
+		if err != nil {
+			return
+		}
+		if d.up == nil {
+			if !d.register(msg) {
+				return
+			}
+			continue
+		}
+
+		switch msg.Command() {
+		case "PING":
+			d.send(irc.NewMessage(":bouncer PONG bouncer :" + strings.TrimPrefix(param(msg, 0), ":")))
+		case "PONG", "CAP", "USER", "PASS":
+		case "QUIT":
+			//Detach without disconnecting from the network
+			return
+		case "PRIVMSG", "NOTICE":
+			if err := d.up.send(msg); err != nil {
+				d.send(irc.NewMessage(":bouncer NOTICE * :" + err.Error()))
+				continue
+			}
+			//Let the other attached clients see what was said
+			d.up.mLock.Lock()
+			client := d.up.client
+			d.up.mLock.Unlock()
+			if client != nil {
+				prefix := client.Hostmask()
+				if prefix == "" {
+					prefix = client.Nick()
+				}
+				d.up.broadcast(irc.NewMessage(":"+prefix+" "+withoutTags(msg.String())), d)
+			}
+		default:
+			if err := d.up.send(msg); err != nil {
+				d.send(irc.NewMessage(":bouncer NOTICE * :" + err.Error()))
+			}
+		}
+	}
+}
+
+//register handles messages until the client has sent PASS, NICK and USER.
+//Returns false if the client should be disconnected.
+func (d *downstream) register(msg irc.Message) bool {
+	switch msg.Command() {
+	case "PASS":
+		d.pass = strings.TrimPrefix(param(msg, 0), ":")
+	case "NICK":
+		d.nick = strings.TrimPrefix(param(msg, 0), ":")
+	case "USER":
+		d.user = param(msg, 0)
+	case "CAP":
+		d.handleCap(msg)
+	case "PING":
+		d.send(irc.NewMessage(":bouncer PONG bouncer :" + strings.TrimPrefix(param(msg, 0), ":")))
+	case "QUIT":
+		return false
+	}
+	if d.nick == "" || d.user == "" || d.capNeg {
+		return true
+	}
+
+	up, client, err := d.bouncer.authenticate(d.pass)
+	if err != nil {
+		d.send(irc.NewMessage(":bouncer 464 " + d.nick + " :" + err.Error()))
+		d.send(irc.NewMessage("ERROR :" + err.Error()))
+		return false
+	}
+	d.up, d.client = up, client
+	d.attach()
+	return true
+}
+
+func (d *downstream) handleCap(msg irc.Message) {
+	nick := d.nick
+	if nick == "" {
+		nick = "*"
+	}
+	switch strings.ToUpper(param(msg, 0)) {
+	case "LS":
+		d.capNeg = true
+		d.send(irc.NewMessage(":bouncer CAP " + nick + " LS :server-time"))
+	case "REQ":
+		d.capNeg = true
+		requested := strings.Fields(msg.Trailing())
+		for _, r := range requested {
+			if !downstreamCaps[strings.TrimPrefix(r, "-")] {
+				d.send(irc.NewMessage(":bouncer CAP " + nick + " NAK :" + msg.Trailing()))
+				return
+			}
+		}
+		for _, r := range requested {
+			if r == "server-time" || r == "-server-time" {
+				d.serverTime = r == "server-time"
+			}
+		}
+		d.send(irc.NewMessage(":bouncer CAP " + nick + " ACK :" + msg.Trailing()))
+	case "END":
+		d.capNeg = false
+	}
+}
+
+//attach adds the client to the upstream, and replays the current state
+func (d *downstream) attach() {
+	client, welcome, backlog := d.up.attach(d)
+	if client == nil {
+		d.send(irc.NewMessage(":bouncer 001 " + d.nick + " :Welcome to the bouncer. Not connected to " + d.up.network + " yet."))
+		d.send(irc.NewMessage(":bouncer 422 " + d.nick + " :MOTD File is missing"))
+		d.replay(backlog)
+		return
+	}
+
+	nick := client.Nick()
+	if d.nick != nick {
+		d.send(irc.NewMessage(":" + d.nick + " NICK :" + nick))
+		d.nick = nick
+	}
+	for _, msg := range welcome {
+		d.send(msg)
+	}
+	d.send(irc.NewMessage(":bouncer 422 " + nick + " :MOTD File is missing"))
+
+	mask := client.Hostmask()
+	if mask == "" {
+		mask = nick
+	}
+	for _, ch := range client.ChannelNames() {
+		d.send(irc.NewMessage(":" + mask + " JOIN " + ch))
+		if topic, _ := client.Topic(ch); topic != "" {
+			d.send(irc.NewMessage(":bouncer 332 " + nick + " " + ch + " :" + topic))
+		}
+		//Prefix users with their highest rank, e.g. @nick for ops
+		users, _ := client.Users(ch)
+		modes, symbols := irc.Prefixes(client)
+		for i, user := range users {
+			if m, _ := client.UserModes(ch, user); m != "" {
+				if j := strings.IndexByte(modes, m[0]); j >= 0 {
+					users[i] = symbols[j:j+1] + user
+				}
+			}
+		}
+		for len(users) > 0 {
+			n := 20
+			if n > len(users) {
+				n = len(users)
+			}
+			d.send(irc.NewMessage(":bouncer 353 " + nick + " = " + ch + " :" + strings.Join(users[:n], " ")))
+			users = users[n:]
+		}
+		d.send(irc.NewMessage(":bouncer 366 " + nick + " " + ch + " :End of /NAMES list."))
+	}
+	d.replay(backlog)
+}
+
+//replay sends the backlog, tagged with the time each message was
+//recieved if the client negotiated server-time
+func (d *downstream) replay(backlog []irc.Message) {
+	for _, msg := range backlog {
+		line := withoutTags(msg.Message())
+		if d.serverTime {
+			line = "@time=" + msg.Timestamp().UTC().Format(serverTimeFormat) + " " + line
+		}
+		d.send(irc.NewMessage(line))
+	}
+}
+
+//withoutTags removes the message tags from the start of a line
+func withoutTags(line string) string {
+	if strings.HasPrefix(line, "@") {
+		line = line[strings.IndexByte(line+" ", ' ')+1:]
+	}
+	return line
+}
+
+//send queues the message for the client. A client that falls
+//sendQueueLength lines behind is disconnected, so it can't hold up the
+//network connection shared with the other clients.
+func (d *downstream) send(msg irc.Message) {
+	select {
+	case d.out <- msg:
+	case <-d.done:
+	default:
+		log.Printf("bouncer: disconnecting a client that fell behind")
+		d.drop()
+	}
+}
+
+//close detaches the client, and disconnects it once the queued lines
+//are written
+func (d *downstream) close() {
+	d.closeOnce.Do(func() {
+		if d.up != nil {
+			d.up.detach(d)
+		}
+		close(d.done)
+	})
+}
+
+//drop disconnects the client without waiting for the queued lines
+func (d *downstream) drop() {
+	d.close()
+	d.conn.Close()
+}
+
+func param(msg irc.Message, n int) string {
+	if n >= len(msg.Params()) {
+		return ""
+	}
+	return msg.Params()[n]
+}
@@ -0,0 +1,244 @@
+package bouncer
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+const (
+	maxReconnectDelay = 5 * time.Minute
+	backlogLength     = 1024 //PRIVMSGs kept to replay to attaching clients
+)
+
+//Numerics sent during registration that are replayed to new downstreams
+var welcomeNumerics = map[string]bool{"001": true, "002": true, "003": true, "004": true, "005": true}
+
+//upstream is the bouncer's connection to a network for a user
+type upstream struct {
+	network string
+	conf    NetworkConfig
+	delay   time.Duration
+
+	mLock       *sync.Mutex
+	client      irc.Client
+	welcome     []irc.Message
+	registered  bool
+	downstreams map[*downstream]bool
+	closed      bool
+	done        chan struct{} //Closed by close
+
+	backlog  []irc.Message        //PRIVMSGs, kept across reconnections
+	lastSeen map[string]time.Time //When each client, by name, last detached
+}
+
+func newUpstream(network string, conf NetworkConfig, delay time.Duration) *upstream {
+	return &upstream{network: network, conf: conf, delay: delay, mLock: new(sync.Mutex),
+		downstreams: make(map[*downstream]bool), lastSeen: make(map[string]time.Time), done: make(chan struct{})}
+}
+
+//run connects to the network, reconnecting with backoff until closed
+func (up *upstream) run() {
+	delay := up.delay
+	for {
+		if up.isClosed() {
+			return
+		}
+		connected := time.Now()
+		err := up.connect()
+		if up.isClosed() {
+			return
+		}
+		log.Printf("bouncer: disconnected from %s: %v", up.network, err)
+		up.broadcast(irc.NewMessage(":bouncer NOTICE * :Disconnected from " + up.network + ", reconnecting"))
+
+		if time.Since(connected) > maxReconnectDelay {
+			delay = up.delay
+		}
+		select {
+		case <-time.After(delay):
+		case <-up.done:
+			return
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+//connect connects and registers with the network, and relays messages
+//until the connection is lost
+func (up *upstream) connect() error {
+	client, err := irc.NewClient(up.conf.Address, up.conf.SSL)
+	if err != nil {
+		return err
+	}
+
+	up.mLock.Lock()
+	if up.closed {
+		up.mLock.Unlock()
+		client.Close()
+		return nil
+	}
+	up.client = client
+	up.welcome = nil
+	up.registered = false
+	up.mLock.Unlock()
+
+	defer func() {
+		up.mLock.Lock()
+		up.client = nil
+		up.registered = false
+		up.mLock.Unlock()
+		client.Close()
+	}()
+
+	if up.conf.Password != "" {
+		client.Send(irc.NewMessage("PASS " + up.conf.Password))
+	}
+	client.Send(irc.NickMessage(up.conf.Nick), irc.UserMessage(up.conf.Username, "0", "*", ":"+up.conf.Realname))
+
+	for {
+		msg, err := client.Read()
+		if err != nil {
+			return err
+		}
+		up.handle(client, msg)
+	}
+}
+
+func (up *upstream) handle(client irc.Client, msg irc.Message) {
+	switch msg.Command() {
+	case "PING", "PONG":
+		return //Answered by the client
+	case "433":
+		//Nick in use during registration. Try another.
+		up.mLock.Lock()
+		registered := up.registered
+		up.mLock.Unlock()
+		if !registered {
+			client.Send(irc.NickMessage(client.Nick() + "_"))
+			return
+		}
+	case "001":
+		up.mLock.Lock()
+		up.registered = true
+		up.mLock.Unlock()
+		if len(up.conf.Channels) > 0 {
+			client.Send(irc.JoinMessage(strings.Join(up.conf.Channels, ",")))
+		}
+	}
+
+	if welcomeNumerics[msg.Command()] {
+		up.mLock.Lock()
+		up.welcome = append(up.welcome, msg)
+		up.mLock.Unlock()
+	}
+	up.broadcast(msg)
+}
+
+//broadcast sends the message to all attached downstreams except those
+//excluded, and adds PRIVMSGs to the backlog
+func (up *upstream) broadcast(msg irc.Message, except ...*downstream) {
+	up.mLock.Lock()
+	if inBacklog(msg) {
+		if up.backlog = append(up.backlog, msg); len(up.backlog) > backlogLength {
+			up.backlog = up.backlog[1:]
+		}
+	}
+	var targets []*downstream
+	for d := range up.downstreams {
+		targets = append(targets, d)
+	}
+	up.mLock.Unlock()
+
+	for _, d := range targets {
+		skip := false
+		for _, e := range except {
+			skip = skip || e == d
+		}
+		if !skip {
+			d.send(msg)
+		}
+	}
+}
+
+//inBacklog returns whether the message is part of a conversation. CTCP
+//requests other than ACTION aren't.
+func inBacklog(msg irc.Message) bool {
+	if msg.Command() != "PRIVMSG" || len(msg.Params()) == 0 {
+		return false
+	}
+	ctcp, ok := irc.ParseCTCP(msg)
+	return !ok || ctcp.Reply || ctcp.Command == "ACTION"
+}
+
+//attach adds a downstream, returning the client, the welcome messages
+//and the backlog the downstream hasn't seen. The client is nil if not
+//registered with the network yet.
+func (up *upstream) attach(d *downstream) (irc.Client, []irc.Message, []irc.Message) {
+	up.mLock.Lock()
+	defer up.mLock.Unlock()
+	up.downstreams[d] = true
+	seen := up.lastSeen[d.client]
+	i := len(up.backlog)
+	for i > 0 && up.backlog[i-1].Timestamp().After(seen) {
+		i--
+	}
+	backlog := append([]irc.Message(nil), up.backlog[i:]...)
+	if !up.registered {
+		return nil, nil, backlog
+	}
+	return up.client, append([]irc.Message(nil), up.welcome...), backlog
+}
+
+//detach removes a downstream, remembering when its client last saw the backlog
+func (up *upstream) detach(d *downstream) {
+	up.mLock.Lock()
+	delete(up.downstreams, d)
+	up.lastSeen[d.client] = time.Now()
+	up.mLock.Unlock()
+}
+
+//send forwards messages from a downstream to the network
+func (up *upstream) send(msgs ...irc.Message) error {
+	up.mLock.Lock()
+	client := up.client
+	up.mLock.Unlock()
+	if client == nil {
+		return errNotConnected
+	}
+	_, err := client.Send(msgs...)
+	return err
+}
+
+func (up *upstream) isClosed() bool {
+	up.mLock.Lock()
+	defer up.mLock.Unlock()
+	return up.closed
+}
+
+func (up *upstream) close() {
+	up.mLock.Lock()
+	if !up.closed {
+		close(up.done)
+	}
+	up.closed = true
+	client := up.client
+	var downs []*downstream
+	for d := range up.downstreams {
+		downs = append(downs, d)
+	}
+	up.mLock.Unlock()
+
+	if client != nil {
+		client.Send(irc.NewMessage("QUIT :Bouncer shutting down"))
+		client.Close()
+	}
+	for _, d := range downs {
+		d.drop()
+	}
+}
@@ -0,0 +1,122 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	rplWhoisAccount = "330"
+	rplWhoSpcRpl    = "354"
+
+	//Token used to recognise replies to WhoAccountsMessage
+	whoAccountsToken = "152"
+)
+
+//Accounts keeps track of the services accounts other users are logged
+//in to. It learns them from the account-tag, account-notify and
+//extended-join capabilities, WHOX replies and WHOIS.
+//
+//Accounts are forgotten when a user quits or leaves a channel, as
+//changes are no longer reported by the server.
+type Accounts interface {
+	//Account returns the account the nick is logged in to, or an empty
+	//string if not logged in. The bool is false if the account is unknown.
+	Account(nick string) (account string, known bool)
+}
+
+type accounts struct {
+	m     map[string]string //Keyed by casefolded nick
+	mLock *sync.RWMutex
+}
+
+func (a accounts) Account(nick string) (string, bool) {
+	a.mLock.RLock()
+	defer a.mLock.RUnlock()
+	account, ok := a.m[casefold(nick)]
+	return account, ok
+}
+
+func (a accounts) set(nick, account string) {
+	if nick == "" {
+		return
+	}
+	if account == "*" || account == "0" {
+		account = ""
+	}
+	a.mLock.Lock()
+	a.m[casefold(nick)] = account
+	a.mLock.Unlock()
+}
+
+func (a accounts) forget(nick string) {
+	a.mLock.Lock()
+	delete(a.m, casefold(nick))
+	a.mLock.Unlock()
+}
+
+//WhoAccountsMessage returns a WHOX request for the accounts of everyone
+//in a channel, for servers that advertise WHOX. Replies are used by
+//the Accounts handler.
+func WhoAccountsMessage(channel string) Message {
+	return NewMessage("WHO " + channel + " %tna," + whoAccountsToken)
+}
+
+//RegisterAccountsHandler keeps track of the accounts users are logged in
+//to, and returns an Accounts object.
+func RegisterAccountsHandler(c Conn) Accounts {
+	accts := newAccounts()
+	c.AddHandler(Incoming, accts.handle)
+	return accts
+}
+
+func newAccounts() accounts {
+	return accounts{m: make(map[string]string), mLock: new(sync.RWMutex)}
+}
+
+//handle updates the accounts from an incoming message
+func (accts accounts) handle(msg Message) {
+	params := msg.Params()
+	//@account=name :nick!user@host PRIVMSG #chan :hi
+	if account, ok := msg.Tags()["account"]; ok && msg.Nick() != "" {
+		accts.set(msg.Nick(), account)
+	}
+
+	switch msg.Command() {
+	case "ACCOUNT":
+		//:nick!user@host ACCOUNT accountname
+		if len(params) > 0 {
+			accts.set(msg.Nick(), strings.TrimPrefix(params[0], ":"))
+		}
+	case "JOIN":
+		//extended-join: :nick!user@host JOIN #chan account :realname
+		if len(params) > 2 {
+			accts.set(msg.Nick(), params[1])
+		}
+	case "NICK":
+		if len(params) > 0 {
+			if account, ok := accts.Account(msg.Nick()); ok {
+				accts.set(strings.TrimPrefix(params[0], ":"), account)
+			} else {
+				accts.forget(strings.TrimPrefix(params[0], ":"))
+			}
+			accts.forget(msg.Nick())
+		}
+	case "QUIT", "PART":
+		accts.forget(msg.Nick())
+	case "KICK":
+		if len(params) > 1 {
+			accts.forget(params[1])
+		}
+	case rplWhoisAccount:
+		//:server 330 me nick account :is logged in as
+		if len(params) > 2 {
+			accts.set(params[1], params[2])
+		}
+	case rplWhoSpcRpl:
+		//:server 354 me 152 nick account
+		if len(params) > 3 && params[1] == whoAccountsToken {
+			accts.set(params[2], strings.TrimPrefix(params[3], ":"))
+		}
+	}
+}
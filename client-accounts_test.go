@@ -0,0 +1,37 @@
+package irc
+
+import "testing"
+
+func TestAccountsHandler(t *testing.T) {
+	conn, _ := newBufferConn(
+		":alice!a@h JOIN #chan alice :Alice",
+		":bob!b@h JOIN #chan * :Bob",
+		"@account=carol :carol!c@h PRIVMSG #chan :hi",
+		":server 354 me 152 dave dave",
+		":server 354 me 152 erin 0",
+		":alice!a@h NICK alice2",
+		":bob!b@h ACCOUNT bob",
+		":carol!c@h QUIT :bye",
+		":server 330 me frank frankacct :is logged in as",
+	)
+	accounts := RegisterAccountsHandler(conn)
+	readAll(conn)
+
+	tests := []struct {
+		nick, account string
+		known         bool
+	}{
+		{"alice", "", false},
+		{"ALICE2", "alice", true},
+		{"bob", "bob", true},
+		{"carol", "", false},
+		{"dave", "dave", true},
+		{"erin", "", true},
+		{"frank", "frankacct", true},
+	}
+	for _, test := range tests {
+		if account, known := accounts.Account(test.nick); account != test.account || known != test.known {
+			t.Errorf("Account(%s): Expected %q %t, Received %q %t", test.nick, test.account, test.known, account, known)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+//Capabilities keeps track of the IRCv3 capabilities offered by the
+//server (CAP LS) and those that have been enabled (CAP ACK).
+type Capabilities interface {
+	//HasCap returns true if the capability has been acknowledged by the server
+	HasCap(name string) bool
+	//CapValue returns the value the server advertised for a capability
+	//(e.g. "PLAIN,EXTERNAL" for sasl). The bool is false if not advertised.
+	CapValue(name string) (string, bool)
+}
+
+type capabilities struct {
+	available map[string]string
+	enabled   map[string]bool
+	mLock     *sync.RWMutex
+}
+
+func newCapabilities() capabilities {
+	return capabilities{available: make(map[string]string), enabled: make(map[string]bool), mLock: new(sync.RWMutex)}
+}
+
+func (c capabilities) HasCap(name string) bool {
+	c.mLock.RLock()
+	defer c.mLock.RUnlock()
+	return c.enabled[name]
+}
+
+func (c capabilities) CapValue(name string) (string, bool) {
+	c.mLock.RLock()
+	defer c.mLock.RUnlock()
+	v, ok := c.available[name]
+	return v, ok
+}
+
+//RegisterCapsHandler listens for CAP replies from the server and returns a
+//Capabilities object. It does not request any capabilities itself.
+func RegisterCapsHandler(c Conn) Capabilities {
+	caps := newCapabilities()
+	handler := func(msg Message) {
+		//:server CAP nick LS * :multi-prefix sasl=PLAIN
+		params := msg.Params()
+		if len(params) < 3 {
+			return
+		}
+		caps.mLock.Lock()
+		defer caps.mLock.Unlock()
+		for _, capability := range strings.Fields(msg.Trailing()) {
+			kv := strings.SplitN(capability, "=", 2)
+			name := kv[0]
+			switch strings.ToUpper(params[1]) {
+			case "LS", "NEW":
+				if len(kv) > 1 {
+					caps.available[name] = kv[1]
+				} else {
+					caps.available[name] = ""
+				}
+			case "DEL":
+				delete(caps.available, name)
+				delete(caps.enabled, name)
+			case "ACK", "LIST":
+				if strings.HasPrefix(name, "-") {
+					delete(caps.enabled, name[1:])
+				} else {
+					caps.enabled[name] = true
+				}
+			}
+		}
+	}
+	c.AddHandler(Incoming, handler, "CAP")
+	return caps
+}
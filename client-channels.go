@@ -3,16 +3,48 @@ package irc
 import (
 	"errors"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 //ErrChannelDNE is returned when the specified channel does not exist
 var ErrChannelDNE = errors.New("Channel Does Not Exist")
 
+//ErrListNotLoaded is returned when a channel's mode list hasn't been fetched
+var ErrListNotLoaded = errors.New("Mode List Not Loaded")
+
+//ListEntry is an entry in a channel's ban, exception or invite list
+type ListEntry struct {
+	Mask  string
+	SetBy string    //Empty if unknown
+	SetAt time.Time //Zero if unknown
+}
+
+//modeList is the cached entries of a list mode
+type modeList struct {
+	entries   []ListEntry
+	loaded    bool //The whole list has been received
+	receiving bool //Replies are being received
+}
+
 //Channels represents the list of channels the client is currently
 //connected to, and the users present in those channels
 type Channels interface {
 	Users(channel string) (users []string, err error)
+	Topic(channel string) (topic string, err error)
+	//UserModes returns the membership modes (e.g. "o" for an op) the user
+	//has in the channel, highest rank first.
+	UserModes(channel, user string) (modes string, err error)
+	//ModeList returns the entries of a list mode (bans, exceptions, invite
+	//exceptions, etc) for the channel. Returns ErrListNotLoaded if the list
+	//hasn't been fetched with ModeListMessage.
+	ModeList(channel string, mode byte) (entries []ListEntry, err error)
+	//ChannelModes returns the channel's modes, without their parameters and
+	//excluding list and membership modes (e.g. "mnt"). Modes are known once
+	//the server has replied to "MODE #channel", or have been changed since
+	//joining.
+	ChannelModes(channel string) (modes string, err error)
 	ChannelNames() (channels []string)
 	NumChannels() int
 }
@@ -22,12 +54,17 @@ type Channels interface {
 type userList map[string]string
 
 type channels struct {
-	m     map[string]userList
-	mLock *sync.RWMutex
+	m      map[string]userList
+	topics map[string]string
+	lists  map[string]map[byte]*modeList
+	modes  map[string]map[byte]bool
+	mLock  *sync.RWMutex
 }
 
 func newChannels() channels {
-	return channels{m: make(map[string]userList), mLock: new(sync.RWMutex)}
+	return channels{m: make(map[string]userList), topics: make(map[string]string),
+		lists: make(map[string]map[byte]*modeList), modes: make(map[string]map[byte]bool),
+		mLock: new(sync.RWMutex)}
 }
 
 //Creates an empty channel.
@@ -41,6 +78,9 @@ func (c channels) Add(channel string) {
 func (c channels) Remove(channel string) {
 	c.mLock.Lock()
 	delete(c.m, channel)
+	delete(c.topics, channel)
+	delete(c.lists, channel)
+	delete(c.modes, channel)
 	c.mLock.Unlock()
 }
 
@@ -83,6 +123,53 @@ func (c channels) UserQuits(user string) {
 	c.mLock.Unlock()
 }
 
+//Renames a user in all channels, keeping their modes
+func (c channels) UserRenames(user, nick string) {
+	c.mLock.Lock()
+	for _, ul := range c.m {
+		if modes, ok := ul[user]; ok {
+			delete(ul, user)
+			ul[nick] = modes
+		}
+	}
+	c.mLock.Unlock()
+}
+
+//Sets the membership modes of a user already in the channel, ordered by
+//rank according to prefixModes.
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) SetUserModes(channel, user, modes, prefixModes string) error {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	ul, ok := c.m[channel]
+	if !ok {
+		return ErrChannelDNE
+	}
+	if _, ok := ul[user]; ok {
+		ordered := ""
+		for _, m := range prefixModes {
+			if strings.ContainsRune(modes, m) {
+				ordered += string(m)
+			}
+		}
+		ul[user] = ordered
+	}
+	return nil
+}
+
+//Returns the membership modes of a user in a channel, or an empty string
+//if they have none or aren't in the channel.
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) UserModes(channel, user string) (string, error) {
+	c.mLock.RLock()
+	defer c.mLock.RUnlock()
+	ul, ok := c.m[channel]
+	if !ok {
+		return "", ErrChannelDNE
+	}
+	return ul[user], nil
+}
+
 //Returns a sorted slice containing the users in a given channel.
 //Returns an empty slice if no channel exists
 //The bool value is true if the room exists, false otherwise
@@ -103,6 +190,163 @@ func (c channels) Users(channel string) ([]string, error) {
 	return []string{}, ErrChannelDNE
 }
 
+//Sets the topic of the specified channel.
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) SetTopic(channel, topic string) error {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	if _, ok := c.m[channel]; ok {
+		c.topics[channel] = topic
+		return nil
+	}
+	return ErrChannelDNE
+}
+
+//Returns the topic of the specified channel, or an empty string if none is set.
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) Topic(channel string) (string, error) {
+	c.mLock.RLock()
+	defer c.mLock.RUnlock()
+	if _, ok := c.m[channel]; ok {
+		return c.topics[channel], nil
+	}
+	return "", ErrChannelDNE
+}
+
+//Replaces the modes of the specified channel, after a reply to
+//"MODE #channel".
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) SetChannelModes(channel, modes string) error {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	if _, ok := c.m[channel]; !ok {
+		return ErrChannelDNE
+	}
+	c.modes[channel] = make(map[byte]bool)
+	for i := 0; i < len(modes); i++ {
+		c.modes[channel][modes[i]] = true
+	}
+	return nil
+}
+
+//Sets or unsets a mode of the specified channel after a MODE change.
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) ChannelModeChange(channel string, mode byte, set bool) error {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	if _, ok := c.m[channel]; !ok {
+		return ErrChannelDNE
+	}
+	if c.modes[channel] == nil {
+		c.modes[channel] = make(map[byte]bool)
+	}
+	if set {
+		c.modes[channel][mode] = true
+	} else {
+		delete(c.modes[channel], mode)
+	}
+	return nil
+}
+
+//Returns the sorted modes of the specified channel.
+//Returns ErrChannelDNE if channel does not exist
+func (c channels) ChannelModes(channel string) (string, error) {
+	c.mLock.RLock()
+	defer c.mLock.RUnlock()
+	if _, ok := c.m[channel]; !ok {
+		return "", ErrChannelDNE
+	}
+	modes := make([]byte, 0, len(c.modes[channel]))
+	for mode := range c.modes[channel] {
+		modes = append(modes, mode)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i] < modes[j] })
+	return string(modes), nil
+}
+
+//Returns the entries of a channel's list mode.
+//Returns ErrChannelDNE if channel does not exist, or ErrListNotLoaded if the
+//list hasn't been received
+func (c channels) ModeList(channel string, mode byte) ([]ListEntry, error) {
+	c.mLock.RLock()
+	defer c.mLock.RUnlock()
+	if _, ok := c.m[channel]; !ok {
+		return nil, ErrChannelDNE
+	}
+	list := c.lists[channel][mode]
+	if list == nil || !list.loaded {
+		return nil, ErrListNotLoaded
+	}
+	return append([]ListEntry(nil), list.entries...), nil
+}
+
+//Adds an entry received in reply to a list request. The first entry
+//replaces the cached list.
+func (c channels) ListReply(channel string, mode byte, entry ListEntry) {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	list := c.modeList(channel, mode)
+	if list == nil {
+		return
+	}
+	if !list.receiving {
+		list.entries = nil
+		list.receiving = true
+	}
+	list.entries = append(list.entries, entry)
+}
+
+//Marks the list as completely received
+func (c channels) ListEnd(channel string, mode byte) {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	list := c.modeList(channel, mode)
+	if list == nil {
+		return
+	}
+	if !list.receiving {
+		list.entries = nil //Empty list
+	}
+	list.receiving = false
+	list.loaded = true
+}
+
+//Adds or removes an entry after a MODE change
+func (c channels) ListChange(channel string, mode byte, set bool, entry ListEntry) {
+	c.mLock.Lock()
+	defer c.mLock.Unlock()
+	list := c.modeList(channel, mode)
+	if list == nil {
+		return
+	}
+	for i, e := range list.entries {
+		if casefold(e.Mask) == casefold(entry.Mask) {
+			list.entries = append(list.entries[:i], list.entries[i+1:]...)
+			break
+		}
+	}
+	if set {
+		list.entries = append(list.entries, entry)
+	}
+}
+
+//modeList returns the list, creating it if needed. Returns nil if the
+//channel doesn't exist. mLock must be held.
+func (c channels) modeList(channel string, mode byte) *modeList {
+	if _, ok := c.m[channel]; !ok {
+		return nil
+	}
+	lists := c.lists[channel]
+	if lists == nil {
+		lists = make(map[byte]*modeList)
+		c.lists[channel] = lists
+	}
+	if lists[mode] == nil {
+		lists[mode] = &modeList{}
+	}
+	return lists[mode]
+}
+
 //Returns the number of open channels
 func (c channels) NumChannels() int {
 	c.mLock.RLock()
@@ -1,6 +1,7 @@
 package irc
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -158,3 +159,68 @@ func TestChannelUserList_users(t *testing.T) {
 	}
 
 }
+
+func TestChannelsHandlerTopic(t *testing.T) {
+	conn, _ := newBufferConn(
+		"JOIN #chan",
+		"JOIN #other",
+		":server 332 me #chan :the topic",
+		":server 331 me #other :No topic is set",
+		":a!b@c TOPIC #other :new topic",
+		":a!b@c TOPIC #chan :",
+	)
+	channels := RegisterChannelsHandler(conn)
+	readAll(conn)
+
+	if topic, _ := channels.Topic("#other"); topic != "new topic" {
+		t.Errorf("Expected topic to be updated by TOPIC. Received: %q", topic)
+	}
+	if topic, _ := channels.Topic("#chan"); topic != "" {
+		t.Errorf("Expected topic to be cleared. Received: %q", topic)
+	}
+}
+
+func TestChannelsHandlerModes(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 005 me PREFIX=(qov)~@+ :are supported",
+		"JOIN #chan",
+		":server 353 me = #chan :me ~@owner @op +voice plain!u@h",
+		":server 366 me #chan :End of /NAMES list.",
+		":op!u@h MODE #chan +v-o+l op op 10",
+		":op!u@h MODE #chan +o plain",
+		":plain!u@h NICK renamed",
+		":op!u@h KICK #chan voice :bye",
+	)
+	channels := RegisterChannelsHandler(conn)
+	readAll(conn)
+
+	users, _ := channels.Users("#chan")
+	expected := []string{"me", "op", "owner", "renamed"}
+	if !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected users %v, Received %v", expected, users)
+	}
+	modes := map[string]string{"me": "", "owner": "qo", "op": "v", "renamed": "o"}
+	for user, expected := range modes {
+		if m, _ := channels.UserModes("#chan", user); m != expected {
+			t.Errorf("Expected %s to have modes %q, Received %q", user, expected, m)
+		}
+	}
+}
+
+func TestChannelsHandlerChannelModes(t *testing.T) {
+	conn, _ := newBufferConn(
+		"JOIN #chan",
+		":server 324 me #chan +ntk key",
+		":op!u@h MODE #chan +ml-k+bo 5 key *!*@h me",
+		":op!u@h MODE #chan -n",
+	)
+	channels := RegisterChannelsHandler(conn)
+	readAll(conn)
+
+	if modes, err := channels.ChannelModes("#chan"); modes != "lmt" || err != nil {
+		t.Errorf("Expected modes \"lmt\", Received %q (%v)", modes, err)
+	}
+	if _, err := channels.ChannelModes("#other"); err != ErrChannelDNE {
+		t.Errorf("Expected ErrChannelDNE, Received %v", err)
+	}
+}
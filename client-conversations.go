@@ -1,6 +1,9 @@
 package irc
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 func newConversations(length int) conversations {
 	return conversations{messages: make(map[string][]string), mLock: new(sync.RWMutex), length: length}
@@ -9,6 +12,7 @@ func newConversations(length int) conversations {
 //Conversations keeps track of the last 'length' privmessages to a channel
 type Conversations interface {
 	Messages(string) []string
+	ConversationNames() []string
 }
 
 type conversations struct {
@@ -36,3 +40,15 @@ func (c conversations) Messages(ch string) []string {
 	c.mLock.RUnlock()
 	return messages
 }
+
+//Returns a sorted list of the channels and nicks with logged messages
+func (c conversations) ConversationNames() []string {
+	c.mLock.RLock()
+	names := make([]string, 0, len(c.messages))
+	for name := range c.messages {
+		names = append(names, name)
+	}
+	c.mLock.RUnlock()
+	sort.Strings(names)
+	return names
+}
@@ -62,3 +62,21 @@ func TestConversations(t *testing.T) {
 	}
 
 }
+
+func TestConversationsHandler(t *testing.T) {
+	conn, _ := newBufferConn(
+		":a!b@c PRIVMSG #chan :hello",
+		":a!b@c PRIVMSG #chan :\x01VERSION\x01",
+		":a!b@c PRIVMSG #chan :\x01ACTION waves\x01",
+	)
+	convos := RegisterConversationsHandler(conn)
+	readAll(conn)
+
+	messages := convos.Messages("#chan")
+	if len(messages) != 2 {
+		t.Fatalf("CTCP requests should not be logged. Expected 2 messages, Received: %+v", messages)
+	}
+	if messages[1] != ":a!b@c PRIVMSG #chan :\x01ACTION waves\x01" {
+		t.Errorf("ACTIONs should be logged. Received: %q", messages[1])
+	}
+}
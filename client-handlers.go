@@ -4,6 +4,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 )
 
 /* Client Handlers are functions that add useful functionality to
@@ -15,8 +16,11 @@ import (
 type ClientHandler func(Client)
 
 const (
-	rplName       = "353"
-	rplEndofNames = "366"
+	rplChannelModeIs = "324"
+	rplNoTopic       = "331"
+	rplTopic         = "332"
+	rplName          = "353"
+	rplEndofNames    = "366"
 )
 
 //LogHandler logs all messages to the default logger
@@ -28,7 +32,7 @@ func LogHandler(client Client) {
 }
 
 func conversationHandler(client *clientImpl) {
-	convo := RegisterConversationsHandler(client)
+	convo := registerConversationsHandler(client, client.ISupport, client.events)
 	client.Conversations = convo
 }
 
@@ -36,14 +40,66 @@ func conversationHandler(client *clientImpl) {
 //with the connection and returns a Conversations object to access
 //captured data.
 func RegisterConversationsHandler(c Conn) Conversations {
+	return registerConversationsHandler(c, nil, nil)
+}
+
+//registerConversationsHandler also emits message events, if ev isn't nil,
+//using the client's ISupport
+func registerConversationsHandler(c Conn, is ISupport, ev *events) Conversations {
 	convos := newConversations(1024)
 	handler := func(msg Message) {
-		convos.Add(msg.Params()[0], msg.Message())
+		//CTCP requests other than ACTION aren't part of the conversation
+		if len(msg.Params()) > 0 && !isCTCPRequest(msg) {
+			convos.Add(msg.Params()[0], msg.Message())
+		}
 	}
 	c.AddHandler(Both, handler, "PRIVMSG")
+	if ev != nil {
+		events := func(msg Message) {
+			if e, ok := messageEvent(is, msg); ok {
+				ev.emit(e)
+			}
+		}
+		c.AddHandler(Incoming, events, "PRIVMSG", "NOTICE")
+	}
 	return convos
 }
 
+//Registers the events handler to a fullclient, emitting the events not
+//emitted by the other handlers.
+func eventsHandler(client *clientImpl) {
+	handler := func(msg Message) {
+		client.events.handle(client.ISupport, msg)
+	}
+	client.AddHandler(Incoming, handler, rplWelcome, "INVITE", "JOIN", "PART", "KICK", "NICK", "MODE", "TOPIC")
+}
+
+//Registers the ISUPPORT handler to a fullclient, and sets the
+//ISupport object.
+func isupportHandler(client *clientImpl) {
+	client.ISupport = RegisterISupportHandler(client)
+}
+
+//Registers the accounts handler to a fullclient as a state handler, and
+//sets the Accounts object.
+func accountsHandler(client *clientImpl) {
+	accts := newAccounts()
+	client.AddHandler(IncomingState, accts.handle)
+	client.Accounts = accts
+}
+
+//Registers the identity handler to a fullclient, and sets the
+//identity object.
+func identityHandler(client *clientImpl) {
+	client.Identity = RegisterIdentityHandler(client)
+}
+
+//Registers the capabilities handler to a fullclient, and sets the
+//capabilities object.
+func capsHandler(client *clientImpl) {
+	client.Capabilities = RegisterCapsHandler(client)
+}
+
 //PingHandler registers a handler to respond to pings
 func pingHandler(client Client) {
 	handler := func(msg Message) {
@@ -60,16 +116,34 @@ func pingHandler(client Client) {
 
 //Registers the channels handler to a fullclient, and sets the
 //channels object.
+//Incoming lines are handled as state, so they update the channels even
+//when interceptors drop them.
 func channelHandler(client *clientImpl) {
-	ch := RegisterChannelsHandler(client)
+	ch, handler := newChannelsHandler(client.ISupport)
+	client.AddHandler(Outgoing, handler, channelsBothCommands...)
+	client.AddHandler(IncomingState, handler, append(channelsBothCommands, channelsIncomingCommands...)...)
 	client.Channels = ch
 }
 
-//RegisterChannelsHandler keeps track of which rooms you're in, and who else is in those channels
-//Returns a Channels object.
-//TODO: Keep track of modes / other pertinent data
-//TODO: Listen for nick changes
+//Commands handled by the channels handler
+var (
+	channelsBothCommands     = []string{"JOIN", "PART", "KICK", "QUIT", "NAMES"}
+	channelsIncomingCommands = []string{"NICK", "MODE", rplChannelModeIs, "TOPIC", rplNoTopic, rplTopic, rplName, rplEndofNames,
+		rplBanList, rplEndOfBanList, rplExceptList, rplEndOfExceptList, rplInviteList, rplEndOfInviteList,
+		rplQuietList, rplEndOfQuietList}
+)
+
+//RegisterChannelsHandler keeps track of which rooms you're in, who else is in those channels
+//and their membership modes (op, voice, etc). Returns a Channels object.
 func RegisterChannelsHandler(c Conn) Channels {
+	ch, handler := newChannelsHandler(RegisterISupportHandler(c))
+	c.AddHandler(Both, handler, channelsBothCommands...)
+	c.AddHandler(Incoming, handler, channelsIncomingCommands...)
+	return ch
+}
+
+//newChannelsHandler returns the Channels and the handler updating them
+func newChannelsHandler(is ISupport) (Channels, MessageHandler) {
 	cul := newChannels()
 	namesUpdating := make(map[string]bool) //Keeps track of rplName/rplEndofNames
 	namesUpdatingLock := new(sync.Mutex)
@@ -77,34 +151,110 @@ func RegisterChannelsHandler(c Conn) Channels {
 		switch msg.Command() {
 		case "JOIN":
 			if len(msg.Params()) > 0 {
-				if msg.Nick() == "" {
-					//JOIN #room
-					cul.Add(msg.Params()[0])
-
-					//ANeed to note that a /names update is coming for this channel
-					namesUpdatingLock.Lock()
-					namesUpdating[msg.Params()[0]] = true
-					namesUpdatingLock.Unlock()
-				} else {
-					//nick JOIN #room
-					cul.UserJoins(msg.Params()[0], msg.Nick())
+				//JOIN #room1,#room2 may join several channels
+				for _, ch := range strings.Split(msg.Params()[0], ",") {
+					if msg.Nick() == "" {
+						//JOIN #room
+						cul.Add(ch)
+
+						//ANeed to note that a /names update is coming for this channel
+						namesUpdatingLock.Lock()
+						namesUpdating[ch] = true
+						namesUpdatingLock.Unlock()
+					} else {
+						//nick JOIN #room
+						cul.UserJoins(ch, msg.Nick())
+					}
 				}
 			} //else malformed request - ignoring
 		case "PART":
 			if len(msg.Params()) > 0 {
-				if msg.Nick() == "" {
-					//PART #room
-					cul.Remove(msg.Params()[0])
-				} else {
-					//nick PART #channel :reason
-					cul.UserParts(msg.Params()[0], msg.Nick())
+				for _, ch := range strings.Split(msg.Params()[0], ",") {
+					if msg.Nick() == "" {
+						//PART #room
+						cul.Remove(ch)
+					} else {
+						//nick PART #channel :reason
+						cul.UserParts(ch, msg.Nick())
+					}
 				}
 			} //else malformed request - ignoring
 		case "KICK":
-			if msg.Nick() != "" && len(msg.Params()) > 0 {
-				cul.UserParts(msg.Params()[0], msg.Nick())
+			//:nick!user@host KICK #channel kicked :reason
+			if msg.Nick() != "" && len(msg.Params()) > 1 {
+				cul.UserParts(msg.Params()[0], msg.Params()[1])
 				//TODO: Determine if it was the client that got kicked
 			}
+		case "NICK":
+			//:old!user@host NICK new
+			if msg.Nick() != "" && len(msg.Params()) > 0 {
+				cul.UserRenames(msg.Nick(), strings.TrimPrefix(msg.Params()[0], ":"))
+			}
+		case "MODE":
+			//:nick!user@host MODE #channel +o-v alice bob
+			params := msg.Params()
+			if len(params) < 2 || !IsChannel(is, params[0]) {
+				return
+			}
+			prefixModes, _ := Prefixes(is)
+			changes := ParseModeChanges(is, strings.TrimPrefix(params[1], ":"), params[2:])
+			for _, change := range changes {
+				if strings.IndexByte(prefixModes, change.Mode) < 0 || change.Param == "" {
+					continue
+				}
+				modes, err := cul.UserModes(params[0], change.Param)
+				if err != nil {
+					continue
+				}
+				if change.Set {
+					modes += string(change.Mode)
+				} else {
+					modes = strings.Replace(modes, string(change.Mode), "", -1)
+				}
+				cul.SetUserModes(params[0], change.Param, modes, prefixModes)
+			}
+			//List modes (bans, etc)
+			listModes, _, _, _ := ChanModes(is)
+			for _, change := range changes {
+				if strings.IndexByte(listModes, change.Mode) >= 0 && change.Param != "" {
+					cul.ListChange(params[0], change.Mode, change.Set, ListEntry{Mask: change.Param, SetBy: strings.TrimPrefix(msg.Prefix(), ":"), SetAt: time.Now()})
+				}
+			}
+			//Channel modes (moderated, key, etc)
+			for _, change := range changes {
+				if strings.IndexByte(listModes+prefixModes, change.Mode) < 0 {
+					cul.ChannelModeChange(params[0], change.Mode, change.Set)
+				}
+			}
+		case rplChannelModeIs:
+			//:server 324 me #channel +ntk key
+			if params := msg.Params(); len(params) > 2 {
+				modes := ""
+				for _, change := range ParseModeChanges(is, strings.TrimPrefix(params[2], ":"), params[3:]) {
+					if change.Set {
+						modes += string(change.Mode)
+					}
+				}
+				cul.SetChannelModes(params[1], modes)
+			}
+		case rplBanList, rplExceptList, rplInviteList:
+			//:server 367 me #channel mask setter 1500000000
+			if params := msg.Params(); len(params) > 2 {
+				cul.ListReply(params[1], listNumerics[msg.Command()], listEntry(params[2:]))
+			}
+		case rplEndOfBanList, rplEndOfExceptList, rplEndOfInviteList:
+			if params := msg.Params(); len(params) > 1 {
+				cul.ListEnd(params[1], listNumerics[msg.Command()])
+			}
+		case rplQuietList:
+			//:server 728 me #channel q mask setter 1500000000
+			if params := msg.Params(); len(params) > 3 && len(params[2]) == 1 {
+				cul.ListReply(params[1], params[2][0], listEntry(params[3:]))
+			}
+		case rplEndOfQuietList:
+			if params := msg.Params(); len(params) > 2 && len(params[2]) == 1 {
+				cul.ListEnd(params[1], params[2][0])
+			}
 		case "QUIT":
 			if msg.Nick() == "" {
 				//Client is quitting, empty channel list
@@ -133,11 +283,38 @@ func RegisterChannelsHandler(c Conn) Channels {
 				if updating {
 					//Only update names if we're requesting the info
 					//from a /names #channel or /join command
-					names := strings.Split(msg.Trailing(), " ")
-					cul.UserJoins(ch, names...)
+					prefixModes, symbols := Prefixes(is)
+					for _, name := range strings.Fields(msg.Trailing()) {
+						//multi-prefix sends all of a user's prefixes
+						modes := ""
+						for len(name) > 0 && strings.IndexByte(symbols, name[0]) >= 0 {
+							modes += string(prefixModes[strings.IndexByte(symbols, name[0])])
+							name = name[1:]
+						}
+						//userhost-in-names sends nick!user@host
+						if i := strings.IndexByte(name, '!'); i >= 0 {
+							name = name[:i]
+						}
+						cul.UserJoins(ch, name)
+						cul.SetUserModes(ch, name, modes, prefixModes)
+					}
 				}
 
 			}
+		case "TOPIC":
+			//:nick!user@host TOPIC #channel :new topic
+			if msg.Nick() != "" && len(msg.Params()) > 1 {
+				cul.SetTopic(msg.Params()[0], msg.Trailing())
+			}
+		case rplTopic:
+			//:server 332 nick #channel :topic
+			if len(msg.Params()) > 2 {
+				cul.SetTopic(msg.Params()[1], msg.Trailing())
+			}
+		case rplNoTopic:
+			if len(msg.Params()) > 1 {
+				cul.SetTopic(msg.Params()[1], "")
+			}
 		case rplEndofNames:
 			//:tepper.freenode.net 366 goirctest #gotest :End of /NAMES list.
 			namesUpdatingLock.Lock()
@@ -148,7 +325,5 @@ func RegisterChannelsHandler(c Conn) Channels {
 			}
 		}
 	}
-	c.AddHandler(Both, handler, "JOIN", "PART", "KICK", "QUIT", "NAMES")
-	c.AddHandler(Incoming, handler, rplName, rplEndofNames)
-	return Channels(cul)
+	return Channels(cul), handler
 }
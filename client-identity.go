@@ -0,0 +1,124 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	rplWelcome    = "001"
+	rplHostHidden = "396"
+)
+
+//Identity keeps track of the nick the client is using, and the
+//hostmask (nick!user@host) other users see it as.
+type Identity interface {
+	Nick() string
+	Hostmask() string
+}
+
+type identity struct {
+	nick       string
+	user       string
+	host       string
+	registered bool
+	mLock      *sync.RWMutex
+}
+
+func newIdentity() *identity {
+	return &identity{mLock: new(sync.RWMutex)}
+}
+
+//Nick returns the current nick of the client. Before registration
+//completes, this is the last nick requested.
+func (i *identity) Nick() string {
+	i.mLock.RLock()
+	defer i.mLock.RUnlock()
+	return i.nick
+}
+
+//Hostmask returns nick!user@host as seen by other users, or an empty
+//string if the server has not told us what our user and host are.
+func (i *identity) Hostmask() string {
+	i.mLock.RLock()
+	defer i.mLock.RUnlock()
+	if i.nick == "" || i.user == "" || i.host == "" {
+		return ""
+	}
+	return i.nick + "!" + i.user + "@" + i.host
+}
+
+//isSelf returns true if the nick is the client's nick
+func (i *identity) isSelf(nick string) bool {
+	return nick != "" && strings.EqualFold(nick, i.Nick())
+}
+
+func (i *identity) setNick(nick string) {
+	i.mLock.Lock()
+	i.nick = nick
+	i.mLock.Unlock()
+}
+
+func (i *identity) setUserHost(user, host string) {
+	i.mLock.Lock()
+	if user != "" {
+		i.user = user
+	}
+	if host != "" {
+		i.host = host
+	}
+	i.mLock.Unlock()
+}
+
+//RegisterIdentityHandler keeps track of the nick and hostmask the server
+//knows the client as. Returns an Identity object.
+func RegisterIdentityHandler(c Conn) Identity {
+	id := newIdentity()
+	outgoing := func(msg Message) {
+		//Until the server accepts the nick, assume the requested one
+		id.mLock.RLock()
+		registered := id.registered
+		id.mLock.RUnlock()
+		if !registered && len(msg.Params()) > 0 {
+			id.setNick(msg.Params()[0])
+		}
+	}
+
+	incoming := func(msg Message) {
+		switch msg.Command() {
+		case rplWelcome:
+			//:server 001 nick :Welcome to the Internet Relay Network nick!user@host
+			if len(msg.Params()) > 0 {
+				id.setNick(msg.Params()[0])
+			}
+			id.mLock.Lock()
+			id.registered = true
+			id.mLock.Unlock()
+			fields := strings.Fields(msg.Trailing())
+			if len(fields) > 0 {
+				mask := NewMessage(":" + fields[len(fields)-1] + " PING")
+				if id.isSelf(mask.Nick()) {
+					id.setUserHost(mask.User(), mask.Host())
+				}
+			}
+		case rplHostHidden:
+			//:server 396 nick host :is now your displayed host
+			if len(msg.Params()) > 1 {
+				id.setUserHost("", msg.Params()[1])
+			}
+		case "NICK":
+			if id.isSelf(msg.Nick()) && len(msg.Params()) > 0 {
+				id.setNick(strings.TrimPrefix(msg.Params()[0], ":"))
+			}
+		}
+
+		//Messages echoed back to us (JOIN, etc) contain our user and host
+		if id.isSelf(msg.Nick()) {
+			id.setUserHost(msg.User(), msg.Host())
+		}
+	}
+
+	c.AddHandler(Outgoing, outgoing, "NICK")
+	c.AddHandler(Incoming, incoming)
+	return id
+}
@@ -0,0 +1,67 @@
+package irc
+
+import "testing"
+
+func TestIdentity(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 433 * gotest :Nickname is already in use",
+		":server 001 gotest_ :Welcome to the Internet Relay Network gotest_!~go@1.2.3.4",
+	)
+	id := RegisterIdentityHandler(conn)
+
+	conn.Write(NickMessage("gotest"))
+	if id.Nick() != "gotest" {
+		t.Errorf("Nick() did not return the requested nick. Expected: gotest, Received: %s", id.Nick())
+	}
+	if id.Hostmask() != "" {
+		t.Errorf("Hostmask() should be empty before registration. Received: %s", id.Hostmask())
+	}
+
+	conn.Write(NickMessage("gotest_"))
+	readAll(conn)
+	if id.Nick() != "gotest_" {
+		t.Errorf("Nick() did not return the nick from 001. Expected: gotest_, Received: %s", id.Nick())
+	}
+	if id.Hostmask() != "gotest_!~go@1.2.3.4" {
+		t.Errorf("Hostmask() did not return the mask from 001. Expected: gotest_!~go@1.2.3.4, Received: %s", id.Hostmask())
+	}
+
+	conn, _ = newBufferConn(
+		":server 001 gotest :Welcome to the network",
+		":gotest!~go@5.6.7.8 JOIN #gotest",
+		":gotest!~go@5.6.7.8 NICK :gotest2",
+		":server 396 gotest2 user/gotest :is now your displayed host",
+	)
+	id = RegisterIdentityHandler(conn)
+	readAll(conn)
+	if id.Hostmask() != "gotest2!~go@user/gotest" {
+		t.Errorf("Hostmask() did not track nick and host changes. Expected: gotest2!~go@user/gotest, Received: %s", id.Hostmask())
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL",
+		":server CAP * LS :draft/multiline=max-bytes=4096,max-lines=24",
+		":server CAP * ACK :multi-prefix draft/multiline",
+		":server CAP * ACK :-multi-prefix",
+	)
+	caps := RegisterCapsHandler(conn)
+	readAll(conn)
+
+	if v, ok := caps.CapValue("sasl"); !ok || v != "PLAIN,EXTERNAL" {
+		t.Errorf("CapValue(sasl) returned the wrong value. Received: %s, %t", v, ok)
+	}
+	if v, _ := caps.CapValue(capMultiline); v != "max-bytes=4096,max-lines=24" {
+		t.Errorf("CapValue(draft/multiline) returned the wrong value. Received: %s", v)
+	}
+	if caps.HasCap("multi-prefix") {
+		t.Error("HasCap(multi-prefix) returned true after the capability was removed")
+	}
+	if !caps.HasCap(capMultiline) {
+		t.Error("HasCap(draft/multiline) returned false after the capability was acknowledged")
+	}
+	if caps.HasCap("sasl") {
+		t.Error("HasCap(sasl) returned true for a capability that was not acknowledged")
+	}
+}
@@ -0,0 +1,127 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	rplISupport = "005"
+
+	defaultPrefix = "(ov)@+"
+)
+
+//ISupport keeps track of the features the server advertises in
+//RPL_ISUPPORT (005), such as PREFIX, CHANTYPES and CHANMODES.
+type ISupport interface {
+	//Supports returns the value of the token (e.g. "(ov)@+" for PREFIX).
+	//The bool is false if the server has not advertised it.
+	Supports(token string) (string, bool)
+}
+
+type isupport struct {
+	tokens map[string]string
+	mLock  *sync.RWMutex
+}
+
+func (is isupport) Supports(token string) (string, bool) {
+	is.mLock.RLock()
+	defer is.mLock.RUnlock()
+	v, ok := is.tokens[strings.ToUpper(token)]
+	return v, ok
+}
+
+//RegisterISupportHandler keeps track of the tokens sent in RPL_ISUPPORT
+//and returns an ISupport object.
+func RegisterISupportHandler(c Conn) ISupport {
+	is := isupport{tokens: make(map[string]string), mLock: new(sync.RWMutex)}
+	handler := func(msg Message) {
+		//:server 005 nick PREFIX=(ov)@+ -EXCEPTS NETWORK=Example :are supported by this server
+		params := msg.Params()
+		if len(params) < 3 {
+			return
+		}
+		is.mLock.Lock()
+		defer is.mLock.Unlock()
+		for _, token := range params[1 : len(params)-1] {
+			kv := strings.SplitN(token, "=", 2)
+			name := strings.ToUpper(kv[0])
+			switch {
+			case strings.HasPrefix(name, "-"):
+				delete(is.tokens, name[1:])
+			case len(kv) > 1:
+				is.tokens[name] = unescapeISupport(kv[1])
+			default:
+				is.tokens[name] = ""
+			}
+		}
+	}
+	c.AddHandler(Incoming, handler, rplISupport)
+	return is
+}
+
+//unescapeISupport replaces \xHH escapes in ISUPPORT values
+func unescapeISupport(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' && isHex(value[i+2]) && isHex(value[i+3]) {
+			b.WriteByte(unhex(value[i+2])<<4 | unhex(value[i+3]))
+			i += 3
+			continue
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+//Prefixes returns the channel membership modes (e.g. "ov") and their
+//symbols (e.g. "@+") from the PREFIX token, highest rank first.
+func Prefixes(is ISupport) (modes, symbols string) {
+	prefix, ok := is.Supports("PREFIX")
+	if !ok {
+		prefix = defaultPrefix
+	}
+	i := strings.IndexByte(prefix, ')')
+	if !strings.HasPrefix(prefix, "(") || i < 0 || len(prefix)-i-1 != i-1 {
+		return "", ""
+	}
+	return prefix[1:i], prefix[i+1:]
+}
+
+//ChanModes returns the four types of channel modes from the CHANMODES
+//token: modes that are lists (A), always take a parameter (B), take a
+//parameter only when set (C), and never take a parameter (D).
+func ChanModes(is ISupport) (a, b, c, d string) {
+	chanmodes, _ := is.Supports("CHANMODES")
+	types := strings.SplitN(chanmodes, ",", 4)
+	for len(types) < 4 {
+		types = append(types, "")
+	}
+	if chanmodes == "" {
+		types[0], types[1], types[2], types[3] = "b", "k", "l", "imnpst"
+	}
+	return types[0], types[1], types[2], types[3]
+}
+
+//IsChannel reports whether the target is a channel according to the
+//server's CHANTYPES.
+func IsChannel(is ISupport, target string) bool {
+	chantypes, ok := is.Supports("CHANTYPES")
+	if !ok {
+		chantypes = "#&"
+	}
+	return target != "" && strings.IndexByte(chantypes, target[0]) >= 0
+}
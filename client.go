@@ -5,38 +5,64 @@ package irc
 //who else is in those channels, modes for users, etc.
 type Client interface {
 	Send(...Message) (int, error)
+	PrivMsg(target, text string) (int, error)
+	Notice(target, text string) (int, error)
 	Conn
 	Channels
 	Conversations
+	Identity
+	Capabilities
+	ISupport
+	Accounts
+	Events
 }
 
 const (
 	Incoming handlerDirection = iota
 	Outgoing
 	Both
+	//IncomingState handlers keep state, such as the channels a client is
+	//in. They are called for every incoming line before the interceptors,
+	//so they also see the lines interceptors drop.
+	IncomingState
 )
 
 //NewClient returns a basic IRC client interface with
 //the ability to Read and Write messages to a server,
 //as well as add
+//To fail over between the servers of a network, use ServerList.
 func NewClient(serverAddress string, useSSL bool, handlers ...ClientHandler) (Client, error) {
 	conn, err := NewConnection(serverAddress, useSSL)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewClientWrapper(conn, handlers...), nil
+}
+
+//NewClientWrapper returns a Client using an existing Conn. Useful for
+//connections made with NewConnectionWrapper or connection options.
+func NewClientWrapper(conn Conn, handlers ...ClientHandler) Client {
+	ev := newEvents()
 	c := clientImpl{
-		Conn: conn,
+		Conn:   conn,
+		Events: ev,
+		events: ev,
 	}
+	identityHandler(&c)
+	capsHandler(&c)
+	isupportHandler(&c)
 	channelHandler(&c)
+	accountsHandler(&c)
 	conversationHandler(&c)
+	eventsHandler(&c)
 	pingHandler(&c)
 
 	for _, h := range handlers {
 		h(&c)
 	}
 
-	return &c, nil
+	return &c
 }
 
 //LiteClient implements the LiteClient interface
@@ -44,27 +70,55 @@ type clientImpl struct {
 	Conn
 	Channels
 	Conversations
+	Identity
+	Capabilities
+	ISupport
+	Accounts
+	Events
+
+	events *events
 }
 
 //Send sends all of the supplied messages to the server.
 //Returns the number of successfully sent messages. It
 //stops sending and returns the first error message recieved
 func (c clientImpl) Send(msgs ...Message) (int, error) {
-	var k int
-	var msg Message
-	for k, msg = range msgs {
+	for k, msg := range msgs {
 		err := c.Write(msg)
 		if err != nil {
-			return k - 1, err
+			return k, err
 		}
 	}
-	return k, nil
+	return len(msgs), nil
+}
+
+//PrivMsg sends text to the target as one or more PRIVMSGs, splitting it so
+//the server won't truncate it. If the draft/multiline capability has been
+//enabled, the text is sent as a multiline batch.
+//Returns the number of messages sent, and the first error recieved.
+func (c clientImpl) PrivMsg(target, text string) (int, error) {
+	return c.Send(c.textMessages("PRIVMSG", target, text)...)
+}
+
+//Notice sends text to the target as one or more NOTICEs, splitting it the
+//same way as PrivMsg.
+func (c clientImpl) Notice(target, text string) (int, error) {
+	return c.Send(c.textMessages("NOTICE", target, text)...)
+}
+
+func (c clientImpl) textMessages(command, target, text string) []Message {
+	if c.HasCap(capMultiline) {
+		value, _ := c.CapValue(capMultiline)
+		maxBytes, maxLines := multilineLimits(value)
+		return MultilineMessages(command, target, text, c.Hostmask(), maxBytes, maxLines)
+	}
+	return SplitMessages(command, target, text, c.Hostmask())
 }
 
 //Closes the connection to the iRC server. It does not
 //send a QUIT message.
 func (c *clientImpl) Close() {
 	if c != nil {
-		c.Close()
+		c.Conn.Close()
 	}
 }
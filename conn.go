@@ -4,26 +4,35 @@ import (
 	"bufio"
 	"crypto/tls"
 	"io"
+	"log"
 	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
-/****The SSL implementation is currently insecure. ***
+/*
+***The SSL implementation is currently insecure. ***
 
 Conn represents a connection to an IRC server. It provides
 methods to read, write and close a connection.
 
 MessageHandlers can be added to the Conn, and will be called anytime
 a message is sent or recieved if it matches the specified criteria.
+Interceptors can be added to rewrite, drop or inject messages before
+the MessageHandlers are called.
 
 A NewConnectionWrapper method is provided to allow you to provide
-your own implementation of net.Conn (e.g. for a websocket)*/
+your own implementation of net.Conn (e.g. a websocket.Conn from the
+websocket package)
+*/
 type Conn interface {
 	Read() (Message, error)
 	Write(Message) error
 	Close()
 
 	AddHandler(dir handlerDirection, mh MessageHandler, cmds ...string)
+	AddInterceptor(dir handlerDirection, i Interceptor)
 }
 
 //MessageHandler are functions that will be called by a client upon
@@ -37,37 +46,74 @@ const (
 	msgHandlerKey = "*" //key for general handler (triggered on all messages)
 )
 
-//NewConnection returns a new IRC Conn object
-//TODO: The SSL implementation is currently insecure.
-func NewConnection(serverAddress string, useSSL bool) (Conn, error) {
-	var c net.Conn
-	var err error
+//ConnOption configures optional behaviour of a Conn
+type ConnOption func(*conn)
 
-	if useSSL {
-		conf := tls.Config{ //TODO: Fix insecure SSL connection
-			InsecureSkipVerify: true,
-		}
-		c, err = tls.Dial("tcp", serverAddress, &conf)
-	} else {
-		c, err = net.Dial("tcp", serverAddress)
+//NewConnection returns a new IRC Conn object. The connection is made
+//with the Dialer option if given, e.g. through a proxy, and TLS is
+//layered on top of it if useSSL is true.
+//TODO: The SSL implementation is insecure unless TLSConfig is used.
+func NewConnection(serverAddress string, useSSL bool, opts ...ConnOption) (Conn, error) {
+	ircConn := newConn(opts...)
+	dial := ircConn.dial
+	if dial == nil {
+		dial = net.Dial
 	}
-
+	c, err := dial("tcp", serverAddress)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewConnectionWrapper(c), nil
+	if useSSL {
+		conf := &tls.Config{ //TODO: Fix insecure SSL connection
+			InsecureSkipVerify: true,
+		}
+		if ircConn.tlsConfig != nil {
+			conf = ircConn.tlsConfig.Clone()
+		}
+		if conf.ServerName == "" {
+			conf.ServerName, _, _ = net.SplitHostPort(serverAddress)
+		}
+		tc := tls.Client(c, conf)
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+
+	ircConn.setConn(c)
+	return ircConn, nil
 }
 
 //NewConnectionWrapper provides a new IRC Conn object using
 //the specified input stream. Useful for websockets or other
 //connectivity methods
-func NewConnectionWrapper(c io.ReadWriteCloser) Conn {
-	return &conn{conn: c,
-		scanner:          bufio.NewScanner(c),
+func NewConnectionWrapper(c io.ReadWriteCloser, opts ...ConnOption) Conn {
+	ircConn := newConn(opts...)
+	ircConn.setConn(c)
+	return ircConn
+}
+
+//newConn returns a conn with the options applied, without a connection
+func newConn(opts ...ConnOption) *conn {
+	ircConn := &conn{
 		incomingHandlers: make(map[string][]MessageHandler),
 		outgoingHandlers: make(map[string][]MessageHandler),
+		stateHandlers:    make(map[string][]MessageHandler),
+		channelCharsets:  make(map[string]Charset),
+		queued:           make(chan struct{}, 1),
+		done:             make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(ircConn)
+	}
+	return ircConn
+}
+
+func (c *conn) setConn(rwc io.ReadWriteCloser) {
+	c.conn = rwc
+	c.scanner = bufio.NewScanner(rwc)
 }
 
 //A very simple implementation of an IRC client
@@ -77,54 +123,202 @@ type conn struct {
 
 	incomingHandlers map[string][]MessageHandler
 	outgoingHandlers map[string][]MessageHandler
+	stateHandlers    map[string][]MessageHandler //Called before the incoming interceptors
+
+	incomingInterceptors []Interceptor
+	outgoingInterceptors []Interceptor
+	queueLock            sync.Mutex
+	queue                []Message     //Intercepted incoming messages waiting to be read
+	queued               chan struct{} //Signalled when a message is queued
+
+	//Once there are incoming interceptors, lines are scanned by a goroutine
+	//so Read can also wait for messages they delay
+	scanOnce sync.Once
+	lines    chan []byte
+	scanErr  error         //Set before lines is closed
+	done     chan struct{} //Closed by Close, to stop the scanner
+	closed   sync.Once
+
+	fallbackCharset Charset            //Used to decode lines that aren't valid UTF-8
+	channelCharsets map[string]Charset //Per channel charsets, keyed by lowercase name
+	keepRaw         bool               //Keep the undecoded bytes of incoming lines
+
+	dial      DialFunc    //Used by NewConnection. Defaults to net.Dial
+	tlsConfig *tls.Config //Used by NewConnection when useSSL is true
+
+	instr         Instrumentation
+	recoverPanics bool //Log and recover handler panics
 }
 
 //Read blocks until a new line is available from the server,
 //It returns a new Message or returns an error
 func (c *conn) Read() (msg Message, err error) {
-	ok := c.scanner.Scan()
-	if !ok {
-		err = c.scanner.Err()
-		if err == nil { //Scanner doesn't return EOF
-			err = io.EOF
+	for msg == nil {
+		c.queueLock.Lock()
+		if len(c.queue) > 0 {
+			msg = c.queue[0]
+			c.queue = c.queue[1:]
+		}
+		c.queueLock.Unlock()
+		if msg != nil {
+			break
+		}
+
+		var raw []byte
+		raw, err = c.readLine()
+		if err != nil {
+			return
+		}
+		if raw == nil {
+			continue //A message was queued
+		}
+		pmsg := parseString(c.decode(raw))
+		pmsg.timestamp = time.Now()
+		if c.keepRaw {
+			pmsg.raw = append([]byte(nil), raw...)
+		}
+		if c.instr != nil {
+			c.instr.LineRead(pmsg.Command(), len(raw)+2)
+		}
+		c.callHandlers(c.stateHandlers[msgHandlerKey], pmsg)
+		c.callHandlers(c.stateHandlers[pmsg.Command()], pmsg)
+		if len(c.incomingInterceptors) == 0 {
+			msg = pmsg
+		} else {
+			chain(c.incomingInterceptors, c.enqueue)(pmsg)
+		}
+	}
+
+	c.callHandlers(c.incomingHandlers[msgHandlerKey], msg)
+	c.callHandlers(c.incomingHandlers[msg.Command()], msg)
+	return
+}
+
+//readLine returns the next line from the server. With incoming
+//interceptors, it returns a nil line if a message is queued first.
+func (c *conn) readLine() ([]byte, error) {
+	if len(c.incomingInterceptors) == 0 && c.lines == nil {
+		if !c.scanner.Scan() {
+			return nil, c.scannerErr()
+		}
+		return c.scanner.Bytes(), nil
+	}
+
+	c.scanOnce.Do(func() {
+		c.lines = make(chan []byte)
+		go c.scan()
+	})
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			return nil, c.scanErr
 		}
-		return
+		return line, nil
+	case <-c.queued:
+		return nil, nil
 	}
-	line := c.scanner.Text()
-	msg = NewMessage(line)
-	for _, h := range c.incomingHandlers[msgHandlerKey] {
-		h(msg)
+}
+
+//scan sends the lines from the server to Read until an error occurs or
+//the connection is closed
+func (c *conn) scan() {
+	defer close(c.lines)
+	for c.scanner.Scan() {
+		select {
+		case c.lines <- append([]byte{}, c.scanner.Bytes()...):
+		case <-c.done:
+			c.scanErr = io.EOF
+			return
+		}
 	}
+	c.scanErr = c.scannerErr()
+}
 
-	for _, h := range c.incomingHandlers[msg.Command()] {
-		h(msg)
+func (c *conn) scannerErr() error {
+	if err := c.scanner.Err(); err != nil {
+		return err
 	}
+	return io.EOF //Scanner doesn't return EOF
+}
 
-	return
+//enqueue queues an intercepted message to be returned by Read, waking
+//Read if it is waiting for a line
+func (c *conn) enqueue(msg Message) error {
+	c.queueLock.Lock()
+	c.queue = append(c.queue, msg)
+	c.queueLock.Unlock()
+	select {
+	case c.queued <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
-//Writes the message to the server.
-//Returns an error if one occurs
+//Writes the message to the server, after passing it through
+//the outgoing interceptors. Returns an error if one occurs
 func (c *conn) Write(msg Message) error {
-	_, err := c.conn.Write([]byte(msg.String() + "\r\n"))
+	if len(c.outgoingInterceptors) > 0 {
+		return chain(c.outgoingInterceptors, c.write)(msg)
+	}
+	return c.write(msg)
+}
+
+func (c *conn) write(msg Message) error {
+	line := append(c.encode(msg), '\r', '\n')
+	if c.instr != nil {
+		c.instr.WriteStarted()
+	}
+	_, err := c.conn.Write(line)
+	if c.instr != nil {
+		c.instr.WriteDone(msg.Command(), len(line), err)
+	}
 
 	if err == nil {
-		for _, h := range c.outgoingHandlers[msgHandlerKey] {
-			h(msg)
-		}
+		c.callHandlers(c.outgoingHandlers[msgHandlerKey], msg)
+		c.callHandlers(c.outgoingHandlers[msg.Command()], msg)
+	}
+
+	return err
+}
 
-		for _, h := range c.outgoingHandlers[msg.Command()] {
+//callHandlers calls the handlers with the message, timing them if the
+//Conn is instrumented
+func (c *conn) callHandlers(handlers []MessageHandler, msg Message) {
+	for _, h := range handlers {
+		if c.instr == nil && !c.recoverPanics {
 			h(msg)
+		} else {
+			c.callHandler(h, msg)
 		}
 	}
+}
 
-	return err
+//callHandler reports how long the handler took, and whether it panicked,
+//if the Conn is instrumented. Panics are logged and recovered if
+//RecoverPanics is used.
+func (c *conn) callHandler(h MessageHandler, msg Message) {
+	start := time.Now()
+	panicked := true
+	defer func() {
+		if c.instr != nil {
+			c.instr.HandlerDone(msg.Command(), time.Since(start), panicked)
+		}
+		if !panicked || !c.recoverPanics {
+			return
+		}
+		if r := recover(); r != nil {
+			log.Printf("irc: %s handler panicked: %v", msg.Command(), r)
+		}
+	}()
+	h(msg)
+	panicked = false
 }
 
 //Closes the connection to the server. It does not send
 //a quit command.
 func (c *conn) Close() {
 	if c != nil {
+		c.closed.Do(func() { close(c.done) })
 		c.conn.Close()
 	}
 }
@@ -133,12 +327,22 @@ func (c *conn) Close() {
 //will be called for all messages that are going in the specified direction
 //(inbound, outbound or both). If commands are specified, the handler will be
 //called only on those commands. If no commands are specified, the handler will
-//be called for all messages, regardless of the command.
+//be called for all messages, regardless of the command. IncomingState
+//handlers are called for each line read, before the interceptors. In
+//each direction, the handlers for all commands are called before those
+//for the message's command, each in the order they were added.
 func (c *conn) AddHandler(dir handlerDirection, h MessageHandler, cmds ...string) {
 	if len(cmds) < 1 {
 		cmds = []string{msgHandlerKey}
 	}
 
+	if dir == IncomingState {
+		for _, cmd := range cmds {
+			cmd = strings.ToUpper(cmd)
+			c.stateHandlers[cmd] = append(c.stateHandlers[cmd], h)
+		}
+	}
+
 	if dir == Incoming || dir == Both {
 		for _, cmd := range cmds {
 			cmd = strings.ToUpper(cmd)
@@ -155,3 +359,15 @@ func (c *conn) AddHandler(dir handlerDirection, h MessageHandler, cmds ...string
 		}
 	}
 }
+
+//Adds an Interceptor to the connection, for messages going in the specified
+//direction. Interceptors are called in the order they were added, before
+//any MessageHandlers.
+func (c *conn) AddInterceptor(dir handlerDirection, i Interceptor) {
+	if dir == Incoming || dir == Both {
+		c.incomingInterceptors = append(c.incomingInterceptors, i)
+	}
+	if dir == Outgoing || dir == Both {
+		c.outgoingInterceptors = append(c.outgoingInterceptors, i)
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"bufio"
 	"log"
 	"net"
+	"strings"
 	"testing"
 )
 
+//getListener listens on an ephemeral port so tests can run in parallel
 func getListener() net.Listener {
-	l, err := net.Listen("tcp", "127.0.0.1:8080")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		log.Fatalf("Unable to listen on port 8080: %s", err.Error())
+		log.Fatalf("Unable to listen on a loopback port: %s", err.Error())
 	}
 	return l
 }
@@ -18,6 +20,7 @@ func getListener() net.Listener {
 //TODO: Test SSL
 func TestNewConnection(t *testing.T) {
 	l := getListener()
+	addr := l.Addr().String()
 	go func() {
 		_, err := l.Accept()
 		if err != nil {
@@ -25,15 +28,15 @@ func TestNewConnection(t *testing.T) {
 		}
 	}()
 
-	ircConn, err := NewConnection("127.0.0.1:8080", false)
+	ircConn, err := NewConnection(addr, false)
 	if err != nil {
-		t.Errorf("Unable to connect to IRC server.")
+		t.Fatalf("Unable to connect to IRC server.")
 	}
 	ircConn.Close()
 	l.Close()
 
 	//Listener is now off. NewConnection should return an error
-	ircConn, err = NewConnection("127.0.0.1:8080", false)
+	ircConn, err = NewConnection(addr, false)
 	if err == nil {
 		t.Error("Connection failed, but no error returned.")
 	}
@@ -45,14 +48,15 @@ func TestRead(t *testing.T) {
 	go func() {
 		lconn, err := l.Accept()
 		if err != nil {
-			t.Fatalf("Unable to accept connection from IRC client: %s", err.Error())
+			t.Errorf("Unable to accept connection from IRC client: %s", err.Error())
+			return
 		}
 		lconn.Write([]byte("Message 1\r\n"))
 		lconn.Write([]byte("Message 2\r\n"))
 		lconn.Close()
 	}()
 
-	ircConn, err := NewConnection("127.0.0.1:8080", false)
+	ircConn, err := NewConnection(l.Addr().String(), false)
 	if err != nil {
 		t.Errorf("Unable to connect to IRC server.")
 	}
@@ -62,7 +66,7 @@ func TestRead(t *testing.T) {
 		t.Errorf("Error returned while reading from server: %s", err.Error())
 	}
 	if msg.Message() != "Message 1" {
-		t.Errorf(`Read() did not return the expected message. Expected: "Message 1", Received: "%s"`, msg.Message)
+		t.Errorf(`Read() did not return the expected message. Expected: "Message 1", Received: "%s"`, msg.Message())
 	}
 
 	msg, err = ircConn.Read()
@@ -70,7 +74,7 @@ func TestRead(t *testing.T) {
 		t.Errorf("Error returned while reading from server: %s", err.Error())
 	}
 	if msg.Message() != "Message 2" {
-		t.Errorf(`Read() did not return the expected message. Expected: "Message 2", Received: "%s"`, msg.Message)
+		t.Errorf(`Read() did not return the expected message. Expected: "Message 2", Received: "%s"`, msg.Message())
 	}
 
 	msg, err = ircConn.Read()
@@ -85,12 +89,14 @@ func TestWrite(t *testing.T) {
 	go func() {
 		lconn, err := l.Accept()
 		if err != nil {
-			t.Fatalf("Unable to accept connection from IRC client: %s", err.Error())
+			t.Errorf("Unable to accept connection from IRC client: %s", err.Error())
+			return
 		}
 		s := bufio.NewScanner(lconn)
 		ok := s.Scan()
 		if !ok {
-			t.Fatalf("Unable to read data from irc.Conn. Error: %s", s.Err())
+			t.Errorf("Unable to read data from irc.Conn. Error: %s", s.Err())
+			return
 		}
 		line := s.Text()
 		if line != "Message 1" {
@@ -99,7 +105,8 @@ func TestWrite(t *testing.T) {
 
 		ok = s.Scan()
 		if !ok {
-			t.Fatalf("Unable to read data from irc.Conn. Error: %s", s.Err())
+			t.Errorf("Unable to read data from irc.Conn. Error: %s", s.Err())
+			return
 		}
 		line = s.Text()
 		if line != "Message 2" {
@@ -108,7 +115,7 @@ func TestWrite(t *testing.T) {
 		lconn.Close()
 	}()
 
-	ircConn, err := NewConnection("127.0.0.1:8080", false)
+	ircConn, err := NewConnection(l.Addr().String(), false)
 	if err != nil {
 		t.Errorf("Unable to connect to IRC server.")
 	}
@@ -139,7 +146,7 @@ func TestHandlers(t *testing.T) {
 	l := getListener()
 	defer l.Close()
 
-	ircConn, err := NewConnection("127.0.0.1:8080", false)
+	ircConn, err := NewConnection(l.Addr().String(), false)
 	if err != nil {
 		t.Errorf("Unable to connect to IRC server.")
 	}
@@ -206,3 +213,24 @@ func TestHandlers(t *testing.T) {
 		t.Errorf("Error /w handler listening to specific messages in both directions. Expected: 2 calls, Received: %d calls", bothOnMessage)
 	}
 }
+
+func TestRecoverPanics(t *testing.T) {
+	conn := NewConnectionWrapper(&bufferConn{r: strings.NewReader(":alice!a@host PRIVMSG #chan :hi\r\n")}, RecoverPanics())
+	conn.AddHandler(Incoming, func(Message) {
+		panic("handler failed")
+	})
+	if msg, err := conn.Read(); err != nil || msg.Trailing() != "hi" {
+		t.Errorf("Expected the message despite the panic, Received %v %v", msg, err)
+	}
+}
+
+func TestStateHandlerOrder(t *testing.T) {
+	conn := NewConnectionWrapper(&bufferConn{r: strings.NewReader(":alice!a@host QUIT :bye\r\n")})
+	var order []string
+	conn.AddHandler(IncomingState, func(Message) { order = append(order, "QUIT") }, "QUIT")
+	conn.AddHandler(IncomingState, func(Message) { order = append(order, "*") })
+	conn.Read()
+	if len(order) != 2 || order[0] != "*" {
+		t.Errorf("Expected the handler for all commands first, Received %q", order)
+	}
+}
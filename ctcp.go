@@ -0,0 +1,274 @@
+package irc
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*CTCP (Client-To-Client Protocol) messages are PRIVMSGs (requests) and
+NOTICEs (replies) with the text wrapped in \x01 characters:
+	PRIVMSG nick :\x01VERSION\x01
+	NOTICE nick :\x01VERSION irssi v0.8.12\x01
+
+http://www.irchelp.org/protocol/ctcpspec.html
+*/
+
+const ctcpDelim = '\x01'
+
+//Low level (M-QUOTE) and CTCP level (X-QUOTE) quoting characters
+const (
+	ctcpLowQuote = '\x10'
+	ctcpQuote    = '\\'
+)
+
+//ErrCTCPTimeout is returned when a CTCP request is not answered in time
+var ErrCTCPTimeout = errors.New("CTCP request timed out")
+
+//CTCP is a decoded CTCP message
+type CTCP struct {
+	Command string //Uppercase command, e.g. VERSION
+	Args    string //Everything after the command
+	Reply   bool   //True if the CTCP was sent in a NOTICE
+}
+
+//ParseCTCP returns the CTCP message contained in a PRIVMSG or NOTICE.
+//The bool value is false if the message is not a CTCP message.
+func ParseCTCP(msg Message) (CTCP, bool) {
+	if msg.Command() != "PRIVMSG" && msg.Command() != "NOTICE" {
+		return CTCP{}, false
+	}
+	text := ctcpLowDequote(msg.Trailing())
+	if len(text) < 2 || text[0] != ctcpDelim {
+		return CTCP{}, false
+	}
+
+	//The closing delimiter is optional in practice
+	text = strings.TrimSuffix(text[1:], string(ctcpDelim))
+	text = ctcpDequote(text)
+	parts := strings.SplitN(text, " ", 2)
+	ctcp := CTCP{Command: strings.ToUpper(parts[0]), Reply: msg.Command() == "NOTICE"}
+	if len(parts) > 1 {
+		ctcp.Args = parts[1]
+	}
+	return ctcp, ctcp.Command != ""
+}
+
+//String returns the CTCP message, quoted and wrapped in delimiters
+func (c CTCP) String() string {
+	text := c.Command
+	if c.Args != "" {
+		text += " " + c.Args
+	}
+	return ctcpLowQuoteString(string(ctcpDelim) + ctcpQuoteString(text) + string(ctcpDelim))
+}
+
+//CTCPMessage returns a PRIVMSG sending a CTCP request to the target
+func CTCPMessage(target, command, args string) Message {
+	return PrivMessage(target, CTCP{Command: strings.ToUpper(command), Args: args}.String())
+}
+
+//CTCPReplyMessage returns a NOTICE replying to a CTCP request
+func CTCPReplyMessage(target, command, args string) Message {
+	return NoticeMessage(target, CTCP{Command: strings.ToUpper(command), Args: args, Reply: true}.String())
+}
+
+//ActionMessage returns a PRIVMSG containing a CTCP ACTION (/me)
+func ActionMessage(target, action string) Message {
+	return CTCPMessage(target, "ACTION", action)
+}
+
+var (
+	lowQuoter   = strings.NewReplacer("\x10", "\x10\x10", "\x00", "\x100", "\n", "\x10n", "\r", "\x10r")
+	lowDequoter = strings.NewReplacer("\x10\x10", "\x10", "\x100", "\x00", "\x10n", "\n", "\x10r", "\r", "\x10", "")
+	quoter      = strings.NewReplacer(`\`, `\\`, "\x01", `\a`)
+	dequoter    = strings.NewReplacer(`\\`, `\`, `\a`, "\x01", `\`, "")
+)
+
+func ctcpLowQuoteString(s string) string { return lowQuoter.Replace(s) }
+func ctcpLowDequote(s string) string     { return lowDequoter.Replace(s) }
+func ctcpQuoteString(s string) string    { return quoter.Replace(s) }
+func ctcpDequote(s string) string        { return dequoter.Replace(s) }
+
+//isCTCPRequest returns true if the message is a CTCP request other than ACTION
+func isCTCPRequest(msg Message) bool {
+	ctcp, ok := ParseCTCP(msg)
+	return ok && !ctcp.Reply && ctcp.Command != "ACTION"
+}
+
+//CTCPReplyFunc returns the reply to a CTCP request, or an empty string
+//to not reply
+type CTCPReplyFunc func(from Message, request CTCP) string
+
+//CTCPConfig configures the replies sent by RegisterCTCPHandler
+type CTCPConfig struct {
+	Version string //Reply to VERSION. Defaults to the library name
+	Source  string //Reply to SOURCE. Defaults to the library URL
+
+	//Replies for other commands. These override the built in replies.
+	Replies map[string]CTCPReplyFunc
+
+	//Minimum time between replies to the same nick. Defaults to 2 seconds.
+	//Requests recieved sooner are ignored.
+	RateLimit time.Duration
+}
+
+const (
+	defaultCTCPVersion   = "github.com/oooska/irc"
+	defaultCTCPSource    = "https://github.com/oooska/irc"
+	defaultCTCPRateLimit = 2 * time.Second
+)
+
+//CTCPRequester sends CTCP requests and waits for the reply
+type CTCPRequester interface {
+	//Request sends a CTCP request to the target, and waits until it
+	//replies or the timeout expires. The client must continue to Read
+	//from another goroutine for the reply to be recieved.
+	Request(target, command, args string, timeout time.Duration) (CTCP, error)
+}
+
+//CTCPHandler answers CTCP VERSION, PING, TIME, CLIENTINFO and SOURCE
+//requests using the default configuration.
+func CTCPHandler(client Client) {
+	RegisterCTCPHandler(client, CTCPConfig{})
+}
+
+//RegisterCTCPHandler answers CTCP requests sent to the connection, and
+//returns a CTCPRequester to send requests of our own.
+func RegisterCTCPHandler(c Conn, conf CTCPConfig) CTCPRequester {
+	ch := &ctcpHandler{conn: c, conf: conf, lastReply: make(map[string]time.Time), waiting: make(map[string][]chan CTCP), mLock: new(sync.Mutex)}
+	if ch.conf.Version == "" {
+		ch.conf.Version = defaultCTCPVersion
+	}
+	if ch.conf.Source == "" {
+		ch.conf.Source = defaultCTCPSource
+	}
+	if ch.conf.RateLimit == 0 {
+		ch.conf.RateLimit = defaultCTCPRateLimit
+	}
+
+	ch.replies = map[string]CTCPReplyFunc{
+		"VERSION":    func(Message, CTCP) string { return ch.conf.Version },
+		"SOURCE":     func(Message, CTCP) string { return ch.conf.Source },
+		"PING":       func(_ Message, req CTCP) string { return req.Args },
+		"TIME":       func(Message, CTCP) string { return time.Now().Format(time.RFC1123Z) },
+		"CLIENTINFO": func(Message, CTCP) string { return strings.Join(ch.commands(), " ") },
+	}
+	for cmd, f := range conf.Replies {
+		ch.replies[strings.ToUpper(cmd)] = f
+	}
+
+	c.AddHandler(Incoming, ch.handle, "PRIVMSG", "NOTICE")
+	return ch
+}
+
+type ctcpHandler struct {
+	conn    Conn
+	conf    CTCPConfig
+	replies map[string]CTCPReplyFunc
+
+	lastReply map[string]time.Time   //Last reply sent to a nick
+	waiting   map[string][]chan CTCP //Requests waiting for a reply, keyed by nick and command
+	mLock     *sync.Mutex
+}
+
+func (ch *ctcpHandler) handle(msg Message) {
+	ctcp, ok := ParseCTCP(msg)
+	if !ok || msg.Nick() == "" {
+		return
+	}
+
+	if ctcp.Reply {
+		key := ctcpKey(msg.Nick(), ctcp.Command)
+		ch.mLock.Lock()
+		waiting := ch.waiting[key]
+		delete(ch.waiting, key)
+		ch.mLock.Unlock()
+		for _, w := range waiting {
+			w <- ctcp
+		}
+		return
+	}
+
+	reply, ok := ch.replies[ctcp.Command]
+	if !ok || !ch.allow(msg.Nick()) {
+		return
+	}
+	if resp := reply(msg, ctcp); resp != "" || ctcp.Command == "PING" {
+		ch.conn.Write(CTCPReplyMessage(msg.Nick(), ctcp.Command, resp))
+	}
+}
+
+//allow returns true if a reply may be sent to the nick, and records it
+func (ch *ctcpHandler) allow(nick string) bool {
+	now := time.Now()
+	key := strings.ToLower(nick)
+
+	ch.mLock.Lock()
+	defer ch.mLock.Unlock()
+	if last, ok := ch.lastReply[key]; ok && now.Sub(last) < ch.conf.RateLimit {
+		return false
+	}
+
+	//Forget nicks we haven't heard from in a while
+	for n, last := range ch.lastReply {
+		if now.Sub(last) >= ch.conf.RateLimit {
+			delete(ch.lastReply, n)
+		}
+	}
+	ch.lastReply[key] = now
+	return true
+}
+
+//commands returns the sorted list of commands we reply to
+func (ch *ctcpHandler) commands() []string {
+	cmds := []string{"ACTION"}
+	for cmd := range ch.replies {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+func (ch *ctcpHandler) Request(target, command, args string, timeout time.Duration) (CTCP, error) {
+	key := ctcpKey(target, command)
+	reply := make(chan CTCP, 1)
+	ch.mLock.Lock()
+	ch.waiting[key] = append(ch.waiting[key], reply)
+	ch.mLock.Unlock()
+
+	if err := ch.conn.Write(CTCPMessage(target, command, args)); err != nil {
+		ch.cancel(key, reply)
+		return CTCP{}, err
+	}
+
+	select {
+	case ctcp := <-reply:
+		return ctcp, nil
+	case <-time.After(timeout):
+		ch.cancel(key, reply)
+		return CTCP{}, ErrCTCPTimeout
+	}
+}
+
+//cancel stops waiting for a reply
+func (ch *ctcpHandler) cancel(key string, reply chan CTCP) {
+	ch.mLock.Lock()
+	defer ch.mLock.Unlock()
+	waiting := ch.waiting[key]
+	for k, w := range waiting {
+		if w == reply {
+			ch.waiting[key] = append(waiting[:k], waiting[k+1:]...)
+			break
+		}
+	}
+	if len(ch.waiting[key]) == 0 {
+		delete(ch.waiting, key)
+	}
+}
+
+func ctcpKey(nick, command string) string {
+	return strings.ToLower(nick) + " " + strings.ToUpper(command)
+}
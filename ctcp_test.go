@@ -0,0 +1,95 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ctcpInput = []struct {
+	line     string
+	expected CTCP
+	ok       bool
+}{
+	{":a!b@c PRIVMSG me :\x01VERSION\x01", CTCP{Command: "VERSION"}, true},
+	{":a!b@c PRIVMSG #chan :\x01ACTION waves hello\x01", CTCP{Command: "ACTION", Args: "waves hello"}, true},
+	{":a!b@c NOTICE me :\x01PING 12345\x01", CTCP{Command: "PING", Args: "12345", Reply: true}, true},
+	{":a!b@c PRIVMSG me :\x01ping 1", CTCP{Command: "PING", Args: "1"}, true},
+	{":a!b@c PRIVMSG me :\x01ECHO a\\\\b\\ac\x10nd\x01", CTCP{Command: "ECHO", Args: "a\\b\x01c\nd"}, true},
+	{":a!b@c PRIVMSG me :not ctcp", CTCP{}, false},
+	{":a!b@c JOIN :\x01VERSION\x01", CTCP{}, false},
+}
+
+func TestParseCTCP(t *testing.T) {
+	for j, in := range ctcpInput {
+		ctcp, ok := ParseCTCP(NewMessage(in.line))
+		if ok != in.ok || ctcp != in.expected {
+			t.Errorf("input[%d]: ParseCTCP() returned %+v, %t. Expected: %+v, %t", j, ctcp, ok, in.expected, in.ok)
+		}
+	}
+
+	msg := CTCPMessage("nick", "echo", "a\\b\x01c\nd")
+	if msg.String() != "PRIVMSG nick :\x01ECHO a\\\\b\\ac\x10nd\x01" {
+		t.Errorf("CTCPMessage() did not quote correctly. Received: %q", msg.String())
+	}
+	if ctcp, _ := ParseCTCP(msg); ctcp.Args != "a\\b\x01c\nd" {
+		t.Errorf("ParseCTCP() did not reverse CTCPMessage(). Received: %q", ctcp.Args)
+	}
+}
+
+func TestCTCPHandler(t *testing.T) {
+	lines := []string{
+		":a!b@c PRIVMSG me :\x01VERSION\x01",
+		":a!b@c PRIVMSG me :\x01VERSION\x01", //Rate limited
+		":d!e@f PRIVMSG me :\x01PING 123\x01",
+		":d!e@f PRIVMSG me :\x01UNKNOWN\x01",
+		":g!h@i PRIVMSG me :\x01CLIENTINFO\x01",
+		":j!k@l PRIVMSG me :\x01FINGER\x01",
+	}
+	conn, b := newBufferConn(lines...)
+	RegisterCTCPHandler(conn, CTCPConfig{
+		Version: "test 1.0",
+		Replies: map[string]CTCPReplyFunc{"finger": func(from Message, _ CTCP) string { return "hi " + from.Nick() }},
+	})
+	readAll(conn)
+
+	expected := "NOTICE a :\x01VERSION test 1.0\x01\r\n" +
+		"NOTICE d :\x01PING 123\x01\r\n" +
+		"NOTICE g :\x01CLIENTINFO ACTION CLIENTINFO FINGER PING SOURCE TIME VERSION\x01\r\n" +
+		"NOTICE j :\x01FINGER hi j\x01\r\n"
+	if b.written.String() != expected {
+		t.Errorf("CTCP handler sent unexpected replies.\nExpected: %q\nReceived: %q", expected, b.written.String())
+	}
+}
+
+func TestCTCPRequest(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := NewConnectionWrapper(client)
+	requester := RegisterCTCPHandler(conn, CTCPConfig{})
+	go readAll(conn)
+
+	go func() {
+		s := bufio.NewScanner(server)
+		for s.Scan() {
+			if strings.Contains(s.Text(), "PING") {
+				server.Write([]byte(":other!u@h NOTICE me :\x01PING 42\x01\r\n"))
+			}
+		}
+	}()
+
+	reply, err := requester.Request("Other", "PING", "42", time.Second)
+	if err != nil {
+		t.Fatalf("Request() returned an error: %s", err.Error())
+	}
+	if reply.Command != "PING" || reply.Args != "42" || !reply.Reply {
+		t.Errorf("Request() returned the wrong reply: %+v", reply)
+	}
+
+	_, err = requester.Request("other", "VERSION", "", 10*time.Millisecond)
+	if err != ErrCTCPTimeout {
+		t.Errorf("Request() did not time out. Received: %v", err)
+	}
+}
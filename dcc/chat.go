@@ -0,0 +1,64 @@
+package dcc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+//ChatConn is a line based connection for a DCC CHAT session. Unlike an
+//irc.Conn, the lines are plain text rather than IRC messages.
+type ChatConn interface {
+	//ReadLine blocks until a line is recieved. The line ending is removed.
+	ReadLine() (string, error)
+	WriteLine(line string) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+//NewChatConn returns a ChatConn using the supplied connection
+func NewChatConn(c net.Conn) ChatConn {
+	return &chatConn{conn: c, scanner: bufio.NewScanner(c), wLock: new(sync.Mutex)}
+}
+
+//DialChat connects to a CHAT offer
+func DialChat(o Offer) (ChatConn, error) {
+	c, err := Dial(o, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewChatConn(c), nil
+}
+
+type chatConn struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	wLock   *sync.Mutex
+}
+
+func (c *chatConn) ReadLine() (string, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return strings.TrimRight(c.scanner.Text(), "\r"), nil
+}
+
+func (c *chatConn) WriteLine(line string) error {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+func (c *chatConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *chatConn) Close() error {
+	return c.conn.Close()
+}
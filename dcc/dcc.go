@@ -0,0 +1,293 @@
+/*
+Package dcc implements DCC (Direct Client-to-Client) CHAT and file
+transfers. Offers are sent over IRC as CTCP messages, and the chat or
+file is then sent over a direct TCP connection between the clients.
+
+	PRIVMSG nick :\x01DCC CHAT chat <ip> <port>\x01
+	PRIVMSG nick :\x01DCC SEND <filename> <ip> <port> <size> [token]\x01
+	PRIVMSG nick :\x01DCC RESUME <filename> <port> <position> [token]\x01
+	PRIVMSG nick :\x01DCC ACCEPT <filename> <port> <position> [token]\x01
+
+Passive (reverse) DCC is used when the sender can't accept connections:
+the offer has a port of 0 and a token, and the receiver replies with an
+offer of its own, using the same token, that the sender connects to.
+
+http://www.irchelp.org/protocol/dccspec.html
+*/
+package dcc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//DCC offer types
+const (
+	Chat   = "CHAT"
+	Send   = "SEND"
+	Resume = "RESUME"
+	Accept = "ACCEPT"
+)
+
+var (
+	//ErrNotDCC is returned when parsing a CTCP message that isn't a DCC offer
+	ErrNotDCC = errors.New("Not a DCC message")
+	//ErrMalformedOffer is returned when a DCC offer can't be parsed
+	ErrMalformedOffer = errors.New("Malformed DCC offer")
+)
+
+//Offer is a DCC offer sent as a CTCP message
+type Offer struct {
+	Type     string //CHAT, SEND, RESUME or ACCEPT
+	Filename string //"chat" for CHAT offers
+	IP       net.IP //Not sent for RESUME or ACCEPT
+	Port     int    //0 for passive offers
+	Size     int64  //Size of the file for SEND, or -1 if unknown
+	Position int64  //Resume position for RESUME and ACCEPT
+	Token    string //Identifies passive offers
+}
+
+//ParseOffer parses a DCC offer from a CTCP message
+func ParseOffer(ctcp irc.CTCP) (Offer, error) {
+	if ctcp.Command != "DCC" {
+		return Offer{}, ErrNotDCC
+	}
+	args := splitArgs(ctcp.Args)
+	if len(args) < 3 {
+		return Offer{}, ErrMalformedOffer
+	}
+
+	o := Offer{Type: strings.ToUpper(args[0]), Filename: args[1], Size: -1}
+	var err error
+	switch o.Type {
+	case Chat, Send:
+		//<type> <filename> <ip> <port> [size] [token]
+		if len(args) < 4 {
+			return Offer{}, ErrMalformedOffer
+		}
+		if o.IP = parseIP(args[2]); o.IP == nil {
+			return Offer{}, ErrMalformedOffer
+		}
+		if o.Port, err = strconv.Atoi(args[3]); err != nil {
+			return Offer{}, ErrMalformedOffer
+		}
+		if len(args) > 4 {
+			if o.Size, err = strconv.ParseInt(args[4], 10, 64); err != nil {
+				return Offer{}, ErrMalformedOffer
+			}
+		}
+		if len(args) > 5 {
+			o.Token = args[5]
+		}
+	case Resume, Accept:
+		//<type> <filename> <port> <position> [token]
+		if len(args) < 4 {
+			return Offer{}, ErrMalformedOffer
+		}
+		if o.Port, err = strconv.Atoi(args[2]); err != nil {
+			return Offer{}, ErrMalformedOffer
+		}
+		if o.Position, err = strconv.ParseInt(args[3], 10, 64); err != nil {
+			return Offer{}, ErrMalformedOffer
+		}
+		if len(args) > 4 {
+			o.Token = args[4]
+		}
+	default:
+		return Offer{}, ErrMalformedOffer
+	}
+
+	if o.Port < 0 || o.Port > 65535 {
+		return Offer{}, ErrMalformedOffer
+	}
+	return o, nil
+}
+
+//ParseMessage returns the DCC offer contained in a PRIVMSG
+func ParseMessage(msg irc.Message) (Offer, error) {
+	ctcp, ok := irc.ParseCTCP(msg)
+	if !ok || ctcp.Reply {
+		return Offer{}, ErrNotDCC
+	}
+	return ParseOffer(ctcp)
+}
+
+//CTCP returns the offer as a CTCP message
+func (o Offer) CTCP() irc.CTCP {
+	args := []string{o.Type, quoteFilename(o.Filename)}
+	switch o.Type {
+	case Resume, Accept:
+		args = append(args, strconv.Itoa(o.Port), strconv.FormatInt(o.Position, 10))
+	default:
+		args = append(args, formatIP(o.IP), strconv.Itoa(o.Port))
+		if o.Size >= 0 || o.Token != "" {
+			args = append(args, strconv.FormatInt(o.Size, 10))
+		}
+	}
+	if o.Token != "" {
+		args = append(args, o.Token)
+	}
+	return irc.CTCP{Command: "DCC", Args: strings.Join(args, " ")}
+}
+
+//Message returns a PRIVMSG sending the offer to the target
+func (o Offer) Message(target string) irc.Message {
+	ctcp := o.CTCP()
+	return irc.CTCPMessage(target, ctcp.Command, ctcp.Args)
+}
+
+//Passive returns true if this is a passive offer, and the reciever should
+//listen for the sender to connect to it.
+func (o Offer) Passive() bool {
+	return o.Port == 0 && o.Token != ""
+}
+
+//Addr returns the address to connect to for the offer
+func (o Offer) Addr() string {
+	return net.JoinHostPort(o.IP.String(), strconv.Itoa(o.Port))
+}
+
+//SafeFilename returns the filename with any directories removed, so
+//it can be safely saved
+func (o Offer) SafeFilename() string {
+	name := filepath.Base(filepath.Clean("/" + strings.Replace(o.Filename, "\\", "/", -1)))
+	if name == "/" || name == "." {
+		return "file"
+	}
+	return name
+}
+
+//ResumeOffer returns the RESUME request to send in reply to a SEND offer,
+//asking to resume the transfer at the given position.
+func (o Offer) ResumeOffer(position int64) Offer {
+	return Offer{Type: Resume, Filename: o.Filename, Port: o.Port, Position: position, Token: o.Token, Size: -1}
+}
+
+//AcceptOffer returns the ACCEPT reply to a RESUME request
+func (o Offer) AcceptOffer() Offer {
+	return Offer{Type: Accept, Filename: o.Filename, Port: o.Port, Position: o.Position, Token: o.Token, Size: -1}
+}
+
+//Dial connects to the address in the offer
+func Dial(o Offer, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", o.Addr(), timeout)
+}
+
+//Listener listens for a peer to connect for a DCC offer
+type Listener struct {
+	net.Listener
+}
+
+//Listen starts listening on an ephemeral port on the specified IP
+func Listen(ip net.IP) (*Listener, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{l}, nil
+}
+
+//Offer returns an offer for the peer to connect to this listener.
+//advertiseIP is the address the peer should connect to (e.g. our public
+//address); if nil, the address of the listener is used.
+func (l *Listener) Offer(offerType, filename string, size int64, advertiseIP net.IP) Offer {
+	addr := l.Addr().(*net.TCPAddr)
+	if advertiseIP == nil {
+		advertiseIP = addr.IP
+	}
+	return Offer{Type: offerType, Filename: filename, IP: advertiseIP, Port: addr.Port, Size: size}
+}
+
+//PassiveOffer returns a passive offer, for when we can't accept
+//connections. The receiver listens and replies with PassiveReply, using
+//the offer's token, and we connect to the reply with Dial. ip is our
+//address; it may be nil.
+func PassiveOffer(offerType, filename string, size int64, ip net.IP) Offer {
+	return Offer{Type: offerType, Filename: filename, IP: ip, Size: size, Token: newToken()}
+}
+
+//PassiveReply returns the reply to a passive offer, asking the sender to
+//connect to the listener. advertiseIP is as for Listener.Offer.
+func (o Offer) PassiveReply(l *Listener, advertiseIP net.IP) Offer {
+	reply := l.Offer(o.Type, o.Filename, o.Size, advertiseIP)
+	reply.Token = o.Token
+	return reply
+}
+
+//IsReplyTo returns true if the offer is the reply to our passive offer
+func (o Offer) IsReplyTo(passive Offer) bool {
+	return o.Type == passive.Type && o.Token != "" && o.Token == passive.Token && o.Port != 0
+}
+
+//newToken returns a random token identifying a passive offer
+func newToken() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(b)), 10)
+}
+
+//AcceptTimeout waits for a single connection, and closes the listener.
+func (l *Listener) AcceptTimeout(timeout time.Duration) (net.Conn, error) {
+	defer l.Close()
+	if tl, ok := l.Listener.(*net.TCPListener); ok && timeout > 0 {
+		tl.SetDeadline(time.Now().Add(timeout))
+	}
+	return l.Accept()
+}
+
+//parseIP parses IPv4 addresses sent as a 32 bit integer, or IPv6 addresses
+func parseIP(s string) net.IP {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip
+	}
+	return net.ParseIP(s)
+}
+
+func formatIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	if ip == nil {
+		return "0"
+	}
+	return ip.String()
+}
+
+func quoteFilename(name string) string {
+	if strings.ContainsAny(name, " \"") {
+		return fmt.Sprintf("\"%s\"", strings.Replace(name, "\"", "'", -1))
+	}
+	return name
+}
+
+//splitArgs splits on spaces, keeping quoted strings together
+func splitArgs(s string) []string {
+	var args []string
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		if s[0] == '"' {
+			if end := strings.IndexByte(s[1:], '"'); end >= 0 {
+				args = append(args, s[1:end+1])
+				s = s[end+2:]
+				continue
+			}
+		}
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+		args = append(args, s[:end])
+		s = s[end:]
+	}
+	return args
+}
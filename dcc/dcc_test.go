@@ -0,0 +1,277 @@
+package dcc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+var loopback = net.ParseIP("127.0.0.1")
+
+var offerInput = []struct {
+	args     string
+	expected Offer
+}{
+	{"CHAT chat 2130706433 5000", Offer{Type: Chat, Filename: "chat", IP: loopback, Port: 5000, Size: -1}},
+	{"SEND file.txt 2130706433 5000 1024", Offer{Type: Send, Filename: "file.txt", IP: loopback, Port: 5000, Size: 1024}},
+	{`SEND "my file.txt" 2130706433 0 1024 42`, Offer{Type: Send, Filename: "my file.txt", IP: loopback, Port: 0, Size: 1024, Token: "42"}},
+	{"SEND v6.bin ::1 5000 7", Offer{Type: Send, Filename: "v6.bin", IP: net.ParseIP("::1"), Port: 5000, Size: 7}},
+	{"RESUME file.txt 5000 512", Offer{Type: Resume, Filename: "file.txt", Port: 5000, Position: 512, Size: -1}},
+	{"ACCEPT file.txt 0 512 42", Offer{Type: Accept, Filename: "file.txt", Port: 0, Position: 512, Size: -1, Token: "42"}},
+}
+
+func TestParseOffer(t *testing.T) {
+	for j, in := range offerInput {
+		offer, err := ParseOffer(irc.CTCP{Command: "DCC", Args: in.args})
+		if err != nil {
+			t.Errorf("input[%d]: ParseOffer() returned an error: %s", j, err.Error())
+			continue
+		}
+		if offer.Type != in.expected.Type || offer.Filename != in.expected.Filename || !offer.IP.Equal(in.expected.IP) ||
+			offer.Port != in.expected.Port || offer.Size != in.expected.Size || offer.Position != in.expected.Position ||
+			offer.Token != in.expected.Token {
+			t.Errorf("input[%d]: ParseOffer() returned %+v, Expected: %+v", j, offer, in.expected)
+		}
+		if offer.CTCP().Args != in.args {
+			t.Errorf("input[%d]: CTCP() did not recreate the offer. Expected: %s, Received: %s", j, in.args, offer.CTCP().Args)
+		}
+	}
+
+	for _, args := range []string{"SEND file.txt", "CHAT chat notanip 5000", "SEND f 2130706433 99999", "FOO a b c"} {
+		if _, err := ParseOffer(irc.CTCP{Command: "DCC", Args: args}); err != ErrMalformedOffer {
+			t.Errorf("ParseOffer(%s) did not return ErrMalformedOffer. Received: %v", args, err)
+		}
+	}
+
+	msg := irc.NewMessage(":a!b@c PRIVMSG me :\x01DCC SEND file.txt 2130706433 5000 1024\x01")
+	if offer, err := ParseMessage(msg); err != nil || offer.Size != 1024 {
+		t.Errorf("ParseMessage() returned %+v, %v", offer, err)
+	}
+
+	offer := Offer{Filename: `..\..\etc/passwd`}
+	if offer.SafeFilename() != "passwd" {
+		t.Errorf("SafeFilename() did not remove directories. Received: %s", offer.SafeFilename())
+	}
+}
+
+func TestChat(t *testing.T) {
+	l, err := Listen(loopback)
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err.Error())
+	}
+	offer := l.Offer(Chat, "chat", -1, nil)
+
+	done := make(chan error)
+	go func() {
+		c, err := l.AcceptTimeout(time.Second)
+		if err != nil {
+			done <- err
+			return
+		}
+		chat := NewChatConn(c)
+		line, err := chat.ReadLine()
+		if err == nil {
+			err = chat.WriteLine("echo: " + line)
+		}
+		done <- err
+	}()
+
+	chat, err := DialChat(offer)
+	if err != nil {
+		t.Fatalf("Unable to dial chat offer: %s", err.Error())
+	}
+	defer chat.Close()
+	chat.WriteLine("hello")
+	line, err := chat.ReadLine()
+	if err != nil || line != "echo: hello" {
+		t.Errorf("ReadLine() returned %q, %v. Expected: \"echo: hello\"", line, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Listener side of chat returned an error: %s", err.Error())
+	}
+}
+
+func transfer(t *testing.T, data []byte, resumeAt int64, passive bool) []byte {
+	var sender, receiver net.Conn
+	l, err := Listen(loopback)
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err.Error())
+	}
+	offer := l.Offer(Send, "file.bin", int64(len(data)), nil)
+	if passive {
+		//The sender's offer has no port, and the receiver replies with one
+		passiveOffer := roundTrip(t, PassiveOffer(Send, "file.bin", int64(len(data)), loopback))
+		if !passiveOffer.Passive() {
+			t.Fatalf("Expected a passive offer, Received %+v", passiveOffer)
+		}
+		offer = roundTrip(t, passiveOffer.PassiveReply(l, nil))
+		if !offer.IsReplyTo(passiveOffer) || offer.Passive() {
+			t.Fatalf("Expected a reply with token %s, Received %+v", passiveOffer.Token, offer)
+		}
+	}
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.AcceptTimeout(time.Second)
+		accepted <- c
+	}()
+	dialed, err := Dial(offer, time.Second)
+	if err != nil {
+		t.Fatalf("Unable to dial offer: %s", err.Error())
+	}
+
+	//With passive DCC the receiver listens and the sender connects
+	if passive {
+		sender, receiver = dialed, <-accepted
+	} else {
+		sender, receiver = <-accepted, dialed
+	}
+
+	var progress []int64
+	sent := make(chan error)
+	go func() {
+		sent <- SendFile(sender, bytes.NewReader(data), resumeAt, int64(len(data)), func(n, size int64) {
+			progress = append(progress, n)
+		})
+	}()
+
+	var buf bytes.Buffer
+	if err := ReceiveFile(receiver, &buf, resumeAt, int64(len(data)), nil); err != nil {
+		t.Errorf("ReceiveFile() returned an error: %s", err.Error())
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("SendFile() returned an error: %s", err.Error())
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(data)) {
+		t.Errorf("SendFile() did not report progress to completion. Received: %v", progress)
+	}
+	return buf.Bytes()
+}
+
+func TestTransfer(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	if received := transfer(t, data, 0, false); !bytes.Equal(received, data) {
+		t.Errorf("Received file does not match. Received %d bytes, expected %d", len(received), len(data))
+	}
+	if received := transfer(t, data, 0, true); !bytes.Equal(received, data) {
+		t.Errorf("Passive transfer does not match. Received %d bytes, expected %d", len(received), len(data))
+	}
+	if received := transfer(t, data, 5000, false); !bytes.Equal(received, data[5000:]) {
+		t.Errorf("Resumed transfer does not match. Received %d bytes, expected %d", len(received), len(data)-5000)
+	}
+}
+
+func TestTransferSizeExceeded(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		server.Write([]byte("too much data"))
+		server.Close()
+	}()
+	var buf bytes.Buffer
+	if err := ReceiveFile(client, &buf, 0, 4, nil); err != ErrSizeExceeded {
+		t.Errorf("ReceiveFile() did not return ErrSizeExceeded. Received: %v", err)
+	}
+}
+
+func TestResumeOffer(t *testing.T) {
+	offer := Offer{Type: Send, Filename: "file.txt", IP: loopback, Port: 5000, Size: 1024}
+	resume := offer.ResumeOffer(512)
+	if resume.CTCP().Args != "RESUME file.txt 5000 512" {
+		t.Errorf("ResumeOffer() returned %s", resume.CTCP().Args)
+	}
+	if accept := resume.AcceptOffer(); accept.CTCP().Args != "ACCEPT file.txt 5000 512" {
+		t.Errorf("AcceptOffer() returned %s", accept.CTCP().Args)
+	}
+	if msg := resume.Message("nick"); msg.String() != "PRIVMSG nick :\x01DCC RESUME file.txt 5000 512\x01" {
+		t.Errorf("Message() returned %q", msg.String())
+	}
+}
+
+//roundTrip sends the offer as a CTCP message and parses it
+func roundTrip(t *testing.T, o Offer) Offer {
+	t.Helper()
+	parsed, err := ParseMessage(o.Message("nick"))
+	if err != nil {
+		t.Fatalf("Unable to parse %s: %s", o.Message("nick"), err.Error())
+	}
+	return parsed
+}
+
+func TestUnknownSize(t *testing.T) {
+	client, server := net.Pipe()
+	data := []byte("size unknown")
+	sent := make(chan error)
+	go func() {
+		sent <- SendFile(server, bytes.NewReader(data), 0, -1, nil)
+	}()
+	var buf bytes.Buffer
+	if err := ReceiveFile(client, &buf, 0, -1, nil); err != nil {
+		t.Errorf("ReceiveFile() returned an error: %s", err.Error())
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("SendFile() returned an error: %s", err.Error())
+	}
+	if buf.String() != string(data) {
+		t.Errorf("Expected %q, Received %q", data, buf.String())
+	}
+}
+
+//smallReads reads at most 256 bytes at a time
+type smallReads struct {
+	net.Conn
+}
+
+func (c smallReads) Read(p []byte) (int, error) {
+	if len(p) > 256 {
+		p = p[:256]
+	}
+	return c.Conn.Read(p)
+}
+
+func TestUnknownSizeLarge(t *testing.T) {
+	l, err := Listen(loopback)
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err.Error())
+	}
+	defer l.Close()
+	offer := l.Offer(Send, "file.bin", -1, nil)
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.AcceptTimeout(time.Second)
+		accepted <- c
+	}()
+	receiver, err := Dial(offer, time.Second)
+	if err != nil {
+		t.Fatalf("Unable to dial offer: %s", err.Error())
+	}
+
+	//Small reads and small buffers for the acks mean they fill the
+	//buffers if the sender doesn't read them
+	sender := <-accepted
+	sender.(*net.TCPConn).SetReadBuffer(4096)
+	receiver.(*net.TCPConn).SetWriteBuffer(4096)
+	receiver.SetDeadline(time.Now().Add(5 * time.Second))
+	receiver = smallReads{receiver}
+	data := bytes.Repeat([]byte("0123456789"), 400*1024)
+	sent := make(chan error)
+	go func() {
+		sent <- SendFile(sender, bytes.NewReader(data), 0, -1, nil)
+	}()
+	var buf bytes.Buffer
+	if err := ReceiveFile(receiver, &buf, 0, -1, nil); err != nil {
+		t.Errorf("ReceiveFile() returned an error: %s", err.Error())
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Errorf("SendFile() returned an error: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for SendFile()")
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Received file does not match. Received %d bytes, expected %d", buf.Len(), len(data))
+	}
+}
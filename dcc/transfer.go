@@ -0,0 +1,131 @@
+package dcc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+var (
+	//ErrSizeExceeded is returned when more data is recieved than the offer's size
+	ErrSizeExceeded = errors.New("DCC transfer exceeded the offered size")
+	//ErrIncomplete is returned when the connection closes before the whole file is transferred
+	ErrIncomplete = errors.New("DCC transfer incomplete")
+)
+
+const transferBufferSize = 16 * 1024
+
+//Progress is called as a transfer progresses with the number of bytes
+//transferred so far (including any resume position), and the total size
+type Progress func(transferred, size int64)
+
+//SendFile sends the file over the connection, starting at offset. The
+//reader is positioned at offset before sending. Acknowledgements from the
+//receiver are reported to progress (which may be nil). SendFile returns
+//once the receiver has acknowledged the whole file, and closes the connection.
+func SendFile(c net.Conn, r io.ReadSeeker, offset, size int64, progress Progress) error {
+	defer c.Close()
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	//Acknowledgements have to be read as they arrive, otherwise the
+	//reciever may block writing them.
+	acks := &ackCounter{}
+	acks.total.Store(offset)
+	acks.end.Store(size)
+	acked := make(chan error, 1)
+	go func() {
+		acked <- acks.read(c, size, progress)
+	}()
+
+	//With an unknown size (-1), the whole of r is sent, and the end is
+	//only known once it has been
+	var src io.Reader = r
+	if size >= 0 {
+		src = io.LimitReader(r, size-offset)
+	}
+	n, err := io.CopyBuffer(c, src, make([]byte, transferBufferSize))
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		acks.end.Store(offset + n)
+		if acks.done() {
+			return nil //Closing the connection stops read
+		}
+	}
+	return <-acked
+}
+
+//ackCounter tracks the acknowledgements of a file being sent
+type ackCounter struct {
+	total atomic.Int64 //Bytes acknowledged, including the resume position
+	end   atomic.Int64 //Size of the file, or -1 until it has all been sent
+}
+
+//done returns whether the whole file has been acknowledged
+func (a *ackCounter) done() bool {
+	end := a.end.Load()
+	return end >= 0 && a.total.Load() >= end
+}
+
+//read reads acknowledgements until the whole file has been acknowledged
+func (a *ackCounter) read(r io.Reader, size int64, progress Progress) error {
+	ack := make([]byte, 4)
+	for !a.done() {
+		if _, err := io.ReadFull(r, ack); err != nil {
+			if a.done() {
+				return nil
+			}
+			return ErrIncomplete
+		}
+		//Acks are the lower 32 bits of the total bytes recieved
+		total := a.total.Load()
+		total += (int64(binary.BigEndian.Uint32(ack)) - total) & 0xffffffff
+		a.total.Store(total)
+		if progress != nil {
+			progress(total, size)
+		}
+	}
+	return nil
+}
+
+//ReceiveFile receives a file sent over the connection, writing it to w.
+//offset is the position the transfer is resuming from, and size is the
+//total size from the offer (or -1 if unknown, in which case the transfer
+//ends when the sender closes the connection). Each read is acknowledged
+//to the sender. Closes the connection when done.
+func ReceiveFile(c net.Conn, w io.Writer, offset, size int64, progress Progress) error {
+	defer c.Close()
+	buf := make([]byte, transferBufferSize)
+	ack := make([]byte, 4)
+	total := offset
+	for size < 0 || total < size {
+		n, err := c.Read(buf)
+		if n > 0 {
+			if size >= 0 && total+int64(n) > size {
+				return ErrSizeExceeded
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			total += int64(n)
+			binary.BigEndian.PutUint32(ack, uint32(total))
+			if _, werr := c.Write(ack); werr != nil && size >= 0 {
+				return werr
+			}
+			if progress != nil {
+				progress(total, size)
+			}
+		}
+		if err == io.EOF && size < 0 {
+			return nil
+		} else if err != nil {
+			return ErrIncomplete
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*Lines are assumed to be UTF-8. Many channels still use legacy 8-bit
+character sets, so a Conn can be configured to fall back to a different
+charset when a line is not valid UTF-8. The fallback can be set for the
+whole server, or for individual channels. Lines sent to a channel with
+a charset configured are encoded using that charset.
+
+Invalid UTF-8 that can't be decoded is replaced with U+FFFD. Handlers
+that need the original bytes can use Message.Raw() if the Conn was
+created with KeepRawBytes.*/
+
+//Charset converts between UTF-8 and a legacy character set
+type Charset interface {
+	Name() string
+	Decode([]byte) string
+	//Encode converts the string to the charset. Characters that can't
+	//be represented are replaced.
+	Encode(string) []byte
+}
+
+//Charmap is a Charset for single-byte encodings that match ASCII
+//for the first 128 characters.
+type Charmap struct {
+	name string
+	high [128]rune //Characters 0x80 - 0xFF
+}
+
+//NewCharmap returns a Charmap using the supplied characters for 0x80 - 0xFF.
+//utf8.RuneError marks a byte that is undefined in the charset.
+func NewCharmap(name string, high [128]rune) *Charmap {
+	return &Charmap{name: name, high: high}
+}
+
+//Name returns the name of the charset
+func (c *Charmap) Name() string {
+	return c.name
+}
+
+//Decode converts bytes in the charset to a UTF-8 string
+func (c *Charmap) Decode(b []byte) string {
+	var buf strings.Builder
+	for _, ch := range b {
+		if ch < utf8.RuneSelf {
+			buf.WriteByte(ch)
+		} else {
+			buf.WriteRune(c.high[ch-utf8.RuneSelf])
+		}
+	}
+	return buf.String()
+}
+
+//Encode converts a UTF-8 string to bytes in the charset. Characters that
+//can't be represented are replaced with '?'
+func (c *Charmap) Encode(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b = append(b, byte(r))
+			continue
+		}
+		ch := byte('?')
+		for k, hr := range c.high {
+			if hr == r && r != utf8.RuneError {
+				ch = byte(k + utf8.RuneSelf)
+				break
+			}
+		}
+		b = append(b, ch)
+	}
+	return b
+}
+
+var (
+	//Latin1 is ISO-8859-1
+	Latin1 = NewCharmap("ISO-8859-1", latin1High())
+
+	//Windows1252 is CP1252, a superset of ISO-8859-1 commonly (mis)labeled as it
+	Windows1252 = NewCharmap("windows-1252", windows1252High())
+
+	//Latin9 is ISO-8859-15
+	Latin9 = NewCharmap("ISO-8859-15", latin9High())
+)
+
+func latin1High() (high [128]rune) {
+	for k := range high {
+		high[k] = rune(k + utf8.RuneSelf)
+	}
+	return
+}
+
+func windows1252High() [128]rune {
+	high := latin1High()
+	copy(high[:32], []rune{
+		'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+		utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+	})
+	return high
+}
+
+func latin9High() [128]rune {
+	high := latin1High()
+	for ch, r := range map[byte]rune{0xA4: '€', 0xA6: 'Š', 0xA8: 'š', 0xB4: 'Ž', 0xB8: 'ž', 0xBC: 'Œ', 0xBD: 'œ', 0xBE: 'Ÿ'} {
+		high[ch-utf8.RuneSelf] = r
+	}
+	return high
+}
+
+//FallbackCharset sets the charset used to decode lines from the server
+//that are not valid UTF-8
+func FallbackCharset(cs Charset) ConnOption {
+	return func(c *conn) {
+		c.fallbackCharset = cs
+	}
+}
+
+//ChannelCharset sets the charset used for a channel (or nick). Incoming
+//lines for the channel that are not valid UTF-8 are decoded with it, and
+//outgoing lines to the channel are encoded with it.
+func ChannelCharset(channel string, cs Charset) ConnOption {
+	return func(c *conn) {
+		c.channelCharsets[strings.ToLower(channel)] = cs
+	}
+}
+
+//KeepRawBytes keeps the undecoded bytes of each incoming line, available
+//from Message.Raw(), for handlers that need to be binary safe
+func KeepRawBytes() ConnOption {
+	return func(c *conn) {
+		c.keepRaw = true
+	}
+}
+
+//decode converts a line read from the server to UTF-8
+func (c *conn) decode(raw []byte) string {
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	cs := c.fallbackCharset
+	if len(c.channelCharsets) > 0 {
+		//Invalid bytes survive parsing, so we can find the target first
+		if chcs := c.channelCharset(parseString(string(raw))); chcs != nil {
+			cs = chcs
+		}
+	}
+
+	if cs == nil {
+		return strings.ToValidUTF8(string(raw), string(utf8.RuneError))
+	}
+	return cs.Decode(raw)
+}
+
+//encode returns the bytes to send to the server for the message
+func (c *conn) encode(msg Message) []byte {
+	if cs := c.channelCharset(msg); cs != nil {
+		return cs.Encode(msg.String())
+	}
+	return []byte(msg.String())
+}
+
+//channelCharset returns the charset of the channel or nick the message is
+//for, or nil. Private messages sent to us use the sender's charset, and
+//numerics use the charset of the channel or nick they name.
+func (c *conn) channelCharset(msg Message) Charset {
+	params := msg.Params()
+	if len(c.channelCharsets) == 0 || len(params) == 0 {
+		return nil
+	}
+	if cs, ok := c.channelCharsets[strings.ToLower(params[0])]; ok {
+		return cs
+	}
+	switch cmd := msg.Command(); {
+	case cmd == "PRIVMSG" || cmd == "NOTICE":
+		//:nick!user@host PRIVMSG me :text
+		return c.channelCharsets[strings.ToLower(msg.Nick())]
+	case len(cmd) == 3 && isNumber(cmd[0]) && isNumber(cmd[1]) && isNumber(cmd[2]):
+		//:server 332 me #channel :topic, :server 353 me = #channel :names
+		for _, p := range params[1:] {
+			if cs, ok := c.channelCharsets[strings.ToLower(p)]; ok {
+				return cs
+			}
+		}
+	}
+	return nil
+}
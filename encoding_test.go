@@ -0,0 +1,81 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCharmap(t *testing.T) {
+	if s := Latin1.Decode([]byte("caf\xe9")); s != "café" {
+		t.Errorf("Latin1.Decode() returned %q, expected \"café\"", s)
+	}
+	if s := Windows1252.Decode([]byte("\x93quoted\x94 \x80")); s != "“quoted” €" {
+		t.Errorf("Windows1252.Decode() returned %q, expected \"“quoted” €\"", s)
+	}
+	if b := Latin9.Encode("€ café ☃"); string(b) != "\xa4 caf\xe9 ?" {
+		t.Errorf("Latin9.Encode() returned %q, expected \"\\xa4 caf\\xe9 ?\"", b)
+	}
+}
+
+func TestConnDecoding(t *testing.T) {
+	lines := []string{
+		":a!b@c PRIVMSG #utf8 :café",
+		":a!b@c PRIVMSG #latin :caf\xe9",
+		":a!b@c PRIVMSG #other :\x93hi\x94",
+		":a!b@c PRIVMSG #Latin :caf\xe9",
+	}
+	b := &bufferConn{r: strings.NewReader(strings.Join(lines, "\r\n") + "\r\n")}
+	conn := NewConnectionWrapper(b, FallbackCharset(Windows1252), ChannelCharset("#latin", Latin1), KeepRawBytes())
+
+	expected := []string{"café", "café", "“hi”", "café"}
+	for k, e := range expected {
+		msg, err := conn.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error reading line %d: %s", k, err.Error())
+		}
+		if msg.Trailing() != e {
+			t.Errorf("line[%d]: Expected %q, Received: %q", k, e, msg.Trailing())
+		}
+		if string(msg.Raw()) != lines[k] {
+			t.Errorf("line[%d]: Raw() did not return the original bytes. Received: %q", k, msg.Raw())
+		}
+	}
+
+	conn.Write(PrivMessage("#LATIN", "café"))
+	conn.Write(PrivMessage("#utf8", "café"))
+	if b.written.String() != "PRIVMSG #LATIN :caf\xe9\r\nPRIVMSG #utf8 :café\r\n" {
+		t.Errorf("Write() did not encode messages for the channel charset. Received: %q", b.written.String())
+	}
+
+	//Without a fallback, invalid bytes are replaced
+	conn, _ = newBufferConn(":a!b@c PRIVMSG #x :caf\xe9")
+	msg, _ := conn.Read()
+	if msg.Trailing() != "caf�" {
+		t.Errorf("Invalid UTF-8 was not replaced. Received: %q", msg.Trailing())
+	}
+	if msg.Raw() != nil {
+		t.Errorf("Raw() should return nil unless KeepRawBytes is used. Received: %q", msg.Raw())
+	}
+}
+
+func TestPrivateAndNumericCharsets(t *testing.T) {
+	lines := []string{
+		":somenick!b@c PRIVMSG me :caf\xe9",
+		":server 332 me #latin :caf\xe9",
+		":server 353 me = #latin :caf\xe9",
+		":other!b@c PRIVMSG me :caf\xe9",
+	}
+	b := &bufferConn{r: strings.NewReader(strings.Join(lines, "\r\n") + "\r\n")}
+	conn := NewConnectionWrapper(b, ChannelCharset("SomeNick", Latin1), ChannelCharset("#latin", Latin1))
+
+	expected := []string{"café", "café", "café", "caf�"}
+	for k, e := range expected {
+		msg, err := conn.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error reading line %d: %s", k, err.Error())
+		}
+		if msg.Trailing() != e {
+			t.Errorf("line[%d]: Expected %q, Received: %q", k, e, msg.Trailing())
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+)
+
+//Event is a typed event, created from a message after the client's state
+//(channels, users, conversations) has been updated for it. Use a type
+//switch to handle the events you're interested in:
+//
+//	client.OnEvent(func(e irc.Event) {
+//		switch e := e.(type) {
+//		case irc.Joined:
+//			log.Printf("%s joined %s", e.User, e.Channel)
+//		case irc.ChannelMessage:
+//			log.Printf("<%s:%s> %s", e.From, e.Channel, e.Text)
+//		}
+//	})
+type Event interface {
+	//Message returns the message the event was created from
+	Message() Message
+}
+
+//EventHandler is called with each event
+type EventHandler func(Event)
+
+//Events dispatches typed events to handlers
+type Events interface {
+	//OnEvent adds a handler called with every event, in the order the
+	//messages are read
+	OnEvent(h EventHandler)
+}
+
+type rawEvent struct {
+	msg Message
+}
+
+func (e rawEvent) Message() Message {
+	return e.msg
+}
+
+//Registered is sent when registration with the server completes (001)
+type Registered struct {
+	rawEvent
+	Nick string
+}
+
+//Joined is sent when a user, or the client, joins a channel
+type Joined struct {
+	rawEvent
+	Channel, User string
+}
+
+//Parted is sent when a user, or the client, leaves a channel
+type Parted struct {
+	rawEvent
+	Channel, User, Reason string
+}
+
+//Kicked is sent when a user, or the client, is kicked from a channel
+type Kicked struct {
+	rawEvent
+	Channel, User string
+	By, Reason    string
+}
+
+//NickChanged is sent when a user, or the client, changes nick
+type NickChanged struct {
+	rawEvent
+	Old, New string
+}
+
+//TopicChanged is sent when someone changes a channel's topic
+type TopicChanged struct {
+	rawEvent
+	Channel, Topic, By string
+}
+
+//ModeChanged is sent when a channel's modes are changed
+type ModeChanged struct {
+	rawEvent
+	Channel, By string
+	Changes     []ModeChange
+}
+
+//PrivateMessage is a PRIVMSG sent to the client
+type PrivateMessage struct {
+	rawEvent
+	From, Text string
+}
+
+//ChannelMessage is a PRIVMSG sent to a channel
+type ChannelMessage struct {
+	rawEvent
+	Channel, From, Text string
+}
+
+//Notice is a NOTICE sent to the client or a channel. CTCP replies are
+//not included.
+type Notice struct {
+	rawEvent
+	From, Target, Text string
+}
+
+//Action is a CTCP ACTION (/me) sent to the client or a channel
+type Action struct {
+	rawEvent
+	From, Target, Text string
+}
+
+//Invited is sent when someone invites the client to a channel
+type Invited struct {
+	rawEvent
+	Channel, By string
+}
+
+type events struct {
+	handlers []EventHandler
+	mLock    *sync.RWMutex
+}
+
+func newEvents() *events {
+	return &events{mLock: new(sync.RWMutex)}
+}
+
+func (e *events) OnEvent(h EventHandler) {
+	e.mLock.Lock()
+	e.handlers = append(e.handlers, h)
+	e.mLock.Unlock()
+}
+
+//emit calls the handlers with the event. It does nothing if e is nil, so
+//handlers registered without a Client don't need to check.
+func (e *events) emit(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mLock.RLock()
+	handlers := e.handlers
+	e.mLock.RUnlock()
+	for _, h := range handlers {
+		h(ev)
+	}
+}
+
+//handle emits the events for incoming messages other than PRIVMSG and
+//NOTICE, which are emitted by the conversations handler
+func (e *events) handle(is ISupport, msg Message) {
+	params := msg.Params()
+	switch msg.Command() {
+	case rplWelcome:
+		if len(params) > 0 {
+			e.emit(Registered{rawEvent{msg}, params[0]})
+		}
+	case "INVITE":
+		//:nick!user@host INVITE me :#channel
+		if len(params) > 1 {
+			e.emit(Invited{rawEvent{msg}, strings.TrimPrefix(params[1], ":"), msg.Nick()})
+		}
+	case "JOIN":
+		if msg.Nick() != "" && len(params) > 0 {
+			e.emit(Joined{rawEvent{msg}, strings.TrimPrefix(params[0], ":"), msg.Nick()})
+		}
+	case "PART":
+		//:nick!user@host PART #channel :reason
+		if msg.Nick() != "" && len(params) > 0 {
+			reason := ""
+			if len(params) > 1 {
+				reason = msg.Trailing()
+			}
+			e.emit(Parted{rawEvent{msg}, strings.TrimPrefix(params[0], ":"), msg.Nick(), reason})
+		}
+	case "KICK":
+		//:nick!user@host KICK #channel kicked :reason
+		if msg.Nick() != "" && len(params) > 1 {
+			reason := ""
+			if len(params) > 2 {
+				reason = msg.Trailing()
+			}
+			e.emit(Kicked{rawEvent{msg}, params[0], strings.TrimPrefix(params[1], ":"), msg.Nick(), reason})
+		}
+	case "NICK":
+		//:old!user@host NICK new
+		if msg.Nick() != "" && len(params) > 0 {
+			e.emit(NickChanged{rawEvent{msg}, msg.Nick(), strings.TrimPrefix(params[0], ":")})
+		}
+	case "MODE":
+		//:nick!user@host MODE #channel +o-v alice bob
+		if len(params) < 2 || !IsChannel(is, params[0]) {
+			return
+		}
+		if changes := ParseModeChanges(is, strings.TrimPrefix(params[1], ":"), params[2:]); len(changes) > 0 {
+			e.emit(ModeChanged{rawEvent{msg}, params[0], msg.Nick(), changes})
+		}
+	case "TOPIC":
+		//:nick!user@host TOPIC #channel :new topic
+		if msg.Nick() != "" && len(params) > 1 {
+			e.emit(TopicChanged{rawEvent{msg}, params[0], msg.Trailing(), msg.Nick()})
+		}
+	}
+}
+
+//messageEvent returns the event for an incoming PRIVMSG or NOTICE. It
+//returns false for CTCP messages other than ACTION.
+func messageEvent(is ISupport, msg Message) (Event, bool) {
+	if len(msg.Params()) < 1 {
+		return nil, false
+	}
+	target := msg.Params()[0]
+	if ctcp, ok := ParseCTCP(msg); ok {
+		if ctcp.Command != "ACTION" || ctcp.Reply {
+			return nil, false
+		}
+		return Action{rawEvent{msg}, msg.Nick(), target, ctcp.Args}, true
+	}
+	switch {
+	case msg.Command() == "NOTICE":
+		return Notice{rawEvent{msg}, msg.Nick(), target, msg.Trailing()}, true
+	case IsChannel(is, target):
+		return ChannelMessage{rawEvent{msg}, target, msg.Nick(), msg.Trailing()}, true
+	}
+	return PrivateMessage{rawEvent{msg}, msg.Nick(), msg.Trailing()}, true
+}
@@ -0,0 +1,76 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 001 me :Welcome",
+		":me!u@h JOIN #chan",
+		":alice!u@h JOIN #chan",
+		":alice!u@h PRIVMSG #chan :hello",
+		":alice!u@h PRIVMSG me :psst",
+		":alice!u@h PRIVMSG #chan :\x01ACTION waves\x01",
+		":alice!u@h PRIVMSG me :\x01VERSION\x01",
+		":alice!u@h NOTICE me :note",
+		":alice!u@h NOTICE me :\x01VERSION irssi\x01",
+		":alice!u@h MODE #chan +ov-b me alice *!*@spam",
+		":alice!u@h TOPIC #chan :new topic",
+		":alice!u@h NICK :alice_",
+		":alice_!u@h KICK #chan bob :bye",
+		":alice_!u@h PART #chan :gone",
+		":alice_!u@h INVITE me :#other",
+	)
+	client := NewClientWrapper(conn)
+
+	var got []Event
+	client.OnEvent(func(e Event) {
+		got = append(got, e)
+		//State is updated before the event
+		if j, ok := e.(Joined); ok && j.User == "alice" {
+			if users, _ := client.Users("#chan"); len(users) != 2 {
+				t.Errorf("Expected alice to be in #chan, Received %v", users)
+			}
+		}
+	})
+	client.Write(JoinMessage("#chan"))
+	readAll(client)
+
+	raw := func(i int) rawEvent { return rawEvent{got[i].Message()} }
+	expected := []Event{
+		Registered{raw(0), "me"},
+		Joined{raw(1), "#chan", "me"},
+		Joined{raw(2), "#chan", "alice"},
+		ChannelMessage{raw(3), "#chan", "alice", "hello"},
+		PrivateMessage{raw(4), "alice", "psst"},
+		Action{raw(5), "alice", "#chan", "waves"},
+		Notice{raw(6), "alice", "me", "note"},
+		ModeChanged{raw(7), "#chan", "alice", []ModeChange{{true, 'o', "me"}, {true, 'v', "alice"}, {false, 'b', "*!*@spam"}}},
+		TopicChanged{raw(8), "#chan", "new topic", "alice"},
+		NickChanged{raw(9), "alice", "alice_"},
+		Kicked{raw(10), "#chan", "bob", "alice_", "bye"},
+		Parted{raw(11), "#chan", "alice_", "gone"},
+		Invited{raw(12), "#other", "alice_"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d events, Received %d: %v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("Expected %+v, Received %+v", expected[i], got[i])
+		}
+	}
+	if got[3].Message().Trailing() != "hello" {
+		t.Errorf("Expected the event's message, Received %v", got[3].Message())
+	}
+}
+
+func TestClientISupportHandlers(t *testing.T) {
+	c, _ := newBufferConn()
+	NewClientWrapper(c)
+	if n := len(c.(*conn).incomingHandlers[rplISupport]); n != 1 {
+		t.Errorf("Expected one ISUPPORT handler, Received %d", n)
+	}
+}
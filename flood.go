@@ -0,0 +1,487 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+//FloodKind is the kind of abuse detected
+type FloodKind int
+
+const (
+	FloodUser      FloodKind = iota //A user sending too many lines
+	FloodChannel                    //Too many lines sent to a channel by everyone
+	FloodRepeat                     //A user repeating the same line
+	FloodHighlight                  //A line mentioning many users (mass highlight)
+	FloodJoinPart                   //A user joining and parting repeatedly
+)
+
+func (k FloodKind) String() string {
+	switch k {
+	case FloodUser:
+		return "flood"
+	case FloodChannel:
+		return "channel flood"
+	case FloodRepeat:
+		return "repeated lines"
+	case FloodHighlight:
+		return "mass highlight"
+	case FloodJoinPart:
+		return "join/part flood"
+	}
+	return "unknown"
+}
+
+//FloodAction is what is done about abuse
+type FloodAction int
+
+const (
+	FloodNotify   FloodAction = iota //Only call OnFlood
+	FloodWarn                        //Send the user a NOTICE
+	FloodQuiet                       //Quiet the user's host, if the server supports it
+	FloodKick                        //Kick the user
+	FloodBan                         //Ban the user's host and kick them
+	FloodModerate                    //Set the channel +m for ModerateDuration
+)
+
+//FloodLimits are the thresholds for a channel. A zero value uses the
+//default, and a negative value disables the check.
+type FloodLimits struct {
+	UserLines  int           //Lines a user may send in UserPeriod. Defaults to 5.
+	UserPeriod time.Duration //Defaults to 3 seconds
+
+	ChannelLines  int           //Lines everyone may send in ChannelPeriod. Defaults to 20.
+	ChannelPeriod time.Duration //Defaults to 5 seconds
+
+	Repeats      int           //Times a user may repeat a line in RepeatPeriod. Defaults to 3.
+	RepeatPeriod time.Duration //Defaults to 30 seconds
+
+	Highlights int //Users that may be mentioned in one line. Defaults to 5.
+
+	JoinParts      int           //Times a host may join and part in JoinPartPeriod. Defaults to 3.
+	JoinPartPeriod time.Duration //Defaults to 1 minute
+
+	//Actions for each kind of flood. Defaults to warning for repeats,
+	//kicking for user floods, banning for mass highlights and join/part
+	//floods, and moderating the channel for channel floods.
+	Actions map[FloodKind]FloodAction
+
+	//Style of quiets and bans. BanDefault uses BanHost.
+	BanStyle         BanStyle
+	ModerateDuration time.Duration //Defaults to 1 minute
+}
+
+//floodPruneInterval is how often entries with no recent lines are removed
+const floodPruneInterval = time.Minute
+
+var defaultFloodLimits = FloodLimits{
+	UserLines:      5,
+	UserPeriod:     3 * time.Second,
+	ChannelLines:   20,
+	ChannelPeriod:  5 * time.Second,
+	Repeats:        3,
+	RepeatPeriod:   30 * time.Second,
+	Highlights:     5,
+	JoinParts:      3,
+	JoinPartPeriod: time.Minute,
+	Actions: map[FloodKind]FloodAction{
+		FloodUser:      FloodKick,
+		FloodChannel:   FloodModerate,
+		FloodRepeat:    FloodWarn,
+		FloodHighlight: FloodBan,
+		FloodJoinPart:  FloodBan,
+	},
+	BanStyle:         BanHost,
+	ModerateDuration: time.Minute,
+}
+
+//withDefaults fills in the zero values from d
+func (l FloodLimits) withDefaults(d FloodLimits) FloodLimits {
+	ints := []struct{ v, d *int }{{&l.UserLines, &d.UserLines}, {&l.ChannelLines, &d.ChannelLines},
+		{&l.Repeats, &d.Repeats}, {&l.Highlights, &d.Highlights}, {&l.JoinParts, &d.JoinParts}}
+	for _, i := range ints {
+		if *i.v == 0 {
+			*i.v = *i.d
+		}
+	}
+	durations := []struct{ v, d *time.Duration }{{&l.UserPeriod, &d.UserPeriod}, {&l.ChannelPeriod, &d.ChannelPeriod},
+		{&l.RepeatPeriod, &d.RepeatPeriod}, {&l.JoinPartPeriod, &d.JoinPartPeriod}, {&l.ModerateDuration, &d.ModerateDuration}}
+	for _, p := range durations {
+		if *p.v == 0 {
+			*p.v = *p.d
+		}
+	}
+	actions := make(map[FloodKind]FloodAction)
+	for k, a := range d.Actions {
+		actions[k] = a
+	}
+	for k, a := range l.Actions {
+		actions[k] = a
+	}
+	l.Actions = actions
+	if l.BanStyle == BanDefault {
+		l.BanStyle = d.BanStyle
+	}
+	return l
+}
+
+//Flood is abuse that has been detected
+type Flood struct {
+	Kind     FloodKind
+	Action   FloodAction
+	Channel  string
+	Nick     string
+	Hostmask string
+}
+
+//FloodConfig configures the flood handler
+type FloodConfig struct {
+	Limits   FloodLimits            //Limits for all channels
+	Channels map[string]FloodLimits //Per channel limits. Zero values use Limits.
+
+	//Kick reason and warning, followed by the kind of flood. Defaults to
+	//"Please don't flood".
+	Reason string
+
+	//Exempt returns true for users that are never acted on. Defaults to
+	//exempting users with a channel prefix mode (op, voice, etc).
+	Exempt func(channel, nick string) bool
+	//OnFlood is called whenever abuse is detected, before acting on it
+	OnFlood func(Flood)
+}
+
+//FloodProtector detects floods in channels and acts on them
+type FloodProtector interface {
+	//SetLimits sets the limits for a channel. Zero values use the defaults.
+	SetLimits(channel string, limits FloodLimits)
+	//Limits returns the limits for a channel
+	Limits(channel string) FloodLimits
+}
+
+type floodUser struct {
+	lines    []time.Time
+	lastLine string
+	repeats  []time.Time
+}
+
+type floodProtector struct {
+	client Client
+	conf   FloodConfig
+
+	mLock     *sync.Mutex
+	limits    map[string]FloodLimits //Keyed by casefolded channel
+	users     map[string]*floodUser  //Keyed by casefolded channel and nick
+	channels  map[string][]time.Time //Line times, keyed by casefolded channel
+	joinParts map[string][]time.Time //Keyed by casefolded channel and host
+	moderated map[string]*time.Timer
+	pruned    time.Time //When old entries were last removed
+}
+
+//FloodHandler returns a ClientHandler protecting channels from floods
+func FloodHandler(conf FloodConfig) ClientHandler {
+	return func(client Client) {
+		RegisterFloodHandler(client, conf)
+	}
+}
+
+//RegisterFloodHandler watches the PRIVMSGs, NOTICEs, JOINs and PARTs in
+//channels, and acts on users sending lines too fast, repeating lines,
+//mentioning many users in one line or joining and parting repeatedly, and
+//on channels recieving too many lines. The client needs to be an op for
+//most actions. It asks for the modes of channels it joins, to know which
+//were already moderated.
+func RegisterFloodHandler(client Client, conf FloodConfig) FloodProtector {
+	conf.Limits = conf.Limits.withDefaults(defaultFloodLimits)
+	if conf.Reason == "" {
+		conf.Reason = "Please don't flood"
+	}
+	if conf.Exempt == nil {
+		conf.Exempt = func(channel, nick string) bool {
+			modes, _ := client.UserModes(channel, nick)
+			return modes != ""
+		}
+	}
+	fp := &floodProtector{client: client, conf: conf, mLock: new(sync.Mutex),
+		limits: make(map[string]FloodLimits), users: make(map[string]*floodUser),
+		channels: make(map[string][]time.Time), joinParts: make(map[string][]time.Time),
+		moderated: make(map[string]*time.Timer)}
+	for channel, limits := range conf.Channels {
+		fp.SetLimits(channel, limits)
+	}
+	client.AddHandler(Incoming, fp.handle, "PRIVMSG", "NOTICE", "JOIN", "PART")
+	client.AddHandler(Incoming, fp.left, "PART", "KICK", "QUIT")
+	client.AddHandler(Incoming, fp.joined, "JOIN")
+	return fp
+}
+
+func (fp *floodProtector) SetLimits(channel string, limits FloodLimits) {
+	fp.mLock.Lock()
+	fp.limits[casefold(channel)] = limits.withDefaults(fp.conf.Limits)
+	fp.mLock.Unlock()
+}
+
+func (fp *floodProtector) Limits(channel string) FloodLimits {
+	fp.mLock.Lock()
+	defer fp.mLock.Unlock()
+	return fp.channelLimits(channel)
+}
+
+//channelLimits returns the limits for a channel. Called with mLock held.
+func (fp *floodProtector) channelLimits(channel string) FloodLimits {
+	if limits, ok := fp.limits[casefold(channel)]; ok {
+		return limits
+	}
+	return fp.conf.Limits
+}
+
+func (fp *floodProtector) handle(msg Message) {
+	params := msg.Params()
+	if msg.Nick() == "" || len(params) < 1 || strings.EqualFold(msg.Nick(), fp.client.Nick()) {
+		return
+	}
+	channel := strings.TrimPrefix(params[0], ":")
+	if !IsChannel(fp.client, channel) || fp.conf.Exempt(channel, msg.Nick()) {
+		return
+	}
+
+	var kinds []FloodKind
+	if msg.Command() == "JOIN" || msg.Command() == "PART" {
+		kinds = fp.joinPart(channel, msg)
+	} else {
+		kinds = fp.line(channel, msg)
+	}
+	for _, kind := range kinds {
+		fp.act(kind, channel, msg)
+	}
+}
+
+//joined asks for the modes of channels the client joins, so that
+//moderating doesn't undo a +m set before the flood
+func (fp *floodProtector) joined(msg Message) {
+	params := msg.Params()
+	if msg.Nick() == "" || len(params) < 1 || !strings.EqualFold(msg.Nick(), fp.client.Nick()) {
+		return
+	}
+	for _, channel := range strings.Split(strings.TrimPrefix(params[0], ":"), ",") {
+		fp.client.Send(NewMessage("MODE " + channel))
+	}
+}
+
+//left forgets the lines of users that leave a channel or quit
+func (fp *floodProtector) left(msg Message) {
+	params := msg.Params()
+	var channel, nick string
+	switch msg.Command() {
+	case "PART":
+		if len(params) < 1 {
+			return
+		}
+		channel, nick = strings.TrimPrefix(params[0], ":"), msg.Nick()
+	case "KICK":
+		//:nick!user@host KICK #channel kicked :reason
+		if len(params) < 2 {
+			return
+		}
+		channel, nick = params[0], params[1]
+	case "QUIT":
+		nick = msg.Nick()
+	}
+
+	fp.mLock.Lock()
+	defer fp.mLock.Unlock()
+	suffix := " " + casefold(nick)
+	for key := range fp.users {
+		if strings.HasSuffix(key, suffix) && (channel == "" || key == casefold(channel)+suffix) {
+			delete(fp.users, key)
+		}
+	}
+}
+
+//prune removes entries with no recent times, at most once per
+//floodPruneInterval. Called with mLock held.
+func (fp *floodProtector) prune(now time.Time) {
+	if now.Sub(fp.pruned) < floodPruneInterval {
+		return
+	}
+	fp.pruned = now
+	channel := func(key string) string {
+		return strings.SplitN(key, " ", 2)[0]
+	}
+	for key, user := range fp.users {
+		limits := fp.channelLimits(channel(key))
+		user.lines = recent(user.lines, now, limits.UserPeriod)
+		user.repeats = recent(user.repeats, now, limits.RepeatPeriod)
+		if len(user.lines) == 0 && len(user.repeats) == 0 {
+			delete(fp.users, key)
+		}
+	}
+	for key, times := range fp.channels {
+		if times = recent(times, now, fp.channelLimits(key).ChannelPeriod); len(times) == 0 {
+			delete(fp.channels, key)
+		} else {
+			fp.channels[key] = times
+		}
+	}
+	for key, times := range fp.joinParts {
+		if times = recent(times, now, fp.channelLimits(channel(key)).JoinPartPeriod); len(times) == 0 {
+			delete(fp.joinParts, key)
+		} else {
+			fp.joinParts[key] = times
+		}
+	}
+}
+
+//line records a line sent to a channel, and returns the floods it causes
+func (fp *floodProtector) line(channel string, msg Message) []FloodKind {
+	fp.mLock.Lock()
+	defer fp.mLock.Unlock()
+	limits := fp.channelLimits(channel)
+	now := time.Now()
+	fp.prune(now)
+	key := casefold(channel) + " " + casefold(msg.Nick())
+	user, ok := fp.users[key]
+	if !ok {
+		user = &floodUser{}
+		fp.users[key] = user
+	}
+
+	var kinds []FloodKind
+	user.lines = recent(append(user.lines, now), now, limits.UserPeriod)
+	if limits.UserLines > 0 && len(user.lines) > limits.UserLines {
+		kinds = append(kinds, FloodUser)
+		user.lines = nil
+	}
+
+	text := strings.ToLower(strings.TrimSpace(msg.Trailing()))
+	if text != user.lastLine {
+		user.lastLine, user.repeats = text, nil
+	}
+	user.repeats = recent(append(user.repeats, now), now, limits.RepeatPeriod)
+	if limits.Repeats > 0 && len(user.repeats) > limits.Repeats {
+		kinds = append(kinds, FloodRepeat)
+		user.repeats = nil
+	}
+
+	if limits.Highlights > 0 && fp.highlights(channel, msg.Trailing()) > limits.Highlights {
+		kinds = append(kinds, FloodHighlight)
+	}
+
+	folded := casefold(channel)
+	fp.channels[folded] = recent(append(fp.channels[folded], now), now, limits.ChannelPeriod)
+	if limits.ChannelLines > 0 && len(fp.channels[folded]) > limits.ChannelLines {
+		kinds = append(kinds, FloodChannel)
+		fp.channels[folded] = nil
+	}
+	return kinds
+}
+
+//highlights returns the number of channel members mentioned in the text
+func (fp *floodProtector) highlights(channel, text string) int {
+	users, err := fp.client.Users(channel)
+	if err != nil {
+		return 0
+	}
+	members := make(map[string]bool, len(users))
+	for _, u := range users {
+		members[casefold(u)] = true
+	}
+	mentioned := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == ',' || r == ':' }) {
+		if w := casefold(word); members[w] {
+			mentioned[w] = true
+		}
+	}
+	return len(mentioned)
+}
+
+//joinPart records a JOIN or PART, and returns the floods it causes
+func (fp *floodProtector) joinPart(channel string, msg Message) []FloodKind {
+	fp.mLock.Lock()
+	defer fp.mLock.Unlock()
+	limits := fp.channelLimits(channel)
+	if limits.JoinParts <= 0 {
+		return nil
+	}
+	now := time.Now()
+	fp.prune(now)
+	key := casefold(channel) + " " + strings.ToLower(msg.Host())
+	//Each join and part counts as half
+	times := recent(append(fp.joinParts[key], now), now, limits.JoinPartPeriod)
+	fp.joinParts[key] = times
+	if len(times) > 2*limits.JoinParts {
+		fp.joinParts[key] = nil
+		return []FloodKind{FloodJoinPart}
+	}
+	return nil
+}
+
+//recent returns the times within period of now
+func recent(times []time.Time, now time.Time, period time.Duration) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > period {
+		i++
+	}
+	return times[i:]
+}
+
+//act does the configured action for the flood
+func (fp *floodProtector) act(kind FloodKind, channel string, msg Message) {
+	fp.mLock.Lock()
+	limits := fp.channelLimits(channel)
+	fp.mLock.Unlock()
+
+	hostmask := strings.TrimPrefix(msg.Prefix(), ":")
+	flood := Flood{Kind: kind, Action: limits.Actions[kind], Channel: channel, Nick: msg.Nick(), Hostmask: hostmask}
+	if fp.conf.OnFlood != nil {
+		fp.conf.OnFlood(flood)
+	}
+
+	reason := fp.conf.Reason + " (" + kind.String() + ")"
+	kick := NewMessage("KICK " + channel + " " + msg.Nick() + " :" + reason)
+	mask := BanMask(hostmask, limits.BanStyle)
+	switch flood.Action {
+	case FloodWarn:
+		fp.client.Notice(msg.Nick(), reason)
+	case FloodQuiet:
+		if mode, quiet, ok := QuietMask(fp.client, mask); ok {
+			fp.client.Send(ModeMessages(fp.client, channel, []ModeChange{{Set: true, Mode: mode, Param: quiet}})...)
+		}
+	case FloodKick:
+		if kind != FloodJoinPart || msg.Command() == "JOIN" {
+			fp.client.Send(kick)
+		}
+	case FloodBan:
+		fp.client.Send(BanMessages(fp.client, channel, mask)...)
+		if kind != FloodJoinPart || msg.Command() == "JOIN" {
+			fp.client.Send(kick)
+		}
+	case FloodModerate:
+		fp.moderate(channel, limits.ModerateDuration)
+	}
+}
+
+//moderate sets the channel +m, and -m after the duration. A channel that
+//was already moderated is left as it is.
+func (fp *floodProtector) moderate(channel string, d time.Duration) {
+	fp.mLock.Lock()
+	folded := casefold(channel)
+	if timer, ok := fp.moderated[folded]; ok {
+		timer.Reset(d)
+		fp.mLock.Unlock()
+		return
+	}
+	if modes, _ := fp.client.ChannelModes(channel); strings.IndexByte(modes, 'm') >= 0 {
+		fp.mLock.Unlock()
+		return
+	}
+	fp.moderated[folded] = time.AfterFunc(d, func() {
+		fp.mLock.Lock()
+		delete(fp.moderated, folded)
+		fp.mLock.Unlock()
+		fp.client.Send(NewMessage("MODE " + channel + " -m"))
+	})
+	fp.mLock.Unlock()
+
+	fp.client.Send(NewMessage("MODE " + channel + " +m"))
+}
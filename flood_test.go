@@ -0,0 +1,184 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFloodHandler(t *testing.T) {
+	lines := []string{":server 353 me = #chan :@me alice bob carol dave erin frank +voiced"}
+	for i := 0; i < 6; i++ {
+		lines = append(lines, ":alice!a@alice.host PRIVMSG #chan :line "+string(rune('a'+i)))
+	}
+	for i := 0; i < 4; i++ {
+		lines = append(lines, ":bob!b@bob.host PRIVMSG #chan :SPAM")
+	}
+	lines = append(lines, ":carol!c@carol.host PRIVMSG #chan :alice, bob dave: erin frank voiced")
+	for i := 0; i < 10; i++ {
+		lines = append(lines, ":voiced!v@voiced.host PRIVMSG #chan :exempt")
+	}
+	for i := 0; i < 4; i++ {
+		lines = append(lines, ":spam!s@bad.host JOIN #chan", ":spam!s@bad.host PART #chan")
+	}
+	conn, b := newBufferConn(lines...)
+	client := NewClientWrapper(conn)
+
+	var floods []Flood
+	RegisterFloodHandler(client, FloodConfig{OnFlood: func(f Flood) { floods = append(floods, f) }})
+	client.Write(JoinMessage("#chan"))
+	readAll(client)
+
+	expectedFloods := []Flood{
+		{FloodUser, FloodKick, "#chan", "alice", "alice!a@alice.host"},
+		{FloodRepeat, FloodWarn, "#chan", "bob", "bob!b@bob.host"},
+		{FloodHighlight, FloodBan, "#chan", "carol", "carol!c@carol.host"},
+		{FloodJoinPart, FloodBan, "#chan", "spam", "spam!s@bad.host"},
+	}
+	if !reflect.DeepEqual(floods, expectedFloods) {
+		t.Errorf("Expected floods %+v, Received %+v", expectedFloods, floods)
+	}
+	expected := []string{
+		"JOIN #chan",
+		"KICK #chan alice :Please don't flood (flood)",
+		"NOTICE bob :Please don't flood (repeated lines)",
+		"MODE #chan +b *!*@carol.host",
+		"KICK #chan carol :Please don't flood (mass highlight)",
+		"MODE #chan +b *!*@bad.host",
+		"KICK #chan spam :Please don't flood (join/part flood)",
+	}
+	if written := b.lines(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected %q, Received %q", expected, written)
+	}
+}
+
+func TestFloodHandlerChannelLimits(t *testing.T) {
+	var lines []string
+	for _, nick := range []string{"a", "b", "c", "d", "e"} {
+		lines = append(lines, ":"+nick+"!u@"+nick+".host PRIVMSG #busy :hi", ":"+nick+"!u@"+nick+".host PRIVMSG #quiet :hi")
+	}
+	lines = append(lines, ":f!u@f.host PRIVMSG #quiet :f f f f f f")
+	conn, b := newBufferConn(lines...)
+	client := NewClientWrapper(conn)
+	fp := RegisterFloodHandler(client, FloodConfig{
+		Limits: FloodLimits{Actions: map[FloodKind]FloodAction{FloodUser: FloodNotify}},
+		Channels: map[string]FloodLimits{
+			"#Busy":  {ChannelLines: 3, ModerateDuration: 20 * time.Millisecond},
+			"#quiet": {ChannelLines: -1, Highlights: -1},
+		},
+	})
+	if l := fp.Limits("#busy"); l.ChannelLines != 3 || l.UserLines != 5 || l.Actions[FloodUser] != FloodNotify || l.Actions[FloodChannel] != FloodModerate {
+		t.Errorf("Unexpected limits %+v", l)
+	}
+	readAll(client)
+
+	time.Sleep(50 * time.Millisecond)
+	expected := []string{"MODE #busy +m", "MODE #busy -m"}
+	if written := b.lines(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected %q, Received %q", expected, written)
+	}
+}
+
+func TestQuietMask(t *testing.T) {
+	tests := []struct {
+		isupport string
+		mode     byte
+		quiet    string
+		ok       bool
+	}{
+		{"CHANMODES=eIbq,k,flj,CFLMPQScgimnprstuz", 'q', "*!*@host", true},
+		{"CHANMODES=b,k,l,imnpst EXTBAN=,ACNOQRSTUcjmprsz", 'b', "m:*!*@host", true},
+		{"CHANMODES=beI,k,l,imnpst EXTBAN=~,acfjmnqrtT", 'b', "~q:*!*@host", true},
+		{"CHANMODES=beI,k,l,imnpst", 0, "", false},
+	}
+	for _, test := range tests {
+		conn, _ := newBufferConn(":server 005 me " + test.isupport + " :are supported")
+		is := RegisterISupportHandler(conn)
+		readAll(conn)
+		mode, quiet, ok := QuietMask(is, "*!*@host")
+		if mode != test.mode || quiet != test.quiet || ok != test.ok {
+			t.Errorf("%s: Expected %c %q %v, Received %c %q %v", test.isupport, test.mode, test.quiet, test.ok, mode, quiet, ok)
+		}
+	}
+}
+
+func TestFloodHandlerForgets(t *testing.T) {
+	conn, _ := newBufferConn(
+		":alice!a@alice.host PRIVMSG #a :hi",
+		":alice!a@alice.host PRIVMSG #b :hi",
+		":bob!b@bob.host PRIVMSG #a :hi",
+		":carol!c@carol.host PRIVMSG #a :hi",
+		":Alice!a@alice.host PART #A",
+		":op!o@op.host KICK #a bob :bye",
+	)
+	client := NewClientWrapper(conn)
+	fp := RegisterFloodHandler(client, FloodConfig{}).(*floodProtector)
+	readAll(client)
+	expected := []string{"#b alice", "#a carol"}
+	for _, key := range expected {
+		if _, ok := fp.users[key]; !ok {
+			t.Errorf("Expected lines for %q", key)
+		}
+	}
+	if len(fp.users) != len(expected) {
+		t.Errorf("Expected %d users, Received %d", len(expected), len(fp.users))
+	}
+
+	fp.left(NewMessage(":alice!a@alice.host QUIT :gone"))
+	if _, ok := fp.users["#b alice"]; ok {
+		t.Error("Expected alice to be forgotten after quitting")
+	}
+
+	//Entries with no recent times are removed
+	fp.users["#a carol"].lines[0] = time.Now().Add(-time.Hour)
+	fp.joinParts["#a old.host"] = []time.Time{time.Now().Add(-time.Hour)}
+	fp.prune(time.Now().Add(floodPruneInterval))
+	if len(fp.users) != 0 || len(fp.joinParts) != 0 {
+		t.Errorf("Expected old entries to be removed, Received %v %v", fp.users, fp.joinParts)
+	}
+}
+
+func TestFloodHandlerBanNick(t *testing.T) {
+	conn, b := newBufferConn(
+		":server 353 me = #chan :me carol a b c d e f",
+		":carol!c@carol.host PRIVMSG #chan :a b c d e f",
+	)
+	client := NewClientWrapper(conn)
+	RegisterFloodHandler(client, FloodConfig{Limits: FloodLimits{BanStyle: BanNick}})
+	client.Write(JoinMessage("#chan"))
+	readAll(client)
+	expected := []string{
+		"JOIN #chan",
+		"MODE #chan +b carol!*@*",
+		"KICK #chan carol :Please don't flood (mass highlight)",
+	}
+	if written := b.lines(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected %q, Received %q", expected, written)
+	}
+}
+
+func TestFloodHandlerAlreadyModerated(t *testing.T) {
+	var lines []string
+	for _, nick := range []string{"a", "b", "c", "d"} {
+		lines = append(lines, ":"+nick+"!u@"+nick+".host PRIVMSG #set :hi", ":"+nick+"!u@"+nick+".host PRIVMSG #op :hi")
+	}
+	conn, b := newBufferConn(append([]string{
+		":server 001 me :Welcome",
+		":me!u@h JOIN #set",
+		":server 324 me #set +mnt",
+		":me!u@h JOIN #op",
+		":server 324 me #op +nt",
+		":op!u@h MODE #op +m",
+	}, lines...)...)
+	client := NewClientWrapper(conn)
+	RegisterFloodHandler(client, FloodConfig{Limits: FloodLimits{ChannelLines: 3, ModerateDuration: 20 * time.Millisecond}})
+	client.Write(JoinMessage("#set"))
+	client.Write(JoinMessage("#op"))
+	readAll(client)
+
+	time.Sleep(50 * time.Millisecond)
+	expected := []string{"JOIN #set", "JOIN #op", "MODE #set", "MODE #op"}
+	if written := b.lines(); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected %q, Received %q", expected, written)
+	}
+}
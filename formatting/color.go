@@ -0,0 +1,170 @@
+package formatting
+
+import (
+	"fmt"
+	"strconv"
+)
+
+//Color is either an mIRC colour code (0-98), or a 24 bit RGB colour
+//created with RGB.
+type Color int32
+
+//None represents the default colour
+const None Color = -1
+
+//mIRC colour codes 0-15
+const (
+	White Color = iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+const rgbFlag = 1 << 24
+
+//RGB returns a Color for the given red, green and blue values
+func RGB(r, g, b uint8) Color {
+	return Color(rgbFlag | int32(r)<<16 | int32(g)<<8 | int32(b))
+}
+
+//IsRGB returns true if the colour was created with RGB
+func (c Color) IsRGB() bool {
+	return c >= rgbFlag
+}
+
+//RGB returns the red, green and blue values of the colour. mIRC
+//colour codes are converted using the standard palette. ok is false
+//for None and undefined codes.
+func (c Color) RGB() (r, g, b uint8, ok bool) {
+	var rgb int32
+	switch {
+	case c.IsRGB():
+		rgb = int32(c) &^ rgbFlag
+	case c >= 0 && int(c) < len(palette):
+		rgb = palette[c]
+	default:
+		return 0, 0, 0, false
+	}
+	return uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), true
+}
+
+//Hex returns the colour as RRGGBB, or an empty string if it has no value
+func (c Color) Hex() string {
+	r, g, b, ok := c.RGB()
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%02X%02X%02X", r, g, b)
+}
+
+//palette is the RGB value of mIRC colour codes 0-98
+var palette = []int32{
+	0xffffff, 0x000000, 0x00007f, 0x009300, 0xff0000, 0x7f0000, 0x9c009c, 0xfc7f00,
+	0xffff00, 0x00fc00, 0x009393, 0x00ffff, 0x0000fc, 0xff00ff, 0x7f7f7f, 0xd2d2d2,
+	0x470000, 0x472100, 0x474700, 0x324700, 0x004700, 0x00472c, 0x004747, 0x002747, 0x000047, 0x2e0047, 0x470047, 0x47002a,
+	0x740000, 0x743a00, 0x747400, 0x517400, 0x007400, 0x007449, 0x007474, 0x004074, 0x000074, 0x4b0074, 0x740074, 0x740045,
+	0xb50000, 0xb56300, 0xb5b500, 0x7db500, 0x00b500, 0x00b571, 0x00b5b5, 0x0063b5, 0x0000b5, 0x7500b5, 0xb500b5, 0xb5006b,
+	0xff0000, 0xff8c00, 0xffff00, 0xb2ff00, 0x00ff00, 0x00ffa0, 0x00ffff, 0x008cff, 0x0000ff, 0xa500ff, 0xff00ff, 0xff0098,
+	0xff5959, 0xffb459, 0xffff71, 0xcfff60, 0x6fff6f, 0x65ffc9, 0x6dffff, 0x59b4ff, 0x5959ff, 0xc459ff, 0xff66ff, 0xff59bc,
+	0xff9c9c, 0xffd39c, 0xffff9c, 0xe2ff9c, 0x9cff9c, 0x9cffdb, 0x9cffff, 0x9cd3ff, 0x9c9cff, 0xdc9cff, 0xff9cff, 0xff94d3,
+	0x000000, 0x131313, 0x282828, 0x363636, 0x4d4d4d, 0x656565, 0x818181, 0x9f9f9f, 0xbcbcbc, 0xe2e2e2, 0xffffff,
+}
+
+//parseColor parses a \x03 or \x04 colour code at the start of s. Returns
+//the colours, whether a background was set, and the length of the code.
+//A code of length 1 has no colours.
+func parseColor(s string) (fg, bg Color, hasBg bool, n int) {
+	digits, parse := 2, parseCode
+	if s[0] == HexColorCode {
+		digits, parse = 6, parseHex
+	}
+
+	fg, bg, n = None, None, 1
+	l := countValid(s[n:], digits, s[0])
+	if l == 0 || (s[0] == HexColorCode && l != digits) {
+		return
+	}
+	fg = parse(s[n : n+l])
+	n += l
+
+	if n < len(s) && s[n] == ',' {
+		if l = countValid(s[n+1:], digits, s[0]); l > 0 && (s[0] == ColorCode || l == digits) {
+			bg = parse(s[n+1 : n+1+l])
+			hasBg = true
+			n += 1 + l
+		}
+	}
+	return
+}
+
+func countValid(s string, max int, code byte) int {
+	n := 0
+	for n < max && n < len(s) && isDigit(s[n], code == HexColorCode) {
+		n++
+	}
+	return n
+}
+
+func isDigit(ch byte, hex bool) bool {
+	return ('0' <= ch && ch <= '9') ||
+		(hex && (('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')))
+}
+
+func parseCode(s string) Color {
+	n, _ := strconv.Atoi(s)
+	if n == 99 {
+		return None //99 is the default colour
+	}
+	return Color(n)
+}
+
+func parseHex(s string) Color {
+	n, _ := strconv.ParseUint(s, 16, 32)
+	return RGB(uint8(n>>16), uint8(n>>8), uint8(n))
+}
+
+//colorCode returns the formatting code to set the colours
+func colorCode(fg, bg Color) string {
+	if fg == None && bg == None {
+		return string(ColorCode)
+	}
+	if fg.IsRGB() || bg.IsRGB() {
+		code := string(HexColorCode) + hexOrDefault(fg)
+		if bg != None {
+			code += "," + hexOrDefault(bg)
+		}
+		return code
+	}
+
+	code := string(ColorCode) + fmt.Sprintf("%02d", codeOrDefault(fg))
+	if bg != None {
+		code += fmt.Sprintf(",%02d", codeOrDefault(bg))
+	}
+	return code
+}
+
+func codeOrDefault(c Color) int32 {
+	if c == None {
+		return 99
+	}
+	return int32(c)
+}
+
+func hexOrDefault(c Color) string {
+	if c == None {
+		return Black.Hex()
+	}
+	return c.Hex()
+}
@@ -0,0 +1,163 @@
+/*
+Package formatting parses, strips and renders the mIRC formatting codes
+used in IRC messages (bold, colours, etc).
+
+The following codes are supported:
+
+	\x02 bold           \x1d italic        \x1f underline
+	\x1e strikethrough  \x11 monospace     \x16 reverse
+	\x0f reset          \x03fg[,bg] colour \x04RRGGBB[,RRGGBB] hex colour
+
+https://modern.ircdocs.horse/formatting.html
+*/
+package formatting
+
+import "strings"
+
+//Formatting control codes
+const (
+	Bold          = '\x02'
+	ColorCode     = '\x03'
+	HexColorCode  = '\x04'
+	Monospace     = '\x11'
+	Reverse       = '\x16'
+	Italic        = '\x1d'
+	Strikethrough = '\x1e'
+	Underline     = '\x1f'
+	Reset         = '\x0f'
+)
+
+//Style represents the formatting applied to a span of text
+type Style struct {
+	Bold          bool
+	Italic        bool
+	Underline     bool
+	Strikethrough bool
+	Monospace     bool
+	Reverse       bool
+	Foreground    Color
+	Background    Color
+}
+
+//Plain is a Style with no formatting
+var Plain = Style{Foreground: None, Background: None}
+
+//Span is a piece of text with a single style
+type Span struct {
+	Text string
+	Style
+}
+
+//Parse breaks the text into spans of identically styled text. Formatting
+//codes are removed from the text of the spans.
+func Parse(text string) []Span {
+	var spans []Span
+	var buf strings.Builder
+	style := Plain
+
+	flush := func() {
+		if buf.Len() > 0 {
+			spans = append(spans, Span{Text: buf.String(), Style: style})
+			buf.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case Bold:
+			flush()
+			style.Bold = !style.Bold
+		case Italic:
+			flush()
+			style.Italic = !style.Italic
+		case Underline:
+			flush()
+			style.Underline = !style.Underline
+		case Strikethrough:
+			flush()
+			style.Strikethrough = !style.Strikethrough
+		case Monospace:
+			flush()
+			style.Monospace = !style.Monospace
+		case Reverse:
+			flush()
+			style.Reverse = !style.Reverse
+		case Reset:
+			flush()
+			style = Plain
+		case ColorCode, HexColorCode:
+			flush()
+			fg, bg, hasBg, n := parseColor(text[i:])
+			if n == 1 {
+				//A colour code with no colours resets them
+				style.Foreground, style.Background = None, None
+			} else {
+				style.Foreground = fg
+				if hasBg {
+					style.Background = bg
+				}
+			}
+			i += n - 1
+		default:
+			buf.WriteByte(text[i])
+		}
+	}
+	flush()
+	return spans
+}
+
+//Strip removes all formatting codes from the text
+func Strip(text string) string {
+	var buf strings.Builder
+	for _, span := range Parse(text) {
+		buf.WriteString(span.Text)
+	}
+	return buf.String()
+}
+
+//Format builds formatted text from the spans. It is the inverse of Parse.
+func Format(spans []Span) string {
+	var buf strings.Builder
+	current := Plain
+	for _, span := range spans {
+		if span.Text == "" {
+			continue
+		}
+		next := span.Style
+		if turnsOff(current, next) {
+			buf.WriteByte(Reset)
+			current = Plain
+		}
+
+		toggle := func(was, is bool, code byte) {
+			if was != is {
+				buf.WriteByte(code)
+			}
+		}
+		toggle(current.Bold, next.Bold, Bold)
+		toggle(current.Italic, next.Italic, Italic)
+		toggle(current.Underline, next.Underline, Underline)
+		toggle(current.Strikethrough, next.Strikethrough, Strikethrough)
+		toggle(current.Monospace, next.Monospace, Monospace)
+		toggle(current.Reverse, next.Reverse, Reverse)
+
+		if next.Foreground != current.Foreground || next.Background != current.Background {
+			buf.WriteString(colorCode(next.Foreground, next.Background))
+			if strings.HasPrefix(span.Text, ",") {
+				//Keep a leading comma from being read as part of the colour
+				buf.WriteString(string(Bold) + string(Bold))
+			}
+		}
+
+		buf.WriteString(span.Text)
+		current = next
+	}
+	return buf.String()
+}
+
+//turnsOff returns true if going from one style to the next requires
+//a reset (e.g. removing a colour)
+func turnsOff(from, to Style) bool {
+	return (from.Foreground != None && to.Foreground == None) ||
+		(from.Background != None && to.Background == None)
+}
@@ -0,0 +1,83 @@
+package formatting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func style(f func(*Style)) Style {
+	s := Plain
+	f(&s)
+	return s
+}
+
+var parseInput = []struct {
+	text     string
+	expected []Span
+}{
+	{"plain text", []Span{{"plain text", Plain}}},
+	{"\x02bold\x02 not", []Span{
+		{"bold", style(func(s *Style) { s.Bold = true })},
+		{" not", Plain}}},
+	{"\x0304red\x0f \x1d\x1fboth", []Span{
+		{"red", style(func(s *Style) { s.Foreground = Red })},
+		{" ", Plain},
+		{"both", style(func(s *Style) { s.Italic, s.Underline = true, true })}}},
+	{"\x034,12a\x037b\x03c", []Span{
+		{"a", style(func(s *Style) { s.Foreground, s.Background = Red, LightBlue })},
+		{"b", style(func(s *Style) { s.Foreground, s.Background = Orange, LightBlue })},
+		{"c", Plain}}},
+	{"\x0312,x\x0399,01y", []Span{
+		{",x", style(func(s *Style) { s.Foreground = LightBlue })},
+		{"y", style(func(s *Style) { s.Background = Black })}}},
+	{"\x04FF8000,000000hex\x04ab", []Span{
+		{"hex", style(func(s *Style) { s.Foreground, s.Background = RGB(0xff, 0x80, 0), RGB(0, 0, 0) })},
+		{"ab", Plain}}},
+	{"\x1e\x11\x16x", []Span{{"x", style(func(s *Style) { s.Strikethrough, s.Monospace, s.Reverse = true, true, true })}}},
+}
+
+func TestParse(t *testing.T) {
+	for j, in := range parseInput {
+		actual := Parse(in.text)
+		if !reflect.DeepEqual(actual, in.expected) {
+			t.Errorf("input[%d]: Parse() returned the wrong spans.\nExpected: %+v\nReceived: %+v", j, in.expected, actual)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	stripped := Strip("\x02\x0304,01Hello\x0f \x1fworld\x1f\x04ff00ff!")
+	if stripped != "Hello world!" {
+		t.Errorf("Strip() did not remove formatting. Received: %q", stripped)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for j, in := range parseInput {
+		formatted := Format(in.expected)
+		if actual := Parse(formatted); !reflect.DeepEqual(actual, in.expected) {
+			t.Errorf("input[%d]: Format() is not the inverse of Parse(). Formatted: %q, Parsed: %+v", j, formatted, actual)
+		}
+	}
+
+	formatted := Format([]Span{{"a", style(func(s *Style) { s.Bold = true })}, {"b", style(func(s *Style) { s.Bold, s.Foreground = true, Green })}, {"c", Plain}})
+	if formatted != "\x02a\x0303b\x0fc" {
+		t.Errorf("Format() returned unexpected codes: %q", formatted)
+	}
+}
+
+func TestANSI(t *testing.T) {
+	ansi := ANSI("a\x02\x0304b\x0fc")
+	if ansi != "a\x1b[0;1;38;2;255;0;0mb\x1b[0mc" {
+		t.Errorf("ANSI() returned unexpected output: %q", ansi)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	out := HTML("<a>\x02\x0302,15b\x0f & \x16c")
+	expected := `&lt;a&gt;<span style="font-weight:bold;color:#00007F;background-color:#D2D2D2">b</span> &amp; ` +
+		`<span style="color:#FFFFFF;background-color:#000000">c</span>`
+	if out != expected {
+		t.Errorf("HTML() returned unexpected output.\nExpected: %s\nReceived: %s", expected, out)
+	}
+}
@@ -0,0 +1,103 @@
+package formatting
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+//ANSI renders the formatted text using ANSI terminal escape codes.
+//Colours are rendered as 24 bit colour.
+func ANSI(text string) string {
+	var buf strings.Builder
+	styled := false
+	for _, span := range Parse(text) {
+		codes := ansiCodes(span.Style)
+		if len(codes) > 0 {
+			buf.WriteString("\x1b[0;" + strings.Join(codes, ";") + "m")
+			styled = true
+		} else if styled {
+			buf.WriteString("\x1b[0m")
+			styled = false
+		}
+		buf.WriteString(span.Text)
+	}
+	if styled {
+		buf.WriteString("\x1b[0m")
+	}
+	return buf.String()
+}
+
+func ansiCodes(s Style) []string {
+	var codes []string
+	flags := []struct {
+		on   bool
+		code string
+	}{{s.Bold, "1"}, {s.Italic, "3"}, {s.Underline, "4"}, {s.Reverse, "7"}, {s.Strikethrough, "9"}}
+	for _, f := range flags {
+		if f.on {
+			codes = append(codes, f.code)
+		}
+	}
+	if r, g, b, ok := s.Foreground.RGB(); ok {
+		codes = append(codes, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+	}
+	if r, g, b, ok := s.Background.RGB(); ok {
+		codes = append(codes, fmt.Sprintf("48;2;%d;%d;%d", r, g, b))
+	}
+	return codes
+}
+
+//HTML renders the formatted text as HTML. The text is escaped, and each
+//styled span is wrapped in a <span> with an inline style.
+func HTML(text string) string {
+	var buf strings.Builder
+	for _, span := range Parse(text) {
+		css := cssStyle(span.Style)
+		if css == "" {
+			buf.WriteString(html.EscapeString(span.Text))
+			continue
+		}
+		fmt.Fprintf(&buf, `<span style="%s">%s</span>`, css, html.EscapeString(span.Text))
+	}
+	return buf.String()
+}
+
+func cssStyle(s Style) string {
+	var css []string
+	if s.Bold {
+		css = append(css, "font-weight:bold")
+	}
+	if s.Italic {
+		css = append(css, "font-style:italic")
+	}
+	switch {
+	case s.Underline && s.Strikethrough:
+		css = append(css, "text-decoration:underline line-through")
+	case s.Underline:
+		css = append(css, "text-decoration:underline")
+	case s.Strikethrough:
+		css = append(css, "text-decoration:line-through")
+	}
+	if s.Monospace {
+		css = append(css, "font-family:monospace")
+	}
+
+	fg, bg := s.Foreground, s.Background
+	if s.Reverse {
+		if fg == None {
+			fg = Black
+		}
+		if bg == None {
+			bg = White
+		}
+		fg, bg = bg, fg
+	}
+	if hex := fg.Hex(); hex != "" {
+		css = append(css, "color:#"+hex)
+	}
+	if hex := bg.Hex(); hex != "" {
+		css = append(css, "background-color:#"+hex)
+	}
+	return strings.Join(css, ";")
+}
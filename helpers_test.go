@@ -0,0 +1,47 @@
+package irc
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+//bufferConn is an io.ReadWriteCloser that reads from a fixed set of lines
+//and records everything written to it
+type bufferConn struct {
+	r       *strings.Reader
+	written bytes.Buffer
+	mLock   sync.Mutex
+}
+
+func (b *bufferConn) Read(p []byte) (int, error) { return b.r.Read(p) }
+func (b *bufferConn) Close() error               { return nil }
+
+func (b *bufferConn) Write(p []byte) (int, error) {
+	b.mLock.Lock()
+	defer b.mLock.Unlock()
+	return b.written.Write(p)
+}
+
+//lines returns the lines written so far, safe to call while writes
+//are made from other goroutines
+func (b *bufferConn) lines() []string {
+	b.mLock.Lock()
+	defer b.mLock.Unlock()
+	return strings.Split(strings.TrimSpace(b.written.String()), "\r\n")
+}
+
+//newBufferConn returns a Conn that will read the supplied lines
+func newBufferConn(lines ...string) (Conn, *bufferConn) {
+	b := &bufferConn{r: strings.NewReader(strings.Join(lines, "\r\n") + "\r\n")}
+	return NewConnectionWrapper(b), b
+}
+
+//readAll reads from the conn until an error is returned
+func readAll(c Conn) {
+	for {
+		if _, err := c.Read(); err != nil {
+			return
+		}
+	}
+}
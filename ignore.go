@@ -0,0 +1,268 @@
+package irc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//IgnoreType selects the kinds of messages an ignore applies to. Types
+//can be combined, e.g. IgnorePrivMsg|IgnoreNotice.
+type IgnoreType int
+
+const (
+	IgnorePrivMsg  IgnoreType = 1 << iota //PRIVMSGs, including ACTIONs
+	IgnoreNotice                          //NOTICEs
+	IgnoreCTCP                            //CTCP requests and replies other than ACTION
+	IgnoreJoinPart                        //JOIN, PART, QUIT and NICK
+	IgnoreInvite                          //INVITE
+
+	IgnoreAll = IgnorePrivMsg | IgnoreNotice | IgnoreCTCP | IgnoreJoinPart | IgnoreInvite
+)
+
+//Ignore ignores the messages of users matching Mask or Account
+type Ignore struct {
+	Mask    string     `json:",omitempty"` //nick!user@host glob, e.g. *!*@spam.example
+	Account string     `json:",omitempty"`
+	Types   IgnoreType `json:",omitempty"` //Defaults to IgnoreAll
+	Expires time.Time  //Zero never expires
+}
+
+//expired reports whether the ignore has expired at t
+func (ig Ignore) expired(t time.Time) bool {
+	return !ig.Expires.IsZero() && !t.Before(ig.Expires)
+}
+
+//IgnoreConfig configures the ignore handler
+type IgnoreConfig struct {
+	Ignores []Ignore //Initial ignores, added to those loaded from File
+	//File the ignores are loaded from, and saved to whenever they change.
+	//Not saved if empty.
+	File string
+	//Nicks that are never ignored. Defaults to the usual services nicks
+	//(NickServ, ChanServ, etc). Messages from servers are never ignored.
+	Exempt []string
+}
+
+var defaultIgnoreExempt = []string{"NickServ", "ChanServ", "MemoServ", "OperServ", "HostServ", "BotServ", "SaslServ", "Global"}
+
+//IgnoreList silently drops messages from ignored users
+type IgnoreList interface {
+	//Ignore adds an ignore, replacing any with the same mask and account
+	Ignore(ig Ignore) error
+	//Unignore removes the ignores with the mask or account. Returns false
+	//if there were none.
+	Unignore(maskOrAccount string) (bool, error)
+	//Ignores returns the ignores that haven't expired
+	Ignores() []Ignore
+	//IsIgnored reports whether the message is from an ignored user
+	IsIgnored(msg Message) bool
+}
+
+type ignoreList struct {
+	client Client
+	file   string
+	exempt map[string]bool
+
+	mLock   *sync.Mutex
+	ignores []Ignore
+}
+
+//IgnoreHandler returns a ClientHandler ignoring users. Errors loading
+//the file are logged.
+func IgnoreHandler(conf IgnoreConfig) ClientHandler {
+	return func(client Client) {
+		if _, err := RegisterIgnoreHandler(client, conf); err != nil {
+			log.Printf("ignore: %v", err)
+		}
+	}
+}
+
+//RegisterIgnoreHandler adds an incoming interceptor dropping messages from
+//ignored users, so they never reach Conversations or handlers. Ignored
+//JOIN, PART, QUIT and NICK lines still update the client's channels.
+//The ignores are loaded from conf.File; a missing file isn't an error.
+func RegisterIgnoreHandler(client Client, conf IgnoreConfig) (IgnoreList, error) {
+	il := &ignoreList{client: client, file: conf.File, exempt: make(map[string]bool), mLock: new(sync.Mutex)}
+	exempt := conf.Exempt
+	if exempt == nil {
+		exempt = defaultIgnoreExempt
+	}
+	for _, nick := range exempt {
+		il.exempt[casefold(nick)] = true
+	}
+
+	var loaded []Ignore
+	var err error
+	if conf.File != "" {
+		loaded, err = loadIgnores(conf.File)
+	}
+	for _, ig := range append(loaded, conf.Ignores...) {
+		il.add(ig)
+	}
+	client.AddInterceptor(Incoming, il.intercept)
+	return il, err
+}
+
+func (il *ignoreList) Ignore(ig Ignore) error {
+	il.mLock.Lock()
+	il.add(ig)
+	il.mLock.Unlock()
+	return il.save()
+}
+
+//add adds the ignore. Called with mLock held.
+func (il *ignoreList) add(ig Ignore) {
+	if ig.Types == 0 {
+		ig.Types = IgnoreAll
+	}
+	for i, existing := range il.ignores {
+		if existing.Mask == ig.Mask && strings.EqualFold(existing.Account, ig.Account) {
+			il.ignores[i] = ig
+			return
+		}
+	}
+	il.ignores = append(il.ignores, ig)
+}
+
+func (il *ignoreList) Unignore(maskOrAccount string) (bool, error) {
+	il.mLock.Lock()
+	var kept []Ignore
+	for _, ig := range il.ignores {
+		if ig.Mask != maskOrAccount && !strings.EqualFold(ig.Account, maskOrAccount) {
+			kept = append(kept, ig)
+		}
+	}
+	removed := len(kept) != len(il.ignores)
+	il.ignores = kept
+	il.mLock.Unlock()
+	if !removed {
+		return false, nil
+	}
+	return true, il.save()
+}
+
+func (il *ignoreList) Ignores() []Ignore {
+	il.mLock.Lock()
+	defer il.mLock.Unlock()
+	il.prune()
+	return append([]Ignore(nil), il.ignores...)
+}
+
+//prune removes expired ignores. Called with mLock held.
+func (il *ignoreList) prune() {
+	now := time.Now()
+	kept := il.ignores[:0]
+	for _, ig := range il.ignores {
+		if !ig.expired(now) {
+			kept = append(kept, ig)
+		}
+	}
+	il.ignores = kept
+}
+
+func (il *ignoreList) IsIgnored(msg Message) bool {
+	//Servers, services and the client itself are never ignored
+	nick := msg.Nick()
+	if nick == "" || il.exempt[casefold(nick)] || strings.EqualFold(nick, il.client.Nick()) {
+		return false
+	}
+	t := ignoreType(msg)
+	if t == 0 {
+		return false
+	}
+
+	hostmask := strings.TrimPrefix(msg.Prefix(), ":")
+	account, ok := msg.Tags()["account"]
+	if !ok {
+		account, _ = il.client.Account(nick)
+	}
+
+	il.mLock.Lock()
+	defer il.mLock.Unlock()
+	now := time.Now()
+	for _, ig := range il.ignores {
+		if ig.Types&t == 0 || ig.expired(now) {
+			continue
+		}
+		if ig.Mask != "" && MatchMask(ig.Mask, hostmask) {
+			return true
+		}
+		if ig.Account != "" && account != "" && casefold(ig.Account) == casefold(account) {
+			return true
+		}
+	}
+	return false
+}
+
+func (il *ignoreList) intercept(msg Message, next Next) error {
+	if il.IsIgnored(msg) {
+		return nil
+	}
+	return next(msg)
+}
+
+//ignoreType returns the type of the message, or 0 if it can't be ignored
+func ignoreType(msg Message) IgnoreType {
+	switch msg.Command() {
+	case "PRIVMSG", "NOTICE":
+		if ctcp, ok := ParseCTCP(msg); ok && ctcp.Command != "ACTION" {
+			return IgnoreCTCP
+		}
+		if msg.Command() == "NOTICE" {
+			return IgnoreNotice
+		}
+		return IgnorePrivMsg
+	case "JOIN", "PART", "QUIT", "NICK":
+		return IgnoreJoinPart
+	case "INVITE":
+		return IgnoreInvite
+	}
+	return 0
+}
+
+func (il *ignoreList) save() error {
+	if il.file == "" {
+		return nil
+	}
+	il.mLock.Lock()
+	il.prune()
+	data, err := json.MarshalIndent(il.ignores, "", "\t")
+	il.mLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(il.file), filepath.Base(il.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), il.file)
+}
+
+func loadIgnores(path string) ([]Ignore, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ignores []Ignore
+	if err := json.Unmarshal(data, &ignores); err != nil {
+		return nil, err
+	}
+	return ignores, nil
+}
@@ -0,0 +1,98 @@
+package irc
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIgnoreHandler(t *testing.T) {
+	conn, b := newBufferConn(
+		":server 001 me :Welcome",
+		":me!u@h JOIN #chan",
+		":spammer!u@spam.example JOIN #chan",
+		":spammer!u@spam.example PRIVMSG #chan :buy now",
+		":alice!u@h PRIVMSG #chan :hello",
+		"@account=troll :bob!u@h PRIVMSG #chan :ignored by account",
+		":troll2!u@h NOTICE me :notices from troll2 are allowed",
+		":troll2!u@h PRIVMSG me :\x01VERSION\x01",
+		":NickServ!services@services PRIVMSG me :exempt",
+		":spam.example NOTICE me :servers are exempt",
+		":spammer!u@spam.example INVITE me #spam",
+		":spammer!u@spam.example PART #chan",
+	)
+	client := NewClientWrapper(conn)
+	ignores, err := RegisterIgnoreHandler(client, IgnoreConfig{Ignores: []Ignore{
+		{Mask: "*!*@spam.example"},
+		{Mask: "NickServ!*@*"},
+		{Account: "Troll"},
+		{Mask: "troll2!*@*", Types: IgnoreCTCP},
+		{Mask: "alice!*@*", Expires: time.Now().Add(-time.Minute)},
+	}, Exempt: []string{"nickserv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var read []string
+	var users []string
+	client.AddHandler(Incoming, func(msg Message) {
+		read = append(read, msg.Trailing())
+		if msg.Command() == "JOIN" {
+			users, _ = client.Users("#chan")
+		}
+	}, "PRIVMSG", "NOTICE", "INVITE", "JOIN", "PART")
+	client.Write(JoinMessage("#chan"))
+	readAll(client)
+
+	expected := []string{"", "hello", "notices from troll2 are allowed", "exempt", "servers are exempt"}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("Expected %q to be read, Received %q", expected, read)
+	}
+	if messages := client.Messages("#chan"); len(messages) != 1 {
+		t.Errorf("Expected ignored messages not to reach conversations, Received %q", messages)
+	}
+	//The ignored JOIN and PART still updated the channel
+	if len(users) != 1 {
+		t.Errorf("Expected only me when my JOIN was read, Received %v", users)
+	}
+	if users, _ := client.Users("#chan"); len(users) != 1 {
+		t.Errorf("Expected the ignored user to have parted, Received %v", users)
+	}
+	if lines := b.lines(); len(lines) != 1 {
+		t.Errorf("Expected the CTCP request not to be answered, Received %q", lines)
+	}
+
+	if n := len(ignores.Ignores()); n != 4 {
+		t.Errorf("Expected the expired ignore to be removed, Received %d ignores", n)
+	}
+	if ok, _ := ignores.Unignore("troll"); !ok {
+		t.Error("Expected to unignore troll")
+	}
+	if ignores.IsIgnored(NewMessage("@account=troll :bob!u@h PRIVMSG #chan :hi")) {
+		t.Error("Expected troll to no longer be ignored")
+	}
+}
+
+func TestIgnorePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ignores.json")
+	conn, _ := newBufferConn()
+	ignores, err := RegisterIgnoreHandler(NewClientWrapper(conn), IgnoreConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour).Round(time.Second)
+	ignores.Ignore(Ignore{Mask: "*!*@spam.example", Types: IgnorePrivMsg | IgnoreNotice, Expires: expires})
+	ignores.Ignore(Ignore{Account: "troll"})
+
+	conn, _ = newBufferConn()
+	loaded, err := RegisterIgnoreHandler(NewClientWrapper(conn), IgnoreConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.Ignores()
+	if len(got) != 2 || got[0].Mask != "*!*@spam.example" || got[0].Types != IgnorePrivMsg|IgnoreNotice ||
+		!got[0].Expires.Equal(expires) || got[1].Account != "troll" || got[1].Types != IgnoreAll {
+		t.Errorf("Unexpected ignores loaded %+v", got)
+	}
+}
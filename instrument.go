@@ -0,0 +1,32 @@
+package irc
+
+import "time"
+
+//Instrumentation is notified of a Conn's activity, e.g. to collect
+//metrics (see the metrics package). Its methods are called from the
+//goroutines reading and writing, so need to be safe for concurrent use.
+type Instrumentation interface {
+	//LineRead is called for each line read, including its CRLF
+	LineRead(command string, bytes int)
+	//WriteStarted is called before a line is written, and WriteDone after
+	WriteStarted()
+	WriteDone(command string, bytes int, err error)
+	//HandlerDone is called after each MessageHandler returns or panics
+	HandlerDone(command string, d time.Duration, panicked bool)
+}
+
+//Instrument reports the Conn's activity to the Instrumentation. Panics in
+//MessageHandlers are counted before they propagate.
+func Instrument(i Instrumentation) ConnOption {
+	return func(c *conn) {
+		c.instr = i
+	}
+}
+
+//RecoverPanics logs and recovers panics in MessageHandlers, so the Conn
+//keeps running instead of crashing the program
+func RecoverPanics() ConnOption {
+	return func(c *conn) {
+		c.recoverPanics = true
+	}
+}
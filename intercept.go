@@ -0,0 +1,35 @@
+package irc
+
+//Next passes a message on to the rest of the interceptor chain
+type Next func(Message) error
+
+//Interceptor is called with each message before the MessageHandlers.
+//It decides what happens to the message by calling next:
+//   - not calling next drops the message
+//   - calling next with a different message rewrites it
+//   - calling next more than once injects messages
+//   - calling next later, e.g. from a goroutine, delays the message
+//
+//Incoming messages are intercepted as they are read, and the messages
+//passed to next are the ones returned by Read, after the incoming
+//MessageHandlers are called for them. Messages passed to next after the
+//interceptor returns are returned by a later Read, which waits for them
+//as well as for lines from the server. Messages delayed past the end of
+//the connection are returned by Reads after the error.
+//
+//Outgoing messages are intercepted when written. The messages passed to
+//next are sent, and then the outgoing MessageHandlers are called for them.
+//The error returned by next is the error from sending the message.
+type Interceptor func(msg Message, next Next) error
+
+//chain returns a Next that calls the interceptors in order, ending with final
+func chain(interceptors []Interceptor, final Next) Next {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, n := interceptors[i], next
+		next = func(msg Message) error {
+			return interceptor(msg, n)
+		}
+	}
+	return next
+}
@@ -0,0 +1,189 @@
+package irc
+
+import (
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncomingInterceptors(t *testing.T) {
+	conn, _ := newBufferConn(
+		":alice!u@h PRIVMSG #chan :hello",
+		":spammer!u@h PRIVMSG #chan :buy now",
+		":bob!u@h PRIVMSG #chan :hi",
+	)
+
+	var order []string
+	//Drop messages from spammer
+	conn.AddInterceptor(Incoming, func(msg Message, next Next) error {
+		order = append(order, "drop "+msg.Nick())
+		if msg.Nick() == "spammer" {
+			return nil
+		}
+		return next(msg)
+	})
+	//Inject a message after each one, and rewrite the text
+	conn.AddInterceptor(Incoming, func(msg Message, next Next) error {
+		order = append(order, "inject "+msg.Nick())
+		next(NewMessage(strings.Replace(msg.String(), ":h", ":HI", 1)))
+		return next(NewMessage(":server NOTICE me :after " + msg.Nick()))
+	})
+	conn.AddHandler(Incoming, func(msg Message) {
+		order = append(order, "handler "+msg.Trailing())
+	})
+
+	var read []string
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			break
+		}
+		read = append(read, msg.Trailing())
+	}
+
+	expectedRead := []string{"HIello", "after alice", "HIi", "after bob"}
+	if !reflect.DeepEqual(read, expectedRead) {
+		t.Errorf("Expected to read %q, Received %q", expectedRead, read)
+	}
+	expectedOrder := []string{
+		"drop alice", "inject alice", "handler HIello", "handler after alice",
+		"drop spammer",
+		"drop bob", "inject bob", "handler HIi", "handler after bob",
+	}
+	if !reflect.DeepEqual(order, expectedOrder) {
+		t.Errorf("Expected order %q, Received %q", expectedOrder, order)
+	}
+}
+
+func TestOutgoingInterceptors(t *testing.T) {
+	conn, b := newBufferConn()
+	var handled []string
+	conn.AddHandler(Outgoing, func(msg Message) {
+		handled = append(handled, msg.String())
+	})
+	conn.AddInterceptor(Both, func(msg Message, next Next) error {
+		if strings.Contains(msg.Trailing(), "password") {
+			return nil
+		}
+		return next(msg)
+	})
+	conn.AddInterceptor(Outgoing, func(msg Message, next Next) error {
+		if msg.Command() == "JOIN" {
+			if err := next(NewMessage("MODE me +i")); err != nil {
+				return err
+			}
+		}
+		return next(msg)
+	})
+
+	conn.Write(PrivMessage("#chan", "my password is hunter2"))
+	conn.Write(JoinMessage("#chan"))
+	expected := []string{"MODE me +i", "JOIN #chan"}
+	if lines := b.lines(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q to be written, Received %q", expected, lines)
+	}
+	if !reflect.DeepEqual(handled, expected) {
+		t.Errorf("Expected handlers to be called for %q, Received %q", expected, handled)
+	}
+}
+
+func TestDelayedIncoming(t *testing.T) {
+	r, w := io.Pipe()
+	conn := NewConnectionWrapper(pipeConn{r})
+	conn.AddInterceptor(Incoming, func(msg Message, next Next) error {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			next(msg)
+		}()
+		return nil
+	})
+
+	//The server sends nothing more, so Read must wake for the delayed message
+	go io.WriteString(w, ":alice!u@h PRIVMSG #chan :later\r\n")
+	read := make(chan Message)
+	go func() {
+		msg, _ := conn.Read()
+		read <- msg
+	}()
+	select {
+	case msg := <-read:
+		if msg.Trailing() != "later" {
+			t.Errorf("Expected the delayed message, Received %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the delayed message")
+	}
+
+	//Messages delayed past the end of the connection are returned by
+	//Reads after the error
+	go func() {
+		io.WriteString(w, ":alice!u@h PRIVMSG #chan :last\r\n")
+		w.Close()
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		msg, err := conn.Read()
+		if err == nil {
+			if msg.Trailing() != "last" {
+				t.Errorf("Expected the message delayed past EOF, Received %s", msg)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The message delayed past EOF was lost")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStateHandlersSeeDroppedLines(t *testing.T) {
+	conn, _ := newBufferConn(
+		":alice!u@h JOIN #chan",
+		":alice!u@h PRIVMSG #chan :hi",
+	)
+	var state, handled []string
+	conn.AddHandler(IncomingState, func(msg Message) {
+		state = append(state, msg.Command())
+	})
+	conn.AddInterceptor(Incoming, func(msg Message, next Next) error {
+		if msg.Command() == "JOIN" {
+			return nil
+		}
+		return next(msg)
+	})
+	conn.AddHandler(Incoming, func(msg Message) {
+		handled = append(handled, msg.Command())
+	})
+	readAll(conn)
+
+	if !reflect.DeepEqual(state, []string{"JOIN", "PRIVMSG"}) {
+		t.Errorf("Expected state handlers to see every line, Received %q", state)
+	}
+	if !reflect.DeepEqual(handled, []string{"PRIVMSG"}) {
+		t.Errorf("Expected handlers not to see the dropped JOIN, Received %q", handled)
+	}
+}
+
+func TestCloseStopsScanner(t *testing.T) {
+	before := runtime.NumGoroutine()
+	r, w := io.Pipe()
+	conn := NewConnectionWrapper(pipeConn{r})
+	conn.AddInterceptor(Incoming, func(msg Message, next Next) error { return next(msg) })
+	go io.WriteString(w, ":server PING :a\r\n:server PING :b\r\n")
+	if _, err := conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	//The scanner is waiting to send the second line
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the scanner to stop, %d goroutines remain of %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
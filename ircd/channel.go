@@ -0,0 +1,153 @@
+package ircd
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Member prefixes
+const (
+	prefixOp    = "@"
+	prefixVoice = "+"
+)
+
+type channel struct {
+	name       string
+	topic      string
+	topicBy    string
+	topicTime  time.Time
+	created    time.Time
+	key        string
+	limit      int
+	inviteOnly bool
+	moderated  bool
+	noExternal bool
+	secret     bool
+	topicLock  bool
+	bans       []ban
+
+	members map[*client]string //Value is the member's prefix (@, +, or both)
+}
+
+type ban struct {
+	mask  string
+	setBy string
+	time  time.Time
+}
+
+func newChannel(name string) *channel {
+	return &channel{name: name, created: time.Now(), noExternal: true, topicLock: true, members: make(map[*client]string)}
+}
+
+func (ch *channel) isOp(c *client) bool {
+	return strings.Contains(ch.members[c], prefixOp)
+}
+
+func (ch *channel) isVoiced(c *client) bool {
+	return strings.Contains(ch.members[c], prefixVoice)
+}
+
+func (ch *channel) isMember(c *client) bool {
+	_, ok := ch.members[c]
+	return ok
+}
+
+//setPrefix adds or removes a prefix from a member
+func (ch *channel) setPrefix(c *client, prefix string, add bool) {
+	p := strings.Replace(ch.members[c], prefix, "", -1)
+	if add {
+		p += prefix
+	}
+	//Keep ops before voice
+	if strings.Contains(p, prefixOp) && strings.Contains(p, prefixVoice) {
+		p = prefixOp + prefixVoice
+	}
+	ch.members[c] = p
+}
+
+//isBanned returns true if the client matches a ban mask
+func (ch *channel) isBanned(c *client) bool {
+	mask := casefold(c.prefix())
+	for _, b := range ch.bans {
+		if ok, _ := path.Match(escapeGlob(casefold(b.mask)), mask); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//canSpeak returns true if the client may send messages to the channel
+func (ch *channel) canSpeak(c *client) bool {
+	member := ch.isMember(c)
+	switch {
+	case ch.noExternal && !member:
+		return false
+	case ch.moderated && !ch.isOp(c) && !ch.isVoiced(c):
+		return false
+	case ch.isBanned(c) && !ch.isOp(c) && !ch.isVoiced(c):
+		return false
+	}
+	return true
+}
+
+func (ch *channel) remove(c *client) {
+	delete(ch.members, c)
+	delete(c.channels, ch)
+	if len(ch.members) == 0 {
+		delete(c.server.channels, casefold(ch.name))
+	}
+}
+
+//modes returns the channel modes and their parameters. The key is only
+//shown to members.
+func (ch *channel) modes(showKey bool) string {
+	modes, params := "+", []string{}
+	flags := []struct {
+		on   bool
+		mode string
+	}{{ch.inviteOnly, "i"}, {ch.moderated, "m"}, {ch.noExternal, "n"}, {ch.secret, "s"}, {ch.topicLock, "t"}}
+	for _, f := range flags {
+		if f.on {
+			modes += f.mode
+		}
+	}
+	if ch.limit > 0 {
+		modes += "l"
+		params = append(params, itoa(ch.limit))
+	}
+	if ch.key != "" {
+		modes += "k"
+		if showKey {
+			params = append(params, ch.key)
+		} else {
+			params = append(params, "*")
+		}
+	}
+	return strings.TrimSpace(modes + " " + strings.Join(params, " "))
+}
+
+//sortedMembers returns the members sorted by nick
+func (ch *channel) sortedMembers() []*client {
+	members := make([]*client, 0, len(ch.members))
+	for m := range ch.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return casefold(members[i].nick) < casefold(members[j].nick) })
+	return members
+}
+
+//broadcast sends the line to all members except the one excluded (if any)
+func (ch *channel) broadcast(line string, except *client) {
+	for m := range ch.members {
+		if m != except {
+			m.send(line)
+		}
+	}
+}
+
+//escapeGlob escapes characters path.Match treats specially other than * and ?
+func escapeGlob(mask string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(mask)
+}
@@ -0,0 +1,185 @@
+package ircd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+const (
+	maxNickLen = 30
+	maxModes   = 4
+	maxTargets = 4 //PRIVMSG and NOTICE targets, as advertised in TARGMAX
+)
+
+//client is a connection to the server. Fields other than conn and
+//sendq are protected by the server lock.
+type client struct {
+	server *Server
+	conn   irc.Conn
+	sendq  chan string
+	closer *sync.Once
+	sLock  *sync.Mutex //Protects sendq and closed
+	closed bool
+
+	nick      string
+	user      string
+	host      string
+	realname  string
+	password  string
+	away      string
+	oper      bool
+	invisible bool
+
+	registered bool
+	channels   map[*channel]bool
+	invited    map[string]bool //Channels we've been invited to (casefolded)
+	lastActive time.Time
+	pingSent   time.Time
+	signon     time.Time
+}
+
+func newClient(s *Server, conn irc.Conn, host string) *client {
+	return &client{
+		server:     s,
+		conn:       conn,
+		sendq:      make(chan string, s.conf.SendQ),
+		closer:     new(sync.Once),
+		sLock:      new(sync.Mutex),
+		host:       host,
+		channels:   make(map[*channel]bool),
+		invited:    make(map[string]bool),
+		lastActive: time.Now(),
+		signon:     time.Now(),
+	}
+}
+
+func (c *client) readLoop() {
+	for {
+		msg, err := c.conn.Read()
+		if err != nil {
+			c.quit("Connection closed")
+			return
+		}
+		if msg.Command() == "" {
+			continue
+		}
+		c.server.mLock.Lock()
+		c.lastActive = time.Now()
+		c.pingSent = time.Time{}
+		c.server.mLock.Unlock()
+
+		if !c.handle(msg) {
+			return
+		}
+	}
+}
+
+func (c *client) writeLoop() {
+	for line := range c.sendq {
+		if err := c.conn.Write(irc.NewMessage(line)); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+	c.conn.Close()
+}
+
+//send queues lines to be sent to the client. Clients that can't keep up
+//are disconnected.
+func (c *client) send(lines ...string) {
+	c.sLock.Lock()
+	defer c.sLock.Unlock()
+	if c.closed {
+		return
+	}
+	for _, line := range lines {
+		select {
+		case c.sendq <- line:
+		default:
+			go c.quit("SendQ exceeded")
+			return
+		}
+	}
+}
+
+//numeric sends a numeric reply. Must be called with the server lock held.
+func (c *client) numeric(num string, params ...string) {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.send(":" + c.server.conf.Name + " " + num + " " + nick + " " + strings.Join(params, " "))
+}
+
+//prefix returns nick!user@host
+func (c *client) prefix() string {
+	return c.nick + "!" + c.user + "@" + c.host
+}
+
+//quit removes the client from the server and closes its connection,
+//telling users sharing a channel with it
+func (c *client) quit(reason string) {
+	c.closer.Do(func() {
+		s := c.server
+		s.mLock.Lock()
+		if c.registered {
+			line := ":" + c.prefix() + " QUIT :" + reason
+			for _, other := range c.neighbours() {
+				other.send(line)
+			}
+		}
+		for ch := range c.channels {
+			ch.remove(c)
+		}
+		if s.nicks[casefold(c.nick)] == c {
+			delete(s.nicks, casefold(c.nick))
+		}
+		delete(s.clients, c)
+		s.mLock.Unlock()
+
+		c.sLock.Lock()
+		select {
+		case c.sendq <- "ERROR :Closing Link: " + c.host + " (" + reason + ")":
+		default:
+		}
+		c.closed = true
+		close(c.sendq)
+		c.sLock.Unlock()
+	})
+}
+
+//neighbours returns the other clients sharing a channel with this one.
+//Must be called with the server lock held.
+func (c *client) neighbours() []*client {
+	seen := make(map[*client]bool)
+	var clients []*client
+	for ch := range c.channels {
+		for m := range ch.members {
+			if m != c && !seen[m] {
+				seen[m] = true
+				clients = append(clients, m)
+			}
+		}
+	}
+	return clients
+}
+
+//umodes returns the client's user modes
+func (c *client) umodes() string {
+	modes := "+"
+	if c.invisible {
+		modes += "i"
+	}
+	if c.oper {
+		modes += "o"
+	}
+	return modes
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
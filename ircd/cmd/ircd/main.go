@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/oooska/irc/ircd"
+)
+
+var config = flag.String("config", "ircd.json", "Path to the JSON config file")
+
+//A small IRC server. See ircd.Config for the config file format.
+func main() {
+	flag.Parse()
+
+	conf, err := ircd.LoadConfig(*config)
+	if err != nil {
+		log.Fatalf("Unable to load config: %s", err.Error())
+	}
+
+	server := ircd.NewServer(conf)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		log.Print("Shutting down...")
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != ircd.ErrServerClosed {
+		log.Fatalf("Error: %s", err.Error())
+	}
+}
@@ -0,0 +1,617 @@
+package ircd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//commands that may be used before registration
+var preRegistration = map[string]bool{"PASS": true, "NICK": true, "USER": true, "PING": true, "PONG": true, "QUIT": true, "CAP": true}
+
+//handle processes a message from the client. Returns false if the client quit.
+func (c *client) handle(msg irc.Message) bool {
+	s := c.server
+	params := params(msg)
+
+	if msg.Command() == "QUIT" {
+		reason := "Client Quit"
+		if len(params) > 0 {
+			reason = "Quit: " + params[0]
+		}
+		c.quit(reason)
+		return false
+	}
+
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	if !c.registered && !preRegistration[msg.Command()] {
+		c.numeric("451", ":You have not registered")
+		return true
+	}
+
+	switch msg.Command() {
+	case "PASS":
+		if c.registered {
+			c.numeric("462", ":You may not reregister")
+		} else if len(params) < 1 {
+			c.numeric("461", "PASS", ":Not enough parameters")
+		} else {
+			c.password = params[0]
+		}
+	case "NICK":
+		c.handleNick(params)
+	case "USER":
+		c.handleUser(params)
+	case "CAP":
+		//We don't support any capabilities, but must not stall clients that ask
+		if len(params) > 0 && strings.ToUpper(params[0]) == "LS" {
+			c.send(":" + s.conf.Name + " CAP * LS :")
+		}
+	case "PING":
+		if len(params) < 1 {
+			c.numeric("409", ":No origin specified")
+		} else {
+			c.send(":" + s.conf.Name + " PONG " + s.conf.Name + " :" + params[0])
+		}
+	case "PONG":
+	case "JOIN":
+		c.handleJoin(params)
+	case "PART":
+		c.handlePart(params)
+	case "PRIVMSG", "NOTICE":
+		c.handlePrivmsg(msg.Command(), params)
+	case "TOPIC":
+		c.handleTopic(params)
+	case "NAMES":
+		if len(params) == 0 {
+			c.numeric("366", "*", ":End of /NAMES list.")
+		}
+		for _, name := range splitList(params) {
+			if ch := s.channels[casefold(name)]; ch != nil && (!ch.secret || ch.isMember(c)) {
+				c.sendNames(ch)
+			} else {
+				c.numeric("366", name, ":End of /NAMES list.")
+			}
+		}
+	case "MODE":
+		c.handleMode(params)
+	case "KICK":
+		c.handleKick(params)
+	case "INVITE":
+		c.handleInvite(params)
+	case "WHO":
+		c.handleWho(params)
+	case "WHOIS":
+		c.handleWhois(params)
+	case "AWAY":
+		if len(params) == 0 || params[0] == "" {
+			c.away = ""
+			c.numeric("305", ":You are no longer marked as being away")
+		} else {
+			c.away = params[0]
+			c.numeric("306", ":You have been marked as being away")
+		}
+	case "OPER":
+		if len(params) < 2 {
+			c.numeric("461", "OPER", ":Not enough parameters")
+		} else if pass, ok := s.conf.Opers[params[0]]; !ok || pass != params[1] {
+			c.numeric("464", ":Password incorrect")
+		} else {
+			c.oper = true
+			c.send(":" + c.nick + " MODE " + c.nick + " :+o")
+			c.numeric("381", ":You are now an IRC operator")
+		}
+	case "MOTD":
+		c.sendMOTD()
+	case "LUSERS":
+		c.sendLusers()
+	default:
+		c.numeric("421", msg.Command(), ":Unknown command")
+	}
+	return true
+}
+
+func (c *client) handleNick(params []string) {
+	s := c.server
+	if len(params) < 1 || params[0] == "" {
+		c.numeric("431", ":No nickname given")
+		return
+	}
+	nick := params[0]
+	if !validNick(nick) {
+		c.numeric("432", nick, ":Erroneous nickname")
+		return
+	}
+	if other := s.nicks[casefold(nick)]; other != nil && other != c {
+		c.numeric("433", nick, ":Nickname is already in use")
+		return
+	}
+
+	if c.registered {
+		line := ":" + c.prefix() + " NICK :" + nick
+		c.send(line)
+		for _, other := range c.neighbours() {
+			other.send(line)
+		}
+	}
+	delete(s.nicks, casefold(c.nick))
+	s.nicks[casefold(nick)] = c
+	c.nick = nick
+	c.register()
+}
+
+func (c *client) handleUser(params []string) {
+	if c.registered {
+		c.numeric("462", ":You may not reregister")
+		return
+	}
+	if len(params) < 4 {
+		c.numeric("461", "USER", ":Not enough parameters")
+		return
+	}
+	c.user = "~" + params[0]
+	if len(c.user) > 11 {
+		c.user = c.user[:11]
+	}
+	c.realname = params[3]
+	c.register()
+}
+
+//register completes registration once NICK and USER have been recieved
+func (c *client) register() {
+	s := c.server
+	if c.registered || c.nick == "" || c.user == "" {
+		return
+	}
+	if s.conf.Password != "" && c.password != s.conf.Password {
+		c.numeric("464", ":Password incorrect")
+		go c.quit("Bad password")
+		return
+	}
+
+	c.registered = true
+	c.numeric("001", ":Welcome to the "+s.conf.Network+" IRC Network "+c.prefix())
+	c.numeric("002", ":Your host is "+s.conf.Name+", running github.com/oooska/irc/ircd")
+	c.numeric("003", ":This server was created "+s.created.Format(time.RFC1123))
+	c.numeric("004", s.conf.Name, "oooska-ircd", "io", "biklmnostv", "bklov")
+	isupport := s.isupport()
+	for len(isupport) > 0 {
+		n := 12
+		if n > len(isupport) {
+			n = len(isupport)
+		}
+		c.numeric("005", strings.Join(isupport[:n], " "), ":are supported by this server")
+		isupport = isupport[n:]
+	}
+	c.sendLusers()
+	c.sendMOTD()
+}
+
+func (c *client) sendLusers() {
+	s := c.server
+	users, invisible := 0, 0
+	for cl := range s.clients {
+		if cl.registered {
+			users++
+			if cl.invisible {
+				invisible++
+			}
+		}
+	}
+	c.numeric("251", ":There are "+itoa(users-invisible)+" users and "+itoa(invisible)+" invisible on 1 servers")
+	c.numeric("254", itoa(len(s.channels)), ":channels formed")
+	c.numeric("255", ":I have "+itoa(users)+" clients and 0 servers")
+}
+
+func (c *client) sendMOTD() {
+	s := c.server
+	if len(s.conf.MOTD) == 0 {
+		c.numeric("422", ":MOTD File is missing")
+		return
+	}
+	c.numeric("375", ":- "+s.conf.Name+" Message of the day - ")
+	for _, line := range s.conf.MOTD {
+		c.numeric("372", ":- "+line)
+	}
+	c.numeric("376", ":End of /MOTD command.")
+}
+
+func (c *client) handleJoin(params []string) {
+	s := c.server
+	if len(params) < 1 {
+		c.numeric("461", "JOIN", ":Not enough parameters")
+		return
+	}
+	if params[0] == "0" {
+		for ch := range c.channels {
+			ch.broadcast(":"+c.prefix()+" PART "+ch.name+" :Left all channels", nil)
+			ch.remove(c)
+		}
+		return
+	}
+
+	names := strings.Split(params[0], ",")
+	var keys []string
+	if len(params) > 1 {
+		keys = strings.Split(params[1], ",")
+	}
+	for k, name := range names {
+		if !validChannel(name) {
+			c.numeric("403", name, ":No such channel")
+			continue
+		}
+		ch := s.channels[casefold(name)]
+		if ch != nil && ch.isMember(c) {
+			continue
+		}
+		if len(c.channels) >= s.conf.MaxChannels {
+			c.numeric("405", name, ":You have joined too many channels")
+			continue
+		}
+
+		if ch == nil {
+			ch = newChannel(name)
+			s.channels[casefold(name)] = ch
+			ch.members[c] = prefixOp
+		} else {
+			key := ""
+			if k < len(keys) {
+				key = keys[k]
+			}
+			invited := c.invited[casefold(ch.name)]
+			switch {
+			case ch.key != "" && key != ch.key:
+				c.numeric("475", ch.name, ":Cannot join channel (+k)")
+				continue
+			case ch.limit > 0 && len(ch.members) >= ch.limit && !invited:
+				c.numeric("471", ch.name, ":Cannot join channel (+l)")
+				continue
+			case ch.inviteOnly && !invited:
+				c.numeric("473", ch.name, ":Cannot join channel (+i)")
+				continue
+			case ch.isBanned(c) && !invited:
+				c.numeric("474", ch.name, ":Cannot join channel (+b)")
+				continue
+			}
+			ch.members[c] = ""
+		}
+		delete(c.invited, casefold(ch.name))
+		c.channels[ch] = true
+
+		ch.broadcast(":"+c.prefix()+" JOIN "+ch.name, nil)
+		if ch.topic != "" {
+			c.sendTopic(ch)
+		}
+		c.sendNames(ch)
+	}
+}
+
+func (c *client) handlePart(params []string) {
+	if len(params) < 1 {
+		c.numeric("461", "PART", ":Not enough parameters")
+		return
+	}
+	reason := ""
+	if len(params) > 1 {
+		reason = params[1]
+	}
+	for _, name := range strings.Split(params[0], ",") {
+		ch := c.server.channels[casefold(name)]
+		if ch == nil {
+			c.numeric("403", name, ":No such channel")
+			continue
+		}
+		if !ch.isMember(c) {
+			c.numeric("442", ch.name, ":You're not on that channel")
+			continue
+		}
+		ch.broadcast(":"+c.prefix()+" PART "+ch.name+" :"+reason, nil)
+		ch.remove(c)
+	}
+}
+
+func (c *client) handlePrivmsg(command string, params []string) {
+	s := c.server
+	notice := command == "NOTICE"
+	if len(params) < 1 {
+		if !notice {
+			c.numeric("411", ":No recipient given ("+command+")")
+		}
+		return
+	}
+	if len(params) < 2 || params[1] == "" {
+		if !notice {
+			c.numeric("412", ":No text to send")
+		}
+		return
+	}
+
+	targets := strings.Split(params[0], ",")
+	if len(targets) > maxTargets {
+		if !notice {
+			c.numeric("407", params[0], ":Too many recipients")
+		}
+		return
+	}
+	for _, target := range targets {
+		line := ":" + c.prefix() + " " + command + " " + target + " :" + params[1]
+		if validChannel(target) {
+			ch := s.channels[casefold(target)]
+			if ch == nil {
+				if !notice {
+					c.numeric("401", target, ":No such nick/channel")
+				}
+				continue
+			}
+			if !ch.canSpeak(c) {
+				if !notice {
+					c.numeric("404", ch.name, ":Cannot send to channel")
+				}
+				continue
+			}
+			ch.broadcast(line, c)
+			continue
+		}
+
+		to := s.nicks[casefold(target)]
+		if to == nil || !to.registered {
+			if !notice {
+				c.numeric("401", target, ":No such nick/channel")
+			}
+			continue
+		}
+		to.send(line)
+		if to.away != "" && !notice {
+			c.numeric("301", to.nick, ":"+to.away)
+		}
+	}
+}
+
+func (c *client) handleTopic(params []string) {
+	if len(params) < 1 {
+		c.numeric("461", "TOPIC", ":Not enough parameters")
+		return
+	}
+	ch := c.server.channels[casefold(params[0])]
+	if ch == nil {
+		c.numeric("403", params[0], ":No such channel")
+		return
+	}
+	if len(params) == 1 {
+		if ch.secret && !ch.isMember(c) {
+			c.numeric("442", ch.name, ":You're not on that channel")
+		} else if ch.topic == "" {
+			c.numeric("331", ch.name, ":No topic is set")
+		} else {
+			c.sendTopic(ch)
+		}
+		return
+	}
+
+	if !ch.isMember(c) {
+		c.numeric("442", ch.name, ":You're not on that channel")
+		return
+	}
+	if ch.topicLock && !ch.isOp(c) {
+		c.numeric("482", ch.name, ":You're not channel operator")
+		return
+	}
+	topic := params[1]
+	if len(topic) > 390 {
+		topic = topic[:390]
+	}
+	ch.topic, ch.topicBy, ch.topicTime = topic, c.prefix(), time.Now()
+	ch.broadcast(":"+c.prefix()+" TOPIC "+ch.name+" :"+topic, nil)
+}
+
+func (c *client) sendTopic(ch *channel) {
+	c.numeric("332", ch.name, ":"+ch.topic)
+	c.numeric("333", ch.name, ch.topicBy, strconv.FormatInt(ch.topicTime.Unix(), 10))
+}
+
+func (c *client) sendNames(ch *channel) {
+	symbol := "="
+	if ch.secret {
+		symbol = "@"
+	}
+	var names []string
+	flush := func() {
+		if len(names) > 0 {
+			c.numeric("353", symbol, ch.name, ":"+strings.Join(names, " "))
+			names = nil
+		}
+	}
+	for _, m := range ch.sortedMembers() {
+		prefix := ch.members[m]
+		if len(prefix) > 1 {
+			prefix = prefix[:1]
+		}
+		names = append(names, prefix+m.nick)
+		if len(names) >= 20 {
+			flush()
+		}
+	}
+	flush()
+	c.numeric("366", ch.name, ":End of /NAMES list.")
+}
+
+func (c *client) handleKick(params []string) {
+	if len(params) < 2 {
+		c.numeric("461", "KICK", ":Not enough parameters")
+		return
+	}
+	ch := c.server.channels[casefold(params[0])]
+	if ch == nil {
+		c.numeric("403", params[0], ":No such channel")
+		return
+	}
+	if !ch.isMember(c) {
+		c.numeric("442", ch.name, ":You're not on that channel")
+		return
+	}
+	if !ch.isOp(c) {
+		c.numeric("482", ch.name, ":You're not channel operator")
+		return
+	}
+	reason := c.nick
+	if len(params) > 2 && params[2] != "" {
+		reason = params[2]
+	}
+	for _, nick := range strings.Split(params[1], ",") {
+		target := c.server.nicks[casefold(nick)]
+		if target == nil || !ch.isMember(target) {
+			c.numeric("441", nick, ch.name, ":They aren't on that channel")
+			continue
+		}
+		ch.broadcast(":"+c.prefix()+" KICK "+ch.name+" "+target.nick+" :"+reason, nil)
+		ch.remove(target)
+	}
+}
+
+func (c *client) handleInvite(params []string) {
+	if len(params) < 2 {
+		c.numeric("461", "INVITE", ":Not enough parameters")
+		return
+	}
+	target := c.server.nicks[casefold(params[0])]
+	if target == nil {
+		c.numeric("401", params[0], ":No such nick/channel")
+		return
+	}
+	ch := c.server.channels[casefold(params[1])]
+	if ch != nil {
+		if !ch.isMember(c) {
+			c.numeric("442", ch.name, ":You're not on that channel")
+			return
+		}
+		if ch.isMember(target) {
+			c.numeric("443", target.nick, ch.name, ":is already on channel")
+			return
+		}
+		if ch.inviteOnly && !ch.isOp(c) {
+			c.numeric("482", ch.name, ":You're not channel operator")
+			return
+		}
+	}
+	target.invited[casefold(params[1])] = true
+	c.numeric("341", target.nick, params[1])
+	target.send(":" + c.prefix() + " INVITE " + target.nick + " :" + params[1])
+}
+
+func (c *client) handleWho(params []string) {
+	mask := "*"
+	if len(params) > 0 {
+		mask = params[0]
+	}
+	s := c.server
+	reply := func(ch *channel, m *client) {
+		flags := "H"
+		if m.away != "" {
+			flags = "G"
+		}
+		if m.oper {
+			flags += "*"
+		}
+		chname := "*"
+		if ch != nil {
+			chname = ch.name
+			if p := ch.members[m]; p != "" {
+				flags += p[:1]
+			}
+		}
+		c.numeric("352", chname, m.user, m.host, s.conf.Name, m.nick, flags, ":0 "+m.realname)
+	}
+
+	if ch := s.channels[casefold(mask)]; ch != nil {
+		if !ch.secret || ch.isMember(c) {
+			for _, m := range ch.sortedMembers() {
+				reply(ch, m)
+			}
+		}
+	} else if m := s.nicks[casefold(mask)]; m != nil {
+		reply(nil, m)
+	}
+	c.numeric("315", mask, ":End of /WHO list.")
+}
+
+func (c *client) handleWhois(params []string) {
+	if len(params) < 1 {
+		c.numeric("431", ":No nickname given")
+		return
+	}
+	nick := params[len(params)-1]
+	s := c.server
+	target := s.nicks[casefold(nick)]
+	if target == nil || !target.registered {
+		c.numeric("401", nick, ":No such nick/channel")
+		c.numeric("318", nick, ":End of /WHOIS list.")
+		return
+	}
+
+	c.numeric("311", target.nick, target.user, target.host, "*", ":"+target.realname)
+	var channels []string
+	for ch := range target.channels {
+		if ch.secret && !ch.isMember(c) {
+			continue
+		}
+		prefix := ch.members[target]
+		if len(prefix) > 1 {
+			prefix = prefix[:1]
+		}
+		channels = append(channels, prefix+ch.name)
+	}
+	if len(channels) > 0 {
+		c.numeric("319", target.nick, ":"+strings.Join(channels, " "))
+	}
+	c.numeric("312", target.nick, s.conf.Name, ":"+s.conf.Network)
+	if target.away != "" {
+		c.numeric("301", target.nick, ":"+target.away)
+	}
+	if target.oper {
+		c.numeric("313", target.nick, ":is an IRC operator")
+	}
+	idle := int64(time.Since(target.lastActive) / time.Second)
+	c.numeric("317", target.nick, strconv.FormatInt(idle, 10), strconv.FormatInt(target.signon.Unix(), 10), ":seconds idle, signon time")
+	c.numeric("318", target.nick, ":End of /WHOIS list.")
+}
+
+//params returns the message parameters without the ':' on the trailing parameter
+func params(msg irc.Message) []string {
+	p := make([]string, len(msg.Params()))
+	copy(p, msg.Params())
+	if n := len(p); n > 0 && strings.HasPrefix(p[n-1], ":") {
+		p[n-1] = p[n-1][1:]
+	}
+	return p
+}
+
+//splitList splits the first parameter on commas
+func splitList(params []string) []string {
+	if len(params) == 0 {
+		return nil
+	}
+	return strings.Split(params[0], ",")
+}
+
+func validNick(nick string) bool {
+	if len(nick) > maxNickLen || nick == "" {
+		return false
+	}
+	for k, r := range nick {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', strings.ContainsRune("[]\\`_^{|}", r):
+		case k > 0 && (('0' <= r && r <= '9') || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validChannel(name string) bool {
+	return len(name) > 1 && len(name) <= 50 && strings.ContainsRune("#&", rune(name[0])) &&
+		!strings.ContainsAny(name, " ,\x07")
+}
@@ -0,0 +1,94 @@
+package ircd
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+//Config configures a Server. It can be loaded from a JSON file with
+//LoadConfig:
+//
+//	{
+//		"name": "irc.example.com",
+//		"network": "ExampleNet",
+//		"listen": [":6667"],
+//		"motd": ["Welcome!"],
+//		"password": "",
+//		"opers": {"admin": "secret"},
+//		"ping_interval": "2m",
+//		"ping_timeout": "1m"
+//	}
+type Config struct {
+	Name     string            `json:"name"`     //Server name used as the prefix of server messages
+	Network  string            `json:"network"`  //Network name advertised in ISUPPORT
+	Listen   []string          `json:"listen"`   //Addresses to listen on
+	MOTD     []string          `json:"motd"`     //Message of the day, one entry per line
+	Password string            `json:"password"` //Connection password (PASS), if any
+	Opers    map[string]string `json:"opers"`    //Operator names and passwords for OPER
+
+	PingInterval Duration `json:"ping_interval"` //Idle time before the server pings a client
+	PingTimeout  Duration `json:"ping_timeout"`  //Time to wait for a reply before disconnecting
+
+	MaxChannels int `json:"max_channels"` //Channels a client may be in. Defaults to 20
+	SendQ       int `json:"sendq"`        //Lines queued for a client before it's disconnected
+}
+
+//Duration is a time.Duration that is read from JSON as a string (e.g. "90s")
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	d.Duration = dur
+	return err
+}
+
+//MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//LoadConfig reads a JSON config file. Missing values are set to their defaults.
+func LoadConfig(path string) (Config, error) {
+	var conf Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	if err = json.Unmarshal(b, &conf); err != nil {
+		return conf, err
+	}
+	return conf.withDefaults(), nil
+}
+
+func (c Config) withDefaults() Config {
+	if c.Name == "" {
+		c.Name = "irc.localhost"
+	}
+	if c.Network == "" {
+		c.Network = "LocalNet"
+	}
+	if len(c.Listen) == 0 {
+		c.Listen = []string{":6667"}
+	}
+	if c.PingInterval.Duration == 0 {
+		c.PingInterval.Duration = 2 * time.Minute
+	}
+	if c.PingTimeout.Duration == 0 {
+		c.PingTimeout.Duration = time.Minute
+	}
+	if c.MaxChannels == 0 {
+		c.MaxChannels = 20
+	}
+	if c.SendQ == 0 {
+		c.SendQ = 1024
+	}
+	return c
+}
@@ -0,0 +1,277 @@
+package ircd
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+type testClient struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan irc.Message
+}
+
+func connect(t *testing.T, s *Server) *testClient {
+	client, server := net.Pipe()
+	s.ServeConn(server)
+	tc := &testClient{t: t, conn: client, lines: make(chan irc.Message, 100)}
+	go func() {
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			tc.lines <- irc.NewMessage(scanner.Text())
+		}
+		close(tc.lines)
+	}()
+	return tc
+}
+
+func register(t *testing.T, s *Server, nick string) *testClient {
+	tc := connect(t, s)
+	tc.send("NICK "+nick, "USER "+nick+" 0 * :Real Name")
+	tc.expect("001")
+	tc.expect("422")
+	return tc
+}
+
+func (tc *testClient) send(lines ...string) {
+	for _, line := range lines {
+		tc.conn.Write([]byte(line + "\r\n"))
+	}
+}
+
+//expect reads lines until one with the command is found
+func (tc *testClient) expect(command string) irc.Message {
+	tc.t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg, ok := <-tc.lines:
+			if !ok {
+				tc.t.Fatalf("Connection closed while waiting for %s", command)
+			}
+			if msg.Command() == command {
+				return msg
+			}
+		case <-timeout:
+			tc.t.Fatalf("Timed out waiting for %s", command)
+		}
+	}
+}
+
+//sync makes sure everything sent so far has been processed
+func (tc *testClient) sync() {
+	tc.send("PING :sync")
+	tc.expect("PONG")
+}
+
+func TestRegistration(t *testing.T) {
+	s := NewServer(Config{Name: "test.server", Network: "TestNet"})
+	defer s.Close()
+
+	tc := connect(t, s)
+	tc.send("JOIN #test")
+	tc.expect("451")
+	tc.send("NICK 1bad", "NICK good", "USER user 0 * :Real Name")
+	tc.expect("432")
+	if msg := tc.expect("001"); msg.Params()[0] != "good" || msg.Trailing() != "Welcome to the TestNet IRC Network good!~user@localhost" {
+		t.Errorf("Unexpected welcome: %s", msg)
+	}
+	if msg := tc.expect("005"); msg.Params()[len(msg.Params())-1] != ":are supported by this server" {
+		t.Errorf("Unexpected ISUPPORT: %s", msg)
+	}
+
+	other := connect(t, s)
+	other.send("NICK GOOD", "NICK {good}")
+	other.expect("433")
+	other.send("USER user 0 * :Real Name")
+	other.expect("001")
+
+	//[ and { are the same character in rfc1459
+	tc.send("NICK [good]")
+	tc.expect("433")
+}
+
+func TestPassword(t *testing.T) {
+	s := NewServer(Config{Password: "secret"})
+	defer s.Close()
+	tc := connect(t, s)
+	tc.send("PASS wrong", "NICK nick", "USER user 0 * :Real Name")
+	tc.expect("464")
+	tc.expect("ERROR")
+}
+
+func TestChannels(t *testing.T) {
+	s := NewServer(Config{})
+	defer s.Close()
+	alice := register(t, s, "alice")
+	bob := register(t, s, "bob")
+
+	alice.send("JOIN #test")
+	if msg := alice.expect("353"); msg.Trailing() != "@alice" {
+		t.Errorf("Channel creator was not opped: %s", msg)
+	}
+	alice.send("TOPIC #test :the topic")
+	alice.expect("TOPIC")
+
+	bob.send("JOIN #test")
+	if msg := bob.expect("332"); msg.Trailing() != "the topic" {
+		t.Errorf("Topic was not sent on join: %s", msg)
+	}
+	if msg := bob.expect("353"); msg.Trailing() != "@alice bob" {
+		t.Errorf("Unexpected names: %s", msg)
+	}
+	alice.expect("JOIN")
+
+	bob.send("PRIVMSG #test :hello")
+	if msg := alice.expect("PRIVMSG"); msg.Nick() != "bob" || msg.Trailing() != "hello" {
+		t.Errorf("Unexpected PRIVMSG: %s", msg)
+	}
+	bob.send("PRIVMSG alice :private")
+	if msg := alice.expect("PRIVMSG"); msg.Params()[0] != "alice" || msg.Trailing() != "private" {
+		t.Errorf("Unexpected PRIVMSG: %s", msg)
+	}
+	bob.send("PRIVMSG a,b,c,d,alice :too many")
+	if msg := bob.expect("407"); msg.Params()[1] != "a,b,c,d,alice" {
+		t.Errorf("Unexpected ERR_TOOMANYTARGETS: %s", msg)
+	}
+
+	//Topic is locked (+t) by default
+	bob.send("TOPIC #test :bob's topic")
+	bob.expect("482")
+
+	//Moderated channels need voice
+	alice.send("MODE #test +m")
+	bob.expect("MODE")
+	bob.send("PRIVMSG #test :can't talk")
+	bob.expect("404")
+	alice.send("MODE #test +v bob")
+	if msg := bob.expect("MODE"); msg.String() != ":alice!~alice@localhost MODE #test +v bob" {
+		t.Errorf("Unexpected MODE: %s", msg)
+	}
+	bob.send("PRIVMSG #test :can talk")
+	alice.expect("PRIVMSG")
+
+	alice.send("MODE #test")
+	if msg := alice.expect("324"); msg.Params()[2] != "+mnt" {
+		t.Errorf("Unexpected channel modes: %s", msg)
+	}
+
+	alice.send("KICK #test bob :bye")
+	if msg := bob.expect("KICK"); msg.Params()[1] != "bob" || msg.Trailing() != "bye" {
+		t.Errorf("Unexpected KICK: %s", msg)
+	}
+	bob.send("PRIVMSG #test :still here?")
+	bob.expect("404")
+}
+
+func TestChannelRestrictions(t *testing.T) {
+	s := NewServer(Config{})
+	defer s.Close()
+	alice := register(t, s, "alice")
+	bob := register(t, s, "bob")
+
+	alice.send("JOIN #test", "MODE #test +ikl secret 1")
+	alice.expect("MODE")
+
+	bob.send("JOIN #test")
+	bob.expect("475")
+	bob.send("JOIN #test secret")
+	bob.expect("471")
+
+	alice.send("MODE #test -l+b bob!*@*", "MODE #test +b")
+	if msg := alice.expect("367"); msg.Params()[2] != "bob!*@*" {
+		t.Errorf("Unexpected ban list entry: %s", msg)
+	}
+	bob.send("JOIN #test secret")
+	bob.expect("473")
+
+	alice.send("INVITE bob #test")
+	alice.expect("341")
+	if msg := bob.expect("INVITE"); msg.Trailing() != "#test" {
+		t.Errorf("Unexpected INVITE: %s", msg)
+	}
+	//An invite overrides +i and bans, but not the key
+	bob.send("JOIN #test secret")
+	bob.expect("JOIN")
+
+	bob.send("MODE #test +o bob")
+	bob.expect("482")
+}
+
+func TestWhois(t *testing.T) {
+	s := NewServer(Config{Opers: map[string]string{"admin": "pass"}})
+	defer s.Close()
+	alice := register(t, s, "alice")
+	bob := register(t, s, "bob")
+
+	alice.send("JOIN #test", "OPER admin wrong")
+	alice.expect("464")
+	alice.send("OPER admin pass")
+	alice.expect("381")
+
+	bob.send("WHOIS alice")
+	if msg := bob.expect("311"); msg.Params()[1] != "alice" || msg.Trailing() != "Real Name" {
+		t.Errorf("Unexpected WHOIS reply: %s", msg)
+	}
+	if msg := bob.expect("319"); msg.Trailing() != "@#test" {
+		t.Errorf("Unexpected WHOIS channels: %s", msg)
+	}
+	bob.expect("313")
+	bob.expect("318")
+
+	bob.send("WHO #test")
+	if msg := bob.expect("352"); msg.Params()[5] != "alice" || msg.Params()[6] != "H*@" {
+		t.Errorf("Unexpected WHO reply: %s", msg)
+	}
+	bob.expect("315")
+}
+
+func TestPingTimeout(t *testing.T) {
+	s := NewServer(Config{PingInterval: Duration{50 * time.Millisecond}, PingTimeout: Duration{100 * time.Millisecond}})
+	defer s.Close()
+	alice := register(t, s, "alice")
+	bob := register(t, s, "bob")
+	alice.send("JOIN #test")
+	alice.expect("366")
+	bob.send("JOIN #test")
+	bob.expect("366")
+
+	//Alice answers pings, bob doesn't
+	go func() {
+		for i := 0; i < 20; i++ {
+			alice.send("PONG :keepalive")
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	bob.expect("PING")
+	if msg := alice.expect("QUIT"); msg.Nick() != "bob" || msg.Trailing() != "Ping timeout: 100ms" {
+		t.Errorf("Unexpected QUIT: %s", msg)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ircd.json")
+	os.WriteFile(path, []byte(`{"name": "irc.example.com", "listen": ["127.0.0.1:0"], "ping_timeout": "90s"}`), 0600)
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an error: %s", err.Error())
+	}
+	if conf.Name != "irc.example.com" || conf.PingTimeout.Duration != 90*time.Second || conf.PingInterval.Duration != 2*time.Minute {
+		t.Errorf("LoadConfig() returned an unexpected config: %+v", conf)
+	}
+
+	s := NewServer(conf)
+	errs := make(chan error)
+	go func() { errs <- s.ListenAndServe() }()
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	if err := <-errs; err != ErrServerClosed {
+		t.Errorf("ListenAndServe() did not return ErrServerClosed. Received: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package ircd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *client) handleMode(params []string) {
+	if len(params) < 1 {
+		c.numeric("461", "MODE", ":Not enough parameters")
+		return
+	}
+	if !validChannel(params[0]) {
+		c.handleUserMode(params)
+		return
+	}
+
+	ch := c.server.channels[casefold(params[0])]
+	if ch == nil {
+		c.numeric("403", params[0], ":No such channel")
+		return
+	}
+	if len(params) == 1 {
+		c.numeric("324", ch.name, ch.modes(ch.isMember(c)))
+		c.numeric("329", ch.name, strconv.FormatInt(ch.created.Unix(), 10))
+		return
+	}
+
+	//A lone +b (or b) lists the bans
+	if strings.Trim(params[1], "+") == "b" && len(params) == 2 {
+		for _, b := range ch.bans {
+			c.numeric("367", ch.name, b.mask, b.setBy, strconv.FormatInt(b.time.Unix(), 10))
+		}
+		c.numeric("368", ch.name, ":End of channel ban list")
+		return
+	}
+
+	if !ch.isOp(c) {
+		c.numeric("482", ch.name, ":You're not channel operator")
+		return
+	}
+	c.changeChannelModes(ch, params[1], params[2:])
+}
+
+//changeChannelModes applies the mode changes, and tells the channel
+//about those that were applied
+func (c *client) changeChannelModes(ch *channel, modes string, args []string) {
+	adding := true
+	applied, appliedArgs := "", []string{}
+	lastSign := byte(0)
+	count := 0
+	apply := func(mode rune, arg string) {
+		sign := byte('-')
+		if adding {
+			sign = '+'
+		}
+		if sign != lastSign {
+			applied += string(sign)
+			lastSign = sign
+		}
+		applied += string(mode)
+		if arg != "" {
+			appliedArgs = append(appliedArgs, arg)
+		}
+	}
+	nextArg := func() (string, bool) {
+		if len(args) == 0 {
+			return "", false
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg, true
+	}
+
+	for _, mode := range modes {
+		if mode == '+' || mode == '-' {
+			adding = mode == '+'
+			continue
+		}
+		if count >= maxModes {
+			break
+		}
+		count++
+
+		switch mode {
+		case 'i', 'm', 'n', 's', 't':
+			flag := map[rune]*bool{'i': &ch.inviteOnly, 'm': &ch.moderated, 'n': &ch.noExternal, 's': &ch.secret, 't': &ch.topicLock}[mode]
+			if *flag != adding {
+				*flag = adding
+				apply(mode, "")
+			}
+		case 'k':
+			if adding {
+				key, ok := nextArg()
+				if !ok || key == "" || strings.ContainsAny(key, " ,") {
+					continue
+				}
+				ch.key = key
+				apply(mode, key)
+			} else if ch.key != "" {
+				nextArg() //The key is optional when removing it
+				ch.key = ""
+				apply(mode, "*")
+			}
+		case 'l':
+			if adding {
+				arg, ok := nextArg()
+				limit, err := strconv.Atoi(arg)
+				if !ok || err != nil || limit <= 0 {
+					continue
+				}
+				ch.limit = limit
+				apply(mode, strconv.Itoa(limit))
+			} else if ch.limit > 0 {
+				ch.limit = 0
+				apply(mode, "")
+			}
+		case 'o', 'v':
+			nick, ok := nextArg()
+			if !ok {
+				continue
+			}
+			target := c.server.nicks[casefold(nick)]
+			if target == nil || !ch.isMember(target) {
+				c.numeric("441", nick, ch.name, ":They aren't on that channel")
+				continue
+			}
+			prefix := map[rune]string{'o': prefixOp, 'v': prefixVoice}[mode]
+			ch.setPrefix(target, prefix, adding)
+			apply(mode, target.nick)
+		case 'b':
+			mask, ok := nextArg()
+			if !ok {
+				continue
+			}
+			mask = normaliseMask(mask)
+			if c.changeBan(ch, mask, adding) {
+				apply(mode, mask)
+			}
+		default:
+			c.numeric("472", string(mode), ":is unknown mode char to me for "+ch.name)
+		}
+	}
+
+	if applied != "" {
+		line := ":" + c.prefix() + " MODE " + ch.name + " " + applied
+		if len(appliedArgs) > 0 {
+			line += " " + strings.Join(appliedArgs, " ")
+		}
+		ch.broadcast(line, nil)
+	}
+}
+
+//changeBan adds or removes a ban. Returns false if nothing changed.
+func (c *client) changeBan(ch *channel, mask string, adding bool) bool {
+	for k, b := range ch.bans {
+		if casefold(b.mask) == casefold(mask) {
+			if adding {
+				return false
+			}
+			ch.bans = append(ch.bans[:k], ch.bans[k+1:]...)
+			return true
+		}
+	}
+	if !adding {
+		return false
+	}
+	ch.bans = append(ch.bans, ban{mask: mask, setBy: c.prefix(), time: time.Now()})
+	return true
+}
+
+func (c *client) handleUserMode(params []string) {
+	if casefold(params[0]) != casefold(c.nick) {
+		if c.server.nicks[casefold(params[0])] == nil {
+			c.numeric("401", params[0], ":No such nick/channel")
+		} else {
+			c.numeric("502", ":Can't change mode for other users")
+		}
+		return
+	}
+	if len(params) == 1 {
+		c.numeric("221", c.umodes())
+		return
+	}
+
+	adding := true
+	applied := ""
+	for _, mode := range params[1] {
+		switch mode {
+		case '+', '-':
+			adding = mode == '+'
+		case 'i':
+			if c.invisible != adding {
+				c.invisible = adding
+				applied += map[bool]string{true: "+", false: "-"}[adding] + "i"
+			}
+		case 'o':
+			//Users can only remove oper status. OPER grants it.
+			if !adding && c.oper {
+				c.oper = false
+				applied += "-o"
+			}
+		default:
+			c.numeric("501", ":Unknown MODE flag")
+		}
+	}
+	if applied != "" {
+		c.send(":" + c.nick + " MODE " + c.nick + " :" + applied)
+	}
+}
+
+//normaliseMask turns a partial mask into nick!user@host form
+func normaliseMask(mask string) string {
+	if !strings.Contains(mask, "!") {
+		if strings.Contains(mask, "@") {
+			mask = "*!" + mask
+		} else {
+			mask += "!*@*"
+		}
+	} else if !strings.Contains(mask, "@") {
+		mask += "@*"
+	}
+	return mask
+}
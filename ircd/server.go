@@ -0,0 +1,227 @@
+/*
+Package ircd is a small IRC server built on the irc message layer.
+
+It aims to be correct rather than feature complete. It supports user
+registration, channels with modes (imnstlk, bans, ops and voice), message
+routing, TOPIC, KICK, INVITE, WHO, WHOIS, OPER and ping timeouts, and
+advertises its features with ISUPPORT (005). There is no server linking.
+*/
+package ircd
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//ErrServerClosed is returned by Serve after the server is closed
+var ErrServerClosed = errors.New("ircd: server closed")
+
+//Server is an IRC server
+type Server struct {
+	conf    Config
+	created time.Time
+
+	mLock     *sync.Mutex
+	clients   map[*client]bool
+	nicks     map[string]*client  //Keyed by casefolded nick
+	channels  map[string]*channel //Keyed by casefolded name
+	listeners []net.Listener
+	closed    bool
+	done      chan struct{}
+}
+
+//NewServer returns a server using the config. Missing values are set to their defaults.
+func NewServer(conf Config) *Server {
+	s := &Server{
+		conf:     conf.withDefaults(),
+		created:  time.Now(),
+		mLock:    new(sync.Mutex),
+		clients:  make(map[*client]bool),
+		nicks:    make(map[string]*client),
+		channels: make(map[string]*channel),
+		done:     make(chan struct{}),
+	}
+	go s.pingLoop()
+	return s
+}
+
+//ListenAndServe listens on the addresses in the config, and serves
+//clients until the server is closed.
+func (s *Server) ListenAndServe() error {
+	errs := make(chan error, len(s.conf.Listen))
+	for _, addr := range s.conf.Listen {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		log.Printf("ircd: listening on %s", l.Addr())
+		go func() {
+			errs <- s.Serve(l)
+		}()
+	}
+	return <-errs
+}
+
+//Serve accepts clients on the listener until the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mLock.Lock()
+	if s.closed {
+		s.mLock.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, l)
+	s.mLock.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mLock.Lock()
+			closed := s.closed
+			s.mLock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.ServeConn(c)
+	}
+}
+
+//ServeConn serves a single client connection (e.g. one accepted by a
+//listener you manage, or an in-memory pipe)
+func (s *Server) ServeConn(c net.Conn) {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	cl := newClient(s, irc.NewConnectionWrapper(c), host)
+
+	s.mLock.Lock()
+	if s.closed {
+		s.mLock.Unlock()
+		c.Close()
+		return
+	}
+	s.clients[cl] = true
+	s.mLock.Unlock()
+
+	go cl.writeLoop()
+	go cl.readLoop()
+}
+
+//Close disconnects all clients and stops listening
+func (s *Server) Close() {
+	s.mLock.Lock()
+	if s.closed {
+		s.mLock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	listeners := s.listeners
+	var clients []*client
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mLock.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	for _, c := range clients {
+		c.quit("Server shutting down")
+	}
+}
+
+//pingLoop pings idle clients, and disconnects those that don't reply
+func (s *Server) pingLoop() {
+	interval := s.conf.PingTimeout.Duration / 4
+	if interval > time.Second*10 {
+		interval = time.Second * 10
+	} else if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.checkPings(now)
+		}
+	}
+}
+
+func (s *Server) checkPings(now time.Time) {
+	var timedOut []*client
+	var ping []*client
+
+	s.mLock.Lock()
+	for c := range s.clients {
+		idle := now.Sub(c.lastActive)
+		switch {
+		case !c.registered && idle > s.conf.PingTimeout.Duration:
+			timedOut = append(timedOut, c)
+		case c.pingSent.IsZero() && idle > s.conf.PingInterval.Duration:
+			c.pingSent = now
+			ping = append(ping, c)
+		case !c.pingSent.IsZero() && now.Sub(c.pingSent) > s.conf.PingTimeout.Duration:
+			timedOut = append(timedOut, c)
+		}
+	}
+	s.mLock.Unlock()
+
+	for _, c := range ping {
+		c.send("PING :" + s.conf.Name)
+	}
+	for _, c := range timedOut {
+		c.quit("Ping timeout: " + s.conf.PingTimeout.Duration.String())
+	}
+}
+
+//isupport returns the ISUPPORT tokens advertised by the server
+func (s *Server) isupport() []string {
+	return []string{
+		"AWAYLEN=200",
+		"CASEMAPPING=rfc1459",
+		"CHANLIMIT=#&:" + itoa(s.conf.MaxChannels),
+		"CHANMODES=b,k,l,imnst",
+		"CHANNELLEN=50",
+		"CHANTYPES=#&",
+		"KICKLEN=300",
+		"MODES=" + itoa(maxModes),
+		"NETWORK=" + s.conf.Network,
+		"NICKLEN=" + itoa(maxNickLen),
+		"PREFIX=(ov)@+",
+		"TARGMAX=JOIN:,PART:,PRIVMSG:" + itoa(maxTargets) + ",NOTICE:" + itoa(maxTargets) + ",WHOIS:1,KICK:1",
+		"TOPICLEN=390",
+	}
+}
+
+//casefold returns the rfc1459 lowercase form of a nick or channel name
+func casefold(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'A' <= r && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, name)
+}
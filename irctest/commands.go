@@ -0,0 +1,433 @@
+package irctest
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"github.com/oooska/irc"
+)
+
+//delivery is a line to send once the server lock is released
+type delivery struct {
+	to   *Conn
+	line string
+}
+
+type outbox []delivery
+
+func (o *outbox) add(to *Conn, lines ...string) {
+	for _, line := range lines {
+		*o = append(*o, delivery{to, line})
+	}
+}
+
+func (o outbox) send() {
+	for _, d := range o {
+		d.to.Send(d.line)
+	}
+}
+
+func (c *Conn) handle(msg irc.Message) {
+	var out outbox
+	s := c.server
+	s.mLock.Lock()
+	switch msg.Command() {
+	case "CAP":
+		c.handleCap(msg, &out)
+	case "AUTHENTICATE":
+		c.handleAuthenticate(msg, &out)
+	case "NICK":
+		c.handleNick(msg, &out)
+	case "USER":
+		if len(msg.Params()) > 0 {
+			c.user = "~" + msg.Params()[0]
+			c.realname = msg.Trailing()
+			c.register(&out)
+		}
+	case "PING":
+		out.add(c, ":"+ServerName+" PONG "+ServerName+" :"+param(msg, 0))
+	case "QUIT":
+		c.quitReason = "Quit: " + msg.Trailing()
+		s.mLock.Unlock()
+		c.Disconnect()
+		return
+	default:
+		if !c.registered {
+			out.add(c, numeric("451", ":You have not registered"))
+			break
+		}
+		c.handleRegistered(msg, &out)
+	}
+	s.mLock.Unlock()
+	out.send()
+}
+
+func (c *Conn) handleRegistered(msg irc.Message, out *outbox) {
+	switch msg.Command() {
+	case "JOIN":
+		for _, name := range strings.Split(param(msg, 0), ",") {
+			c.join(name, out)
+		}
+	case "PART":
+		for _, name := range strings.Split(param(msg, 0), ",") {
+			c.part(name, msg.Trailing(), out)
+		}
+	case "PRIVMSG", "NOTICE":
+		c.privmsg(msg, out)
+	case "MODE":
+		c.mode(msg, out)
+	case "NAMES":
+		if ch := c.server.channels[strings.ToLower(param(msg, 0))]; ch != nil {
+			c.names(ch, out)
+		} else {
+			out.add(c, numeric("366", param(msg, 0), ":End of /NAMES list."))
+		}
+	case "TOPIC":
+		c.topic(msg, out)
+	case "WHO":
+		if ch := c.server.channels[strings.ToLower(param(msg, 0))]; ch != nil {
+			for _, m := range ch.sortedMembers() {
+				out.add(c, numeric("352", ch.name, m.user, "127.0.0.1", ServerName, m.nick, "H"+ch.members[m], ":0 "+m.realname))
+			}
+		}
+		out.add(c, numeric("315", param(msg, 0), ":End of /WHO list."))
+	case "PONG":
+	default:
+		out.add(c, numeric("421", msg.Command(), ":Unknown command"))
+	}
+}
+
+func (c *Conn) handleCap(msg irc.Message, out *outbox) {
+	switch strings.ToUpper(param(msg, 0)) {
+	case "LS":
+		c.capNeg = true
+		out.add(c, ":"+ServerName+" CAP {nick} LS :"+strings.Join(c.server.Caps, " "))
+	case "REQ":
+		c.capNeg = true
+		requested := strings.Fields(msg.Trailing())
+		for _, r := range requested {
+			if !c.server.hasCap(strings.TrimPrefix(r, "-")) {
+				out.add(c, ":"+ServerName+" CAP {nick} NAK :"+msg.Trailing())
+				return
+			}
+		}
+		for _, r := range requested {
+			if strings.HasPrefix(r, "-") {
+				delete(c.caps, r[1:])
+			} else {
+				c.caps[r] = true
+			}
+		}
+		out.add(c, ":"+ServerName+" CAP {nick} ACK :"+msg.Trailing())
+	case "END":
+		c.capNeg = false
+		c.register(out)
+	}
+}
+
+func (c *Conn) handleAuthenticate(msg irc.Message, out *outbox) {
+	if !c.caps["sasl"] {
+		out.add(c, numeric("904", ":SASL authentication failed"))
+		return
+	}
+	arg := param(msg, 0)
+	switch {
+	case strings.ToUpper(arg) == "PLAIN":
+		out.add(c, "AUTHENTICATE +")
+	case arg == "*":
+		out.add(c, numeric("906", ":SASL authentication aborted"))
+	default:
+		//authzid \0 authcid \0 password
+		decoded, err := base64.StdEncoding.DecodeString(arg)
+		parts := strings.Split(string(decoded), "\x00")
+		if err != nil || len(parts) != 3 || c.server.Accounts[parts[1]] == "" || c.server.Accounts[parts[1]] != parts[2] {
+			out.add(c, numeric("904", ":SASL authentication failed"))
+			return
+		}
+		c.account = parts[1]
+		out.add(c, numeric("900", "{nick}!"+c.user+"@127.0.0.1", c.account, ":You are now logged in as "+c.account))
+		out.add(c, numeric("903", ":SASL authentication successful"))
+	}
+}
+
+func (c *Conn) handleNick(msg irc.Message, out *outbox) {
+	nick := strings.TrimPrefix(param(msg, 0), ":")
+	s := c.server
+	if nick == "" || strings.ContainsAny(nick, " ,*?!@#&") {
+		out.add(c, numeric("432", nick, ":Erroneous nickname"))
+		return
+	}
+	if other := s.nicks[strings.ToLower(nick)]; other != nil && other != c {
+		out.add(c, numeric("433", nick, ":Nickname is already in use"))
+		return
+	}
+
+	if c.registered {
+		line := ":" + c.mask() + " NICK :" + nick
+		out.add(c, line)
+		for _, other := range c.neighbours() {
+			out.add(other, line)
+		}
+	}
+	delete(s.nicks, strings.ToLower(c.nick))
+	s.nicks[strings.ToLower(nick)] = c
+	c.nick = nick
+	c.register(out)
+}
+
+//register sends the welcome burst once NICK, USER and CAP negotiation are done
+func (c *Conn) register(out *outbox) {
+	if c.registered || c.nick == "" || c.user == "" || c.capNeg {
+		return
+	}
+	c.registered = true
+	out.add(c,
+		numeric("001", ":Welcome to the irctest network "+c.mask()),
+		numeric("002", ":Your host is "+ServerName),
+		numeric("003", ":This server was created today"),
+		numeric("004", ServerName, "irctest-1.0", "io", "beIklimnstov"),
+		numeric("005", strings.Join(c.server.ISupport, " "), ":are supported by this server"),
+		numeric("422", ":MOTD File is missing"),
+	)
+}
+
+func (c *Conn) join(name string, out *outbox) {
+	s := c.server
+	if name == "" || !strings.ContainsAny(name[:1], "#&") {
+		out.add(c, numeric("403", name, ":No such channel"))
+		return
+	}
+	ch := s.channels[strings.ToLower(name)]
+	if ch == nil {
+		ch = &channel{name: name, members: make(map[*Conn]string)}
+		s.channels[strings.ToLower(name)] = ch
+		ch.members[c] = "@"
+	} else if _, ok := ch.members[c]; ok {
+		return
+	} else {
+		ch.members[c] = ""
+	}
+
+	for m := range ch.members {
+		if m.caps["extended-join"] {
+			account := c.account
+			if account == "" {
+				account = "*"
+			}
+			out.add(m, ":"+c.mask()+" JOIN "+ch.name+" "+account+" :"+c.realname)
+		} else {
+			out.add(m, ":"+c.mask()+" JOIN "+ch.name)
+		}
+	}
+	if ch.topic != "" {
+		out.add(c, numeric("332", ch.name, ":"+ch.topic))
+	}
+	c.names(ch, out)
+}
+
+func (c *Conn) part(name, reason string, out *outbox) {
+	ch := c.server.channels[strings.ToLower(name)]
+	if ch == nil {
+		out.add(c, numeric("403", name, ":No such channel"))
+		return
+	}
+	if _, ok := ch.members[c]; !ok {
+		out.add(c, numeric("442", name, ":You're not on that channel"))
+		return
+	}
+	for m := range ch.members {
+		out.add(m, ":"+c.mask()+" PART "+ch.name+" :"+reason)
+	}
+	c.server.removeMember(ch, c)
+}
+
+func (c *Conn) privmsg(msg irc.Message, out *outbox) {
+	target := param(msg, 0)
+	line := ":" + c.mask() + " " + msg.Command() + " " + target + " :" + msg.Trailing()
+	if ch := c.server.channels[strings.ToLower(target)]; ch != nil {
+		for m := range ch.members {
+			if m != c {
+				out.add(m, line)
+			}
+		}
+	} else if to := c.server.nicks[strings.ToLower(target)]; to != nil {
+		out.add(to, line)
+	} else if msg.Command() == "PRIVMSG" {
+		out.add(c, numeric("401", target, ":No such nick/channel"))
+	}
+}
+
+func (c *Conn) mode(msg irc.Message, out *outbox) {
+	target := param(msg, 0)
+	ch := c.server.channels[strings.ToLower(target)]
+	if ch == nil {
+		if strings.EqualFold(target, c.nick) {
+			out.add(c, numeric("221", "+i"))
+		} else {
+			out.add(c, numeric("403", target, ":No such channel"))
+		}
+		return
+	}
+	if len(msg.Params()) < 2 {
+		out.add(c, numeric("324", ch.name, "+"+ch.modes))
+		return
+	}
+	if ch.members[c] != "@" {
+		out.add(c, numeric("482", ch.name, ":You're not channel operator"))
+		return
+	}
+
+	//Apply prefix modes to members, and remember simple channel modes
+	args := msg.Params()[2:]
+	adding := true
+	for _, m := range msg.Params()[1] {
+		switch m {
+		case '+', '-':
+			adding = m == '+'
+		case 'o', 'v':
+			if len(args) == 0 {
+				continue
+			}
+			if member := c.server.nicks[strings.ToLower(args[0])]; member != nil {
+				if _, ok := ch.members[member]; ok {
+					prefix := map[rune]string{'o': "@", 'v': "+"}[m]
+					if adding {
+						ch.members[member] = prefix
+					} else if ch.members[member] == prefix {
+						ch.members[member] = ""
+					}
+				}
+			}
+			args = args[1:]
+		case 'b', 'e', 'I', 'k', 'l':
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		default:
+			if adding && !strings.ContainsRune(ch.modes, m) {
+				ch.modes += string(m)
+			} else if !adding {
+				ch.modes = strings.Replace(ch.modes, string(m), "", -1)
+			}
+		}
+	}
+
+	line := ":" + c.mask() + " MODE " + ch.name + " " + strings.Join(msg.Params()[1:], " ")
+	for m := range ch.members {
+		out.add(m, line)
+	}
+}
+
+func (c *Conn) topic(msg irc.Message, out *outbox) {
+	ch := c.server.channels[strings.ToLower(param(msg, 0))]
+	if ch == nil {
+		out.add(c, numeric("403", param(msg, 0), ":No such channel"))
+		return
+	}
+	if len(msg.Params()) < 2 {
+		if ch.topic == "" {
+			out.add(c, numeric("331", ch.name, ":No topic is set"))
+		} else {
+			out.add(c, numeric("332", ch.name, ":"+ch.topic))
+		}
+		return
+	}
+	ch.topic = msg.Trailing()
+	for m := range ch.members {
+		out.add(m, ":"+c.mask()+" TOPIC "+ch.name+" :"+ch.topic)
+	}
+}
+
+func (c *Conn) names(ch *channel, out *outbox) {
+	var names []string
+	for _, m := range ch.sortedMembers() {
+		prefix := ch.members[m]
+		if !c.caps["multi-prefix"] && len(prefix) > 1 {
+			prefix = prefix[:1]
+		}
+		names = append(names, prefix+m.nick)
+	}
+	out.add(c, numeric("353", "=", ch.name, ":"+strings.Join(names, " ")))
+	out.add(c, numeric("366", ch.name, ":End of /NAMES list."))
+}
+
+//quit removes the client from the server, telling other clients
+func (c *Conn) quit() {
+	var out outbox
+	s := c.server
+	s.mLock.Lock()
+	reason := c.quitReason
+	if reason == "" {
+		reason = "Connection closed"
+	}
+	if c.registered {
+		for _, other := range c.neighbours() {
+			out.add(other, ":"+c.mask()+" QUIT :"+reason)
+		}
+	}
+	for _, ch := range s.channels {
+		s.removeMember(ch, c)
+	}
+	if s.nicks[strings.ToLower(c.nick)] == c {
+		delete(s.nicks, strings.ToLower(c.nick))
+	}
+	s.mLock.Unlock()
+	c.Disconnect()
+	out.send()
+}
+
+//neighbours returns the other clients that share a channel with c
+func (c *Conn) neighbours() []*Conn {
+	seen := make(map[*Conn]bool)
+	var conns []*Conn
+	for _, ch := range c.server.channels {
+		if _, ok := ch.members[c]; !ok {
+			continue
+		}
+		for m := range ch.members {
+			if m != c && !seen[m] {
+				seen[m] = true
+				conns = append(conns, m)
+			}
+		}
+	}
+	return conns
+}
+
+func (s *Server) removeMember(ch *channel, c *Conn) {
+	delete(ch.members, c)
+	if len(ch.members) == 0 {
+		delete(s.channels, strings.ToLower(ch.name))
+	}
+}
+
+func (s *Server) hasCap(name string) bool {
+	for _, c := range s.Caps {
+		if c == name || strings.HasPrefix(c, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *channel) sortedMembers() []*Conn {
+	var members []*Conn
+	for m := range ch.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].nick < members[j].nick })
+	return members
+}
+
+func numeric(num string, params ...string) string {
+	return ":" + ServerName + " " + num + " {nick} " + strings.Join(params, " ")
+}
+
+//param returns the nth parameter without a leading ':', or an empty string
+func param(msg irc.Message, n int) string {
+	if n >= len(msg.Params()) {
+		return ""
+	}
+	return strings.TrimPrefix(msg.Params()[n], ":")
+}
@@ -0,0 +1,102 @@
+package irctest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//Conn is a client connected to the Server
+type Conn struct {
+	server *Server
+	conn   net.Conn
+	wLock  *sync.Mutex
+
+	//Only accessed with server.mLock held
+	nick       string
+	user       string
+	realname   string
+	account    string
+	registered bool
+	capNeg     bool //CAP negotiation in progress
+	quitReason string
+	caps       map[string]bool
+}
+
+func newConn(s *Server, c net.Conn) *Conn {
+	return &Conn{server: s, conn: c, wLock: new(sync.Mutex), caps: make(map[string]bool)}
+}
+
+//Nick returns the nick of the client
+func (c *Conn) Nick() string {
+	c.server.mLock.Lock()
+	defer c.server.mLock.Unlock()
+	return c.nick
+}
+
+//Account returns the account the client authenticated as
+func (c *Conn) Account() string {
+	c.server.mLock.Lock()
+	defer c.server.mLock.Unlock()
+	return c.account
+}
+
+//Send writes the lines to the client. "{nick}" is replaced with the
+//client's nick.
+func (c *Conn) Send(lines ...string) error {
+	c.server.mLock.Lock()
+	nick, delay := c.nick, c.server.writeDelay
+	c.server.mLock.Unlock()
+	if nick == "" {
+		nick = "*"
+	}
+
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	for _, line := range lines {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		line = strings.Replace(line, "{nick}", nick, -1)
+		if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SendRaw writes the bytes to the client without adding a line ending or
+//substituting the nick. Useful for sending malformed lines.
+func (c *Conn) SendRaw(b []byte) error {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	_, err := c.conn.Write(b)
+	return err
+}
+
+//Disconnect closes the connection without sending anything
+func (c *Conn) Disconnect() {
+	c.conn.Close()
+}
+
+func (c *Conn) readLoop() {
+	defer c.quit()
+	s := bufio.NewScanner(c.conn)
+	for s.Scan() {
+		msg := irc.NewMessage(s.Text())
+		if e := c.server.record(msg); e != nil {
+			e.respond(c, msg)
+			continue
+		}
+		c.handle(msg)
+	}
+}
+
+//mask returns nick!user@host. Must be called with server.mLock held
+func (c *Conn) mask() string {
+	return c.nick + "!" + c.user + "@127.0.0.1"
+}
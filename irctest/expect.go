@@ -0,0 +1,111 @@
+package irctest
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//Expectation is a scripted response to a line from a client. When a
+//matching line is recieved, the scripted responses are sent instead of
+//the server's normal handling. Each expectation is met once.
+type Expectation struct {
+	match      string
+	responses  []string
+	passthru   bool
+	disconnect bool
+
+	mLock  *sync.Mutex
+	done   chan struct{}
+	isDone bool
+}
+
+//Expect adds an expectation for a line. The line matches if it starts
+//with match, ignoring the case of the command. Expectations are checked
+//in the order they were added.
+func (s *Server) Expect(match string) *Expectation {
+	e := &Expectation{match: normalise(match), mLock: new(sync.Mutex), done: make(chan struct{})}
+	s.mLock.Lock()
+	s.expectations = append(s.expectations, e)
+	s.mLock.Unlock()
+	return e
+}
+
+//Unmet returns the expectations that have not been met
+func (s *Server) Unmet() []string {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	var unmet []string
+	for _, e := range s.expectations {
+		if !e.isMet() {
+			unmet = append(unmet, e.match)
+		}
+	}
+	return unmet
+}
+
+//Respond sets the lines sent when the expectation is met. "{nick}" is
+//replaced with the client's nick.
+func (e *Expectation) Respond(lines ...string) *Expectation {
+	e.responses = append(e.responses, lines...)
+	return e
+}
+
+//Handle makes the server process the line normally after sending any
+//scripted responses
+func (e *Expectation) Handle() *Expectation {
+	e.passthru = true
+	return e
+}
+
+//Disconnect closes the client's connection after sending any responses
+func (e *Expectation) Disconnect() *Expectation {
+	e.disconnect = true
+	return e
+}
+
+//Wait blocks until the expectation is met, or the timeout expires
+func (e *Expectation) Wait(timeout time.Duration) error {
+	select {
+	case <-e.done:
+		return nil
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+}
+
+func (e *Expectation) matches(msg irc.Message) bool {
+	return strings.HasPrefix(normalise(msg.String()), e.match)
+}
+
+func (e *Expectation) isMet() bool {
+	e.mLock.Lock()
+	defer e.mLock.Unlock()
+	return e.isDone
+}
+
+func (e *Expectation) met() {
+	e.mLock.Lock()
+	e.isDone = true
+	e.mLock.Unlock()
+}
+
+func (e *Expectation) respond(c *Conn, msg irc.Message) {
+	c.Send(e.responses...)
+	if e.passthru {
+		c.handle(msg)
+	}
+	if e.disconnect {
+		c.Disconnect()
+	}
+	close(e.done)
+}
+
+//normalise uppercases the command of a line so matches are case insensitive
+func normalise(line string) string {
+	parts := strings.SplitN(line, " ", 2)
+	parts[0] = strings.ToUpper(parts[0])
+	return strings.Join(parts, " ")
+}
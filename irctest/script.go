@@ -0,0 +1,42 @@
+package irctest
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+//Script is a connection that reads a fixed set of lines and records what
+//is written to it, for tests that don't need a server to respond. Pass it
+//to irc.NewConnectionWrapper.
+type Script struct {
+	r *strings.Reader
+
+	mLock   *sync.Mutex
+	written bytes.Buffer
+}
+
+//NewScript returns a Script reading the lines, followed by EOF
+func NewScript(lines ...string) *Script {
+	return &Script{r: strings.NewReader(strings.Join(lines, "\r\n") + "\r\n"), mLock: new(sync.Mutex)}
+}
+
+func (s *Script) Read(p []byte) (int, error) { return s.r.Read(p) }
+func (s *Script) Close() error               { return nil }
+
+func (s *Script) Write(p []byte) (int, error) {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	return s.written.Write(p)
+}
+
+//Written returns the lines written, without their line endings
+func (s *Script) Written() []string {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	text := strings.TrimSuffix(s.written.String(), "\r\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\r\n")
+}
@@ -0,0 +1,235 @@
+/*
+Package irctest provides an in-process IRC server for testing clients.
+
+The server speaks enough of the protocol to drive an irc.Client end to end:
+registration, CAP negotiation, SASL PLAIN, JOIN, PART, PRIVMSG, NOTICE,
+MODE, NAMES, TOPIC, WHO, PING and QUIT. Tests can script responses to
+specific lines with Expect, and inject faults (disconnects, slow writes,
+malformed lines).
+
+Servers listen on an ephemeral loopback port, or hand out in-memory
+connections with Pipe, so tests can run in parallel. Tests that only
+replay fixed lines to a client can use a Script instead of a Server.
+
+	s := irctest.NewServer()
+	defer s.Close()
+	s.Expect("JOIN #closed").Respond(":irctest 473 {nick} #closed :Cannot join channel (+i)")
+	client, _ := irc.NewClient(s.Addr(), false)
+*/
+package irctest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//ServerName is the name the server uses in prefixes
+const ServerName = "irctest"
+
+//ErrTimeout is returned when waiting for something that doesn't happen
+var ErrTimeout = errors.New("irctest: timed out")
+
+//Server is an in-process IRC server
+type Server struct {
+	//Accounts that can authenticate with SASL PLAIN, keyed by account name
+	Accounts map[string]string
+	//Caps advertised in reply to CAP LS
+	Caps []string
+	//ISupport tokens sent in RPL_ISUPPORT (005)
+	ISupport []string
+
+	listener net.Listener
+
+	mLock        *sync.Mutex
+	cond         *sync.Cond
+	conns        []*Conn
+	nicks        map[string]*Conn
+	channels     map[string]*channel
+	received     []irc.Message
+	expectations []*Expectation
+	writeDelay   time.Duration
+	closed       bool
+}
+
+type channel struct {
+	name    string
+	topic   string
+	modes   string
+	members map[*Conn]string //Value is the member's prefix (@, +)
+}
+
+//NewServer returns a Server listening on an ephemeral loopback port.
+//It panics if it can't listen, as there is nothing useful a test can do.
+func NewServer() *Server {
+	s := newServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("irctest: unable to listen: " + err.Error())
+	}
+	s.listener = l
+	go s.acceptLoop()
+	return s
+}
+
+//NewPipeServer returns a Server that doesn't listen on the network.
+//Connections are made with Pipe.
+func NewPipeServer() *Server {
+	return newServer()
+}
+
+func newServer() *Server {
+	s := &Server{
+		Accounts: make(map[string]string),
+		Caps:     []string{"multi-prefix", "sasl=PLAIN", "server-time", "account-tag", "extended-join"},
+		ISupport: []string{"CHANTYPES=#&", "PREFIX=(ov)@+", "CHANMODES=beI,k,l,imnst", "MODES=4", "NETWORK=irctest", "NICKLEN=30"},
+		mLock:    new(sync.Mutex),
+		nicks:    make(map[string]*Conn),
+		channels: make(map[string]*channel),
+	}
+	s.cond = sync.NewCond(s.mLock)
+	return s
+}
+
+//Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+//Pipe returns the client end of an in-memory connection to the server
+func (s *Server) Pipe() net.Conn {
+	client, server := net.Pipe()
+	s.serve(server)
+	return client
+}
+
+//Close disconnects all clients and stops listening
+func (s *Server) Close() {
+	s.mLock.Lock()
+	s.closed = true
+	conns := append([]*Conn(nil), s.conns...)
+	s.cond.Broadcast()
+	s.mLock.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, c := range conns {
+		c.Disconnect()
+	}
+}
+
+//SetWriteDelay delays every line the server writes, to simulate a slow server
+func (s *Server) SetWriteDelay(d time.Duration) {
+	s.mLock.Lock()
+	s.writeDelay = d
+	s.mLock.Unlock()
+}
+
+//Received returns every line recieved from clients, in order
+func (s *Server) Received() []irc.Message {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	return append([]irc.Message(nil), s.received...)
+}
+
+//WaitFor waits until a line with the specified command is recieved, and
+//returns the first such line. Lines recieved before calling WaitFor count.
+func (s *Server) WaitFor(command string, timeout time.Duration) (irc.Message, error) {
+	command = strings.ToUpper(command)
+	var found irc.Message
+	err := s.wait(timeout, func() bool {
+		for _, msg := range s.received {
+			if msg.Command() == command {
+				found = msg
+				return true
+			}
+		}
+		return false
+	})
+	return found, err
+}
+
+//WaitForConn waits until at least n clients have connected, and returns
+//the nth connection
+func (s *Server) WaitForConn(n int, timeout time.Duration) (*Conn, error) {
+	var c *Conn
+	err := s.wait(timeout, func() bool {
+		if len(s.conns) >= n {
+			c = s.conns[n-1]
+			return true
+		}
+		return false
+	})
+	return c, err
+}
+
+//Conns returns all connections that have been made to the server
+func (s *Server) Conns() []*Conn {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	return append([]*Conn(nil), s.conns...)
+}
+
+//wait blocks until cond (called with mLock held) is true
+func (s *Server) wait(timeout time.Duration, cond func() bool) error {
+	timer := time.AfterFunc(timeout, func() {
+		s.mLock.Lock()
+		s.cond.Broadcast()
+		s.mLock.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	for !cond() {
+		if s.closed || !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		s.cond.Wait()
+	}
+	return nil
+}
+
+func (s *Server) acceptLoop() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.serve(c)
+	}
+}
+
+func (s *Server) serve(nc net.Conn) *Conn {
+	c := newConn(s, nc)
+	s.mLock.Lock()
+	s.conns = append(s.conns, c)
+	s.cond.Broadcast()
+	s.mLock.Unlock()
+	go c.readLoop()
+	return c
+}
+
+//record records the line, and returns the expectation it matches, if any
+func (s *Server) record(msg irc.Message) *Expectation {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	s.received = append(s.received, msg)
+	s.cond.Broadcast()
+	for _, e := range s.expectations {
+		if !e.isMet() && e.matches(msg) {
+			e.met()
+			return e
+		}
+	}
+	return nil
+}
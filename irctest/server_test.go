@@ -0,0 +1,176 @@
+package irctest
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+const timeout = 2 * time.Second
+
+//reader reads from the client in the background, passing messages on
+func reader(client irc.Conn) (<-chan irc.Message, <-chan error) {
+	msgs := make(chan irc.Message, 100)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := client.Read()
+			if err != nil {
+				errs <- err
+				close(msgs)
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	return msgs, errs
+}
+
+//waitFor returns the first message with the command
+func waitFor(t *testing.T, msgs <-chan irc.Message, command string) irc.Message {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				t.Fatalf("Connection closed while waiting for %s", command)
+			}
+			if msg.Command() == command {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("Timed out waiting for %s", command)
+		}
+	}
+}
+
+func register(t *testing.T, client irc.Client, nick string) <-chan irc.Message {
+	msgs, _ := reader(client)
+	client.Send(irc.NickMessage(nick), irc.UserMessage(nick, "host", "domain", "realname"))
+	waitFor(t, msgs, "001")
+	return msgs
+}
+
+func TestClientEndToEnd(t *testing.T) {
+	t.Parallel()
+	s := NewServer()
+	defer s.Close()
+
+	client, err := irc.NewClient(s.Addr(), false)
+	if err != nil {
+		t.Fatalf("Unable to connect to the test server: %s", err.Error())
+	}
+	defer client.Close()
+	msgs := register(t, client, "gotest")
+
+	if client.Hostmask() != "gotest!~gotest@127.0.0.1" {
+		t.Errorf("Client did not learn its hostmask. Received: %s", client.Hostmask())
+	}
+
+	other := irc.NewClientWrapper(irc.NewConnectionWrapper(s.Pipe()))
+	defer other.Close()
+	otherMsgs := register(t, other, "other")
+
+	client.Send(irc.JoinMessage("#test"))
+	waitFor(t, msgs, "366")
+	other.Send(irc.JoinMessage("#test"))
+	waitFor(t, otherMsgs, "366")
+	waitFor(t, msgs, "JOIN")
+
+	users, _ := client.Users("#test")
+	if len(users) == 0 || users[len(users)-1] != "other" {
+		t.Errorf("Client is not tracking users in #test. Received: %v", users)
+	}
+
+	other.PrivMsg("#test", "hello")
+	msg := waitFor(t, msgs, "PRIVMSG")
+	if msg.Nick() != "other" || msg.Trailing() != "hello" {
+		t.Errorf("Unexpected PRIVMSG recieved: %s", msg)
+	}
+	if convo := client.Messages("#test"); len(convo) != 1 {
+		t.Errorf("Conversation was not recorded. Received: %v", convo)
+	}
+
+	other.Send(irc.NewMessage("TOPIC #test :new topic"))
+	if msg := waitFor(t, msgs, "TOPIC"); msg.Trailing() != "new topic" {
+		t.Errorf("Unexpected TOPIC recieved: %s", msg)
+	}
+}
+
+func TestSASL(t *testing.T) {
+	t.Parallel()
+	s := NewPipeServer()
+	s.Accounts["gotest"] = "secret"
+	defer s.Close()
+
+	client := irc.NewClientWrapper(irc.NewConnectionWrapper(s.Pipe()))
+	defer client.Close()
+	msgs, _ := reader(client)
+
+	client.Send(irc.NewMessage("CAP LS 302"), irc.NickMessage("gotest"), irc.UserMessage("gotest", "host", "domain", "realname"))
+	waitFor(t, msgs, "CAP")
+	if v, _ := client.CapValue("sasl"); v != "PLAIN" {
+		t.Errorf("sasl was not advertised. Received: %s", v)
+	}
+	client.Send(irc.NewMessage("CAP REQ :sasl"))
+	waitFor(t, msgs, "CAP")
+	if !client.HasCap("sasl") {
+		t.Error("sasl was not acknowledged")
+	}
+
+	client.Send(irc.NewMessage("AUTHENTICATE PLAIN"))
+	waitFor(t, msgs, "AUTHENTICATE")
+	client.Send(irc.NewMessage("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("\x00gotest\x00secret"))))
+	waitFor(t, msgs, "903")
+	client.Send(irc.NewMessage("CAP END"))
+	waitFor(t, msgs, "001")
+
+	conn, _ := s.WaitForConn(1, timeout)
+	if conn.Account() != "gotest" {
+		t.Errorf("Client was not logged in. Account: %s", conn.Account())
+	}
+}
+
+func TestExpectations(t *testing.T) {
+	t.Parallel()
+	s := NewPipeServer()
+	defer s.Close()
+	closed := s.Expect("join #closed").Respond(":irctest 473 {nick} #closed :Cannot join channel (+i)")
+	s.Expect("PRIVMSG #test :crash").Respond("ERROR :Closing link").Disconnect()
+
+	client := irc.NewClientWrapper(irc.NewConnectionWrapper(s.Pipe()))
+	msgs, errs := reader(client)
+	client.Send(irc.NickMessage("gotest"), irc.UserMessage("gotest", "host", "domain", "realname"))
+	waitFor(t, msgs, "001")
+
+	client.Send(irc.JoinMessage("#closed"))
+	if msg := waitFor(t, msgs, "473"); msg.Params()[0] != "gotest" {
+		t.Errorf("{nick} was not replaced in the response: %s", msg)
+	}
+	if err := closed.Wait(timeout); err != nil {
+		t.Error("Expectation was not met")
+	}
+	if len(s.Unmet()) != 1 {
+		t.Errorf("Expected one unmet expectation. Received: %v", s.Unmet())
+	}
+
+	//Malformed lines shouldn't stop the client
+	conn, _ := s.WaitForConn(1, timeout)
+	conn.SendRaw([]byte(":\r\n\r\n   \r\n:irctest\r\n"))
+	s.SetWriteDelay(10 * time.Millisecond)
+	client.Send(irc.JoinMessage("#test"))
+	waitFor(t, msgs, "366")
+
+	client.Send(irc.PrivMessage("#test", "crash"))
+	waitFor(t, msgs, "ERROR")
+	select {
+	case <-errs:
+	case <-time.After(timeout):
+		t.Error("Client was not disconnected")
+	}
+	if _, err := s.WaitFor("PRIVMSG", timeout); err != nil {
+		t.Error("WaitFor() did not find the PRIVMSG that was recieved")
+	}
+}
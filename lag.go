@@ -0,0 +1,204 @@
+package irc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPingInterval = time.Minute
+	defaultPingTimeout  = 3 * time.Minute
+	lagSamples          = 10 //Number of round trips averaged by AverageLag
+	lagTokenPrefix      = "lag"
+)
+
+//LagConfig configures the lag handler
+type LagConfig struct {
+	Interval time.Duration //Time between PINGs. Defaults to 1 minute.
+	//Time without hearing from the server after which the connection is
+	//considered dead and closed. Defaults to 3 minutes.
+	Timeout time.Duration
+
+	//OnTimeout is called before the connection is closed
+	OnTimeout func(silence time.Duration)
+}
+
+//LagMeter measures the round trip time to the server
+type LagMeter interface {
+	//Lag returns the last measured round trip time, or the time the
+	//oldest unanswered PING has been waiting if that is longer.
+	Lag() time.Duration
+	//AverageLag returns the average of the last 10 round trip times
+	AverageLag() time.Duration
+	//LastActivity returns when the last line was recieved from the server
+	LastActivity() time.Time
+}
+
+type lagMeter struct {
+	client Client
+	conf   LagConfig
+
+	mLock        *sync.Mutex
+	pending      map[string]time.Time //Sent time of unanswered PINGs, keyed by token
+	samples      []time.Duration
+	lastActivity time.Time
+	seq          int
+	pingTimer    *time.Timer
+	deadTimer    *time.Timer
+	stopped      bool
+}
+
+//LagHandler returns a ClientHandler measuring lag and detecting ping timeouts
+func LagHandler(conf LagConfig) ClientHandler {
+	return func(client Client) {
+		RegisterLagHandler(client, conf)
+	}
+}
+
+//RegisterLagHandler sends a PING with a unique token every Interval once
+//registration completes, and measures how long the server takes to reply.
+//If nothing is recieved from the server for Timeout, including while
+//registering, the connection is closed so Read returns an error. The
+//timeout starts when the handler is registered.
+func RegisterLagHandler(client Client, conf LagConfig) LagMeter {
+	if conf.Interval <= 0 {
+		conf.Interval = defaultPingInterval
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultPingTimeout
+	}
+	lm := &lagMeter{client: client, conf: conf, mLock: new(sync.Mutex),
+		pending: make(map[string]time.Time), lastActivity: time.Now()}
+	lm.mLock.Lock()
+	lm.deadTimer = time.AfterFunc(conf.Timeout, lm.timeout)
+	lm.mLock.Unlock()
+	client.AddHandler(Incoming, lm.activity)
+	client.AddHandler(Incoming, lm.pong, "PONG")
+	client.AddHandler(Incoming, lm.start, rplWelcome)
+	client.AddHandler(Outgoing, func(Message) { lm.stop() }, "QUIT")
+	return lm
+}
+
+func (lm *lagMeter) Lag() time.Duration {
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	var lag time.Duration
+	if len(lm.samples) > 0 {
+		lag = lm.samples[len(lm.samples)-1]
+	}
+	for _, sent := range lm.pending {
+		if waiting := time.Since(sent); waiting > lag {
+			lag = waiting
+		}
+	}
+	return lag
+}
+
+func (lm *lagMeter) AverageLag() time.Duration {
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	if len(lm.samples) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range lm.samples {
+		total += d
+	}
+	return total / time.Duration(len(lm.samples))
+}
+
+func (lm *lagMeter) LastActivity() time.Time {
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	return lm.lastActivity
+}
+
+//start starts pinging when registration completes
+func (lm *lagMeter) start(msg Message) {
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	if lm.stopped || lm.pingTimer != nil {
+		return
+	}
+	lm.pingTimer = time.AfterFunc(lm.conf.Interval, lm.ping)
+}
+
+func (lm *lagMeter) activity(msg Message) {
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	lm.lastActivity = time.Now()
+	if !lm.stopped {
+		lm.deadTimer.Reset(lm.conf.Timeout)
+	}
+}
+
+func (lm *lagMeter) ping() {
+	lm.mLock.Lock()
+	if lm.stopped {
+		lm.mLock.Unlock()
+		return
+	}
+	lm.seq++
+	token := lagTokenPrefix + strconv.Itoa(lm.seq) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	lm.pending[token] = time.Now()
+	lm.mLock.Unlock()
+
+	if _, err := lm.client.Send(NewMessage("PING :" + token)); err != nil {
+		lm.stop() //The connection is gone
+		return
+	}
+
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	if !lm.stopped {
+		lm.pingTimer.Reset(lm.conf.Interval)
+	}
+}
+
+//pong handles ":server PONG server :token"
+func (lm *lagMeter) pong(msg Message) {
+	token := msg.Trailing()
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	sent, ok := lm.pending[token]
+	if !ok {
+		return
+	}
+	//Replies come in order, so older PINGs are lost
+	for t, s := range lm.pending {
+		if !s.After(sent) {
+			delete(lm.pending, t)
+		}
+	}
+	lm.samples = append(lm.samples, time.Since(sent))
+	if len(lm.samples) > lagSamples {
+		lm.samples = lm.samples[1:]
+	}
+}
+
+func (lm *lagMeter) timeout() {
+	lm.mLock.Lock()
+	if lm.stopped {
+		lm.mLock.Unlock()
+		return
+	}
+	silence := time.Since(lm.lastActivity)
+	lm.mLock.Unlock()
+	lm.stop()
+
+	if lm.conf.OnTimeout != nil {
+		lm.conf.OnTimeout(silence)
+	}
+	lm.client.Close()
+}
+
+func (lm *lagMeter) stop() {
+	lm.mLock.Lock()
+	defer lm.mLock.Unlock()
+	lm.stopped = true
+	lm.deadTimer.Stop()
+	if lm.pingTimer != nil {
+		lm.pingTimer.Stop()
+	}
+}
@@ -0,0 +1,113 @@
+package irc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLagHandler(t *testing.T) {
+	clientEnd, server := net.Pipe()
+	defer server.Close()
+	client := NewClientWrapper(NewConnectionWrapper(clientEnd))
+	lag := RegisterLagHandler(client, LagConfig{Interval: 20 * time.Millisecond, Timeout: time.Second})
+	go readAll(client)
+
+	io.WriteString(server, ":server 001 me :Welcome\r\n")
+	lines := bufio.NewScanner(server)
+	for i := 0; i < 3; i++ {
+		if !lines.Scan() {
+			t.Fatal("Expected a PING")
+		}
+		ping := NewMessage(lines.Text())
+		if ping.Command() != "PING" || !strings.HasPrefix(ping.Trailing(), "lag") {
+			t.Fatalf("Expected PING, Received %v", ping)
+		}
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(server, ":server PONG server :"+ping.Trailing()+"\r\n")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if l := lag.Lag(); l < 10*time.Millisecond || l > time.Second {
+		t.Errorf("Expected lag of about 10ms, Received %v", l)
+	}
+	if l := lag.AverageLag(); l < 10*time.Millisecond || l > time.Second {
+		t.Errorf("Expected average lag of about 10ms, Received %v", l)
+	}
+	if since := time.Since(lag.LastActivity()); since > time.Second {
+		t.Errorf("Expected recent activity, Received %v ago", since)
+	}
+
+	//Unknown tokens are ignored
+	io.WriteString(server, ":server PONG server :other\r\n")
+	client.Close()
+}
+
+func TestLagHandlerTimeout(t *testing.T) {
+	clientEnd, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	timedOut := make(chan time.Duration, 1)
+	client := NewClientWrapper(NewConnectionWrapper(clientEnd), LagHandler(LagConfig{
+		Interval:  10 * time.Millisecond,
+		Timeout:   50 * time.Millisecond,
+		OnTimeout: func(silence time.Duration) { timedOut <- silence },
+	}))
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := client.Read(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	io.WriteString(server, ":server 001 me :Welcome\r\n")
+
+	select {
+	case silence := <-timedOut:
+		if silence < 50*time.Millisecond {
+			t.Errorf("Expected at least 50ms of silence, Received %v", silence)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the ping timeout")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected Read to fail after the ping timeout")
+	}
+}
+
+func TestLagHandlerRegistrationTimeout(t *testing.T) {
+	clientEnd, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	timedOut := make(chan time.Duration, 1)
+	client := NewClientWrapper(NewConnectionWrapper(clientEnd), LagHandler(LagConfig{
+		Timeout:   50 * time.Millisecond,
+		OnTimeout: func(silence time.Duration) { timedOut <- silence },
+	}))
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Read()
+		done <- err
+	}()
+
+	//The server never completes registration
+	select {
+	case <-timedOut:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the ping timeout")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected Read to fail after the ping timeout")
+	}
+}
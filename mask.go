@@ -0,0 +1,52 @@
+package irc
+
+//MatchMask reports whether s (e.g. nick!user@host) matches the pattern,
+//where '*' matches any number of characters and '?' matches exactly one.
+//Comparison is case insensitive using the rfc1459 casemapping.
+func MatchMask(pattern, s string) bool {
+	p, str := []byte(casefold(pattern)), []byte(casefold(s))
+	//Backtrack to the last '*' on a mismatch
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+//casefold lowercases s using the rfc1459 casemapping, where []\~ are
+//the uppercase forms of {}|^
+func casefold(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			b[i] = c + 'a' - 'A'
+		case c == '[':
+			b[i] = '{'
+		case c == ']':
+			b[i] = '}'
+		case c == '\\':
+			b[i] = '|'
+		case c == '~':
+			b[i] = '^'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,28 @@
+package irc
+
+import "testing"
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "nick!user@host", true},
+		{"*!*@host", "nick!user@host", true},
+		{"*!*@host", "nick!user@otherhost", false},
+		{"nick!*@*", "NICK!user@host", true},
+		{"n?ck!*", "nock!user@host", true},
+		{"n?ck!*", "nck!user@host", false},
+		{"*!*@*.example.com", "a!b@irc.example.com", true},
+		{"*!*@*.example.com", "a!b@example.com", false},
+		{"[away]!*@*", "{AWAY}!u@h", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		if MatchMask(test.pattern, test.s) != test.match {
+			t.Errorf("MatchMask(%q, %q): Expected %t", test.pattern, test.s, test.match)
+		}
+	}
+}
@@ -35,6 +35,11 @@ func PrivMessage(channel, msg string) Message {
 	return NewMessage(fmt.Sprintf("PRIVMSG %s :%s", channel, msg))
 }
 
+//NoticeMessage returns a parsed NOTICE command
+func NoticeMessage(target, msg string) Message {
+	return NewMessage(fmt.Sprintf("NOTICE %s :%s", target, msg))
+}
+
 //JoinMessage returns a parsed JOIN command
 func JoinMessage(channel string) Message {
 	return NewMessage("JOIN " + channel)
@@ -43,6 +48,7 @@ func JoinMessage(channel string) Message {
 //Message represents a Message sent between the client and server
 type Message interface {
 	Message() string
+	Tags() map[string]string
 	Prefix() string
 	Nick() string
 	User() string
@@ -53,6 +59,7 @@ type Message interface {
 	Trailing() string
 	String() string
 	Timestamp() time.Time
+	Raw() []byte
 }
 
 /*A Message represents a message sent to or from the IRC server.
@@ -62,7 +69,7 @@ The various components of the message are parsed for convenience.
 Pseudo-BNF from: https://tools.ietf.org/html/rfc1459#section-2.3.1
 
 
-    <message>  ::= [':' <prefix> <SPACE> ] <command> <params> <crlf>
+    <message>  ::= ['@' <tags> <SPACE>] [':' <prefix> <SPACE> ] <command> <params> <crlf>
     <prefix>   ::= <servername> | <nick> [ '!' <user> ] [ '@' <host> ]
     <command>  ::= <letter> { <letter> } | <number> <number> <number>
 
@@ -75,6 +82,8 @@ Pseudo-BNF from: https://tools.ietf.org/html/rfc1459#section-2.3.1
 type message struct {
 	message string //The raw, unparsed message
 
+	tags map[string]string //IRCv3 message tags, nil if not present
+
 	prefix string //includes the ':' character
 	nick   string
 	user   string
@@ -87,6 +96,7 @@ type message struct {
 
 	timestamp time.Time
 	parsed    bool
+	raw       []byte //The undecoded bytes, if kept by the Conn
 }
 
 //Message returns the entire message
@@ -94,6 +104,12 @@ func (m message) Message() string {
 	return m.message
 }
 
+//Tags returns the IRCv3 message tags, with values unescaped.
+//Tags without a value map to an empty string. Returns nil if not present
+func (m message) Tags() map[string]string {
+	return m.tags
+}
+
 //Prefix eturns the prefix (including the preceeding colon), or an emtpy string if not present
 func (m message) Prefix() string {
 	return m.prefix
@@ -141,6 +157,13 @@ func (m message) Timestamp() time.Time {
 	return m.timestamp
 }
 
+//Raw returns the bytes of the line exactly as they were recieved, before
+//any character set decoding. Returns nil unless the Conn was created with
+//the KeepRawBytes option.
+func (m message) Raw() []byte {
+	return m.raw
+}
+
 //String returns the entire message (identical to calling Message())
 func (m message) String() string {
 	return m.Message()
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/network"
+)
+
+//Metrics records IRC metrics in a Registry. Every metric is labelled
+//with the name of the network.
+type Metrics struct {
+	LinesRead    *Counter   //irc_lines_read_total{network,command}
+	LinesWritten *Counter   //irc_lines_written_total{network,command}
+	BytesRead    *Counter   //irc_read_bytes_total{network}
+	BytesWritten *Counter   //irc_written_bytes_total{network}
+	WriteErrors  *Counter   //irc_write_errors_total{network}
+	WritesActive *Gauge     //irc_writes_in_progress{network}
+	Handlers     *Histogram //irc_handler_duration_seconds{network,command}
+	Panics       *Counter   //irc_handler_panics_total{network,command}
+	Reconnects   *Counter   //irc_reconnects_total{network}
+	Connected    *Gauge     //irc_connected{network}
+	Lag          *Gauge     //irc_lag_seconds{network}
+	Channels     *Gauge     //irc_channels{network}
+	ChannelUsers *Gauge     //irc_channel_users{network,channel}
+
+	mLock      *sync.Mutex
+	clients    map[string]clientSource
+	managers   []*network.Manager
+	reconnects map[string]int //Last count from each manager's networks
+}
+
+type clientSource struct {
+	client irc.Client
+	lag    irc.LagMeter
+}
+
+//New registers the IRC metrics in the registry
+func New(r *Registry) *Metrics {
+	m := &Metrics{
+		LinesRead:    r.NewCounter("irc_lines_read_total", "Lines read from the server.", "network", "command"),
+		LinesWritten: r.NewCounter("irc_lines_written_total", "Lines written to the server.", "network", "command"),
+		BytesRead:    r.NewCounter("irc_read_bytes_total", "Bytes read from the server.", "network"),
+		BytesWritten: r.NewCounter("irc_written_bytes_total", "Bytes written to the server.", "network"),
+		WriteErrors:  r.NewCounter("irc_write_errors_total", "Lines that couldn't be written.", "network"),
+		WritesActive: r.NewGauge("irc_writes_in_progress",
+			"Lines being written to the server. There is no send queue, so this counts concurrent writes.", "network"),
+		Handlers: r.NewHistogram("irc_handler_duration_seconds", "Time taken by message handlers.",
+			DefaultBuckets, "network", "command"),
+		Panics:       r.NewCounter("irc_handler_panics_total", "Message handlers that panicked.", "network", "command"),
+		Reconnects:   r.NewCounter("irc_reconnects_total", "Times the connection was reestablished.", "network"),
+		Connected:    r.NewGauge("irc_connected", "Whether registered with the network.", "network"),
+		Lag:          r.NewGauge("irc_lag_seconds", "Round trip time to the server.", "network"),
+		Channels:     r.NewGauge("irc_channels", "Channels joined.", "network"),
+		ChannelUsers: r.NewGauge("irc_channel_users", "Users in each joined channel.", "network", "channel"),
+
+		mLock:      new(sync.Mutex),
+		clients:    make(map[string]clientSource),
+		reconnects: make(map[string]int),
+	}
+	r.OnCollect(m.collect)
+	return m
+}
+
+//Conn returns an irc.Instrumentation recording a connection's lines,
+//bytes, pending writes and handlers. Pass it to irc.Instrument when
+//creating the Conn; connections to the same network (e.g. after
+//reconnecting) can share it. For a network.Manager, add it to the
+//network's Config.Options.
+func (m *Metrics) Conn(network string) irc.Instrumentation {
+	return connMetrics{m, network}
+}
+
+//Client samples the client's channels and users, and the lag if lag
+//isn't nil, each time the metrics are collected. It replaces any client
+//previously added for the network; a nil client removes it.
+func (m *Metrics) Client(network string, client irc.Client, lag irc.LagMeter) {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+	if client == nil {
+		delete(m.clients, network)
+		return
+	}
+	m.clients[network] = clientSource{client, lag}
+}
+
+//Manager samples the state, reconnections, lag, channels and users of
+//the manager's networks each time the metrics are collected
+func (m *Metrics) Manager(mgr *network.Manager) {
+	m.mLock.Lock()
+	m.managers = append(m.managers, mgr)
+	m.mLock.Unlock()
+}
+
+func (m *Metrics) collect() {
+	m.mLock.Lock()
+	clients := make(map[string]clientSource, len(m.clients))
+	for name, src := range m.clients {
+		clients[name] = src
+	}
+	managers := append([]*network.Manager(nil), m.managers...)
+	m.mLock.Unlock()
+
+	//Reset so networks and channels that have gone aren't reported
+	m.Connected.Reset()
+	m.Lag.Reset()
+	m.Channels.Reset()
+	m.ChannelUsers.Reset()
+
+	for name, src := range clients {
+		if src.lag != nil {
+			m.Lag.Set(src.lag.Lag().Seconds(), name)
+		}
+		m.collectChannels(name, src.client)
+	}
+	for _, mgr := range managers {
+		for _, s := range mgr.Statuses() {
+			m.addReconnects(s.Network, s.Reconnects)
+			m.Connected.Set(boolValue(s.State == network.Registered), s.Network)
+			m.Lag.Set(s.Lag.Seconds(), s.Network)
+			if client, ok := mgr.Client(s.Network); ok {
+				m.collectChannels(s.Network, client)
+			}
+		}
+	}
+}
+
+//addReconnects adds the reconnections since the last collection. A
+//network that was removed and added again starts counting from zero.
+func (m *Metrics) addReconnects(network string, count int) {
+	m.mLock.Lock()
+	last := m.reconnects[network]
+	m.reconnects[network] = count
+	m.mLock.Unlock()
+	if count > last {
+		m.Reconnects.Add(float64(count-last), network)
+	}
+}
+
+func (m *Metrics) collectChannels(network string, client irc.Client) {
+	channels := client.ChannelNames()
+	m.Channels.Set(float64(len(channels)), network)
+	for _, ch := range channels {
+		if users, err := client.Users(ch); err == nil {
+			m.ChannelUsers.Set(float64(len(users)), network, ch)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type connMetrics struct {
+	m       *Metrics
+	network string
+}
+
+func (c connMetrics) LineRead(command string, bytes int) {
+	c.m.LinesRead.Inc(c.network, command)
+	c.m.BytesRead.Add(float64(bytes), c.network)
+}
+
+func (c connMetrics) WriteStarted() {
+	c.m.WritesActive.Add(1, c.network)
+}
+
+func (c connMetrics) WriteDone(command string, bytes int, err error) {
+	c.m.WritesActive.Add(-1, c.network)
+	if err != nil {
+		c.m.WriteErrors.Inc(c.network)
+		return
+	}
+	c.m.LinesWritten.Inc(c.network, command)
+	c.m.BytesWritten.Add(float64(bytes), c.network)
+}
+
+func (c connMetrics) HandlerDone(command string, d time.Duration, panicked bool) {
+	c.m.Handlers.Observe(d.Seconds(), c.network, command)
+	if panicked {
+		c.m.Panics.Inc(c.network, command)
+	}
+}
@@ -0,0 +1,373 @@
+/*
+Package metrics collects Prometheus style metrics for IRC connections and
+clients, and exposes them in the Prometheus text exposition format.
+
+	reg := metrics.NewRegistry()
+	m := metrics.New(reg)
+	conn, err := irc.NewConnection(addr, true, irc.Instrument(m.Conn("libera")))
+	...
+	client := irc.NewClientWrapper(conn)
+	m.Client("libera", client, irc.RegisterLagHandler(client, irc.LagConfig{}))
+	http.Handle("/metrics", reg.Handler())
+
+Clients run by a network.Manager are collected with m.Manager.
+
+The Registry, Counter, Gauge and Histogram types can also be used for an
+application's own metrics.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+//DefaultBuckets are the default histogram buckets, in seconds
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+//Registry holds metrics, and writes them in the text exposition format
+type Registry struct {
+	mLock      *sync.Mutex
+	metrics    map[string]metric
+	collectors []func()
+}
+
+//NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{mLock: new(sync.Mutex), metrics: make(map[string]metric)}
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+//register adds a metric. It panics if the name is already used, as that
+//is a programming error.
+func (r *Registry) register(name string, m metric) {
+	r.mLock.Lock()
+	defer r.mLock.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.metrics[name] = m
+}
+
+//OnCollect adds a function called before the metrics are written, to
+//update metrics that are sampled rather than counted as they happen.
+func (r *Registry) OnCollect(f func()) {
+	r.mLock.Lock()
+	r.collectors = append(r.collectors, f)
+	r.mLock.Unlock()
+}
+
+//WriteTo writes the metrics, sorted by name, in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mLock.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	r.mLock.Unlock()
+	for _, f := range collectors {
+		f()
+	}
+
+	r.mLock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mLock.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+//Handler returns an http.Handler serving the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//desc describes a metric and its labels
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+//key returns the map key for the label values. It panics if the number
+//of values is wrong.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, recieved %d values", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+//labelString returns {name="value",...} with extra appended to the labels
+func (d desc) labelString(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//values holds a value for each set of label values
+type values struct {
+	desc
+	mLock  *sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func newValues(d desc) values {
+	return values{desc: d, mLock: new(sync.Mutex), values: make(map[string]float64), labels: make(map[string][]string)}
+}
+
+func (v *values) add(delta float64, labels []string) {
+	key := v.key(labels)
+	v.mLock.Lock()
+	v.values[key] += delta
+	v.labels[key] = labels
+	v.mLock.Unlock()
+}
+
+func (v *values) set(value float64, labels []string) {
+	key := v.key(labels)
+	v.mLock.Lock()
+	v.values[key] = value
+	v.labels[key] = labels
+	v.mLock.Unlock()
+}
+
+func (v *values) get(labels []string) float64 {
+	v.mLock.Lock()
+	defer v.mLock.Unlock()
+	return v.values[v.key(labels)]
+}
+
+func (v *values) reset() {
+	v.mLock.Lock()
+	v.values = make(map[string]float64)
+	v.labels = make(map[string][]string)
+	v.mLock.Unlock()
+}
+
+func (v *values) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	v.mLock.Lock()
+	defer v.mLock.Unlock()
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelString(v.labels[key]), formatFloat(v.values[key]))
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Counter is a value that only goes up, such as the number of lines read
+type Counter struct {
+	values
+}
+
+//NewCounter registers a counter with the label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newValues(desc{name, help, "counter", labels})}
+	r.register(name, c)
+	return c
+}
+
+//Inc adds one to the counter with the label values
+func (c *Counter) Inc(labels ...string) {
+	c.add(1, labels)
+}
+
+//Add adds a value, which must not be negative, to the counter
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	c.add(v, labels)
+}
+
+//Value returns the counter with the label values
+func (c *Counter) Value(labels ...string) float64 {
+	return c.get(labels)
+}
+
+//Gauge is a value that can go up and down, such as the number of channels
+type Gauge struct {
+	values
+}
+
+//NewGauge registers a gauge with the label names
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newValues(desc{name, help, "gauge", labels})}
+	r.register(name, g)
+	return g
+}
+
+//Set sets the gauge with the label values
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.set(v, labels)
+}
+
+//Add adds a value, which may be negative, to the gauge
+func (g *Gauge) Add(v float64, labels ...string) {
+	g.add(v, labels)
+}
+
+//Value returns the gauge with the label values
+func (g *Gauge) Value(labels ...string) float64 {
+	return g.get(labels)
+}
+
+//Reset removes all the gauge's values, so label values that no longer
+//exist (e.g. channels that have been left) aren't reported
+func (g *Gauge) Reset() {
+	g.reset()
+}
+
+//Histogram counts observations, such as handler durations, in buckets
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mLock  *sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 //Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+//NewHistogram registers a histogram with the upper bounds of its buckets,
+//in increasing order, and the label names. If buckets is nil,
+//DefaultBuckets is used.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets,
+		mLock: new(sync.Mutex), series: make(map[string]*histogramSeries)}
+	r.register(name, h)
+	return h
+}
+
+//Observe adds an observation to the histogram with the label values
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+	h.mLock.Lock()
+	defer h.mLock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: labels, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+//Count returns the number of observations with the label values
+func (h *Histogram) Count(labels ...string) uint64 {
+	key := h.key(labels)
+	h.mLock.Lock()
+	defer h.mLock.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mLock.Lock()
+	defer h.mLock.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(s.labels), s.count)
+	}
+}
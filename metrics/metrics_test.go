@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oooska/irc"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "A counter.", "name")
+	g := r.NewGauge("test_gauge", "A gauge\nwith two lines.")
+	h := r.NewHistogram("test_seconds", "A histogram.", []float64{.1, 1})
+	c.Inc(`quote"back\slash`)
+	c.Add(2, "a")
+	g.Set(-1.5)
+	h.Observe(.05)
+	h.Observe(.5)
+	h.Observe(5)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_gauge A gauge\nwith two lines.
+# TYPE test_gauge gauge
+test_gauge -1.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{name="a"} 2
+test_total{name="quote\"back\\slash"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nRecieved:\n%s", expected, buf.String())
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected content type %q, recieved %q", ContentType, ct)
+	}
+	if rec.Body.String() != expected {
+		t.Errorf("Handler served:\n%s", rec.Body.String())
+	}
+}
+
+func TestDuplicateMetric(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a duplicate metric to panic")
+		}
+	}()
+	r.NewGauge("dup_total", "")
+}
+
+//rwc reads the lines given to it, and records what is written
+type rwc struct {
+	io.Reader
+	written bytes.Buffer
+}
+
+func (c *rwc) Write(p []byte) (int, error) { return c.written.Write(p) }
+func (c *rwc) Close() error                { return nil }
+
+func TestClientMetrics(t *testing.T) {
+	lines := []string{
+		":server 001 me :Welcome",
+		":me!u@host JOIN #chan",
+		":alice!a@host JOIN #chan",
+		":bob!b@host JOIN #chan",
+		":bob!b@host PART #chan",
+		":alice!a@host PRIVMSG #chan :hi",
+		":alice!a@host PRIVMSG #chan :panic",
+		":alice!a@host PRIVMSG #chan :panic",
+	}
+	in := strings.Join(lines, "\r\n") + "\r\n"
+	reg := NewRegistry()
+	m := New(reg)
+	conn := irc.NewConnectionWrapper(&rwc{Reader: strings.NewReader(in)}, irc.Instrument(m.Conn("test")), irc.RecoverPanics())
+	client := irc.NewClientWrapper(conn)
+	m.Client("test", client, nil)
+	client.AddHandler(irc.Incoming, func(msg irc.Message) {
+		if msg.Trailing() == "panic" {
+			panic("handler failed")
+		}
+	}, "PRIVMSG")
+
+	if err := client.Write(irc.JoinMessage("#chan")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := client.Read(); err != nil {
+			break
+		}
+	}
+
+	if v := m.LinesRead.Value("test", "JOIN"); v != 3 {
+		t.Errorf("Expected 3 JOINs read, recieved %v", v)
+	}
+	if v := m.BytesRead.Value("test"); v != float64(len(in)) {
+		t.Errorf("Expected %d bytes read, recieved %v", len(in), v)
+	}
+	if v := m.LinesWritten.Value("test", "JOIN"); v != 1 {
+		t.Errorf("Expected 1 JOIN written, recieved %v", v)
+	}
+	if v := m.BytesWritten.Value("test"); v != float64(len("JOIN #chan\r\n")) {
+		t.Errorf("Expected %d bytes written, recieved %v", len("JOIN #chan\r\n"), v)
+	}
+	if v := m.WritesActive.Value("test"); v != 0 {
+		t.Errorf("Expected no writes in progress, recieved %v", v)
+	}
+	if v := m.Panics.Value("test", "PRIVMSG"); v != 2 {
+		t.Errorf("Expected 2 panics, recieved %v", v)
+	}
+	if n := m.Handlers.Count("test", "PRIVMSG"); n < 3 {
+		t.Errorf("Expected the PRIVMSG handlers to be timed, recieved %d observations", n)
+	}
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	for _, line := range []string{
+		`irc_channels{network="test"} 1`,
+		`irc_channel_users{network="test",channel="#chan"} 2`,
+		`irc_handler_panics_total{network="test",command="PRIVMSG"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, buf.String())
+		}
+	}
+
+	//Removed clients are no longer reported
+	m.Client("test", nil, nil)
+	buf.Reset()
+	reg.WriteTo(&buf)
+	if strings.Contains(buf.String(), "irc_channels{") {
+		t.Errorf("Expected no channels after removing the client:\n%s", buf.String())
+	}
+}
+
+func TestPanicsPropagate(t *testing.T) {
+	m := New(NewRegistry())
+	in := strings.NewReader(":alice!a@host PRIVMSG #chan :panic\r\n")
+	conn := irc.NewConnectionWrapper(&rwc{Reader: in}, irc.Instrument(m.Conn("test")))
+	conn.AddHandler(irc.Incoming, func(irc.Message) {
+		panic("handler failed")
+	})
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected the handler's panic to propagate")
+		}
+		if v := m.Panics.Value("test", "PRIVMSG"); v != 1 {
+			t.Errorf("Expected the panic to be counted, recieved %v", v)
+		}
+	}()
+	conn.Read()
+}
+
+func TestReconnects(t *testing.T) {
+	m := New(NewRegistry())
+	//The network is removed and added again after 3 reconnections
+	for _, count := range []int{2, 3, 3, 0, 1} {
+		m.addReconnects("test", count)
+	}
+	if v := m.Reconnects.Value("test"); v != 4 {
+		t.Errorf("Expected 4 reconnections, recieved %v", v)
+	}
+}
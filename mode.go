@@ -0,0 +1,55 @@
+package irc
+
+import "strings"
+
+//ModeChange is a single mode set or unset by a MODE message
+type ModeChange struct {
+	Set   bool
+	Mode  byte
+	Param string //Empty if the mode takes no parameter
+}
+
+//String returns the change in MODE form, e.g. "+o nick"
+func (mc ModeChange) String() string {
+	s := "-"
+	if mc.Set {
+		s = "+"
+	}
+	s += string(mc.Mode)
+	if mc.Param != "" {
+		s += " " + mc.Param
+	}
+	return s
+}
+
+//ParseModeChanges splits a channel mode string and its parameters (e.g.
+//"+ov-k", ["alice", "bob", "key"]) into individual changes, using the
+//server's PREFIX and CHANMODES to know which modes take parameters.
+func ParseModeChanges(is ISupport, modes string, params []string) []ModeChange {
+	prefixModes, _ := Prefixes(is)
+	a, b, c, _ := ChanModes(is)
+
+	var changes []ModeChange
+	set := true
+	for i := 0; i < len(modes); i++ {
+		m := modes[i]
+		switch m {
+		case '+':
+			set = true
+			continue
+		case '-':
+			set = false
+			continue
+		}
+
+		change := ModeChange{Set: set, Mode: m}
+		takesParam := strings.IndexByte(prefixModes, m) >= 0 || strings.IndexByte(a, m) >= 0 ||
+			strings.IndexByte(b, m) >= 0 || (set && strings.IndexByte(c, m) >= 0)
+		if takesParam && len(params) > 0 {
+			change.Param = strings.TrimPrefix(params[0], ":")
+			params = params[1:]
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,51 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseModeChanges(t *testing.T) {
+	conn, _ := newBufferConn(":server 005 me PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnst :are supported")
+	is := RegisterISupportHandler(conn)
+	readAll(conn)
+
+	changes := ParseModeChanges(is, "+oql-vk+b-l", []string{"alice", "bob", "10", "carol", "key", "*!*@host"})
+	expected := []ModeChange{
+		{true, 'o', "alice"},
+		{true, 'q', "bob"},
+		{true, 'l', "10"},
+		{false, 'v', "carol"},
+		{false, 'k', "key"},
+		{true, 'b', "*!*@host"},
+		{false, 'l', ""},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, Received %v", expected, changes)
+	}
+	if s := changes[0].String(); s != "+o alice" {
+		t.Errorf("Expected +o alice, Received %s", s)
+	}
+}
+
+func TestISupport(t *testing.T) {
+	conn, _ := newBufferConn(
+		":server 005 me PREFIX=(ov)@+ CHANTYPES=# NETWORK=Example\\x20Net EXCEPTS :are supported",
+		":server 005 me -EXCEPTS :are supported",
+	)
+	is := RegisterISupportHandler(conn)
+	readAll(conn)
+
+	if network, _ := is.Supports("network"); network != "Example Net" {
+		t.Errorf("Expected escaped value to be decoded, Received %q", network)
+	}
+	if _, ok := is.Supports("EXCEPTS"); ok {
+		t.Error("Expected EXCEPTS to be removed")
+	}
+	if modes, symbols := Prefixes(is); modes != "ov" || symbols != "@+" {
+		t.Errorf("Unexpected prefixes %q %q", modes, symbols)
+	}
+	if IsChannel(is, "&chan") || !IsChannel(is, "#chan") {
+		t.Error("Expected CHANTYPES to be used")
+	}
+}
@@ -0,0 +1,299 @@
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSplitGather   = 2 * time.Second
+	defaultRejoinTimeout = 15 * time.Minute
+	hostnameCharacters   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-.*_"
+)
+
+//Netsplit is a group of users that quit, or rejoined, because of a
+//split between two servers
+type Netsplit struct {
+	Servers  [2]string
+	Users    []string //Nicks, sorted
+	Channels []string //Channels the users were in, sorted
+	Time     time.Time
+}
+
+//String describes the split, e.g. "Netsplit: a.net <-> b.net, 120 users"
+func (ns Netsplit) String() string {
+	return fmt.Sprintf("Netsplit: %s <-> %s, %d users", ns.Servers[0], ns.Servers[1], len(ns.Users))
+}
+
+//Netjoin is a group of users rejoining after a Netsplit
+type Netjoin Netsplit
+
+//String describes the netjoin, e.g. "Netjoin: a.net <-> b.net, 120 users"
+func (nj Netjoin) String() string {
+	return fmt.Sprintf("Netjoin: %s <-> %s, %d users", nj.Servers[0], nj.Servers[1], len(nj.Users))
+}
+
+//NetsplitConfig configures netsplit detection
+type NetsplitConfig struct {
+	//Gather is how long to wait after the last quit or join before
+	//reporting a netsplit or netjoin. Defaults to 2 seconds.
+	Gather time.Duration
+	//RejoinTimeout is how long to wait for users to rejoin after a
+	//split. Defaults to 15 minutes.
+	RejoinTimeout time.Duration
+
+	//OnSplit and OnJoin are called from their own goroutine
+	OnSplit func(Netsplit)
+	OnJoin  func(Netjoin)
+}
+
+//Netsplits keeps track of users that quit in a netsplit and haven't rejoined
+type Netsplits interface {
+	//Active returns the netsplits that users haven't rejoined from
+	Active() []Netsplit
+	//Handled reports whether the QUIT or JOIN is part of a netsplit or
+	//netjoin, so logs can show the Netsplit instead
+	Handled(msg Message) bool
+}
+
+//split is a netsplit being gathered, or waiting for users to rejoin
+type split struct {
+	Netsplit
+	users    map[string][]string //Nick to the channels they were in
+	reported bool
+	gather   *time.Timer
+	expire   *time.Timer
+
+	joined     map[string][]string //Users that have rejoined, and the channels
+	joinGather *time.Timer
+}
+
+type netsplits struct {
+	client Client
+	conf   NetsplitConfig
+
+	mLock  *sync.Mutex
+	splits map[[2]string]*split
+}
+
+//IsNetsplitQuit reports whether the QUIT is because of a netsplit, and
+//which servers split. The reason is two server names, e.g. "a.net b.net",
+//or "*.net *.split" on networks that hide servers.
+func IsNetsplitQuit(msg Message) (servers [2]string, ok bool) {
+	if msg.Command() != "QUIT" {
+		return servers, false
+	}
+	parts := strings.Split(msg.Trailing(), " ")
+	if len(parts) != 2 || parts[0] == parts[1] {
+		return servers, false
+	}
+	for _, server := range parts {
+		if !strings.Contains(server, ".") || strings.HasPrefix(server, ".") || strings.HasSuffix(server, ".") ||
+			strings.Trim(server, hostnameCharacters) != "" || strings.Contains(server, "..") {
+			return servers, false
+		}
+	}
+	return [2]string{parts[0], parts[1]}, true
+}
+
+//NetsplitHandler returns a ClientHandler detecting netsplits
+func NetsplitHandler(conf NetsplitConfig) ClientHandler {
+	return func(client Client) {
+		RegisterNetsplitHandler(client, conf)
+	}
+}
+
+//RegisterNetsplitHandler groups quits caused by a netsplit, and the
+//rejoins once the servers reconnect, reporting them to the config's
+//OnSplit and OnJoin.
+func RegisterNetsplitHandler(client Client, conf NetsplitConfig) Netsplits {
+	if conf.Gather == 0 {
+		conf.Gather = defaultSplitGather
+	}
+	if conf.RejoinTimeout == 0 {
+		conf.RejoinTimeout = defaultRejoinTimeout
+	}
+	ns := &netsplits{client: client, conf: conf, mLock: new(sync.Mutex), splits: make(map[[2]string]*split)}
+	//Registered as state for all commands, which are called before the
+	//channel handler's QUIT state handler removes the user
+	client.AddHandler(IncomingState, func(msg Message) {
+		switch msg.Command() {
+		case "QUIT":
+			if servers, ok := IsNetsplitQuit(msg); ok {
+				ns.quit(servers, msg.Nick())
+			}
+		case "JOIN":
+			if len(msg.Params()) > 0 {
+				ns.join(msg.Nick(), msg.Params()[0])
+			}
+		}
+	})
+	return ns
+}
+
+func (ns *netsplits) Active() []Netsplit {
+	ns.mLock.Lock()
+	defer ns.mLock.Unlock()
+	var active []Netsplit
+	for _, s := range ns.splits {
+		if s.reported {
+			active = append(active, s.Netsplit)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Time.Before(active[j].Time) })
+	return active
+}
+
+func (ns *netsplits) Handled(msg Message) bool {
+	switch msg.Command() {
+	case "QUIT":
+		_, ok := IsNetsplitQuit(msg)
+		return ok
+	case "JOIN":
+		ns.mLock.Lock()
+		defer ns.mLock.Unlock()
+		nick := casefold(msg.Nick())
+		for _, s := range ns.splits {
+			if _, ok := s.users[nick]; ok {
+				return true
+			}
+			if _, ok := s.joined[nick]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//quit adds the user to the split between the servers
+func (ns *netsplits) quit(servers [2]string, nick string) {
+	var channels []string
+	for _, ch := range ns.client.ChannelNames() {
+		users, _ := ns.client.Users(ch)
+		for _, user := range users {
+			if strings.EqualFold(user, nick) {
+				channels = append(channels, ch)
+			}
+		}
+	}
+
+	ns.mLock.Lock()
+	defer ns.mLock.Unlock()
+	s, ok := ns.splits[servers]
+	if !ok || s.reported {
+		if ok {
+			//A new split between the same servers replaces the old one
+			ns.remove(s)
+		}
+		s = &split{Netsplit: Netsplit{Servers: servers, Time: time.Now()}, users: make(map[string][]string), joined: make(map[string][]string)}
+		ns.splits[servers] = s
+		s.gather = time.AfterFunc(ns.conf.Gather, func() { ns.reportSplit(s) })
+	} else {
+		s.gather.Reset(ns.conf.Gather)
+	}
+	s.users[casefold(nick)] = channels
+	s.Users = append(s.Users, nick)
+}
+
+//join records a user rejoining after a split
+func (ns *netsplits) join(nick, channel string) {
+	ns.mLock.Lock()
+	defer ns.mLock.Unlock()
+	folded := casefold(nick)
+	for _, s := range ns.splits {
+		if _, ok := s.users[folded]; !ok || !s.reported {
+			continue
+		}
+		delete(s.users, folded)
+		s.joined[folded] = append(s.joined[folded], channel)
+		if s.joinGather == nil {
+			s.joinGather = time.AfterFunc(ns.conf.Gather, func() { ns.reportJoin(s) })
+		} else {
+			s.joinGather.Reset(ns.conf.Gather)
+		}
+		return
+	}
+	//Users rejoin every channel, so keep adding them to the netjoin
+	for _, s := range ns.splits {
+		if _, ok := s.joined[folded]; ok {
+			s.joined[folded] = append(s.joined[folded], channel)
+			return
+		}
+	}
+}
+
+func (ns *netsplits) reportSplit(s *split) {
+	ns.mLock.Lock()
+	s.reported = true
+	sort.Strings(s.Users)
+	s.Channels = channelSet(s.users)
+	split := s.Netsplit
+	split.Users = append([]string(nil), s.Users...)
+	s.expire = time.AfterFunc(ns.conf.RejoinTimeout, func() {
+		ns.mLock.Lock()
+		ns.remove(s)
+		ns.mLock.Unlock()
+	})
+	ns.mLock.Unlock()
+
+	if ns.conf.OnSplit != nil {
+		ns.conf.OnSplit(split)
+	}
+}
+
+func (ns *netsplits) reportJoin(s *split) {
+	ns.mLock.Lock()
+	join := Netjoin{Servers: s.Servers, Time: time.Now(), Channels: channelSet(s.joined)}
+	for _, nick := range s.Users {
+		if _, ok := s.joined[casefold(nick)]; ok {
+			join.Users = append(join.Users, nick)
+		}
+	}
+	//Users that rejoined are no longer part of the split
+	var remaining []string
+	for _, nick := range s.Users {
+		if _, ok := s.users[casefold(nick)]; ok {
+			remaining = append(remaining, nick)
+		}
+	}
+	s.Users = remaining
+	s.joined = make(map[string][]string)
+	s.joinGather = nil
+	if len(s.users) == 0 {
+		ns.remove(s)
+	}
+	ns.mLock.Unlock()
+
+	if ns.conf.OnJoin != nil {
+		ns.conf.OnJoin(join)
+	}
+}
+
+//remove stops tracking the split. mLock must be held.
+func (ns *netsplits) remove(s *split) {
+	if ns.splits[s.Servers] == s {
+		delete(ns.splits, s.Servers)
+	}
+	if s.expire != nil {
+		s.expire.Stop()
+	}
+}
+
+//channelSet returns the sorted channels in the map's values
+func channelSet(users map[string][]string) []string {
+	set := make(map[string]bool)
+	for _, channels := range users {
+		for _, ch := range channels {
+			set[ch] = true
+		}
+	}
+	channels := make([]string, 0, len(set))
+	for ch := range set {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	return channels
+}
@@ -0,0 +1,100 @@
+package irc
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsNetsplitQuit(t *testing.T) {
+	tests := []struct {
+		line    string
+		servers [2]string
+		ok      bool
+	}{
+		{":a!u@h QUIT :irc.a.net irc.b.net", [2]string{"irc.a.net", "irc.b.net"}, true},
+		{":a!u@h QUIT :*.net *.split", [2]string{"*.net", "*.split"}, true},
+		{":a!u@h QUIT :Quit: bye", [2]string{}, false},
+		{":a!u@h QUIT :see you.later then", [2]string{}, false},
+		{":a!u@h QUIT :a.net a.net", [2]string{}, false},
+		{":a!u@h QUIT :go.to www.example.com/", [2]string{}, false},
+		{":a!u@h PART #chan :a.net b.net", [2]string{}, false},
+	}
+	for _, test := range tests {
+		servers, ok := IsNetsplitQuit(NewMessage(test.line))
+		if servers != test.servers || ok != test.ok {
+			t.Errorf("IsNetsplitQuit(%q): Expected %v %t, Received %v %t", test.line, test.servers, test.ok, servers, ok)
+		}
+	}
+}
+
+//pipeConn reads what is written to its PipeWriter, and discards writes
+type pipeConn struct {
+	*io.PipeReader
+}
+
+func (p pipeConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func TestNetsplitHandler(t *testing.T) {
+	splits := make(chan Netsplit, 1)
+	joins := make(chan Netjoin, 1)
+	r, w := io.Pipe()
+	defer w.Close()
+	send := func(lines ...string) {
+		for _, line := range lines {
+			io.WriteString(w, line+"\r\n")
+		}
+	}
+
+	client := NewClientWrapper(NewConnectionWrapper(pipeConn{r}))
+	netsplits := RegisterNetsplitHandler(client, NetsplitConfig{
+		Gather:  20 * time.Millisecond,
+		OnSplit: func(ns Netsplit) { splits <- ns },
+		OnJoin:  func(nj Netjoin) { joins <- nj },
+	})
+	go readAll(client)
+
+	send(
+		"JOIN #a",
+		":server 353 me = #a :me alice bob carol",
+		"JOIN #b",
+		":server 353 me = #b :me alice",
+		":alice!u@h QUIT :hub.net leaf.net",
+		":bob!u@h QUIT :hub.net leaf.net",
+		":carol!u@h QUIT :Quit: bye",
+	)
+	var split Netsplit
+	select {
+	case split = <-splits:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for netsplit")
+	}
+	if split.Servers != [2]string{"hub.net", "leaf.net"} || !reflect.DeepEqual(split.Users, []string{"alice", "bob"}) ||
+		!reflect.DeepEqual(split.Channels, []string{"#a", "#b"}) {
+		t.Errorf("Unexpected netsplit %+v", split)
+	}
+	if s := split.String(); s != "Netsplit: hub.net <-> leaf.net, 2 users" {
+		t.Errorf("Unexpected description %q", s)
+	}
+	if len(netsplits.Active()) != 1 {
+		t.Errorf("Expected an active netsplit, Received %+v", netsplits.Active())
+	}
+	if !netsplits.Handled(NewMessage(":alice!u@h JOIN #a")) || !netsplits.Handled(NewMessage(":bob!u@h QUIT :hub.net leaf.net")) ||
+		netsplits.Handled(NewMessage(":carol!u@h JOIN #a")) {
+		t.Error("Expected only netsplit quits and rejoins to be handled")
+	}
+
+	send(":alice!u@h JOIN #a", ":alice!u@h JOIN #b")
+	select {
+	case join := <-joins:
+		if !reflect.DeepEqual(join.Users, []string{"alice"}) || !reflect.DeepEqual(join.Channels, []string{"#a", "#b"}) {
+			t.Errorf("Unexpected netjoin %+v", join)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for netjoin")
+	}
+	if active := netsplits.Active(); len(active) != 1 || !reflect.DeepEqual(active[0].Users, []string{"bob"}) {
+		t.Errorf("Expected bob to still be split, Received %+v", active)
+	}
+}
@@ -0,0 +1,446 @@
+/*
+Package network runs clients for several IRC networks in one process.
+
+A Manager owns a named Client per network, connects and registers it,
+and reconnects it when the connection is lost. Handlers added with Use
+are registered on every network's client, so one bot.Router can serve
+all of them, and every message read is passed to the Manager's event
+handlers with the name of the network it came from.
+
+	m := network.NewManager()
+	m.Use(router.Handler())
+	m.Handle(func(e network.Event) {
+		log.Printf("[%s] %s", e.Network, e.Message)
+	})
+	m.Add("libera", network.Config{
+		Servers: []irc.Server{{Address: "irc.libera.chat", SSL: true}},
+		Nick:    "mybot",
+	})
+	defer m.Close("Shutting down")
+*/
+package network
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+)
+
+//Errors returned when adding and removing networks
+var (
+	ErrDuplicateNetwork = errors.New("network: network already added")
+	ErrUnknownNetwork   = errors.New("network: unknown network")
+	ErrClosed           = errors.New("network: manager closed")
+)
+
+const (
+	defaultReconnectDelay = 10 * time.Second
+	maxReconnectDelay     = 5 * time.Minute
+)
+
+//Config configures a network
+type Config struct {
+	Servers []irc.Server     //Tried in order. See irc.ServerList.
+	Options []irc.ConnOption //e.g. irc.Proxy
+
+	Nick     string
+	Username string //Defaults to Nick
+	Realname string //Defaults to Nick
+	Password string //Server password, sent with PASS
+
+	//Handlers registered on this network's client, after the shared ones
+	Handlers []irc.ClientHandler
+
+	//Lag configures lag measurement. A connection the server stops
+	//responding on is closed after Lag.Timeout, and reconnected.
+	Lag irc.LagConfig
+
+	//Time to wait before reconnecting. Defaults to 10 seconds,
+	//doubling on each failure up to 5 minutes.
+	ReconnectDelay time.Duration
+}
+
+//Event is a message read from a network. Handlers are called after the
+//client's handlers, so the client's state is already updated.
+type Event struct {
+	Network string
+	Client  irc.Client
+	Message irc.Message
+}
+
+//EventHandler is called with every message read from every network
+type EventHandler func(Event)
+
+//State is the connection state of a network
+type State int
+
+const (
+	Disconnected State = iota
+	Connecting
+	Registered
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Registered:
+		return "registered"
+	case Closed:
+		return "closed"
+	}
+	return "disconnected"
+}
+
+//Status describes a network's connection
+type Status struct {
+	Network    string
+	State      State
+	Server     irc.Server //The server connected to
+	Nick       string
+	Channels   []string
+	Lag        time.Duration
+	Since      time.Time //When the state last changed
+	Reconnects int
+	LastError  error //Why the last connection was lost
+}
+
+//Manager runs a client for each of several networks
+type Manager struct {
+	mLock    *sync.Mutex
+	networks map[string]*network
+	shared   []irc.ClientHandler
+	events   []EventHandler
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+//NewManager returns a Manager with no networks
+func NewManager() *Manager {
+	return &Manager{mLock: new(sync.Mutex), networks: make(map[string]*network)}
+}
+
+//Use adds handlers registered on the client of every network. They take
+//effect on the next connection of networks that are already connected.
+func (m *Manager) Use(handlers ...irc.ClientHandler) {
+	m.mLock.Lock()
+	m.shared = append(m.shared, handlers...)
+	m.mLock.Unlock()
+}
+
+//Handle adds a handler called with every message read from any network
+func (m *Manager) Handle(h EventHandler) {
+	m.mLock.Lock()
+	m.events = append(m.events, h)
+	m.mLock.Unlock()
+}
+
+//Add adds a network, and starts connecting to it
+func (m *Manager) Add(name string, conf Config) error {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	key := strings.ToLower(name)
+	if _, ok := m.networks[key]; ok {
+		return ErrDuplicateNetwork
+	}
+	n := &network{
+		name:    name,
+		conf:    conf,
+		servers: &irc.ServerList{Servers: conf.Servers, Options: conf.Options},
+		manager: m,
+		done:    make(chan struct{}),
+		since:   time.Now(),
+	}
+	m.networks[key] = n
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		n.run()
+	}()
+	return nil
+}
+
+//Remove disconnects from a network, sending QUIT with the reason, and
+//removes it
+func (m *Manager) Remove(name, reason string) error {
+	m.mLock.Lock()
+	n, ok := m.networks[strings.ToLower(name)]
+	delete(m.networks, strings.ToLower(name))
+	m.mLock.Unlock()
+	if !ok {
+		return ErrUnknownNetwork
+	}
+	n.close(reason)
+	return nil
+}
+
+//Client returns the client of a network. It returns false if the network
+//is unknown or not connected.
+func (m *Manager) Client(name string) (irc.Client, bool) {
+	m.mLock.Lock()
+	n, ok := m.networks[strings.ToLower(name)]
+	m.mLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	n.mLock.Lock()
+	defer n.mLock.Unlock()
+	return n.client, n.client != nil
+}
+
+//Network returns the name of the network a client belongs to, for
+//handlers shared across networks. It returns "" for unknown clients.
+func (m *Manager) Network(client irc.Client) string {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+	for _, n := range m.networks {
+		n.mLock.Lock()
+		found := n.client == client
+		n.mLock.Unlock()
+		if found {
+			return n.name
+		}
+	}
+	return ""
+}
+
+//Networks returns the names of the networks, sorted
+func (m *Manager) Networks() []string {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+	names := make([]string, 0, len(m.networks))
+	for _, n := range m.networks {
+		names = append(names, n.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Status returns the status of a network
+func (m *Manager) Status(name string) (Status, error) {
+	m.mLock.Lock()
+	n, ok := m.networks[strings.ToLower(name)]
+	m.mLock.Unlock()
+	if !ok {
+		return Status{}, ErrUnknownNetwork
+	}
+	return n.status(), nil
+}
+
+//Statuses returns the status of every network, sorted by name
+func (m *Manager) Statuses() []Status {
+	var statuses []Status
+	for _, name := range m.Networks() {
+		if s, err := m.Status(name); err == nil {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses
+}
+
+//Send sends messages to a network
+func (m *Manager) Send(name string, msgs ...irc.Message) (int, error) {
+	client, ok := m.Client(name)
+	if !ok {
+		return 0, ErrUnknownNetwork
+	}
+	return client.Send(msgs...)
+}
+
+//Close disconnects from every network, sending QUIT with the reason, and
+//waits for their connections to close
+func (m *Manager) Close(reason string) {
+	m.mLock.Lock()
+	m.closed = true
+	networks := m.networks
+	m.networks = make(map[string]*network)
+	m.mLock.Unlock()
+
+	for _, n := range networks {
+		n.close(reason)
+	}
+	m.wg.Wait()
+}
+
+func (m *Manager) handlers() ([]irc.ClientHandler, []EventHandler) {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+	return append([]irc.ClientHandler(nil), m.shared...), append([]EventHandler(nil), m.events...)
+}
+
+//network is a managed connection to a network
+type network struct {
+	name    string
+	conf    Config
+	servers *irc.ServerList
+	manager *Manager
+	done    chan struct{}
+
+	mLock      sync.Mutex
+	client     irc.Client
+	lag        irc.LagMeter
+	state      State
+	since      time.Time
+	reconnects int
+	lastErr    error
+}
+
+//run connects to the network, reconnecting with backoff until closed
+func (n *network) run() {
+	delay := n.conf.ReconnectDelay
+	if delay <= 0 {
+		delay = defaultReconnectDelay
+	}
+	for {
+		connected := time.Now()
+		err := n.connect()
+		if n.isClosed() {
+			return
+		}
+		n.setState(Disconnected, err)
+
+		if time.Since(connected) > maxReconnectDelay {
+			delay = n.conf.ReconnectDelay
+			if delay <= 0 {
+				delay = defaultReconnectDelay
+			}
+		}
+		select {
+		case <-n.done:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		n.mLock.Lock()
+		n.reconnects++
+		n.mLock.Unlock()
+	}
+}
+
+//connect connects and registers with the network, and reads messages
+//until the connection is lost
+func (n *network) connect() error {
+	n.setState(Connecting, nil)
+	shared, events := n.manager.handlers()
+	client, err := n.servers.NewClient(append(shared, n.conf.Handlers...)...)
+	if err != nil {
+		return err
+	}
+
+	n.mLock.Lock()
+	if n.state == Closed {
+		n.mLock.Unlock()
+		client.Close()
+		return nil
+	}
+	n.client = client
+	n.lag = irc.RegisterLagHandler(client, n.conf.Lag)
+	n.mLock.Unlock()
+	defer func() {
+		n.mLock.Lock()
+		n.client = nil
+		n.lag = nil
+		n.mLock.Unlock()
+		client.Close()
+	}()
+
+	username, realname := n.conf.Username, n.conf.Realname
+	if username == "" {
+		username = n.conf.Nick
+	}
+	if realname == "" {
+		realname = n.conf.Nick
+	}
+	if n.conf.Password != "" {
+		client.Send(irc.NewMessage("PASS " + n.conf.Password))
+	}
+	client.Send(irc.NickMessage(n.conf.Nick), irc.UserMessage(username, "0", "*", ":"+realname))
+
+	registered := false
+	for {
+		msg, err := client.Read()
+		if err != nil {
+			return err
+		}
+		switch msg.Command() {
+		case "001":
+			registered = true
+			n.setState(Registered, nil)
+		case "433":
+			//Nick in use during registration. Try another.
+			if !registered {
+				client.Send(irc.NickMessage(client.Nick() + "_"))
+			}
+		}
+		for _, h := range events {
+			h(Event{Network: n.name, Client: client, Message: msg})
+		}
+	}
+}
+
+func (n *network) setState(state State, err error) {
+	n.mLock.Lock()
+	defer n.mLock.Unlock()
+	if n.state == Closed {
+		return
+	}
+	n.state = state
+	n.since = time.Now()
+	if err != nil {
+		n.lastErr = err
+	}
+}
+
+func (n *network) status() Status {
+	n.mLock.Lock()
+	defer n.mLock.Unlock()
+	s := Status{
+		Network:    n.name,
+		State:      n.state,
+		Since:      n.since,
+		Reconnects: n.reconnects,
+		LastError:  n.lastErr,
+	}
+	if n.client != nil {
+		s.Server = n.servers.Current()
+		s.Nick = n.client.Nick()
+		s.Channels = n.client.ChannelNames()
+		sort.Strings(s.Channels)
+		s.Lag = n.lag.Lag()
+	}
+	return s
+}
+
+func (n *network) isClosed() bool {
+	n.mLock.Lock()
+	defer n.mLock.Unlock()
+	return n.state == Closed
+}
+
+func (n *network) close(reason string) {
+	n.mLock.Lock()
+	if n.state == Closed {
+		n.mLock.Unlock()
+		return
+	}
+	n.state = Closed
+	n.since = time.Now()
+	client := n.client
+	n.mLock.Unlock()
+
+	close(n.done)
+	if client != nil {
+		client.Send(irc.NewMessage("QUIT :" + reason))
+		client.Close()
+	}
+}
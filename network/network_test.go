@@ -0,0 +1,133 @@
+package network
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/irctest"
+)
+
+const timeout = 2 * time.Second
+
+//waitFor polls until cond is true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManager(t *testing.T) {
+	s1, s2 := irctest.NewServer(), irctest.NewServer()
+	defer s1.Close()
+	defer s2.Close()
+
+	m := NewManager()
+	var mLock sync.Mutex
+	joined := make(map[string]string) //Network the shared handler saw each JOIN on
+	var events []Event
+	m.Use(func(client irc.Client) {
+		client.AddHandler(irc.Incoming, func(msg irc.Message) {
+			if msg.Command() == "001" {
+				client.Send(irc.JoinMessage("#" + client.Nick()))
+			}
+		}, "001")
+	})
+	m.Handle(func(e Event) {
+		mLock.Lock()
+		defer mLock.Unlock()
+		events = append(events, e)
+		if e.Message.Command() == "JOIN" {
+			joined[e.Network] = e.Message.Params()[0]
+			if channels := e.Client.ChannelNames(); len(channels) != 1 {
+				t.Errorf("Expected channel state to be updated, Received %v", channels)
+			}
+			if m.Network(e.Client) != e.Network {
+				t.Errorf("Expected Network to return %s, Received %s", e.Network, m.Network(e.Client))
+			}
+		}
+	})
+
+	m.Add("One", Config{Servers: []irc.Server{{Address: s1.Addr()}}, Nick: "alice"})
+	m.Add("two", Config{Servers: []irc.Server{{Address: s2.Addr()}}, Nick: "bob", ReconnectDelay: 10 * time.Millisecond,
+		Lag: irc.LagConfig{Interval: 10 * time.Millisecond}})
+	if err := m.Add("one", Config{}); err != ErrDuplicateNetwork {
+		t.Errorf("Expected ErrDuplicateNetwork, Received %v", err)
+	}
+	if names := m.Networks(); len(names) != 2 || names[0] != "One" || names[1] != "two" {
+		t.Errorf("Unexpected networks %v", names)
+	}
+
+	waitFor(t, "joins", func() bool {
+		mLock.Lock()
+		defer mLock.Unlock()
+		return joined["One"] == "#alice" && joined["two"] == "#bob"
+	})
+
+	status, err := m.Status("one")
+	if err != nil || status.State != Registered || status.Nick != "alice" || status.Server.Address != s1.Addr() ||
+		len(status.Channels) != 1 || status.Channels[0] != "#alice" {
+		t.Errorf("Unexpected status %+v (%v)", status, err)
+	}
+	if _, err := m.Status("three"); err != ErrUnknownNetwork {
+		t.Errorf("Expected ErrUnknownNetwork, Received %v", err)
+	}
+	if _, err := m.Send("one", irc.PrivMessage("#alice", "hello")); err != nil {
+		t.Error(err)
+	}
+	if _, err := s1.WaitFor("PRIVMSG", timeout); err != nil {
+		t.Error("Expected PRIVMSG to be sent to network one")
+	}
+
+	if _, err := s2.WaitFor("PING", timeout); err != nil {
+		t.Error("Expected the lag handler to PING")
+	}
+
+	//Losing the connection reconnects
+	c, _ := s2.WaitForConn(1, timeout)
+	c.Disconnect()
+	if _, err := s2.WaitForConn(2, timeout); err != nil {
+		t.Fatal("Expected a reconnection")
+	}
+	waitFor(t, "reconnect", func() bool {
+		status, _ := m.Status("two")
+		return status.State == Registered && status.Reconnects == 1 && status.LastError != nil
+	})
+
+	if err := m.Remove("two", "Bye"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := m.Client("two"); ok {
+		t.Error("Expected removed network to be gone")
+	}
+	m.Close("Shutting down")
+	msg, err := s1.WaitFor("QUIT", timeout)
+	if err != nil || msg.Trailing() != "Shutting down" {
+		t.Errorf("Expected QUIT, Received %v (%v)", msg, err)
+	}
+	if err := m.Add("three", Config{}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, Received %v", err)
+	}
+}
+
+func TestNickInUse(t *testing.T) {
+	s := irctest.NewServer()
+	defer s.Close()
+	s.Expect("NICK bot").Respond(":irctest 433 * bot :Nickname is already in use")
+
+	m := NewManager()
+	defer m.Close("")
+	m.Add("net", Config{Servers: []irc.Server{{Address: s.Addr()}}, Nick: "bot", Password: "secret"})
+	waitFor(t, "registration", func() bool {
+		status, _ := m.Status("net")
+		return status.State == Registered && status.Nick == "bot_"
+	})
+	if msg, err := s.WaitFor("PASS", timeout); err != nil || msg.Params()[0] != "secret" {
+		t.Errorf("Expected PASS, Received %v (%v)", msg, err)
+	}
+}
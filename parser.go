@@ -4,50 +4,62 @@ import "strings"
 
 //ParseString string takes a raw irc command and parses it
 //into a ParsedMessage
-//:PREFIX COMMAND ARG1 ARG2 :Last arg may have spaces if preceeded by colon
+//@TAGS :PREFIX COMMAND ARG1 ARG2 :Last arg may have spaces if preceeded by colon
+//TAGS are IRCv3 message tags (key=value;key2), and are optional
 //PREFIX is nick!user@host or servername, and is optional
 func parseString(message string) (pm message) {
-	tokens := strings.Split(strings.TrimSpace(message), " ")
-	k := 0
+	rest := strings.TrimRight(strings.TrimLeft(message, " "), "\r\n")
 
 	pm.parsed = true
 	pm.message = message
 
-	//Check for prefix
-	if k < len(tokens) && parsePrefix(tokens[k], &pm) {
-		k++
+	//Check for tags
+	if strings.HasPrefix(rest, "@") {
+		var tags string
+		tags, rest = nextToken(rest)
+		pm.tags = parseTags(tags[1:])
 	}
 
-	//Parse command (making it uppercase), ignoring empty tokens
-	for ; k < len(tokens); k++ {
-		if tokens[k] != "" {
-			pm.command = strings.ToUpper(tokens[k])
-			k++
-			break
-		}
+	//Check for prefix
+	if strings.HasPrefix(rest, ":") {
+		var prefix string
+		prefix, rest = nextToken(rest)
+		parsePrefix(prefix, &pm)
 	}
 
+	//Parse command (making it uppercase)
+	var cmd string
+	cmd, rest = nextToken(rest)
+	pm.command = strings.ToUpper(cmd)
+
 	//Check params, ignoring empty tokens.
 	//The last argument will include
 	//the ':' if present
-	for ; k < len(tokens); k++ {
-		if tokens[k] == "" {
-			continue
-		} else if tokens[k][0] == ':' {
+	for rest != "" {
+		if rest[0] == ':' {
 			//Grab the rest of the string
-			s := strings.SplitAfterN(message[1:], ":", 2)
-			if len(s) > 1 {
-				pm.params = append(pm.params, ":"+s[1])
-				pm.trailing = s[1]
-			}
+			pm.params = append(pm.params, rest)
+			pm.trailing = rest[1:]
 			return
 		}
 
-		pm.params = append(pm.params, tokens[k])
+		var param string
+		param, rest = nextToken(rest)
+		pm.params = append(pm.params, param)
 	}
 	return
 }
 
+//nextToken returns the next space delimited token, and the remainder
+//of the string with any leading spaces removed
+func nextToken(s string) (token, rest string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i+1:], " ")
+}
+
 //parses a prefix, and updates the parsedMEssage fields. Returns true if the string is a prefix
 func parsePrefix(prefix string, pm *message) bool {
 	if len(prefix) < 1 || prefix[0] != ':' {
@@ -75,4 +87,74 @@ func parsePrefix(prefix string, pm *message) bool {
 	return true
 }
 
+//parseTags parses the IRCv3 tags section of a message (without the leading '@')
+//http://ircv3.net/specs/core/message-tags-3.2.html
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = unescapeTagValue(kv[1])
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags
+}
+
+//EscapeTagValue escapes a tag value so it can be sent in a message
+func EscapeTagValue(value string) string {
+	var buf strings.Builder
+	for _, r := range value {
+		switch r {
+		case ';':
+			buf.WriteString(`\:`)
+		case ' ':
+			buf.WriteString(`\s`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i >= len(value) {
+			break //A trailing backslash is dropped
+		}
+		switch value[i] {
+		case ':':
+			buf.WriteByte(';')
+		case 's':
+			buf.WriteByte(' ')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
+
 /*  TODO: Implement a parser for the lexer. */
@@ -24,6 +24,11 @@ var parserInput = []message{
 		command: "PRIVMSG", params: []string{"#go-nuts", ":https://golang.org/pkg/time/#Time.String"}, trailing: "https://golang.org/pkg/time/#Time.String"},
 	message{message: ":somenick!~@5-6-7-8.static.bgth.bz  QUIT", prefix: ":somenick!~@5-6-7-8.static.bgth.bz",
 		nick: "somenick", user: "~", host: "5-6-7-8.static.bgth.bz", command: "QUIT"},
+	message{message: "@time=2016-04-28T12:00:00.000Z;account=Oooska :Oooska!~o@2001:db8::1 PRIVMSG #go-nuts :hi: there",
+		tags: map[string]string{"time": "2016-04-28T12:00:00.000Z", "account": "Oooska"}, prefix: ":Oooska!~o@2001:db8::1",
+		nick: "Oooska", user: "~o", host: "2001:db8::1", command: "PRIVMSG", params: []string{"#go-nuts", ":hi: there"}, trailing: "hi: there"},
+	message{message: `@draft/label=a\:b\sc;+draft/reply PRIVMSG #go-nuts ::)`,
+		tags: map[string]string{"draft/label": "a;b c", "+draft/reply": ""}, command: "PRIVMSG", params: []string{"#go-nuts", "::)"}, trailing: ":)"},
 }
 
 func TestParseString(t *testing.T) {
@@ -67,6 +72,26 @@ func TestParseString(t *testing.T) {
 		if actual.Trailing() != expected.Trailing() {
 			t.Errorf("input[%d]: Host field not parsed correctly. Expected: %s. Received: %s", j, expected.Trailing(), actual.Trailing())
 		}
+
+		if len(actual.Tags()) != len(expected.Tags()) {
+			t.Errorf("input[%d]: Unequal number of tags. Expected: %+v. Received: %+v", j, expected.Tags(), actual.Tags())
+		}
+		for k, v := range expected.Tags() {
+			if actual.Tags()[k] != v {
+				t.Errorf("input[%d]: Tag %s is not equal. Expected: %s. Received: %s", j, k, v, actual.Tags()[k])
+			}
+		}
 	}
 
 }
+
+func TestEscapeTagValue(t *testing.T) {
+	value := "a;b c\\d\r\n"
+	escaped := EscapeTagValue(value)
+	if escaped != `a\:b\sc\\d\r\n` {
+		t.Errorf("EscapeTagValue() did not escape correctly. Received: %s", escaped)
+	}
+	if unescapeTagValue(escaped) != value {
+		t.Errorf("unescapeTagValue() did not reverse EscapeTagValue(). Received: %q", unescapeTagValue(escaped))
+	}
+}
@@ -0,0 +1,230 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+//welcomeServer listens on a loopback port and sends a welcome to each connection
+func welcomeServer(t *testing.T, conf *tls.Config) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf != nil {
+		l = tls.NewListener(l, conf)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte(":server 001 nick :Welcome\r\n"))
+			c.Close()
+		}
+	}()
+	return l
+}
+
+//socks5Proxy is a minimal SOCKS5 proxy. Domain names are resolved to the
+//address in hosts, so tests don't need DNS.
+func socks5Proxy(t *testing.T, username, password string, hosts map[string]string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 262)
+				io.ReadFull(c, buf[:2])
+				io.ReadFull(c, buf[:buf[1]])
+				if username == "" {
+					c.Write([]byte{5, 0})
+				} else {
+					c.Write([]byte{5, 2})
+					io.ReadFull(c, buf[:2])
+					user := make([]byte, buf[1])
+					io.ReadFull(c, user)
+					io.ReadFull(c, buf[:1])
+					pass := make([]byte, buf[0])
+					io.ReadFull(c, pass)
+					if string(user) != username || string(pass) != password {
+						c.Write([]byte{1, 1})
+						return
+					}
+					c.Write([]byte{1, 0})
+				}
+
+				io.ReadFull(c, buf[:4])
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(c, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(c, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(c, name)
+					host = string(name)
+				}
+				io.ReadFull(c, buf[:2])
+				addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf))))
+				if a, ok := hosts[host]; ok {
+					addr = a
+				}
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				c.Write([]byte{5, 0, 0, 3, 4, 'h', 'o', 's', 't', 0, 0})
+				go io.Copy(upstream, c)
+				io.Copy(c, upstream)
+			}()
+		}
+	}()
+	return l
+}
+
+//httpProxy is a minimal HTTP CONNECT proxy
+func httpProxy(t *testing.T, auth string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil || req.Method != "CONNECT" {
+					return
+				}
+				if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				//The welcome arrives in the same read as the response
+				welcome := make([]byte, 64)
+				n, _ := upstream.Read(welcome)
+				io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"+string(welcome[:n]))
+				go io.Copy(upstream, c)
+				io.Copy(c, upstream)
+			}()
+		}
+	}()
+	return l
+}
+
+func expectWelcome(t *testing.T, name string, conn Conn, err error) {
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	defer conn.Close()
+	if msg, err := conn.Read(); err != nil || msg.Command() != "001" {
+		t.Errorf("%s: Expected 001, Received %v (%v)", name, msg, err)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	server := welcomeServer(t, nil)
+	defer server.Close()
+	hosts := map[string]string{"irc.example.onion": server.Addr().String()}
+
+	open := socks5Proxy(t, "", "", hosts)
+	defer open.Close()
+	authed := socks5Proxy(t, "user", "pass", hosts)
+	defer authed.Close()
+	web := httpProxy(t, "Basic dXNlcjpwYXNz")
+	defer web.Close()
+
+	tests := []struct {
+		name, proxy, addr string
+		err               bool
+	}{
+		{"socks5", "socks5://" + open.Addr().String(), server.Addr().String(), false},
+		{"socks5 hostname", "socks5h://" + open.Addr().String(), "irc.example.onion:6667", false},
+		{"socks5 auth", "socks5://user:pass@" + authed.Addr().String(), "irc.example.onion:6667", false},
+		{"socks5 bad auth", "socks5://user:wrong@" + authed.Addr().String(), "irc.example.onion:6667", true},
+		{"socks5 unreachable", "socks5://" + open.Addr().String(), "127.0.0.1:1", true},
+		{"http", "http://user:pass@" + web.Addr().String(), server.Addr().String(), false},
+		{"http bad auth", "http://" + web.Addr().String(), server.Addr().String(), true},
+		{"bad scheme", "ftp://" + web.Addr().String(), server.Addr().String(), true},
+	}
+	for _, test := range tests {
+		conn, err := NewConnection(test.addr, false, Proxy(test.proxy))
+		if test.err {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: Expected an error", test.name)
+			}
+			continue
+		}
+		expectWelcome(t, test.name, conn, err)
+	}
+}
+
+func TestProxyTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	server := welcomeServer(t, ts.TLS)
+	defer server.Close()
+	proxy := socks5Proxy(t, "", "", nil)
+	defer proxy.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	conf := &tls.Config{RootCAs: pool, ServerName: "example.com"}
+
+	conn, err := NewConnection(server.Addr().String(), true, Proxy("socks5://"+proxy.Addr().String()), TLSConfig(conf))
+	expectWelcome(t, "tls", conn, err)
+
+	//Without the server's certificate, verification fails
+	_, err = NewConnection(server.Addr().String(), true, Proxy("socks5://"+proxy.Addr().String()), TLSConfig(&tls.Config{}))
+	if err == nil {
+		t.Error("Expected certificate verification to fail")
+	}
+}
+
+func TestDialer(t *testing.T) {
+	server := welcomeServer(t, nil)
+	defer server.Close()
+
+	var dialed string
+	dial := func(network, addr string) (net.Conn, error) {
+		dialed = addr
+		return net.Dial(network, server.Addr().String())
+	}
+	conn, err := NewConnection("irc.example.com:6667", false, Dialer(dial))
+	expectWelcome(t, "dialer", conn, err)
+	if dialed != "irc.example.com:6667" {
+		t.Errorf("Expected dial to irc.example.com:6667, Received %q", dialed)
+	}
+}
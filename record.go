@@ -0,0 +1,184 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Sessions are recorded one line per message:
+
+	2017-01-02T15:04:05.123456789Z < :server 001 nick :Welcome
+	2017-01-02T15:04:05.223456789Z > JOIN #channel
+
+'<' lines were read from the server and '>' lines were written to it.
+*/
+const (
+	recordIncoming = "<"
+	recordOutgoing = ">"
+)
+
+//recorder is an io.ReadWriteCloser that logs everything read and
+//written through it
+type recorder struct {
+	rwc   io.ReadWriteCloser
+	w     io.Writer
+	mLock *sync.Mutex
+	in    []byte //Partial line read
+	out   []byte //Partial line written
+}
+
+//NewRecorder wraps rwc, writing every line read from or written to it
+//to w with a timestamp. The result can be passed to NewConnectionWrapper,
+//and the recording played back with NewReplayer.
+func NewRecorder(rwc io.ReadWriteCloser, w io.Writer) io.ReadWriteCloser {
+	return &recorder{rwc: rwc, w: w, mLock: new(sync.Mutex)}
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.rwc.Read(p)
+	r.mLock.Lock()
+	r.in = r.record(recordIncoming, append(r.in, p[:n]...))
+	r.mLock.Unlock()
+	return n, err
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	n, err := r.rwc.Write(p)
+	r.mLock.Lock()
+	r.out = r.record(recordOutgoing, append(r.out, p[:n]...))
+	r.mLock.Unlock()
+	return n, err
+}
+
+//Close closes the underlying connection. The writer is left open.
+func (r *recorder) Close() error {
+	return r.rwc.Close()
+}
+
+//record logs each complete line in buf, returning what remains
+func (r *recorder) record(dir string, buf []byte) []byte {
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return buf
+		}
+		line := bytes.TrimRight(buf[:i], "\r")
+		fmt.Fprintf(r.w, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339Nano), dir, line)
+		buf = buf[i+1:]
+	}
+}
+
+//Replayer is an io.ReadWriteCloser that plays back a session recorded
+//by NewRecorder. Reads return the lines that were read from the server,
+//and anything written is kept, and can be retrieved with Written.
+type Replayer struct {
+	scanner  *bufio.Scanner
+	realTime bool
+	buf      []byte
+	last     time.Time
+
+	mLock   *sync.Mutex
+	written bytes.Buffer
+	closed  chan struct{}
+	once    *sync.Once
+}
+
+//NewReplayer returns a Replayer reading the recording from r. If realTime
+//is true, lines are returned with the same delays as when recorded,
+//otherwise they are returned as fast as they are read.
+func NewReplayer(r io.Reader, realTime bool) *Replayer {
+	return &Replayer{
+		scanner:  bufio.NewScanner(r),
+		realTime: realTime,
+		mLock:    new(sync.Mutex),
+		closed:   make(chan struct{}),
+		once:     new(sync.Once),
+	}
+}
+
+//Read returns the next recorded incoming lines. io.EOF is returned
+//at the end of the recording, or once the Replayer is closed.
+func (r *Replayer) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		select {
+		case <-r.closed:
+			return 0, io.EOF
+		default:
+		}
+
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		ts, dir, line, err := parseRecord(r.scanner.Text())
+		if err != nil {
+			return 0, err
+		}
+		if dir != recordIncoming {
+			continue
+		}
+		if r.realTime && !r.last.IsZero() && ts.After(r.last) {
+			select {
+			case <-time.After(ts.Sub(r.last)):
+			case <-r.closed:
+				return 0, io.EOF
+			}
+		}
+		r.last = ts
+		r.buf = []byte(line + "\r\n")
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+//Write keeps p so it can be retrieved with Written
+func (r *Replayer) Write(p []byte) (int, error) {
+	r.mLock.Lock()
+	defer r.mLock.Unlock()
+	return r.written.Write(p)
+}
+
+//Close stops the replay. Subsequent reads return io.EOF.
+func (r *Replayer) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+//Written returns the lines written to the Replayer, without line endings
+func (r *Replayer) Written() []string {
+	r.mLock.Lock()
+	defer r.mLock.Unlock()
+	var lines []string
+	for _, line := range strings.Split(r.written.String(), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+//parseRecord parses a line of a recording
+func parseRecord(record string) (ts time.Time, dir, line string, err error) {
+	fields := strings.SplitN(record, " ", 3)
+	if len(fields) < 2 {
+		return ts, "", "", fmt.Errorf("irc: malformed recording: %q", record)
+	}
+	ts, err = time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return ts, "", "", fmt.Errorf("irc: malformed recording: %q", record)
+	}
+	if len(fields) == 3 {
+		line = fields[2]
+	}
+	return ts, fields[1], line, nil
+}
@@ -0,0 +1,85 @@
+package irc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var recording bytes.Buffer
+	_, b := newBufferConn(
+		":server 001 me :Welcome",
+		":me!u@h JOIN #chan",
+		":server 353 me = #chan :me other",
+		":server 366 me #chan :End of /NAMES list.",
+		":other!u@h PRIVMSG #chan :hello",
+	)
+	conn := NewConnectionWrapper(NewRecorder(b, &recording))
+	conn.Write(JoinMessage("#chan"))
+	readAll(conn)
+
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 recorded lines, Received: %q", lines)
+	}
+	if _, dir, line, err := parseRecord(lines[0]); err != nil || dir != recordOutgoing || line != "JOIN #chan" {
+		t.Errorf("Expected outgoing JOIN to be recorded first, Received: %q (%v)", lines[0], err)
+	}
+
+	replayer := NewReplayer(&recording, false)
+	replay := NewConnectionWrapper(replayer)
+	convos := RegisterConversationsHandler(replay)
+	replay.Write(PrivMessage("#chan", "hi"))
+	readAll(replay)
+
+	if messages := convos.Messages("#chan"); len(messages) != 2 || messages[1] != ":other!u@h PRIVMSG #chan :hello" {
+		t.Errorf("Expected replayed message to be logged, Received: %+v", messages)
+	}
+	if written := replayer.Written(); len(written) != 1 || written[0] != "PRIVMSG #chan :hi" {
+		t.Errorf("Expected writes to be kept, Received: %+v", written)
+	}
+}
+
+func TestReplayRealTime(t *testing.T) {
+	recording := "2017-01-02T15:04:05Z < :server 001 me :Welcome\n" +
+		"2017-01-02T15:04:05.01Z > JOIN #chan\n" +
+		"2017-01-02T15:04:05.05Z < :me!u@h JOIN #chan\n"
+	conn := NewConnectionWrapper(NewReplayer(strings.NewReader(recording), true))
+
+	start := time.Now()
+	readAll(conn)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected replay to take at least 50ms, took %s", elapsed)
+	}
+}
+
+func TestReplayClose(t *testing.T) {
+	recording := "2017-01-02T15:04:05Z < :server 001 me :Welcome\n" +
+		"2017-01-02T16:04:05Z < :me!u@h JOIN #chan\n"
+	replayer := NewReplayer(strings.NewReader(recording), true)
+	conn := NewConnectionWrapper(replayer)
+	if _, err := conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		readAll(conn)
+		close(done)
+	}()
+	replayer.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected Close to stop the replay")
+	}
+}
+
+func TestReplayMalformed(t *testing.T) {
+	conn := NewConnectionWrapper(NewReplayer(strings.NewReader("not a recording\n"), false))
+	if _, err := conn.Read(); err == nil {
+		t.Error("Expected an error for a malformed recording")
+	}
+}
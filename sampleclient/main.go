@@ -17,12 +17,13 @@ var (
 	ssl      = flag.Bool("ssl", false, "Use SSL")
 	nick     = flag.String("nick", "go_test_client", "User nick")
 	username = flag.String("username", "go_name", "User name")
+	channels = flag.String("channels", "#go_test", "Comma separated channels to join")
 )
 
 //A barebones IRC 'client' in the loosest sense of the word.
 //Takes input from console. If command starts with a '/', everything after is sent as a raw IRC command.
 //Otherwise the first argument is considered the channel/username, and the rest of the line is the message to send
-// as a privmsg.
+//as a privmsg.
 func main() {
 	flag.Parse()
 
@@ -31,7 +32,14 @@ func main() {
 	fmt.Printf("Connecting to %s . . . \n", *address)
 
 	//LogClientHandler will handle printing out to stdio unless we change the default logger
-	client, err := irc.NewClient(*address, *ssl, irc.LogHandler)
+	//AutoJoinHandler joins the channels once registered
+	autojoin := irc.AutoJoinConfig{Channels: make(map[string]string), RejoinOnKick: true}
+	for _, ch := range strings.Split(*channels, ",") {
+		if ch != "" {
+			autojoin.Channels[ch] = ""
+		}
+	}
+	client, err := irc.NewClient(*address, *ssl, irc.LogHandler, irc.AutoJoinHandler(autojoin))
 
 	if err != nil {
 		log.Fatalf("Error: %s", err.Error())
@@ -40,7 +48,6 @@ func main() {
 
 	client.Send(irc.UserMessage(*username, "host", "domain", "realname"))
 	client.Send(irc.NickMessage(*nick))
-	client.Send(irc.JoinMessage("#go_test"))
 
 	//Listen for input.
 	go readInput(client)
@@ -0,0 +1,254 @@
+package irc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rplBounce = "010"
+
+	defaultPort          = "6667"
+	defaultSSLPort       = "6697"
+	defaultFallbackDelay = 300 * time.Millisecond
+)
+
+//ErrNoServers is returned when dialing an empty ServerList
+var ErrNoServers = errors.New("irc: no servers to connect to")
+
+//Server is one of the servers of a network
+type Server struct {
+	//Address is host:port. If the port is omitted, 6697 is used
+	//with SSL, 6667 without.
+	Address   string
+	SSL       bool
+	TLSConfig *tls.Config //Overrides the TLSConfig option for this server
+}
+
+//addr returns the address with the port filled in
+func (s Server) addr() string {
+	if _, _, err := net.SplitHostPort(s.Address); err == nil {
+		return s.Address
+	}
+	port := defaultPort
+	if s.SSL {
+		port = defaultSSLPort
+	}
+	return net.JoinHostPort(strings.Trim(s.Address, "[]"), port)
+}
+
+func (s Server) String() string {
+	if s.SSL {
+		return s.addr() + " (SSL)"
+	}
+	return s.addr()
+}
+
+//ServerList connects to the first available server of a network.
+//Each connection is made to the servers in order, except that a server
+//the last one redirected us to with RPL_BOUNCE (010) is tried first.
+//
+//Host names are resolved and their addresses dialed as described in
+//RFC 8305 (happy eyeballs), unless the Dialer or Proxy option is used.
+type ServerList struct {
+	Servers []Server
+	Options []ConnOption //Used for every connection
+
+	PreferIPv4    bool          //Try IPv4 addresses before IPv6 ones
+	FallbackDelay time.Duration //Delay before trying the next address. Defaults to 300ms.
+
+	//LookupIP resolves host names. Defaults to net.LookupIP
+	LookupIP func(host string) ([]net.IP, error)
+
+	mLock   sync.Mutex
+	current Server
+	bounce  *Server
+}
+
+//NewClient connects to a server from the list, and returns a Client
+//using it. RPL_BOUNCE redirects are recorded for the next connection.
+func (l *ServerList) NewClient(handlers ...ClientHandler) (Client, error) {
+	conn, err := l.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWrapper(conn, append([]ClientHandler{l.Handler()}, handlers...)...), nil
+}
+
+//Dial connects to the first server that accepts the connection. It
+//returns the last error if none do.
+func (l *ServerList) Dial() (Conn, error) {
+	l.mLock.Lock()
+	servers := append([]Server(nil), l.Servers...)
+	if l.bounce != nil {
+		servers = append([]Server{*l.bounce}, servers...)
+		l.bounce = nil
+	}
+	l.mLock.Unlock()
+
+	err := ErrNoServers
+	for _, s := range servers {
+		var conn Conn
+		conn, err = l.dial(s)
+		if err == nil {
+			l.mLock.Lock()
+			l.current = s
+			l.mLock.Unlock()
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (l *ServerList) dial(s Server) (Conn, error) {
+	opts := append([]ConnOption(nil), l.Options...)
+	if s.TLSConfig != nil {
+		opts = append(opts, TLSConfig(s.TLSConfig))
+	}
+	if newConn(opts...).dial == nil {
+		opts = append(opts, Dialer(l.dialAddrs))
+	}
+	return NewConnection(s.addr(), s.SSL, opts...)
+}
+
+//Current returns the server of the last successful connection
+func (l *ServerList) Current() Server {
+	l.mLock.Lock()
+	defer l.mLock.Unlock()
+	return l.current
+}
+
+//Handler returns a ClientHandler that records RPL_BOUNCE redirects, so
+//the next connection is made to the server we were redirected to.
+func (l *ServerList) Handler() ClientHandler {
+	return func(client Client) {
+		client.AddHandler(Incoming, l.handleBounce, rplBounce)
+	}
+}
+
+//handleBounce handles ":server 010 nick host port :info". A port starting
+//with "+" is an SSL port. Otherwise, the current server's SSL setting is used.
+func (l *ServerList) handleBounce(msg Message) {
+	params := msg.Params()
+	if len(params) < 3 || params[1] == "" {
+		return
+	}
+	l.mLock.Lock()
+	defer l.mLock.Unlock()
+	s := Server{SSL: l.current.SSL, TLSConfig: l.current.TLSConfig}
+	port := params[2]
+	if strings.HasPrefix(port, "+") {
+		port = port[1:]
+		s.SSL = true
+	}
+	s.Address = net.JoinHostPort(params[1], port)
+	l.bounce = &s
+}
+
+//dialAddrs resolves the host, and races connections to its addresses,
+//starting a new attempt every FallbackDelay, or as soon as one fails.
+func (l *ServerList) dialAddrs(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		lookup := l.LookupIP
+		if lookup == nil {
+			lookup = net.LookupIP
+		}
+		if ips, err = lookup(host); err != nil {
+			return nil, err
+		}
+	}
+	var addrs []string
+	for _, ip := range sortAddrs(ips, l.PreferIPv4) {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host}
+	}
+
+	delay := l.FallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			c, err := net.Dial(network, addr)
+			results <- result{c, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				//Close the connections that lose the race
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.c != nil {
+							r.c.Close()
+						}
+					}
+				}(pending)
+				return r.c, nil
+			}
+			err = r.err
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, err
+}
+
+//sortAddrs interleaves IPv6 and IPv4 addresses, starting with the
+//preferred family
+func sortAddrs(ips []net.IP, preferIPv4 bool) []net.IP {
+	var first, second []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == preferIPv4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
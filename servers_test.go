@@ -0,0 +1,128 @@
+package irc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSortAddrs(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.3")}
+	tests := []struct {
+		preferIPv4 bool
+		expected   []string
+	}{
+		{false, []string{"2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"}},
+		{true, []string{"192.0.2.1", "2001:db8::1", "192.0.2.2", "192.0.2.3"}},
+	}
+	for _, test := range tests {
+		var sorted []string
+		for _, ip := range sortAddrs(ips, test.preferIPv4) {
+			sorted = append(sorted, ip.String())
+		}
+		if !reflect.DeepEqual(sorted, test.expected) {
+			t.Errorf("preferIPv4 %v: Expected %v, Received %v", test.preferIPv4, test.expected, sorted)
+		}
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	tests := []struct {
+		server   Server
+		expected string
+	}{
+		{Server{Address: "irc.example.com"}, "irc.example.com:6667"},
+		{Server{Address: "irc.example.com", SSL: true}, "irc.example.com:6697"},
+		{Server{Address: "irc.example.com:7000", SSL: true}, "irc.example.com:7000"},
+		{Server{Address: "[2001:db8::1]"}, "[2001:db8::1]:6667"},
+	}
+	for _, test := range tests {
+		if addr := test.server.addr(); addr != test.expected {
+			t.Errorf("Expected %s, Received %s", test.expected, addr)
+		}
+	}
+}
+
+func TestServerListFailover(t *testing.T) {
+	server := welcomeServer(t, nil)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+
+	//A closed port, then a host name whose first address refuses
+	//connections before the one that works
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	l := &ServerList{
+		Servers: []Server{
+			{Address: closed.Addr().String()},
+			{Address: "irc.example.com:" + port},
+		},
+		PreferIPv4: true,
+		LookupIP: func(host string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}, nil
+		},
+	}
+	conn, err := l.Dial()
+	expectWelcome(t, "failover", conn, err)
+	if l.Current() != l.Servers[1] {
+		t.Errorf("Expected current server %v, Received %v", l.Servers[1], l.Current())
+	}
+
+	if _, err := (&ServerList{}).Dial(); err != ErrNoServers {
+		t.Errorf("Expected ErrNoServers, Received %v", err)
+	}
+	l.Servers = l.Servers[:1]
+	if _, err := l.Dial(); err == nil {
+		t.Error("Expected an error when no server accepts the connection")
+	}
+}
+
+func TestServerListTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	server := welcomeServer(t, ts.TLS)
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	l := &ServerList{
+		Servers: []Server{{Address: server.Addr().String(), SSL: true, TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"}}},
+		Options: []ConnOption{TLSConfig(&tls.Config{})},
+	}
+	conn, err := l.Dial()
+	expectWelcome(t, "tls", conn, err)
+}
+
+func TestServerListBounce(t *testing.T) {
+	server := welcomeServer(t, nil)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Addr().String())
+
+	l := &ServerList{Servers: []Server{{Address: "127.0.0.1:1"}}}
+	line := ":irc.example.com 010 nick " + host + " " + port + " :Server full, try this one\r\n"
+	client := NewClientWrapper(NewConnectionWrapper(&bufferConn{r: strings.NewReader(line)}), l.Handler())
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Dial()
+	expectWelcome(t, "bounce", conn, err)
+	if l.Current().Address != server.Addr().String() {
+		t.Errorf("Expected to be bounced to %s, Received %v", server.Addr(), l.Current())
+	}
+
+	//The redirect is only used once
+	if _, err := l.Dial(); err == nil {
+		t.Error("Expected the bounce to be forgotten")
+	}
+
+	l.current = Server{}
+	l.handleBounce(NewMessage(":irc.example.com 010 nick irc2.example.com +6697 :Try this one"))
+	if l.bounce == nil || *l.bounce != (Server{Address: "irc2.example.com:6697", SSL: true}) {
+		t.Errorf("Expected SSL bounce, Received %v", l.bounce)
+	}
+}
@@ -0,0 +1,378 @@
+/*
+Package services integrates a client with network services: NickServ
+for accounts and nick ownership, and ChanServ for channel access.
+
+It understands the notices sent by Atheme, Anope and Ergo. When SASL
+was used to log in during registration, NickServ is not used.
+
+	s := services.Register(client, services.Config{
+		Nick:     "mybot",
+		Password: "secret",
+		AutoOp:   true,
+		Channels: []string{"#registered-only"},
+	})
+*/
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/formatting"
+)
+
+//Errors returned by Wait
+var (
+	ErrIdentifyFailed = errors.New("services: identification failed")
+	ErrTimeout        = errors.New("services: timed out waiting for identification")
+)
+
+const (
+	rplWelcome       = "001"
+	rplEndOfNames    = "366"
+	errNicknameInUse = "433"
+	rplLoggedIn      = "900"
+	rplLoggedOut     = "901"
+	rplSaslSuccess   = "903"
+	errSaslFail      = "904"
+
+	defaultIdentifyTimeout = 30 * time.Second
+)
+
+//Notices sent by services, lowercased with formatting removed
+var (
+	identifiedNotices = []string{
+		"you are now identified for", //Atheme
+		"password accepted",          //Anope
+		"you are now recognized",     //Anope
+		"you're now logged in as",    //Ergo
+		"you are already logged in",  //Atheme, Ergo
+		"you are already identified", //Anope
+	}
+	failedNotices = []string{
+		"invalid password",       //Atheme, Ergo
+		"password incorrect",     //Anope
+		"authentication failed",  //Ergo
+		"is not registered",      //Atheme
+		"isn't registered",       //Anope
+		"account does not exist", //Ergo
+	}
+	registeredNotices = []string{
+		"this nickname is registered", //Atheme, Anope
+		"nickname is registered to",   //Atheme
+		"this nick is owned by",       //Anope
+		"nickname is reserved",        //Ergo
+	}
+	ghostedNotices = []string{
+		"has been ghosted",                     //Atheme
+		"ghost with your nick has been killed", //Anope
+		"has been regained",                    //Atheme
+		"you have regained",                    //Atheme
+		"ghosted",                              //Ergo
+	}
+	unknownCommandNotices = []string{
+		"invalid command", //Atheme
+		"unknown command", //Anope, Ergo
+	}
+)
+
+//Config configures how to identify and which channels to join
+type Config struct {
+	Nick     string //Nick to use, regained with REGAIN or GHOST if taken. Defaults to the client's nick.
+	Account  string //Account to identify to. Defaults to Nick.
+	Password string
+	NickServ string //Defaults to NickServ
+	ChanServ string //Defaults to ChanServ
+
+	//AutoOp asks ChanServ to op us when we join a channel without ops
+	AutoOp bool
+	//Channels to join once identified, for channels requiring an account
+	Channels []string
+	//IdentifyTimeout is how long to wait for identification before
+	//joining Channels anyway. Defaults to 30 seconds.
+	IdentifyTimeout time.Duration
+}
+
+//Services keeps track of whether the client is identified
+type Services struct {
+	client irc.Client
+	conf   Config
+
+	mLock       *sync.Mutex
+	welcomed    bool
+	identified  bool
+	failed      bool
+	identifying bool
+	regaining   bool //REGAIN sent, falling back to GHOST if unsupported
+	ghosting    bool //GHOST sent, changing nick once the ghost is gone
+	joined      bool
+	done        chan struct{} //Closed once identification succeeds or fails
+	opWaiting   []string      //Channels to request ops in once identified
+	timer       *time.Timer
+}
+
+//Register attaches services handling to the client. It should be called
+//before the client registers with the server.
+func Register(client irc.Client, conf Config) *Services {
+	if conf.NickServ == "" {
+		conf.NickServ = "NickServ"
+	}
+	if conf.ChanServ == "" {
+		conf.ChanServ = "ChanServ"
+	}
+	if conf.IdentifyTimeout == 0 {
+		conf.IdentifyTimeout = defaultIdentifyTimeout
+	}
+	s := &Services{client: client, conf: conf, mLock: new(sync.Mutex), done: make(chan struct{})}
+	client.AddHandler(irc.Incoming, s.handle, rplWelcome, rplEndOfNames, errNicknameInUse,
+		rplLoggedIn, rplLoggedOut, rplSaslSuccess, errSaslFail, "NOTICE", "QUIT", "NICK")
+	return s
+}
+
+//Identified returns true if logged in to our account
+func (s *Services) Identified() bool {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	return s.identified
+}
+
+//Wait blocks until identification succeeds, fails or times out. It must
+//not be called from a message handler.
+func (s *Services) Wait(timeout time.Duration) error {
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+	if !s.Identified() {
+		return ErrIdentifyFailed
+	}
+	return nil
+}
+
+//Identify sends our password to NickServ
+func (s *Services) Identify() error {
+	s.mLock.Lock()
+	s.identifying = true
+	s.mLock.Unlock()
+	_, err := s.client.PrivMsg(s.conf.NickServ, "IDENTIFY "+s.account()+" "+s.conf.Password)
+	return err
+}
+
+func (s *Services) handle(msg irc.Message) {
+	params := msg.Params()
+	switch msg.Command() {
+	case rplLoggedIn, rplSaslSuccess:
+		s.setIdentified(true)
+	case rplLoggedOut:
+		s.mLock.Lock()
+		s.identified = false
+		s.mLock.Unlock()
+	case errSaslFail:
+		//Fall back to NickServ once registered
+	case errNicknameInUse:
+		//Choose another nick to finish registering, and regain ours later
+		if len(params) > 1 && !s.registered() && strings.EqualFold(params[1], s.nick()) {
+			s.client.Send(irc.NickMessage(s.nick() + "_"))
+		}
+	case rplWelcome:
+		s.mLock.Lock()
+		s.welcomed = true
+		s.mLock.Unlock()
+		if s.Identified() {
+			//Logged in with SASL
+			s.finish(true)
+			s.regain()
+			s.joinChannels()
+			return
+		}
+		if s.conf.Password == "" {
+			s.finish(false)
+			s.joinChannels()
+			return
+		}
+		s.Identify()
+		s.mLock.Lock()
+		s.timer = time.AfterFunc(s.conf.IdentifyTimeout, s.joinChannels)
+		s.mLock.Unlock()
+	case "NOTICE":
+		if strings.EqualFold(msg.Nick(), s.conf.NickServ) {
+			s.handleNickServ(formatting.Strip(msg.Trailing()))
+		}
+	case "QUIT", "NICK":
+		//Our nick is free once the ghost quits or changes nick
+		if strings.EqualFold(msg.Nick(), s.nick()) {
+			s.reclaim()
+		}
+	case rplEndOfNames:
+		//:server 366 me #channel :End of /NAMES list.
+		if s.conf.AutoOp && len(params) > 1 {
+			s.requestOp(params[1])
+		}
+	}
+}
+
+func (s *Services) handleNickServ(text string) {
+	text = strings.ToLower(text)
+	switch {
+	case containsAny(text, identifiedNotices):
+		s.setIdentified(true)
+	case containsAny(text, failedNotices):
+		s.mLock.Lock()
+		identifying := s.identifying
+		s.mLock.Unlock()
+		if identifying {
+			s.finish(false)
+			s.joinChannels()
+		}
+	case containsAny(text, ghostedNotices):
+		s.reclaim()
+	case containsAny(text, unknownCommandNotices):
+		s.ghost()
+	case containsAny(text, registeredNotices):
+		s.mLock.Lock()
+		identifying := s.identifying || s.identified
+		s.mLock.Unlock()
+		if !identifying && s.conf.Password != "" && s.registered() {
+			s.Identify()
+		}
+	}
+}
+
+//setIdentified records the result of identifying. Once registered, we
+//regain our nick, join channels and request ops.
+func (s *Services) setIdentified(ok bool) {
+	s.mLock.Lock()
+	already := s.identified
+	s.identified = ok
+	s.mLock.Unlock()
+	if already || !s.registered() {
+		return
+	}
+	s.finish(ok)
+	s.regain()
+	s.joinChannels()
+
+	s.mLock.Lock()
+	waiting := s.opWaiting
+	s.opWaiting = nil
+	s.mLock.Unlock()
+	for _, ch := range waiting {
+		s.requestOp(ch)
+	}
+}
+
+//finish marks identification as complete
+func (s *Services) finish(identified bool) {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	s.identifying = false
+	s.failed = !identified && s.conf.Password != ""
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+//regain asks NickServ to disconnect whoever is using our nick and give
+//it to us. If REGAIN isn't supported, GHOST is used instead.
+func (s *Services) regain() {
+	if s.conf.Nick == "" || strings.EqualFold(s.client.Nick(), s.conf.Nick) {
+		return
+	}
+	s.mLock.Lock()
+	s.regaining = true
+	s.mLock.Unlock()
+	s.client.PrivMsg(s.conf.NickServ, "REGAIN "+s.conf.Nick)
+}
+
+//ghost falls back to GHOST when NickServ doesn't know REGAIN
+func (s *Services) ghost() {
+	s.mLock.Lock()
+	regaining := s.regaining
+	s.regaining = false
+	s.ghosting = regaining
+	s.mLock.Unlock()
+	if regaining {
+		s.client.PrivMsg(s.conf.NickServ, "GHOST "+s.conf.Nick)
+	}
+}
+
+//reclaim changes to our nick once the ghost is gone. After REGAIN,
+//services change our nick themselves.
+func (s *Services) reclaim() {
+	s.mLock.Lock()
+	ghosting := s.ghosting
+	s.ghosting = false
+	s.regaining = false
+	s.mLock.Unlock()
+	if ghosting && !strings.EqualFold(s.client.Nick(), s.nick()) {
+		s.client.Send(irc.NickMessage(s.nick()))
+	}
+}
+
+//joinChannels joins the configured channels once
+func (s *Services) joinChannels() {
+	s.mLock.Lock()
+	joined := s.joined
+	s.joined = true
+	s.mLock.Unlock()
+	if !joined && len(s.conf.Channels) > 0 {
+		s.client.Send(irc.JoinMessage(strings.Join(s.conf.Channels, ",")))
+	}
+}
+
+//requestOp asks ChanServ for ops if we don't have them. Requests made
+//before identifying are sent once identified.
+func (s *Services) requestOp(channel string) {
+	if modes, err := s.client.UserModes(channel, s.client.Nick()); err != nil || strings.ContainsAny(modes, "qao") {
+		return
+	}
+	s.mLock.Lock()
+	if !s.identified {
+		if !s.failed {
+			s.opWaiting = append(s.opWaiting, channel)
+		}
+		s.mLock.Unlock()
+		return
+	}
+	s.mLock.Unlock()
+	s.client.PrivMsg(s.conf.ChanServ, "OP "+channel)
+}
+
+func (s *Services) nick() string {
+	if s.conf.Nick != "" {
+		return s.conf.Nick
+	}
+	return s.client.Nick()
+}
+
+func (s *Services) account() string {
+	if s.conf.Account != "" {
+		return s.conf.Account
+	}
+	return s.nick()
+}
+
+//registered returns true once the server has sent RPL_WELCOME
+func (s *Services) registered() bool {
+	s.mLock.Lock()
+	defer s.mLock.Unlock()
+	return s.welcomed
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/irctest"
+)
+
+//run feeds the lines to a client using services, and returns what the
+//client sent after registering as nick
+func run(conf Config, nick string, lines ...string) (*Services, []string) {
+	script := irctest.NewScript(lines...)
+	client := irc.NewClientWrapper(irc.NewConnectionWrapper(script))
+	s := Register(client, conf)
+	client.Send(irc.NickMessage(nick))
+	for {
+		if _, err := client.Read(); err != nil {
+			break
+		}
+	}
+	return s, script.Written()[1:]
+}
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		name   string
+		notice string
+	}{
+		{"Atheme", "You are now identified for \x02bot\x02."},
+		{"Anope", "Password accepted - you are now recognized."},
+		{"Ergo", "You're now logged in as bot"},
+	}
+	for _, test := range tests {
+		s, sent := run(Config{Password: "secret", Channels: []string{"#a", "#b"}}, "bot",
+			":server 001 bot :Welcome",
+			":NickServ!NickServ@services. NOTICE bot :This nickname is registered. Please choose a different nickname, or identify via /msg NickServ identify <password>.",
+			":NickServ!NickServ@services. NOTICE bot :"+test.notice,
+		)
+		expected := []string{"PRIVMSG NickServ :IDENTIFY bot secret", "JOIN #a,#b"}
+		if !reflect.DeepEqual(sent, expected) {
+			t.Errorf("%s: Expected %q, Received %q", test.name, expected, sent)
+		}
+		if err := s.Wait(time.Second); err != nil {
+			t.Errorf("%s: Expected to be identified, Received %v", test.name, err)
+		}
+	}
+}
+
+func TestIdentifyFailed(t *testing.T) {
+	s, sent := run(Config{Password: "wrong", Channels: []string{"#a"}, AutoOp: true}, "bot",
+		":server 001 bot :Welcome",
+		":NickServ!NickServ@services. NOTICE bot :Invalid password for \x02bot\x02.",
+	)
+	expected := []string{"PRIVMSG NickServ :IDENTIFY bot wrong", "JOIN #a"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+	if err := s.Wait(time.Second); err != ErrIdentifyFailed {
+		t.Errorf("Expected ErrIdentifyFailed, Received %v", err)
+	}
+}
+
+func TestSASL(t *testing.T) {
+	s, sent := run(Config{Password: "secret", Channels: []string{"#a"}}, "bot",
+		":server 900 bot bot!b@h bot :You are now logged in as bot",
+		":server 903 bot :SASL authentication successful",
+		":server 001 bot :Welcome",
+	)
+	expected := []string{"JOIN #a"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected NickServ not to be used, Received %q", sent)
+	}
+	if !s.Identified() {
+		t.Error("Expected SASL login to count as identified")
+	}
+}
+
+func TestNoPassword(t *testing.T) {
+	_, sent := run(Config{Channels: []string{"#a"}}, "bot", ":server 001 bot :Welcome")
+	expected := []string{"JOIN #a"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
+
+func TestRegain(t *testing.T) {
+	_, sent := run(Config{Nick: "bot", Password: "secret"}, "bot",
+		":server 433 * bot :Nickname is already in use",
+		":server 001 bot_ :Welcome",
+		":NickServ!NickServ@services. NOTICE bot_ :You are now identified for \x02bot\x02.",
+		":bot!old@host QUIT :Killed (NickServ (REGAIN command used by bot_))",
+		":bot_!new@host NICK bot",
+		":NickServ!NickServ@services. NOTICE bot :You have regained \x02bot\x02.",
+	)
+	expected := []string{
+		"NICK bot_",
+		"PRIVMSG NickServ :IDENTIFY bot secret",
+		"PRIVMSG NickServ :REGAIN bot",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
+
+func TestGhost(t *testing.T) {
+	_, sent := run(Config{Nick: "bot", Password: "secret"}, "bot",
+		":server 433 * bot :Nickname is already in use",
+		":server 001 bot_ :Welcome",
+		":NickServ!NickServ@services. NOTICE bot_ :You are now identified for \x02bot\x02.",
+		":NickServ!NickServ@services. NOTICE bot_ :Unknown command \x02REGAIN\x02. \"/msg NickServ HELP\" for help.",
+		":NickServ!NickServ@services. NOTICE bot_ :\x02bot\x02 has been ghosted.",
+		":bot!old@host QUIT :Killed (NickServ (GHOST command used by bot_))",
+	)
+	expected := []string{
+		"NICK bot_",
+		"PRIVMSG NickServ :IDENTIFY bot secret",
+		"PRIVMSG NickServ :REGAIN bot",
+		"PRIVMSG NickServ :GHOST bot",
+		"NICK bot",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
+
+func TestAutoOp(t *testing.T) {
+	_, sent := run(Config{Password: "secret", AutoOp: true}, "bot",
+		":server 001 bot :Welcome",
+		"JOIN #a",
+		":server 353 bot = #a :bot other",
+		":server 366 bot #a :End of /NAMES list.",
+		"JOIN #b",
+		":server 353 bot = #b :@bot other",
+		":server 366 bot #b :End of /NAMES list.",
+		":NickServ!NickServ@services. NOTICE bot :Password accepted - you are now recognized.",
+		"JOIN #c",
+		":server 353 bot = #c :bot",
+		":server 366 bot #c :End of /NAMES list.",
+	)
+	expected := []string{
+		"PRIVMSG NickServ :IDENTIFY bot secret",
+		"PRIVMSG ChanServ :OP #a",
+		"PRIVMSG ChanServ :OP #c",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
+
+func TestAutoOpJoinedChannels(t *testing.T) {
+	_, sent := run(Config{Password: "secret", Channels: []string{"#a", "#b"}, AutoOp: true}, "bot",
+		":server 001 bot :Welcome",
+		":NickServ!NickServ@services. NOTICE bot :Password accepted - you are now recognized.",
+		":bot!b@h JOIN #a",
+		":server 353 bot = #a :bot other",
+		":server 366 bot #a :End of /NAMES list.",
+		":bot!b@h JOIN #b",
+		":server 353 bot = #b :@bot",
+		":server 366 bot #b :End of /NAMES list.",
+	)
+	expected := []string{
+		"PRIVMSG NickServ :IDENTIFY bot secret",
+		"JOIN #a,#b",
+		"PRIVMSG ChanServ :OP #a",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, Received %q", expected, sent)
+	}
+}
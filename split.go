@@ -0,0 +1,222 @@
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	//MaxLineLength is the maximum length of a line sent to or from a server,
+	//including the trailing CRLF. Tags are not included in this limit.
+	MaxLineLength = 512
+
+	//Length of the hostmask assumed when the server hasn't told us
+	//ours: nick(30) ! user(10) @ host(63)
+	defaultHostmaskLength = 30 + 1 + 10 + 1 + 63
+
+	capMultiline       = "draft/multiline"
+	tagMultilineConcat = "draft/multiline-concat"
+
+	ctrlColor    = '\x03'
+	ctrlHexColor = '\x04'
+)
+
+var batchID uint64
+
+//SplitText breaks text into chunks of at most max bytes. Text is split
+//on spaces where possible, and never in the middle of a UTF-8 sequence or a
+//formatting code. Text shorter than max, or any text if max isn't
+//positive, is returned as the only element.
+func SplitText(text string, max int) []string {
+	return splitText(text, max, false)
+}
+
+//splitText is SplitText. If keepSpaces is true the spaces split at are
+//kept, at the end of a chunk or the start of the next, so the chunks can
+//be concatenated back into text.
+func splitText(text string, max int, keepSpaces bool) []string {
+	if max <= 0 {
+		return []string{text}
+	}
+	var chunks []string
+	for len(text) > max {
+		cut, space := splitPoint(text, max)
+		switch {
+		case space > 0 && !keepSpaces:
+			chunks = append(chunks, text[:space])
+			text = text[space+1:]
+		case space > 0 && space < max:
+			chunks = append(chunks, text[:space+1])
+			text = text[space+1:]
+		case space > 0:
+			chunks = append(chunks, text[:space])
+			text = text[space:]
+		default:
+			chunks = append(chunks, text[:cut])
+			text = text[cut:]
+		}
+	}
+	return append(chunks, text)
+}
+
+//splitPoint returns the last index <= max that text can be cut at, and
+//the index of the last space before that point (or -1)
+func splitPoint(text string, max int) (cut, space int) {
+	space = -1
+	for i := 0; i <= max && i < len(text); {
+		cut = i
+		if text[i] == ' ' && i > 0 {
+			space = i
+		}
+		i += codeLength(text[i:])
+	}
+
+	if cut == 0 {
+		//A single code or rune longer than max. Cut at the last rune boundary
+		for cut = max; cut > 0 && !utf8.RuneStart(text[cut]); cut-- {
+		}
+		if cut == 0 {
+			cut = max
+		}
+	}
+	return
+}
+
+//codeLength returns the length of the rune or formatting code at the start of s
+func codeLength(s string) int {
+	switch s[0] {
+	case ctrlColor:
+		//\x03[fg[,bg]] where fg and bg are 1 or 2 digits
+		return 1 + colorLength(s[1:], 2, isNumber)
+	case ctrlHexColor:
+		//\x04[RRGGBB[,RRGGBB]]
+		return 1 + colorLength(s[1:], 6, isHex)
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return size
+}
+
+func colorLength(s string, digits int, valid func(byte) bool) int {
+	n := countDigits(s, digits, valid)
+	if n > 0 && n < len(s) && s[n] == ',' {
+		if bg := countDigits(s[n+1:], digits, valid); bg > 0 {
+			n += 1 + bg
+		}
+	}
+	return n
+}
+
+func countDigits(s string, max int, valid func(byte) bool) int {
+	n := 0
+	for n < max && n < len(s) && valid(s[n]) {
+		n++
+	}
+	return n
+}
+
+func isHex(r byte) bool {
+	return isNumber(r) || ('a' <= r && r <= 'f') || ('A' <= r && r <= 'F')
+}
+
+//SplitMessages returns the PRIVMSG or NOTICE messages needed to send text to
+//target. Each line of text is sent as a separate message, and long lines are
+//split so that the message, once the server adds our hostmask as the prefix,
+//fits in MaxLineLength. If hostmask is empty, the longest likely hostmask is
+//assumed.
+func SplitMessages(command, target, text, hostmask string) []Message {
+	var msgs []Message
+	max := maxTextLength(command, target, hostmask)
+	for _, line := range splitLines(text) {
+		for _, chunk := range SplitText(line, max) {
+			msgs = append(msgs, NewMessage(fmt.Sprintf("%s %s :%s", command, target, chunk)))
+		}
+	}
+	return msgs
+}
+
+//MultilineMessages returns text as one or more draft/multiline batches.
+//maxBytes and maxLines are the limits advertised by the server; zero means
+//no limit.
+//http://ircv3.net/specs/extensions/multiline
+func MultilineMessages(command, target, text, hostmask string, maxBytes, maxLines int) []Message {
+	var msgs, batch []Message
+	var batchBytes int
+	var ref string
+	max := maxTextLength(command, target, hostmask)
+
+	closeBatch := func() {
+		if len(batch) > 0 {
+			msgs = append(msgs, NewMessage(fmt.Sprintf("BATCH +%s %s %s", ref, capMultiline, target)))
+			msgs = append(msgs, batch...)
+			msgs = append(msgs, NewMessage("BATCH -"+ref))
+		}
+		batch = nil
+		batchBytes = 0
+	}
+
+	for _, line := range splitLines(text) {
+		for k, chunk := range splitText(line, max, true) {
+			if (maxLines > 0 && len(batch) >= maxLines) ||
+				(maxBytes > 0 && batchBytes+len(chunk) > maxBytes) {
+				closeBatch()
+			}
+			if len(batch) == 0 {
+				ref = strconv.FormatUint(atomic.AddUint64(&batchID, 1), 36)
+			}
+
+			tags := "@batch=" + ref
+			if k > 0 && len(batch) > 0 {
+				tags += ";" + tagMultilineConcat
+			}
+			batch = append(batch, NewMessage(fmt.Sprintf("%s %s %s :%s", tags, command, target, chunk)))
+			batchBytes += len(chunk)
+		}
+	}
+	closeBatch()
+	return msgs
+}
+
+//maxTextLength is the number of bytes of text that can be sent in a
+//message without the server truncating it
+func maxTextLength(command, target, hostmask string) int {
+	prefix := len(hostmask)
+	if prefix == 0 {
+		prefix = defaultHostmaskLength
+	}
+	//:hostmask COMMAND target :text\r\n
+	return MaxLineLength - (1 + prefix + 1 + len(command) + 1 + len(target) + 2 + 2)
+}
+
+//splitLines splits text on newlines, dropping empty lines which cannot be sent
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+//multilineLimits parses the max-bytes and max-lines values from the
+//draft/multiline capability value
+func multilineLimits(value string) (maxBytes, maxLines int) {
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		n, _ := strconv.Atoi(parts[1])
+		switch parts[0] {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+	return
+}
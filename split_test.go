@@ -0,0 +1,147 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var splitTextInput = []struct {
+	text     string
+	max      int
+	expected []string
+}{
+	{"short", 10, []string{"short"}},
+	{"hello there world", 11, []string{"hello there", "world"}},
+	{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+	{"héé", 4, []string{"hé", "é"}},
+	{"ab\x0304,12cd", 7, []string{"ab", "\x0304,12c", "d"}},
+	{"a\x04ff00ffbc", 8, []string{"a\x04ff00ff", "bc"}},
+	{"\x02bold\x02 text", 6, []string{"\x02bold\x02", "text"}},
+	{"no room", 0, []string{"no room"}},
+	{"no room", -5, []string{"no room"}},
+}
+
+func TestSplitText(t *testing.T) {
+	for j, in := range splitTextInput {
+		actual := SplitText(in.text, in.max)
+		if strings.Join(actual, "|") != strings.Join(in.expected, "|") {
+			t.Errorf("input[%d]: SplitText() did not split correctly. Expected: %q, Received: %q", j, in.expected, actual)
+		}
+	}
+}
+
+func TestSplitMessages(t *testing.T) {
+	hostmask := "gotest!~go@1.2.3.4"
+	text := strings.Repeat("ünïcödé wörds ", 200) + "\r\n\r\nsecond line"
+	msgs := SplitMessages("PRIVMSG", "#gotest", text, hostmask)
+	if len(msgs) < 2 {
+		t.Fatalf("SplitMessages() did not split a long message. Received %d messages", len(msgs))
+	}
+
+	var joined []string
+	for _, msg := range msgs {
+		line := ":" + hostmask + " " + msg.String() + "\r\n"
+		if len(line) > MaxLineLength {
+			t.Errorf("SplitMessages() returned a line longer than %d bytes: %d", MaxLineLength, len(line))
+		}
+		if !utf8.ValidString(msg.Trailing()) {
+			t.Errorf("SplitMessages() split in the middle of a UTF-8 sequence: %q", msg.Trailing())
+		}
+		if msg.Command() != "PRIVMSG" || msg.Params()[0] != "#gotest" {
+			t.Errorf("SplitMessages() returned an unexpected message: %s", msg)
+		}
+		joined = append(joined, msg.Trailing())
+	}
+
+	if msgs[len(msgs)-1].Trailing() != "second line" {
+		t.Errorf("SplitMessages() did not send each line separately. Received: %s", msgs[len(msgs)-1])
+	}
+	if strings.Join(joined[:len(joined)-1], " ") != strings.Repeat("ünïcödé wörds ", 200) {
+		t.Error("SplitMessages() did not preserve the text of the message")
+	}
+
+	//An unknown hostmask should assume the longest one
+	if len(SplitMessages("PRIVMSG", "#gotest", text, "")) < len(msgs) {
+		t.Error("SplitMessages() with an unknown hostmask returned fewer messages than with a known one")
+	}
+}
+
+func TestMultilineMessages(t *testing.T) {
+	text := strings.Repeat("a", 600) + "\nline 2\nline 3"
+	msgs := MultilineMessages("PRIVMSG", "#gotest", text, "gotest!~go@1.2.3.4", 0, 3)
+
+	expected := []string{"BATCH", "PRIVMSG", "PRIVMSG", "PRIVMSG", "BATCH", "BATCH", "PRIVMSG", "BATCH"}
+	if len(msgs) != len(expected) {
+		t.Fatalf("MultilineMessages() returned the wrong number of messages. Expected: %d, Received: %d", len(expected), len(msgs))
+	}
+	for k, cmd := range expected {
+		if msgs[k].Command() != cmd {
+			t.Errorf("msgs[%d]: Expected command %s, Received: %s", k, cmd, msgs[k])
+		}
+	}
+
+	ref := strings.TrimPrefix(msgs[0].Params()[0], "+")
+	if msgs[0].Params()[1] != capMultiline || msgs[0].Params()[2] != "#gotest" {
+		t.Errorf("Batch was not opened correctly: %s", msgs[0])
+	}
+	if msgs[1].Tags()["batch"] != ref || msgs[2].Tags()["batch"] != ref {
+		t.Errorf("Messages were not tagged with the batch reference %s", ref)
+	}
+	if _, ok := msgs[1].Tags()[tagMultilineConcat]; ok {
+		t.Error("The first line in a batch should not be concatenated")
+	}
+	if _, ok := msgs[2].Tags()[tagMultilineConcat]; !ok {
+		t.Error("The continuation of a long line should be concatenated")
+	}
+	if _, ok := msgs[3].Tags()[tagMultilineConcat]; ok {
+		t.Error("A new line should not be concatenated")
+	}
+	if msgs[4].Params()[0] != "-"+ref {
+		t.Errorf("Batch was not closed correctly: %s", msgs[4])
+	}
+}
+
+func TestMultilineMessagesWords(t *testing.T) {
+	text := strings.Repeat("hello world ", 60)
+	msgs := MultilineMessages("PRIVMSG", "#gotest", text, "gotest!~go@1.2.3.4", 0, 0)
+	if len(msgs) < 4 {
+		t.Fatalf("Expected the line to be split, received %d messages", len(msgs))
+	}
+
+	var joined string
+	for _, msg := range msgs[1 : len(msgs)-1] {
+		joined += msg.Trailing()
+	}
+	if joined != text {
+		t.Errorf("Concatenated chunks don't match the text. Expected: %q, Received: %q", text, joined)
+	}
+}
+
+func TestPrivMsgCount(t *testing.T) {
+	conn, b := newBufferConn()
+	client := NewClientWrapper(conn)
+	if n, err := client.PrivMsg("#chan", "hello"); n != 1 || err != nil {
+		t.Errorf("Expected 1 message sent, Received %d %v", n, err)
+	}
+	if n, err := client.Notice("#chan", strings.Repeat("word ", 200)); n != 3 || err != nil {
+		t.Errorf("Expected 3 messages sent, Received %d %v", n, err)
+	}
+
+	//Messages after a failed write aren't sent
+	failed := errors.New("write failed")
+	client.AddInterceptor(Outgoing, func(msg Message, next Next) error {
+		if msg.Trailing() == "fail" {
+			return failed
+		}
+		return next(msg)
+	})
+	n, err := client.Send(PrivMessage("#chan", "ok"), PrivMessage("#chan", "fail"), PrivMessage("#chan", "unsent"))
+	if n != 1 || err != failed {
+		t.Errorf("Expected 1 message sent and an error, Received %d %v", n, err)
+	}
+	if lines := b.lines(); len(lines) != 5 || lines[4] != "PRIVMSG #chan :ok" {
+		t.Errorf("Unexpected lines sent %q", lines)
+	}
+}
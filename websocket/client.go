@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var randReader io.Reader = rand.Reader
+
+//ErrBadHandshake is returned when the server doesn't accept the WebSocket
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+//Dialer connects to WebSocket servers
+type Dialer struct {
+	TLSConfig *tls.Config //Used for wss:// URLs
+	Header    http.Header //Sent with the handshake, e.g. Origin
+	//Subprotocols in order of preference. Defaults to binary.ircv3.net
+	//then text.ircv3.net.
+	Subprotocols []string
+	//NetDial dials the TCP connection. Defaults to net.Dial.
+	NetDial func(network, addr string) (net.Conn, error)
+}
+
+//DefaultDialer is used by Dial
+var DefaultDialer = &Dialer{}
+
+//Dial connects to a ws:// or wss:// URL using the DefaultDialer
+func Dial(rawurl string) (*Conn, error) {
+	return DefaultDialer.Dial(rawurl)
+}
+
+//Dial connects to a ws:// or wss:// URL
+func (d *Dialer) Dial(rawurl string) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	dial := d.NetDial
+	if dial == nil {
+		dial = net.Dial
+	}
+	c, err := dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		conf := &tls.Config{}
+		if d.TLSConfig != nil {
+			conf = d.TLSConfig.Clone()
+		}
+		if conf.ServerName == "" {
+			conf.ServerName = u.Hostname()
+		}
+		tc := tls.Client(c, conf)
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+
+	ws, err := d.handshake(c, u)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (d *Dialer) handshake(c net.Conn, u *url.URL) (*Conn, error) {
+	protocols := d.Subprotocols
+	if len(protocols) == 0 {
+		protocols = []string{BinaryProtocol, TextProtocol}
+	}
+	var nonce [16]byte
+	if _, err := io.ReadFull(randReader, nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Host:       u.Host,
+		Header:     make(http.Header),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, ErrBadHandshake
+	}
+
+	protocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if protocol != "" && !contains(protocols, protocol) {
+		return nil, ErrBadHandshake
+	}
+	return newConn(c, br, true, protocol), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
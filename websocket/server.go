@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+//Upgrader accepts WebSocket connections from HTTP requests
+type Upgrader struct {
+	//Subprotocols the server supports, in order of preference. Defaults
+	//to binary.ircv3.net then text.ircv3.net.
+	Subprotocols []string
+	//CheckOrigin returns true if the request's Origin is allowed. If nil,
+	//all origins are allowed.
+	CheckOrigin func(r *http.Request) bool
+}
+
+//Upgrade completes the WebSocket handshake. On failure, an HTTP error is
+//sent to the client.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != "GET" || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "Bad WebSocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, ErrBadHandshake
+	}
+
+	supported := u.Subprotocols
+	if len(supported) == 0 {
+		supported = []string{BinaryProtocol, TextProtocol}
+	}
+	requested := headerValues(r.Header, "Sec-WebSocket-Protocol")
+	protocol := ""
+	for _, p := range supported {
+		if contains(requested, p) {
+			protocol = p
+			break
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	c, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n"
+	if protocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	if _, err := c.Write([]byte(resp + "\r\n")); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return newConn(c, brw.Reader, false, protocol), nil
+}
+
+//Handler returns an http.Handler that upgrades requests and passes the
+//connection to serve, e.g. an ircd.Server's ServeConn.
+func Handler(u *Upgrader, serve func(*Conn)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		serve(c)
+	})
+}
+
+//headerValues returns the comma separated values of a header
+func headerValues(h http.Header, name string) []string {
+	var values []string
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range headerValues(h, name) {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,318 @@
+/*
+Package websocket implements the IRCv3 WebSocket transport, using the
+text.ircv3.net and binary.ircv3.net subprotocols.
+
+Each WebSocket message carries one IRC line without its line ending. Conn
+adapts this to the line based stream expected by irc.NewConnectionWrapper,
+adding CRLF to each message read, and sending each line written as a
+message. Conn is a net.Conn, so it can also be served by the ircd and
+bouncer packages.
+
+	ws, err := websocket.Dial("wss://irc.example.com/webirc")
+	conn := irc.NewConnectionWrapper(ws)
+*/
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//Subprotocols defined by IRCv3
+const (
+	TextProtocol   = "text.ircv3.net"
+	BinaryProtocol = "binary.ircv3.net"
+)
+
+//MaxMessageSize is the largest message accepted. IRC lines with tags are
+//at most 8703 bytes.
+const MaxMessageSize = 16384
+
+//Errors returned by Conn
+var (
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	ErrProtocol        = errors.New("websocket: protocol error")
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	closeNormal   = 1000
+	closeProtocol = 1002
+	closeTooBig   = 1009
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+//Conn is a WebSocket connection carrying IRC lines
+type Conn struct {
+	conn     net.Conn
+	r        io.Reader //Buffered reader of conn, which may hold data read during the handshake
+	client   bool      //Clients mask the frames they send
+	protocol string
+
+	readBuf []byte //Remainder of the last message, with CRLF
+	readErr error
+
+	wLock    *sync.Mutex
+	writeBuf []byte //Partial line written
+	closed   bool
+}
+
+func newConn(c net.Conn, r io.Reader, client bool, protocol string) *Conn {
+	return &Conn{conn: c, r: r, client: client, protocol: protocol, wLock: new(sync.Mutex)}
+}
+
+//Subprotocol returns the negotiated subprotocol, or an empty string if none
+//was negotiated, in which case messages are sent as text.
+func (c *Conn) Subprotocol() string {
+	return c.protocol
+}
+
+//Read reads IRC lines, each ending with CRLF
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		msg, err := c.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return 0, err
+		}
+		c.readBuf = append(msg, '\r', '\n')
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+//Write sends each complete line in p as a message. Partial lines are
+//buffered until the line ending is written.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	c.writeBuf = append(c.writeBuf, p...)
+	for {
+		i := bytes.IndexByte(c.writeBuf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := bytes.TrimRight(c.writeBuf[:i], "\r")
+		c.writeBuf = c.writeBuf[i+1:]
+		if len(line) == 0 {
+			continue
+		}
+		if err := c.writeMessage(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+//ReadMessage returns the next message, without a line ending. Pings are
+//answered, and io.EOF is returned once the connection is closed.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			if err == ErrMessageTooLarge {
+				c.closeWith(closeTooBig)
+			} else if err == ErrProtocol {
+				c.closeWith(closeProtocol)
+			}
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			c.wLock.Lock()
+			err = c.writeFrame(opPong, payload)
+			c.wLock.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeWith(closeNormal)
+			return nil, io.EOF
+		case opText, opBinary:
+			if started {
+				return nil, ErrProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, ErrProtocol
+			}
+		default:
+			return nil, ErrProtocol
+		}
+
+		if len(msg)+len(payload) > MaxMessageSize {
+			c.closeWith(closeTooBig)
+			return nil, ErrMessageTooLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+//readFrame reads a single frame
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if header[0]&0x70 != 0 || masked == c.client {
+		//No extensions are negotiated, and only clients mask frames
+		return fin, op, nil, ErrProtocol
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (length > 125 || !fin) {
+		return fin, op, nil, ErrProtocol
+	}
+	if length > MaxMessageSize {
+		return fin, op, nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, op, payload, nil
+}
+
+//writeMessage sends a line as a text or binary message. wLock must be held.
+func (c *Conn) writeMessage(line []byte) error {
+	if c.protocol == BinaryProtocol {
+		return c.writeFrame(opBinary, line)
+	}
+	//Text frames must be valid UTF-8
+	if !utf8.Valid(line) {
+		line = bytes.ToValidUTF8(line, []byte("\uFFFD"))
+	}
+	return c.writeFrame(opText, line)
+}
+
+//writeFrame sends a single, final frame. wLock must be held.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+	frame := []byte{0x80 | op, 0}
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		frame[1] = 126
+		frame = append(frame, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(len(payload)))
+		frame = append(frame, ext[:]...)
+	}
+
+	if c.client {
+		frame[1] |= 0x80
+		var mask [4]byte
+		if _, err := io.ReadFull(randReader, mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		masked := append([]byte(nil), payload...)
+		maskBytes(mask, masked)
+		payload = masked
+	}
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+//closeWith sends a close frame with the status code, and no longer allows writes
+func (c *Conn) closeWith(code uint16) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	if !c.closed {
+		c.writeFrame(opClose, []byte{byte(code >> 8), byte(code)})
+		c.closed = true
+	}
+}
+
+//Close sends a close frame and closes the connection
+func (c *Conn) Close() error {
+	c.closeWith(closeNormal)
+	return c.conn.Close()
+}
+
+//LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+//RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+//SetDeadline sets the read and write deadlines of the underlying connection
+func (c *Conn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+//SetReadDeadline sets the read deadline of the underlying connection
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+//SetWriteDeadline sets the write deadline of the underlying connection
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+//acceptKey returns the Sec-WebSocket-Accept value for a key
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
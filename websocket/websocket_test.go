@@ -0,0 +1,231 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oooska/irc"
+	"github.com/oooska/irc/ircd"
+)
+
+const timeout = 2 * time.Second
+
+//echoServer replies to each line with "ECHO <line>"
+func echoServer(u *Upgrader) *httptest.Server {
+	return httptest.NewServer(Handler(u, func(c *Conn) {
+		conn := irc.NewConnectionWrapper(c)
+		defer conn.Close()
+		for {
+			msg, err := conn.Read()
+			if err != nil {
+				return
+			}
+			conn.Write(irc.NewMessage("ECHO :" + msg.String()))
+		}
+	}))
+}
+
+func wsURL(s *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func TestDialSubprotocols(t *testing.T) {
+	tests := []struct {
+		server, client []string
+		protocol       string
+	}{
+		{nil, nil, BinaryProtocol},
+		{[]string{TextProtocol, BinaryProtocol}, nil, TextProtocol},
+		{nil, []string{TextProtocol}, TextProtocol},
+		{[]string{BinaryProtocol}, []string{TextProtocol}, ""},
+	}
+	for _, test := range tests {
+		s := echoServer(&Upgrader{Subprotocols: test.server})
+		ws, err := (&Dialer{Subprotocols: test.client}).Dial(wsURL(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.Subprotocol() != test.protocol {
+			t.Errorf("Expected subprotocol %q, Received %q", test.protocol, ws.Subprotocol())
+		}
+
+		conn := irc.NewConnectionWrapper(ws)
+		conn.Write(irc.PrivMessage("#chan", "héllo"))
+		msg, err := conn.Read()
+		if err != nil || msg.Trailing() != "PRIVMSG #chan :héllo" {
+			t.Errorf("Expected echo, Received %v (%v)", msg, err)
+		}
+		conn.Close()
+		s.Close()
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	s := httptest.NewTLSServer(Handler(&Upgrader{}, func(c *Conn) {
+		c.Write([]byte(":server 001 nick :Welcome\r\n"))
+		c.Close()
+	}))
+	defer s.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	d := &Dialer{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"}}
+	ws, err := d.Dial("wss" + strings.TrimPrefix(s.URL, "https"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := irc.NewConnectionWrapper(ws)
+	if msg, err := conn.Read(); err != nil || msg.Command() != "001" {
+		t.Errorf("Expected 001, Received %v (%v)", msg, err)
+	}
+	if _, err := conn.Read(); err != io.EOF {
+		t.Errorf("Expected EOF after close, Received %v", err)
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	s := echoServer(&Upgrader{CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://allowed.example"
+	}})
+	defer s.Close()
+
+	if _, err := Dial(wsURL(s)); err != ErrBadHandshake {
+		t.Errorf("Expected ErrBadHandshake, Received %v", err)
+	}
+	d := &Dialer{Header: http.Header{"Origin": {"https://allowed.example"}}}
+	ws, err := d.Dial(wsURL(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected plain HTTP requests to fail, Received %d", resp.StatusCode)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	client, server := net.Pipe()
+	ws := newConn(server, server, false, TextProtocol)
+	defer ws.Close()
+
+	//Client frames are masked. The second message is fragmented, with a
+	//ping in between.
+	frame := func(header byte, payload string) []byte {
+		mask := [4]byte{1, 2, 3, 4}
+		b := []byte(payload)
+		maskBytes(mask, b)
+		return append([]byte{header, 0x80 | byte(len(b)), 1, 2, 3, 4}, b...)
+	}
+	go func() {
+		client.Write(frame(0x81, "NICK a"))
+		client.Write(frame(0x01, "PRIVMSG #a "))
+		client.Write(frame(0x89, "ping"))
+		client.Write(frame(0x80, ":hi"))
+		client.Write(frame(0x88, "\x03\xe8"))
+	}()
+
+	//The pong and close are sent back
+	replies := make(chan []byte, 1)
+	go func() {
+		var got []byte
+		buf := make([]byte, 64)
+		for len(got) < 10 {
+			n, err := client.Read(buf)
+			if err != nil {
+				break
+			}
+			got = append(got, buf[:n]...)
+		}
+		replies <- got
+	}()
+
+	data, err := io.ReadAll(ws)
+	if err != nil || string(data) != "NICK a\r\nPRIVMSG #a :hi\r\n" {
+		t.Errorf("Unexpected data %q (%v)", data, err)
+	}
+	select {
+	case got := <-replies:
+		if string(got) != "\x8a\x04ping\x88\x02\x03\xe8" {
+			t.Errorf("Expected pong and close, Received %q", got)
+		}
+	case <-time.After(timeout):
+		t.Error("Timed out waiting for pong")
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"unmasked", []byte{0x81, 0x01, 'a'}, ErrProtocol},
+		{"continuation first", []byte{0x80, 0x81, 0, 0, 0, 0, 'a'}, ErrProtocol},
+		{"too large", []byte{0x81, 0xFF, 0, 0, 0, 0, 0, 1, 0, 0}, ErrMessageTooLarge},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		ws := newConn(server, bytes.NewReader(test.frame), false, "")
+		go io.Copy(io.Discard, client)
+		if _, err := ws.ReadMessage(); err != test.err {
+			t.Errorf("%s: Expected %v, Received %v", test.name, test.err, err)
+		}
+		ws.Close()
+		client.Close()
+	}
+}
+
+func TestIRCServer(t *testing.T) {
+	ircServer := ircd.NewServer(ircd.Config{})
+	defer ircServer.Close()
+	s := httptest.NewServer(Handler(&Upgrader{}, func(c *Conn) { ircServer.ServeConn(c) }))
+	defer s.Close()
+
+	ws, err := Dial(wsURL(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := irc.NewClientWrapper(irc.NewConnectionWrapper(ws))
+	defer client.Close()
+	client.Send(irc.NickMessage("alice"), irc.UserMessage("alice", "0", "*", ":Alice"))
+
+	done := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := client.Read()
+			if err != nil {
+				done <- err
+				return
+			}
+			if msg.Command() == "001" {
+				done <- nil
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for 001")
+	}
+	if client.Nick() != "alice" {
+		t.Errorf("Expected nick alice, Received %s", client.Nick())
+	}
+}